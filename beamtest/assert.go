@@ -0,0 +1,88 @@
+package beamtest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/caeret/beam"
+	"github.com/caeret/beam/client"
+)
+
+// AssertReply checks that the reply equals to the expected one byte by byte.
+func AssertReply(t testing.TB, expected, reply beam.Reply) bool {
+	t.Helper()
+	if string(expected) != string(reply) {
+		t.Errorf("beamtest: expected reply %s, got %s", expected, reply)
+		return false
+	}
+	return true
+}
+
+// AssertSimpleString checks that the reply is the simple string.
+func AssertSimpleString(t testing.TB, expected string, reply beam.Reply) bool {
+	t.Helper()
+	return AssertReply(t, beam.NewSimpleStringsReply(expected), reply)
+}
+
+// AssertOK checks that the reply is "+OK".
+func AssertOK(t testing.TB, reply beam.Reply) bool {
+	t.Helper()
+	return AssertSimpleString(t, "OK", reply)
+}
+
+// AssertError checks that the reply is an error starts with the prefix, such as "ERR" or "WRONGTYPE".
+func AssertError(t testing.TB, prefix string, reply beam.Reply) bool {
+	t.Helper()
+	e, ok := reply.Err().(beam.ReplyError)
+	if !ok || !strings.HasPrefix(string(e), prefix) {
+		t.Errorf("beamtest: expected error reply starts with %q, got %s", prefix, reply)
+		return false
+	}
+	return true
+}
+
+// AssertInteger checks that the reply is the integer.
+func AssertInteger(t testing.TB, expected int64, reply beam.Reply) bool {
+	t.Helper()
+	n, err := reply.Int()
+	if reply.Type() != beam.TypeIntegers || err != nil || n != expected {
+		t.Errorf("beamtest: expected integer reply %d, got %s", expected, reply)
+		return false
+	}
+	return true
+}
+
+// AssertBulkString checks that the reply is the bulk string.
+func AssertBulkString(t testing.TB, expected string, reply beam.Reply) bool {
+	t.Helper()
+	return AssertReply(t, beam.NewBulkStringsReply(expected), reply)
+}
+
+// AssertNil checks that the reply is a nil bulk string, nil array or RESP3 null.
+func AssertNil(t testing.TB, reply beam.Reply) bool {
+	t.Helper()
+	if reply.IsNil() {
+		return true
+	}
+	t.Errorf("beamtest: expected nil reply, got %s", reply)
+	return false
+}
+
+// AssertStrings checks that the reply is an aggregate of the strings, nil elements are compared as empty strings.
+func AssertStrings(t testing.TB, expected []string, reply beam.Reply) bool {
+	t.Helper()
+	strs, err := client.Strings(reply, nil)
+	if err != nil {
+		t.Errorf("beamtest: expected array reply %q, got %s", expected, reply)
+		return false
+	}
+	if len(expected) == 0 && len(strs) == 0 {
+		return true
+	}
+	if !reflect.DeepEqual(expected, strs) {
+		t.Errorf("beamtest: expected array reply %q, got %q", expected, strs)
+		return false
+	}
+	return true
+}
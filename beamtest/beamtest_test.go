@@ -0,0 +1,102 @@
+package beamtest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caeret/beam"
+)
+
+func newHandler() beam.Handler {
+	mh := beam.NewMappedHandler()
+	mh.SetFunc("AUTH", func(request *beam.Request) (beam.Reply, error) {
+		if request.ArgStr(0) != "secret" {
+			return beam.NewErrorsReply("WRONGPASS invalid password"), nil
+		}
+		request.SetAttr("auth", struct{}{})
+		return beam.NewSimpleStringsReply("OK"), nil
+	})
+	mh.SetFunc("ECHO", func(request *beam.Request) (beam.Reply, error) {
+		return beam.NewBulkStringsReplyRaw(request.Arg(0)), nil
+	})
+	mh.SetFunc("INCR", func(request *beam.Request) (beam.Reply, error) {
+		n, _ := beam.GetAttrAs[int](request.Client, "counter")
+		request.SetAttr("counter", n+1)
+		return beam.NewIntegersReply(n + 1), nil
+	})
+	mh.SetFunc("LIST", func(request *beam.Request) (beam.Reply, error) {
+		return beam.NewArraysReplyRaw([]byte("foo"), nil), nil
+	})
+	mh.SetFunc("QUIT", func(request *beam.Request) (beam.Reply, error) {
+		return nil, beam.ErrHaltClient
+	})
+
+	chain := beam.NewHandlerChain(mh)
+	chain.AddFunc(func(request *beam.Request, next beam.Handler) (beam.Reply, error) {
+		if !request.HasAttr("auth") && strings.ToUpper(request.CommandStr()) != "AUTH" {
+			return beam.NewErrorsReply("NOAUTH Authentication required."), nil
+		}
+		return next.Handle(request)
+	})
+	return chain
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer(t, newHandler(), beam.Config{})
+	conn := s.Dial()
+
+	AssertError(t, "NOAUTH", conn.Do("ECHO", "foo"))
+	AssertError(t, "WRONGPASS", conn.Do("AUTH", "foo"))
+	AssertOK(t, conn.Do("AUTH", "secret"))
+	AssertBulkString(t, "foo", conn.Do("ECHO", "foo"))
+	AssertStrings(t, []string{"foo", ""}, conn.Do("LIST"))
+	AssertError(t, "ERR unknown command", conn.Do("FOO"))
+
+	// the attributes are scoped to the connection.
+	other := s.Dial()
+	AssertError(t, "NOAUTH", other.Do("ECHO", "foo"))
+}
+
+func TestServer_Pipeline(t *testing.T) {
+	s := NewServer(t, newHandler(), beam.Config{})
+	conn := s.Dial()
+
+	conn.Send(
+		beam.NewQuery("AUTH", "secret"),
+		beam.NewQuery("INCR"),
+		beam.NewQuery("INCR"),
+	)
+	conn.SendRaw("INCR\r\nECHO bar\r\n")
+
+	AssertOK(t, conn.Read())
+	AssertInteger(t, 1, conn.Read())
+	AssertInteger(t, 2, conn.Read())
+	AssertInteger(t, 3, conn.Read())
+	AssertBulkString(t, "bar", conn.Read())
+}
+
+func TestServer_Close(t *testing.T) {
+	s := NewServer(t, newHandler(), beam.Config{})
+	conn := s.Dial()
+
+	AssertOK(t, conn.Do("AUTH", "secret"))
+	AssertError(t, "ERR connection is closed", conn.Do("QUIT"))
+	conn.ExpectClosed()
+
+	conn = s.Dial()
+	conn.SendRaw("*2\r\nhello\r\n")
+	AssertError(t, "ERR Protocol error: expected '$', got 'h'", conn.Read())
+	conn.ExpectClosed()
+}
+
+func TestServer_IdleTimeout(t *testing.T) {
+	s := NewServer(t, newHandler(), beam.Config{
+		RWTimeout:   time.Millisecond * 10,
+		IdleTimeout: time.Millisecond * 50,
+	})
+	conn := s.Dial()
+
+	AssertOK(t, conn.Do("AUTH", "secret"))
+	conn.ExpectClosed()
+}
@@ -0,0 +1,90 @@
+package beamtest
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/caeret/beam"
+	"github.com/caeret/beam/client"
+)
+
+// DefaultTimeout limits the time of the reads and writes of Conn.
+var DefaultTimeout = time.Second * 5
+
+// NewConn wraps the connection to a beam server.
+func NewConn(t testing.TB, conn net.Conn) *Conn {
+	c := new(Conn)
+	c.t = t
+	c.conn = conn
+	c.br = bufio.NewReader(conn)
+	c.Timeout = DefaultTimeout
+	return c
+}
+
+// Conn sends the queries and reads the replies, any failure of the exchange fails the test.
+type Conn struct {
+	Timeout time.Duration
+	t       testing.TB
+	conn    net.Conn
+	br      *bufio.Reader
+}
+
+// Send writes the queries without reading the replies, so that they can be pipelined.
+func (c *Conn) Send(queries ...beam.Query) {
+	c.t.Helper()
+	c.SendRaw(beam.Querys(queries).Raw())
+}
+
+// SendRaw writes the raw data, such as the inline commands.
+func (c *Conn) SendRaw(data string) {
+	c.t.Helper()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.Timeout))
+	if _, err := io.WriteString(c.conn, data); err != nil {
+		c.t.Fatalf("beamtest: fail to write: %s", err)
+	}
+}
+
+// Read reads the next reply.
+func (c *Conn) Read() beam.Reply {
+	c.t.Helper()
+	reply, err := c.ReadErr()
+	if err != nil {
+		c.t.Fatalf("beamtest: fail to read reply: %s", err)
+	}
+	return reply
+}
+
+// ReadErr reads the next reply, the error is returned instead of failing the test.
+func (c *Conn) ReadErr() (beam.Reply, error) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(c.Timeout))
+	return client.ReadReply(c.br)
+}
+
+// Do sends the command and reads its reply.
+func (c *Conn) Do(command string, args ...string) beam.Reply {
+	c.t.Helper()
+	c.Send(beam.NewQuery(command, args...))
+	return c.Read()
+}
+
+// ExpectClosed checks that the server closes the connection without sending more replies.
+func (c *Conn) ExpectClosed() {
+	c.t.Helper()
+	reply, err := c.ReadErr()
+	switch {
+	case err == nil:
+		c.t.Errorf("beamtest: expected the connection to be closed, got reply %s", reply)
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		c.t.Errorf("beamtest: expected the connection to be closed, but it is still open")
+	}
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,69 @@
+package beamtest
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// ErrListenerClosed will be returned when accepting from or dialing to a closed PipeListener.
+var ErrListenerClosed = errors.New("beamtest: listener closed")
+
+// NewPipeListener creates an in-memory listener, the connections are created by net.Pipe.
+func NewPipeListener() *PipeListener {
+	l := new(PipeListener)
+	l.conns = make(chan net.Conn)
+	l.closeCh = make(chan struct{})
+	return l
+}
+
+// PipeListener is an in-memory net.Listener.
+type PipeListener struct {
+	conns     chan net.Conn
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+// Accept waits for the next connection created by Dial.
+func (l *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closeCh:
+		return nil, ErrListenerClosed
+	}
+}
+
+// Dial creates a connection to the listener.
+func (l *PipeListener) Dial() (net.Conn, error) {
+	server, client := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closeCh:
+		return nil, ErrListenerClosed
+	}
+}
+
+// Close closes the listener.
+func (l *PipeListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closeCh)
+	})
+	return nil
+}
+
+// Addr returns the address of the listener.
+func (l *PipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (pipeAddr) String() string {
+	return "pipe"
+}
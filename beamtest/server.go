@@ -0,0 +1,61 @@
+// Package beamtest provides utilities for testing the beam handlers through the real protocol.
+package beamtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caeret/beam"
+)
+
+// NewServer starts a beam server with the handler on an in-memory listener,
+// the server is closed when the test finishes.
+func NewServer(t testing.TB, handler beam.Handler, config beam.Config) *Server {
+	t.Helper()
+	if config.RWTimeout <= 0 {
+		config.RWTimeout = time.Millisecond * 100
+	}
+	s := new(Server)
+	s.t = t
+	s.Server = beam.NewServer(handler, config)
+	s.listener = NewPipeListener()
+	s.done = make(chan error, 1)
+	go func() {
+		s.done <- s.Server.ServeListener(s.listener)
+	}()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Server is a running beam server for tests.
+type Server struct {
+	*beam.Server
+	t        testing.TB
+	listener *PipeListener
+	done     chan error
+	closed   bool
+}
+
+// Dial creates a connection to the server, the connection is closed when the test finishes.
+func (s *Server) Dial() *Conn {
+	s.t.Helper()
+	conn, err := s.listener.Dial()
+	if err != nil {
+		s.t.Fatalf("beamtest: fail to dial: %s", err)
+	}
+	c := NewConn(s.t, conn)
+	s.t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+// Close closes the server and waits for all the clients to be stopped.
+func (s *Server) Close() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	_ = s.Server.Close()
+	if err := <-s.done; err != nil && err != beam.ErrServerClosed {
+		s.t.Errorf("beamtest: server stops with error: %s", err)
+	}
+}
@@ -1,9 +1,16 @@
 package beam
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,6 +18,56 @@ var (
 	ErrHaltClient = errors.New("halt client")
 )
 
+// PanicError wraps the value recovered from a panicking handler.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// DisconnectReason describes why a client is disconnected.
+type DisconnectReason int32
+
+const (
+	DisconnectUnknown DisconnectReason = iota
+	DisconnectClientClosed
+	DisconnectServerClosed
+	DisconnectRejected
+	DisconnectHalted
+	DisconnectIdleTimeout
+	DisconnectQueryTooLarge
+	DisconnectProtocolError
+	DisconnectReadError
+	DisconnectWriteError
+	DisconnectOutputBufferLimit
+	DisconnectStopped
+)
+
+var disconnectReasonNames = [...]string{
+	DisconnectUnknown:           "unknown",
+	DisconnectClientClosed:      "client closed",
+	DisconnectServerClosed:      "server closed",
+	DisconnectRejected:          "rejected",
+	DisconnectHalted:            "halted",
+	DisconnectIdleTimeout:       "idle timeout",
+	DisconnectQueryTooLarge:     "query too large",
+	DisconnectProtocolError:     "protocol error",
+	DisconnectReadError:         "read error",
+	DisconnectWriteError:        "write error",
+	DisconnectOutputBufferLimit: "output buffer limit",
+	DisconnectStopped:           "stopped",
+}
+
+func (r DisconnectReason) String() string {
+	if r >= 0 && int(r) < len(disconnectReasonNames) {
+		return disconnectReasonNames[r]
+	}
+	return disconnectReasonNames[DisconnectUnknown]
+}
+
 // NewRequest creates a new Request with the given Client and Request.
 func NewRequest(client *Client, query Query) *Request {
 	req := new(Request)
@@ -35,33 +92,365 @@ type ClientStats struct {
 // Client contains the client connection and deadline for closing.
 type Client struct {
 	s          *Server
+	id         uint64
+	logger     *slog.Logger
 	conn       net.Conn
 	deadline   time.Time
 	b          []byte
 	bsize      int
+	unread     int
 	stats      *ClientStats
+	statsMutex sync.Mutex
 	closeCh    chan struct{}
+	done       chan struct{}
+	doneOnce   sync.Once
+	watchMutex sync.Mutex
+	processing bool
+	watchDone  chan struct{}
+	hungUp     bool
 	attributes map[string]interface{}
+	attrsMutex sync.RWMutex
+	class      ClientClass
+	out        *outputBuffer
+	writerDone chan struct{}
+	closeOnce  sync.Once
+	reason     int32
+	replies    Replies
+	errs       []error
+	wakeup     func()
+}
+
+// Done returns a channel which is closed when the client is stopped, hangs up or the server is
+// closed, the handlers blocking for long should return after it is closed.
+func (c *Client) Done() <-chan struct{} {
+	c.watch()
+	return c.done
+}
+
+// watch starts reading the connection when a handler is going to block on Done, so that the hangup
+// of the client is noticed before the handler returns. The data read meanwhile is kept in the input
+// buffer after bsize, counted by unread, and parsed after the queries are processed. The connection
+// is not watched once the buffer is full.
+func (c *Client) watch() {
+	c.watchMutex.Lock()
+	defer c.watchMutex.Unlock()
+	if !c.processing || c.watchDone != nil {
+		return
+	}
+	if err := c.conn.SetReadDeadline(time.Time{}); err != nil {
+		return
+	}
+	c.watchDone = make(chan struct{})
+	go c.watchConn(c.watchDone)
+}
+
+func (c *Client) watchConn(done chan struct{}) {
+	defer close(done)
+	for c.bsize+c.unread < cap(c.b) {
+		n, err := c.conn.Read(c.b[c.bsize+c.unread:])
+		c.unread += n
+		c.updateStats(n, 0, 0)
+		if err == nil {
+			continue
+		}
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			// stopped by stopWatching.
+			return
+		}
+		if err == io.EOF {
+			c.logger.Debug("receive EOF")
+			c.setReason(DisconnectClientClosed)
+		} else {
+			c.setReason(DisconnectReadError)
+			if !c.outputClosed() {
+				c.logger.Error("fail to read request", LogKeyError, err)
+			}
+		}
+		c.hungUp = true
+		c.markDone()
+		return
+	}
+}
+
+// stopWatching stops watching the connection after the queries are processed, false will be
+// returned if the client has hung up meanwhile.
+func (c *Client) stopWatching() bool {
+	c.watchMutex.Lock()
+	c.processing = false
+	done := c.watchDone
+	c.watchDone = nil
+	c.watchMutex.Unlock()
+	if done == nil {
+		return true
+	}
+	_ = c.conn.SetReadDeadline(time.Now())
+	<-done
+	return !c.hungUp
+}
+
+func (c *Client) markDone() {
+	c.doneOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// ID retrieves the unique identifier of the client.
+func (c *Client) ID() uint64 {
+	return c.id
+}
+
+// RemoteAddr returns the remote network address of the client.
+func (c *Client) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// LocalAddr returns the local network address of the client.
+func (c *Client) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
 }
 
+// GetAttr retrieves the attribute with the given key, nil will be returned if it does not exist.
 func (c *Client) GetAttr(key string) interface{} {
+	c.attrsMutex.RLock()
+	defer c.attrsMutex.RUnlock()
 	return c.attributes[key]
 }
 
+// SetAttr sets the attribute with the given key.
 func (c *Client) SetAttr(key string, value interface{}) {
+	c.attrsMutex.Lock()
+	defer c.attrsMutex.Unlock()
 	c.attributes[key] = value
 }
 
+// HasAttr checks if the attribute with the given key exists.
 func (c *Client) HasAttr(key string) bool {
+	c.attrsMutex.RLock()
+	defer c.attrsMutex.RUnlock()
 	_, exist := c.attributes[key]
 	return exist
 }
 
-// Stats retrieves the ClientStats value.
+// DelAttr deletes the attribute with the given key.
+func (c *Client) DelAttr(key string) {
+	c.attrsMutex.Lock()
+	defer c.attrsMutex.Unlock()
+	delete(c.attributes, key)
+}
+
+// Attrs retrieves a copy of all the attributes.
+func (c *Client) Attrs() map[string]interface{} {
+	c.attrsMutex.RLock()
+	defer c.attrsMutex.RUnlock()
+	attrs := make(map[string]interface{}, len(c.attributes))
+	for key, value := range c.attributes {
+		attrs[key] = value
+	}
+	return attrs
+}
+
+// GetAttrAs retrieves the attribute with the given key as type T,
+// ok is false if the attribute does not exist or is not a T.
+func GetAttrAs[T any](c *Client, key string) (value T, ok bool) {
+	value, ok = c.GetAttr(key).(T)
+	return
+}
+
+// Stats retrieves a consistent snapshot of the ClientStats value.
 func (c *Client) Stats() ClientStats {
+	c.statsMutex.Lock()
+	defer c.statsMutex.Unlock()
 	return *c.stats
 }
 
+// updateStats adds the given deltas to the ClientStats value.
+func (c *Client) updateStats(bytesIn, bytesOut, queries int) {
+	c.statsMutex.Lock()
+	c.stats.BytesIn += bytesIn
+	c.stats.BytesOut += bytesOut
+	c.stats.Queries += queries
+	c.statsMutex.Unlock()
+}
+
+// setReason records why the client is disconnected, only the first reason is kept.
+func (c *Client) setReason(reason DisconnectReason) {
+	atomic.CompareAndSwapInt32(&c.reason, int32(DisconnectUnknown), int32(reason))
+}
+
+// Class retrieves the ClientClass of the client.
+func (c *Client) Class() ClientClass {
+	c.out.mu.Lock()
+	defer c.out.mu.Unlock()
+	return c.class
+}
+
+// SetClass changes the ClientClass of the client, which decides the output buffer limit of it.
+func (c *Client) SetClass(class ClientClass) {
+	c.out.mu.Lock()
+	defer c.out.mu.Unlock()
+	c.class = class
+}
+
+// Send appends the replies to the output buffer of the client, they will be written to the connection
+// asynchronously. If the output buffer limit is exceeded, the client will be disconnected and
+// ErrOutputBufferLimit will be returned.
+func (c *Client) Send(replies ...Reply) error {
+	ob := c.out
+	ob.mu.Lock()
+	if ob.closed {
+		ob.mu.Unlock()
+		return ErrClientClosed
+	}
+	if ob.buf == nil {
+		ob.buf = acquireBuffer()
+	}
+	for _, reply := range replies {
+		*ob.buf = append(*ob.buf, reply...)
+		ob.pending += len(reply)
+	}
+	exceeded := c.outputLimitExceeded()
+	ob.mu.Unlock()
+
+	if exceeded {
+		c.disconnectForOutputLimit()
+		return ErrOutputBufferLimit
+	}
+	c.wakeup()
+	return nil
+}
+
+// outputLimitExceeded checks the output buffer limit, the lock of the output buffer must be held.
+func (c *Client) outputLimitExceeded() bool {
+	return c.s.outputBufferLimit(c.class).exceeded(c.out.pending, &c.out.softSince, time.Now())
+}
+
+func (c *Client) disconnectForOutputLimit() {
+	ob := c.out
+	ob.mu.Lock()
+	if ob.killed {
+		ob.mu.Unlock()
+		return
+	}
+	ob.killed = true
+	ob.closed = true
+	pending := ob.pending
+	class := c.class
+	ob.mu.Unlock()
+
+	c.setReason(DisconnectOutputBufferLimit)
+	atomic.AddInt64(&c.s.stats.outputBufferLimitDisconnections, 1)
+	c.logger.Warn("close client for exceeding the output buffer limit", LogKeyClass, class.String(), LogKeyBytes, pending)
+	c.closeConn()
+}
+
+// closeOutput stops accepting new replies, the pending replies are still going to be written.
+func (c *Client) closeOutput() {
+	c.out.mu.Lock()
+	c.out.closed = true
+	c.out.mu.Unlock()
+	c.out.wakeup()
+}
+
+// outputClosed checks if the client has stopped accepting replies.
+func (c *Client) outputClosed() bool {
+	c.out.mu.Lock()
+	defer c.out.mu.Unlock()
+	return c.out.closed
+}
+
+// closeConn closes the connection once.
+func (c *Client) closeConn() {
+	c.closeOnce.Do(func() {
+		c.logger.Debug("close connection")
+		err := c.conn.Close()
+		if err != nil {
+			c.logger.Warn("fail to close connection", LogKeyError, err)
+		}
+	})
+}
+
+// writeLoop writes the data in the output buffer to the connection until the output is closed and drained.
+func (c *Client) writeLoop() {
+	defer close(c.writerDone)
+
+	ob := c.out
+	var (
+		data *[]byte
+		off  int
+	)
+	for {
+		ob.mu.Lock()
+		if data == nil || off == len(*data) {
+			// swaps the buffers, the written one is reused for the new replies.
+			if data != nil {
+				*data = (*data)[:0]
+			}
+			data, ob.buf, off = ob.buf, data, 0
+			if data == nil || len(*data) == 0 {
+				// nothing is pending, returns the buffers to the pool while idle.
+				releaseBuffer(data)
+				releaseBuffer(ob.buf)
+				data, ob.buf = nil, nil
+			}
+		}
+		closed := ob.closed
+		ob.mu.Unlock()
+
+		if data == nil {
+			if closed {
+				return
+			}
+			<-ob.notify
+			continue
+		}
+
+		err := c.conn.SetWriteDeadline(time.Now().Add(c.s.config.RWTimeout))
+		if err != nil {
+			c.logger.Error("fail to set write deadline", LogKeyError, err)
+			c.setReason(DisconnectWriteError)
+			c.closeOutput()
+			c.closeConn()
+			return
+		}
+
+		nw, err := c.conn.Write((*data)[off:])
+		off += nw
+		c.updateStats(0, nw, 0)
+
+		ob.mu.Lock()
+		ob.pending -= nw
+		exceeded := c.outputLimitExceeded()
+		ob.mu.Unlock()
+
+		if exceeded {
+			c.disconnectForOutputLimit()
+			return
+		}
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				if closed {
+					c.logger.Warn("write timeout, drop the pending replies", LogKeyBytes, len(*data)-off)
+					return
+				}
+				if c.debugEnabled() {
+					c.logger.Debug("write timeout", LogKeyBytes, len(*data)-off)
+				}
+				continue
+			}
+			c.logger.Error("fail to write response", LogKeyError, err)
+			c.setReason(DisconnectWriteError)
+			c.closeOutput()
+			c.closeConn()
+			return
+		}
+	}
+}
+
+// debugEnabled checks if the debug records are enabled, so that the arguments are not evaluated in vain.
+func (c *Client) debugEnabled() bool {
+	return c.logger.Enabled(context.Background(), slog.LevelDebug)
+}
+
 // refreshDeadline sets the deadline with the current time and the given duration d.
 func (c *Client) refreshDeadline(d time.Duration) {
 	c.deadline = time.Now().Add(d)
@@ -75,126 +464,274 @@ func (c *Client) beforeDeadline() bool {
 func (c *Client) run() {
 	defer c.s.stopClient(c)
 
-	c.s.logger.Debug("handle new connection from %s.", c.conn.RemoteAddr())
+	c.logger.Debug("handle new connection")
+
+	go c.writeLoop()
 
 	defer func() {
-		c.s.logger.Debug("close connection from %s.", c.conn.RemoteAddr())
-		err := c.conn.Close()
-		if err != nil {
-			c.s.logger.Warning("there is an error when close the connection from %s.", c.conn.RemoteAddr())
-		}
+		c.closeOutput()
+		<-c.writerDone
+		c.closeConn()
+		c.disconnected()
 	}()
 
-	var shouldReturn bool
+	if !c.connect() {
+		return
+	}
+
 	c.refreshDeadline(c.s.config.IdleTimeout)
 
 	for {
 		select {
 		case <-c.s.closeCh:
+			c.setReason(DisconnectServerClosed)
 			return
 		case <-c.closeCh:
+			c.setReason(DisconnectStopped)
 			return
 		default:
 		}
 		if !c.beforeDeadline() {
-			c.s.logger.Debug("deadline exceeded from %s.", c.conn.RemoteAddr())
+			c.logger.Debug("deadline exceeded")
+			c.setReason(DisconnectIdleTimeout)
 			return
 		}
 		err := c.conn.SetReadDeadline(time.Now().Add(c.s.config.RWTimeout))
 		if err != nil {
-			c.s.logger.Error("fail to set read deadline: %s.", err.Error())
-			return
-		}
-
-		if c.bsize >= cap(c.b) {
-			c.s.logger.Warning("too large command data.")
+			c.logger.Error("fail to set read deadline", LogKeyError, err)
+			c.setReason(DisconnectReadError)
 			return
 		}
 
-		nr, err := c.conn.Read(c.b[c.bsize:])
-		if err != nil {
-			if err == io.EOF {
-				c.s.logger.Debug("receive EOF from %s.", c.conn.RemoteAddr())
+		var nr int
+		if c.unread > 0 {
+			// the data read while watching the connection is parsed first.
+			nr, c.unread = c.unread, 0
+		} else {
+			if c.bsize >= cap(c.b) {
+				c.logger.Warn("too large command data", LogKeyBytes, c.bsize)
+				c.setReason(DisconnectQueryTooLarge)
+				_ = c.Send(NewErrorsReply("ERR " + errQueryTooLarge.Error()))
 				return
 			}
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				c.s.logger.Debug("read timeout from %s.", c.conn.RemoteAddr())
-				continue
+
+			nr, err = c.conn.Read(c.b[c.bsize:])
+			if err != nil {
+				if err == io.EOF {
+					c.logger.Debug("receive EOF")
+					c.setReason(DisconnectClientClosed)
+					return
+				}
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					c.logger.Debug("read timeout")
+					continue
+				}
+				c.setReason(DisconnectReadError)
+				if c.outputClosed() {
+					return
+				}
+				c.logger.Error("fail to read request", LogKeyError, err)
+				return
 			}
-			c.s.logger.Error("fail to read request: %s.", err.Error())
-			return
-		}
 
-		c.stats.BytesIn += nr
+			c.updateStats(nr, 0, 0)
+		}
 
 		var queries Querys
 		l := c.b[:c.bsize+nr]
-		queries, l, err = ReadQuery(l)
+		queries, l, err = ReadQueryLimits(l, c.s.config.QueryLimits)
 		if err != nil {
-			c.s.logger.Error("fail to read command: %s.", err.Error())
+			// the queries before the malformed one are still handled.
+			if len(queries) == 0 || c.processInput(queries) {
+				c.protocolError(err)
+			}
 			return
 		}
 		copy(c.b, l)
 		c.bsize = len(l)
 
-		c.stats.Queries += len(queries)
 		c.refreshDeadline(c.s.config.IdleTimeout)
 
-		c.s.logger.Debug("read %d queries: \"%s\".", len(queries), queries)
+		if !c.processInput(queries) {
+			return
+		}
+	}
+}
+
+// protocolError replies the *ProtocolError before the client is closed.
+func (c *Client) protocolError(err error) {
+	c.setReason(DisconnectProtocolError)
+	var perr *ProtocolError
+	if !errors.As(err, &perr) {
+		c.logger.Error("fail to read command", LogKeyError, err)
+		return
+	}
+	c.logger.Error("fail to read command", LogKeyError, err, LogKeyOffset, perr.Offset)
+	_ = c.Send(NewErrorsReply("ERR " + perr.Error()))
+}
+
+// connect calls the OnConnect hook, false will be returned if the connection is rejected.
+func (c *Client) connect() bool {
+	if c.s.config.OnConnect != nil {
+		if err := c.s.config.OnConnect(c); err != nil {
+			c.logger.Debug("reject connection", LogKeyError, err)
+			c.setReason(DisconnectRejected)
+			_ = c.Send(NewErrorsReply("ERR " + err.Error()))
+			return false
+		}
+	}
+	return true
+}
 
-		var replies Replies
+// disconnected calls the OnDisconnect hook after the connection is closed.
+func (c *Client) disconnected() {
+	reason := DisconnectReason(atomic.LoadInt32(&c.reason))
+	c.logger.Debug("client disconnected", LogKeyReason, reason.String())
+	if c.s.config.OnDisconnect != nil {
+		c.s.config.OnDisconnect(c, reason)
+	}
+}
 
-		for _, query := range queries {
-			var reply Reply
+// processInput processes the queries read from the connection, which is watched if a handler
+// blocks on Done meanwhile.
+func (c *Client) processInput(queries Querys) bool {
+	c.watchMutex.Lock()
+	c.processing = true
+	c.watchMutex.Unlock()
+	ok := c.process(queries)
+	return c.stopWatching() && ok
+}
 
-			reply, err = c.s.handler.Handle(NewRequest(c, query))
-			if err != nil {
-				if err == ErrHaltClient {
-					shouldReturn = true
-					reply = NewErrorsReply("ERR connection is closed by the server")
-				} else {
-					reply = NewErrorsReply("ERR internal server error")
-					c.s.logger.Error("fail to handle request: %s", err.Error())
-				}
-			}
+// process handles the queries and sends the replies, false will be returned if the client should be closed.
+func (c *Client) process(queries Querys) bool {
+	c.updateStats(0, 0, len(queries))
 
-			replies = append(replies, reply)
-		}
+	if c.debugEnabled() {
+		c.logger.Debug("read queries", LogKeyQueries, queries)
+	}
 
-		c.s.logger.Debug("send %d replies: \"%s\".", len(replies), replies)
+	var (
+		requests     = make([]Request, len(queries))
+		replies      = slices.Grow(c.replies[:0], len(queries))[:len(queries)]
+		errs         = slices.Grow(c.errs[:0], len(queries))[:len(queries)]
+		shouldReturn bool
+	)
 
-		err = c.conn.SetWriteDeadline(time.Now().Add(c.s.config.RWTimeout))
-		if err != nil {
-			if err == io.EOF {
-				c.s.logger.Debug("receive EOF from %s.", c.conn.RemoteAddr())
-				return
-			}
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				c.s.logger.Warning("write timeout from %s.", c.conn.RemoteAddr())
-				return
-			}
-			c.s.logger.Error("fail to set write deadline: %s.", err.Error())
-			return
+	for i, query := range queries {
+		requests[i].Client = c
+		requests[i].Query = query
+	}
+	for i := 0; i < len(requests); {
+		if n := c.parallelLen(requests[i:]); n > 1 {
+			c.handleParallel(requests[i:i+n], replies[i:i+n], errs[i:i+n])
+			i += n
+			continue
 		}
+		replies[i], errs[i] = c.handle(&requests[i])
+		i++
+	}
 
-		nw, err := c.conn.Write([]byte(replies.Raw()))
-		if err != nil {
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		request := &requests[i]
+		if err == ErrHaltClient {
 			shouldReturn = true
-			c.s.logger.Error("fail to write response: %s.", err.Error())
+			c.setReason(DisconnectHalted)
+			replies[i] = NewErrorsReply("ERR connection is closed by the server")
+			continue
+		}
+		if c.s.config.OnCommandError != nil {
+			c.s.config.OnCommandError(request, err)
 		}
-		c.stats.BytesOut += nw
+		replies[i] = NewErrorsReply("ERR internal server error")
+		c.logger.Error("fail to handle request", LogKeyCommand, request.CommandStr(), LogKeyError, err)
+	}
+	clear(errs)
+	c.errs = errs[:0]
 
-		if shouldReturn {
-			return
+	if c.debugEnabled() {
+		c.logger.Debug("send replies", LogKeyReplies, replies)
+	}
+
+	err := c.Send(replies...)
+	clear(replies)
+	c.replies = replies[:0]
+	if err != nil {
+		c.logger.Debug("fail to send replies", LogKeyError, err)
+		return false
+	}
+
+	return !shouldReturn
+}
+
+// parallelLen counts the leading requests flagged with FlagParallel.
+func (c *Client) parallelLen(requests []Request) int {
+	if c.s.flagged == nil {
+		return 0
+	}
+	n := 0
+	for n < len(requests) && c.s.flagged.Flags(&requests[n])&FlagParallel != 0 {
+		n++
+	}
+	return n
+}
+
+// handleParallel handles the requests concurrently with the parallel workers of the server,
+// it waits for a worker if all of them are busy.
+func (c *Client) handleParallel(requests []Request, replies []Reply, errs []error) {
+	var wg sync.WaitGroup
+	wg.Add(len(requests))
+	for i := range requests {
+		c.s.parallel <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-c.s.parallel
+				wg.Done()
+			}()
+			replies[i], errs[i] = c.handle(&requests[i])
+		}(i)
+	}
+	wg.Wait()
+}
+
+// handle calls the handler with the request, a panic in the handler is recovered and
+// replied as an error, so that the other queries of the client are not affected.
+func (c *Client) handle(request *Request) (reply Reply, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			perr := &PanicError{Value: v, Stack: debug.Stack()}
+			atomic.AddInt64(&c.s.stats.recoveredPanics, 1)
+			c.logger.Error("recover from panic", LogKeyCommand, request.CommandStr(), LogKeyPanic, v, LogKeyStack, string(perr.Stack))
+			if c.s.config.OnCommandError != nil {
+				c.s.config.OnCommandError(request, perr)
+			}
+			reply, err = NewErrorsReply("ERR internal error"), nil
 		}
+	}()
+	if !c.debugEnabled() {
+		return c.s.handler.Handle(request)
 	}
+	start := time.Now()
+	reply, err = c.s.handler.Handle(request)
+	c.logger.Debug("handle query", LogKeyCommand, request.CommandStr(), LogKeyLatency, time.Since(start))
+	return
+}
+
+// Close disconnects the client from outside of the handlers, such as a replica dropped by the
+// primary, the pending replies are discarded.
+func (c *Client) Close() {
+	c.setReason(DisconnectStopped)
+	c.closeOutput()
+	c.closeConn()
 }
 
 func (c *Client) stop() {
 	select {
 	case <-c.closeCh:
-		c.s.logger.Debug("client is already closed: %s.", c.conn.RemoteAddr)
+		c.logger.Debug("client is already closed")
 	default:
-		c.closeCh <- struct{}{}
+		close(c.closeCh)
 	}
 }
@@ -0,0 +1,181 @@
+// Package client implements a RESP client for talking to beam and redis servers.
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/caeret/beam"
+)
+
+// New creates a Client with a connection pool.
+func New(opts Options) *Client {
+	c := new(Client)
+	c.opts = opts.withDefaults()
+	c.sem = make(chan struct{}, c.opts.PoolSize)
+	return c
+}
+
+// Client is a pool of connections which is safe for concurrent use.
+type Client struct {
+	opts   Options
+	sem    chan struct{}
+	mutex  sync.Mutex
+	idle   []*Conn
+	closed bool
+}
+
+// Get retrieves a connection from the pool, it blocks if all the connections are in use.
+// The connection must be returned by Put.
+func (c *Client) Get(ctx context.Context) (*Conn, error) {
+	select {
+	case c.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		<-c.sem
+		return nil, ErrClosed
+	}
+	for len(c.idle) > 0 {
+		conn := c.idle[len(c.idle)-1]
+		c.idle = c.idle[:len(c.idle)-1]
+		if c.opts.IdleTimeout > 0 && time.Since(conn.usedAt) > c.opts.IdleTimeout {
+			_ = conn.Close()
+			continue
+		}
+		c.mutex.Unlock()
+		return conn, nil
+	}
+	c.mutex.Unlock()
+
+	conn, err := Dial(ctx, c.opts)
+	if err != nil {
+		<-c.sem
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Put returns the connection to the pool, the broken connections are closed.
+func (c *Client) Put(conn *Conn) {
+	defer func() { <-c.sem }()
+
+	if conn.Err() != nil || conn.pending > 0 {
+		_ = conn.Close()
+		return
+	}
+	conn.usedAt = time.Now()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed || len(c.idle) >= c.opts.MaxIdle {
+		_ = conn.Close()
+		return
+	}
+	c.idle = append(c.idle, conn)
+}
+
+// Do sends the command with a pooled connection and receives the reply,
+// the error reply is returned with the Error.
+func (c *Client) Do(ctx context.Context, cmd string, args ...interface{}) (beam.Reply, error) {
+	conn, err := c.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Put(conn)
+	return conn.Do(ctx, cmd, args...)
+}
+
+// DoQuery sends the query with a pooled connection and receives the reply as Do.
+func (c *Client) DoQuery(ctx context.Context, query beam.Query) (beam.Reply, error) {
+	conn, err := c.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Put(conn)
+	return conn.DoQuery(ctx, query)
+}
+
+// Pipeline creates a Pipeline which sends the commands in one round trip.
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{c: c}
+}
+
+// Close closes the idle connections, the connections in use are closed when they are returned.
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	for _, conn := range c.idle {
+		_ = conn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+// Pipeline queues the commands and executes them in one round trip.
+type Pipeline struct {
+	c       *Client
+	queries beam.Querys
+	err     error
+}
+
+// Do queues the command.
+func (p *Pipeline) Do(cmd string, args ...interface{}) {
+	query, err := NewQuery(cmd, args...)
+	if err != nil && p.err == nil {
+		p.err = err
+	}
+	p.queries = append(p.queries, query)
+}
+
+// Len retrieves the number of the queued commands.
+func (p *Pipeline) Len() int {
+	return len(p.queries)
+}
+
+// Exec sends the queued commands and receives their replies in order, the queue is reset after that.
+// The error replies are kept in the replies, the returned error only reports the failure of the exchange.
+func (p *Pipeline) Exec(ctx context.Context) ([]beam.Reply, error) {
+	queries, err := p.queries, p.err
+	p.queries, p.err = nil, nil
+	if err != nil {
+		return nil, err
+	}
+	if len(queries) == 0 {
+		return nil, nil
+	}
+
+	conn, err := p.c.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer p.c.Put(conn)
+
+	for _, query := range queries {
+		if _, err = conn.bw.Write(query.AppendRaw(conn.bw.AvailableBuffer())); err != nil {
+			return nil, conn.fatal(err)
+		}
+	}
+	conn.pending += len(queries)
+	if err = conn.Flush(ctx); err != nil {
+		return nil, err
+	}
+
+	replies := make([]beam.Reply, len(queries))
+	for i := range replies {
+		replies[i], err = conn.Receive(ctx)
+		if conn.Err() != nil {
+			return nil, conn.Err()
+		}
+	}
+	return replies, nil
+}
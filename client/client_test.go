@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caeret/beam"
+	"github.com/stretchr/testify/assert"
+)
+
+func startServer(t *testing.T) string {
+	var (
+		storage sync.Map
+		handler = beam.NewMappedHandler()
+	)
+	handler.SetFunc("GET", func(request *beam.Request) (beam.Reply, error) {
+		v, ok := storage.Load(request.ArgStr(0))
+		if !ok {
+			return beam.NewBulkStringsReplyRaw(nil), nil
+		}
+		return beam.NewBulkStringsReplyRaw(v.([]byte)), nil
+	})
+	handler.SetFunc("SET", func(request *beam.Request) (beam.Reply, error) {
+		storage.Store(request.ArgStr(0), request.Arg(1))
+		return beam.NewSimpleStringsReply("OK"), nil
+	})
+	handler.SetFunc("SLEEP", func(request *beam.Request) (beam.Reply, error) {
+		time.Sleep(time.Millisecond * 200)
+		return beam.NewSimpleStringsReply("OK"), nil
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := beam.NewServer(handler, beam.Config{})
+	go s.ServeListener(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+func TestClient_Do(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	c := New(Options{Addr: startServer(t), PoolSize: 2})
+	defer c.Close()
+
+	ok, err := Bool(c.Do(ctx, "SET", "foo", 42))
+	assert.Nil(err)
+	assert.True(ok)
+
+	n, err := Int(c.Do(ctx, "GET", "foo"))
+	assert.Nil(err)
+	assert.Equal(42, n)
+
+	_, err = String(c.Do(ctx, "GET", "bar"))
+	assert.Equal(ErrNil, err)
+
+	_, err = c.Do(ctx, "UNKNOWN")
+	assert.Equal(Error("ERR unknown command 'UNKNOWN'"), err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Do(ctx, "GET", "foo")
+			assert.Nil(err)
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(len(c.idle), 2)
+}
+
+func TestClient_Pipeline(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	c := New(Options{Addr: startServer(t)})
+	defer c.Close()
+
+	p := c.Pipeline()
+	p.Do("SET", "foo", []byte("bar"))
+	p.Do("GET", "foo")
+	p.Do("UNKNOWN")
+	assert.Equal(3, p.Len())
+
+	replies, err := p.Exec(ctx)
+	assert.Nil(err)
+	assert.Equal([]beam.Reply{
+		beam.Reply("+OK\r\n"),
+		beam.Reply("$3\r\nbar\r\n"),
+		beam.Reply("-ERR unknown command 'UNKNOWN'\r\n"),
+	}, replies)
+	assert.Equal(0, p.Len())
+
+	p.Do("GET", struct{}{})
+	_, err = p.Exec(ctx)
+	assert.NotNil(err)
+}
+
+func TestClient_Context(t *testing.T) {
+	assert := assert.New(t)
+	c := New(Options{Addr: startServer(t)})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err := c.Do(ctx, "SLEEP")
+	assert.Equal(context.DeadlineExceeded, err)
+	assert.Empty(c.idle)
+
+	_, err = String(c.Do(context.Background(), "GET", "foo"))
+	assert.Equal(ErrNil, err)
+}
@@ -0,0 +1,295 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/caeret/beam"
+)
+
+// ErrClosed will be returned when using a closed Conn or Client.
+var ErrClosed = errors.New("client: closed")
+
+const defaultBufferSize = 16 * 1024
+
+// Options provides the configuration of the connections.
+type Options struct {
+	Network      string
+	Addr         string
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// PoolSize limits the number of connections of a Client, the default is 10.
+	PoolSize int
+	// MaxIdle limits the number of idle connections of a Client, the default is PoolSize.
+	MaxIdle int
+	// IdleTimeout closes the connections which stay idle for longer, zero means never.
+	IdleTimeout time.Duration
+	// Dialer overrides the default dialer if provided.
+	Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+func (opts Options) withDefaults() Options {
+	if len(opts.Network) == 0 {
+		opts.Network = "tcp"
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = time.Second * 5
+	}
+	if opts.ReadTimeout <= 0 {
+		opts.ReadTimeout = time.Second * 5
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = time.Second * 5
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 10
+	}
+	if opts.MaxIdle <= 0 || opts.MaxIdle > opts.PoolSize {
+		opts.MaxIdle = opts.PoolSize
+	}
+	if opts.Dialer == nil {
+		var d net.Dialer
+		opts.Dialer = d.DialContext
+	}
+	return opts
+}
+
+// Dial connects to the RESP server with the given options.
+func Dial(ctx context.Context, opts Options) (*Conn, error) {
+	opts = opts.withDefaults()
+	dialCtx, cancel := context.WithTimeout(ctx, opts.DialTimeout)
+	defer cancel()
+	conn, err := opts.Dialer(dialCtx, opts.Network, opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn, opts), nil
+}
+
+// NewConn wraps the established connection.
+func NewConn(conn net.Conn, opts Options) *Conn {
+	opts = opts.withDefaults()
+	c := new(Conn)
+	c.conn = conn
+	c.opts = opts
+	c.br = bufio.NewReaderSize(conn, defaultBufferSize)
+	c.bw = bufio.NewWriterSize(conn, defaultBufferSize)
+	return c
+}
+
+// Conn is a single connection to the RESP server, which is not safe for concurrent use.
+// The commands can be pipelined with Send, Flush and Receive.
+type Conn struct {
+	conn    net.Conn
+	opts    Options
+	br      *bufio.Reader
+	bw      *bufio.Writer
+	pending int
+	err     error
+	usedAt  time.Time
+}
+
+// Err returns the fatal error of the connection, the connection is not usable if it is not nil.
+func (c *Conn) Err() error {
+	return c.err
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	if c.err == nil {
+		c.err = ErrClosed
+	}
+	return c.conn.Close()
+}
+
+// Send writes the command to the output buffer without flushing.
+func (c *Conn) Send(cmd string, args ...interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+	query, err := NewQuery(cmd, args...)
+	if err != nil {
+		return err
+	}
+	return c.SendQuery(query)
+}
+
+// SendQuery writes the query to the output buffer without flushing.
+func (c *Conn) SendQuery(query beam.Query) error {
+	if c.err != nil {
+		return c.err
+	}
+	if _, err := c.bw.Write(query.AppendRaw(c.bw.AvailableBuffer())); err != nil {
+		return c.fatal(err)
+	}
+	c.pending++
+	return nil
+}
+
+// Flush writes the buffered commands to the server.
+func (c *Conn) Flush(ctx context.Context) error {
+	if c.err != nil {
+		return c.err
+	}
+	stop := c.watch(ctx, c.opts.WriteTimeout)
+	if err := stop(c.bw.Flush()); err != nil {
+		return c.fatal(err)
+	}
+	return nil
+}
+
+// Receive reads the reply of the earliest pending command, the error reply is returned
+// with the Error.
+func (c *Conn) Receive(ctx context.Context) (beam.Reply, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	stop := c.watch(ctx, c.opts.ReadTimeout)
+	reply, err := ReadReply(c.br)
+	if err = stop(err); err != nil {
+		return nil, c.fatal(err)
+	}
+	if c.pending > 0 {
+		c.pending--
+	}
+	return reply, reply.Err()
+}
+
+// Do sends the command and receives all the pending replies, the reply of the command is returned.
+func (c *Conn) Do(ctx context.Context, cmd string, args ...interface{}) (beam.Reply, error) {
+	if err := c.Send(cmd, args...); err != nil {
+		return nil, err
+	}
+	return c.receiveAll(ctx)
+}
+
+// DoQuery sends the query and receives all the pending replies as Do.
+func (c *Conn) DoQuery(ctx context.Context, query beam.Query) (beam.Reply, error) {
+	if err := c.SendQuery(query); err != nil {
+		return nil, err
+	}
+	return c.receiveAll(ctx)
+}
+
+// receiveAll flushes the commands and receives all the pending replies, the last one is returned.
+func (c *Conn) receiveAll(ctx context.Context) (beam.Reply, error) {
+	if err := c.Flush(ctx); err != nil {
+		return nil, err
+	}
+	var (
+		reply beam.Reply
+		err   error
+	)
+	for c.pending > 0 {
+		reply, err = c.Receive(ctx)
+		if c.err != nil {
+			return nil, err
+		}
+	}
+	return reply, err
+}
+
+// watch sets the deadline of the connection by the timeout and ctx, the deadline is moved to
+// the past if ctx is done. The returned stop function replaces the error with the error of ctx.
+func (c *Conn) watch(ctx context.Context, timeout time.Duration) (stop func(err error) error) {
+	deadline := time.Now().Add(timeout)
+	ctxDeadline, hasDeadline := ctx.Deadline()
+	if hasDeadline && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	_ = c.conn.SetDeadline(deadline)
+
+	stopAfter := func() bool { return false }
+	if ctx.Done() != nil {
+		stopAfter = context.AfterFunc(ctx, func() {
+			_ = c.conn.SetDeadline(time.Unix(1, 0))
+		})
+	}
+	return func(err error) error {
+		stopAfter()
+		if err == nil {
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		// the connection deadline may fire slightly earlier than ctx.
+		if hasDeadline && !time.Now().Before(ctxDeadline) {
+			return context.DeadlineExceeded
+		}
+		return err
+	}
+}
+
+// fatal records the error, after which the connection is not usable.
+func (c *Conn) fatal(err error) error {
+	if c.err == nil {
+		c.err = err
+		_ = c.conn.Close()
+	}
+	return err
+}
+
+// NewQuery creates the Query with the arguments, which can be strings, bytes, numbers or bools.
+func NewQuery(cmd string, args ...interface{}) (beam.Query, error) {
+	query := beam.Query{Command: []byte(cmd), Arguments: make([][]byte, len(args))}
+	for i, arg := range args {
+		b, err := formatArg(arg)
+		if err != nil {
+			return query, err
+		}
+		query.Arguments[i] = b
+	}
+	return query, nil
+}
+
+func formatArg(arg interface{}) ([]byte, error) {
+	switch v := arg.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case int:
+		return strconv.AppendInt(nil, int64(v), 10), nil
+	case int8:
+		return strconv.AppendInt(nil, int64(v), 10), nil
+	case int16:
+		return strconv.AppendInt(nil, int64(v), 10), nil
+	case int32:
+		return strconv.AppendInt(nil, int64(v), 10), nil
+	case int64:
+		return strconv.AppendInt(nil, v, 10), nil
+	case uint:
+		return strconv.AppendUint(nil, uint64(v), 10), nil
+	case uint8:
+		return strconv.AppendUint(nil, uint64(v), 10), nil
+	case uint16:
+		return strconv.AppendUint(nil, uint64(v), 10), nil
+	case uint32:
+		return strconv.AppendUint(nil, uint64(v), 10), nil
+	case uint64:
+		return strconv.AppendUint(nil, v, 10), nil
+	case float32:
+		return strconv.AppendFloat(nil, float64(v), 'f', -1, 32), nil
+	case float64:
+		return strconv.AppendFloat(nil, v, 'f', -1, 64), nil
+	case bool:
+		if v {
+			return []byte("1"), nil
+		}
+		return []byte("0"), nil
+	case time.Duration:
+		return strconv.AppendInt(nil, v.Milliseconds(), 10), nil
+	case fmt.Stringer:
+		return []byte(v.String()), nil
+	case nil:
+		return []byte{}, nil
+	}
+	return nil, fmt.Errorf("client: unsupported argument type %T", arg)
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"errors"
+
+	"github.com/caeret/beam"
+)
+
+// ErrNil will be returned when converting a nil reply.
+var ErrNil = beam.ErrNilReply
+
+// Error represents the error reply from the server.
+type Error = beam.ReplyError
+
+// Bytes converts the reply to bytes.
+func Bytes(reply beam.Reply, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	return reply.Bytes()
+}
+
+// String converts the reply to string.
+func String(reply beam.Reply, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	return reply.Text()
+}
+
+// Int64 converts the reply to int64.
+func Int64(reply beam.Reply, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	return reply.Int()
+}
+
+// Int converts the reply to int.
+func Int(reply beam.Reply, err error) (int, error) {
+	n, err := Int64(reply, err)
+	return int(n), err
+}
+
+// Float64 converts the reply to float64.
+func Float64(reply beam.Reply, err error) (float64, error) {
+	if err != nil {
+		return 0, err
+	}
+	return reply.Float()
+}
+
+// Bool converts the reply to bool, the integer replies are true if they are not zero,
+// and "+OK" is true.
+func Bool(reply beam.Reply, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	if string(reply) == "+OK\r\n" {
+		return true, nil
+	}
+	return reply.Bool()
+}
+
+// Values splits the aggregate reply to the replies of its elements,
+// the map replies are flattened to the key value pairs.
+func Values(reply beam.Reply, err error) ([]beam.Reply, error) {
+	if err != nil {
+		return nil, err
+	}
+	return reply.Array()
+}
+
+// Strings converts the aggregate reply to strings, the nil elements are converted to empty strings.
+func Strings(reply beam.Reply, err error) ([]string, error) {
+	replies, err := Values(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	strs := make([]string, len(replies))
+	for i, r := range replies {
+		strs[i], err = r.Text()
+		if err != nil && err != ErrNil {
+			return nil, err
+		}
+	}
+	return strs, nil
+}
+
+// StringMap converts the map reply, or the array reply with key value pairs to a map.
+func StringMap(reply beam.Reply, err error) (map[string]string, error) {
+	strs, err := Strings(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	if len(strs)%2 != 0 {
+		return nil, errors.New("client: odd number of elements for a map")
+	}
+	m := make(map[string]string, len(strs)/2)
+	for i := 0; i < len(strs); i += 2 {
+		m[strs[i]] = strs[i+1]
+	}
+	return m, nil
+}
@@ -0,0 +1,195 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/caeret/beam"
+)
+
+// maxBulkLength is the same as the proto-max-bulk-len default of redis.
+const maxBulkLength = 512 << 20
+
+var (
+	// ErrProtocol will be returned when the server replies an invalid protocol sequence.
+	ErrProtocol = errors.New("client: invalid reply format")
+)
+
+// ReadReply reads one complete RESP2 or RESP3 reply from r, the raw bytes of the reply are returned.
+func ReadReply(r *bufio.Reader) (beam.Reply, error) {
+	b, err := readReply(r, nil, 0)
+	if err != nil {
+		if err == io.EOF && len(b) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// readReply appends the reply to buf, depth is the number of the aggregates enclosing it, the
+// replies nested deeper than beam.MaxReplyDepth are rejected.
+func readReply(r *bufio.Reader, buf []byte, depth int) ([]byte, error) {
+	start := len(buf)
+	buf, err := readLine(r, buf)
+	if err != nil {
+		return buf, err
+	}
+	line := buf[start : len(buf)-2]
+	if len(line) == 0 {
+		return buf, ErrProtocol
+	}
+
+	prefix := line[0]
+	switch prefix {
+	case beam.SimpleStringsReplyPrefix, beam.ErrorsReplyPrefix, beam.DoubleReplyPrefix, beam.BigNumberReplyPrefix:
+		return buf, nil
+	case beam.IntegersReplyPrefix:
+		if _, err = strconv.ParseInt(string(line[1:]), 10, 64); err != nil {
+			return buf, ErrProtocol
+		}
+		return buf, nil
+	case beam.NullReplyPrefix:
+		if len(line) != 1 {
+			return buf, ErrProtocol
+		}
+		return buf, nil
+	case beam.BooleanReplyPrefix:
+		if len(line) != 2 || (line[1] != 't' && line[1] != 'f') {
+			return buf, ErrProtocol
+		}
+		return buf, nil
+	case beam.BulkStringsReplyPrefix, beam.BlobErrorReplyPrefix, beam.VerbatimStringReplyPrefix:
+		if bytes.Equal(line[1:], []byte("?")) {
+			return readStreamedString(r, buf)
+		}
+		n, err := parseLength(line[1:], prefix == beam.BulkStringsReplyPrefix)
+		if err != nil || n < 0 {
+			return buf, err
+		}
+		return readBulk(r, buf, n)
+	case beam.ArraysReplyPrefix, beam.SetReplyPrefix, beam.PushReplyPrefix, beam.MapReplyPrefix, beam.AttributeReplyPrefix:
+		if depth >= beam.MaxReplyDepth {
+			return buf, ErrProtocol
+		}
+		paired := prefix == beam.MapReplyPrefix || prefix == beam.AttributeReplyPrefix
+		if bytes.Equal(line[1:], []byte("?")) {
+			return readStreamedAggregate(r, buf, depth+1)
+		}
+		n, err := parseLength(line[1:], prefix == beam.ArraysReplyPrefix)
+		if err != nil || n < 0 {
+			return buf, err
+		}
+		if paired {
+			n *= 2
+		}
+		for i := 0; i < n; i++ {
+			if buf, err = readReply(r, buf, depth+1); err != nil {
+				return buf, err
+			}
+		}
+		if prefix == beam.AttributeReplyPrefix {
+			// the attribute is followed by the actual reply, the chained attributes are counted
+			// as the nesting so that they can not grow the stack without a bound.
+			return readReply(r, buf, depth+1)
+		}
+		return buf, nil
+	}
+	return buf, ErrProtocol
+}
+
+// readStreamedString reads the chunks of a streamed string until the zero length chunk.
+func readStreamedString(r *bufio.Reader, buf []byte) ([]byte, error) {
+	for {
+		start := len(buf)
+		var err error
+		buf, err = readLine(r, buf)
+		if err != nil {
+			return buf, err
+		}
+		line := buf[start : len(buf)-2]
+		if len(line) == 0 || line[0] != ';' {
+			return buf, ErrProtocol
+		}
+		n, err := parseLength(line[1:], false)
+		if err != nil {
+			return buf, err
+		}
+		if n == 0 {
+			return buf, nil
+		}
+		if buf, err = readBulk(r, buf, n); err != nil {
+			return buf, err
+		}
+	}
+}
+
+// readStreamedAggregate reads the elements of a streamed aggregate until the end marker, depth is
+// the depth of the elements.
+func readStreamedAggregate(r *bufio.Reader, buf []byte, depth int) ([]byte, error) {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return buf, err
+		}
+		if b[0] == '.' {
+			start := len(buf)
+			if buf, err = readLine(r, buf); err != nil {
+				return buf, err
+			}
+			if len(buf)-start != 3 {
+				return buf, ErrProtocol
+			}
+			return buf, nil
+		}
+		if buf, err = readReply(r, buf, depth); err != nil {
+			return buf, err
+		}
+	}
+}
+
+// readLine appends the line ends with crlf to buf.
+func readLine(r *bufio.Reader, buf []byte) ([]byte, error) {
+	for {
+		line, err := r.ReadSlice('\n')
+		buf = append(buf, line...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return buf, err
+		}
+		if len(buf) < 2 || buf[len(buf)-2] != '\r' {
+			return buf, ErrProtocol
+		}
+		return buf, nil
+	}
+}
+
+// readBulk appends the n bytes data and the trailing crlf to buf.
+func readBulk(r *bufio.Reader, buf []byte, n int) ([]byte, error) {
+	start := len(buf)
+	buf = append(buf, make([]byte, n+2)...)
+	if _, err := io.ReadFull(r, buf[start:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return buf, err
+	}
+	if buf[len(buf)-2] != '\r' || buf[len(buf)-1] != '\n' {
+		return buf, ErrProtocol
+	}
+	return buf, nil
+}
+
+// parseLength parses the length of bulk or aggregate replies, -1 is allowed if nullable.
+func parseLength(b []byte, nullable bool) (int, error) {
+	n, err := strconv.Atoi(string(b))
+	if err != nil || n < -1 || (n == -1 && !nullable) || n > maxBulkLength {
+		return 0, ErrProtocol
+	}
+	return n, nil
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/caeret/beam"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadReply(t *testing.T) {
+	assert := assert.New(t)
+	replies := []string{
+		"+OK\r\n",
+		"-ERR unknown command\r\n",
+		":-42\r\n",
+		"$3\r\nfoo\r\n",
+		"$-1\r\n",
+		"$0\r\n\r\n",
+		"*-1\r\n",
+		"*2\r\n$3\r\nfoo\r\n*1\r\n:1\r\n",
+		"_\r\n",
+		",3.14\r\n",
+		"#t\r\n",
+		"!21\r\nSYNTAX invalid syntax\r\n",
+		"=15\r\ntxt:Some string\r\n",
+		"(3492890328409238509324850943850943825024385\r\n",
+		"%2\r\n+first\r\n:1\r\n+second\r\n:2\r\n",
+		"~2\r\n+a\r\n+b\r\n",
+		">2\r\n+message\r\n+hello\r\n",
+		"|1\r\n+ttl\r\n:3600\r\n$3\r\nbar\r\n",
+		"$?\r\n;4\r\nHell\r\n;1\r\no\r\n;0\r\n",
+		"*?\r\n:1\r\n:2\r\n.\r\n",
+	}
+	r := bufio.NewReader(strings.NewReader(strings.Join(replies, "")))
+	for _, expected := range replies {
+		reply, err := ReadReply(r)
+		assert.Nil(err)
+		assert.Equal(beam.Reply(expected), reply)
+	}
+	_, err := ReadReply(r)
+	assert.Equal(io.EOF, err)
+
+	_, err = ReadReply(bufio.NewReader(strings.NewReader("$3\r\nfo")))
+	assert.Equal(io.ErrUnexpectedEOF, err)
+
+	for _, invalid := range []string{"?\r\n", ":abc\r\n", "$-2\r\n", "#x\r\n", "$3\r\nfooo\r\n", "+OK\n"} {
+		_, err = ReadReply(bufio.NewReader(strings.NewReader(invalid)))
+		assert.Equal(ErrProtocol, err, invalid)
+	}
+
+	// the aggregates nested deeper than the limit are rejected.
+	nested := func(depth int) string {
+		return strings.Repeat("*1\r\n", depth) + ":1\r\n"
+	}
+	reply, err := ReadReply(bufio.NewReader(strings.NewReader(nested(beam.MaxReplyDepth))))
+	assert.Nil(err)
+	assert.Equal(beam.Reply(nested(beam.MaxReplyDepth)), reply)
+	for _, invalid := range []string{
+		nested(beam.MaxReplyDepth + 1),
+		strings.Repeat("*?\r\n", 1000000),
+		strings.Repeat("|0\r\n", 1000000) + ":1\r\n",
+	} {
+		_, err = ReadReply(bufio.NewReader(strings.NewReader(invalid)))
+		assert.Equal(ErrProtocol, err)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := String(beam.Reply("$3\r\nfoo\r\n"), nil)
+	assert.Nil(err)
+	assert.Equal("foo", s)
+
+	s, err = String(beam.Reply("=15\r\ntxt:Some string\r\n"), nil)
+	assert.Nil(err)
+	assert.Equal("Some string", s)
+
+	s, err = String(beam.Reply("$?\r\n;4\r\nHell\r\n;1\r\no\r\n;0\r\n"), nil)
+	assert.Nil(err)
+	assert.Equal("Hello", s)
+
+	_, err = String(beam.Reply("$-1\r\n"), nil)
+	assert.Equal(ErrNil, err)
+
+	_, err = String(beam.Reply("-ERR failed\r\n"), nil)
+	assert.Equal(Error("ERR failed"), err)
+
+	_, err = Int(beam.Reply("!21\r\nSYNTAX invalid syntax\r\n"), nil)
+	assert.Equal(Error("SYNTAX invalid syntax"), err)
+
+	n, err := Int64(beam.Reply(":-42\r\n"), nil)
+	assert.Nil(err)
+	assert.EqualValues(-42, n)
+
+	f, err := Float64(beam.Reply(",3.14\r\n"), nil)
+	assert.Nil(err)
+	assert.Equal(3.14, f)
+
+	b, err := Bool(beam.Reply("#t\r\n"), nil)
+	assert.Nil(err)
+	assert.True(b)
+
+	b, err = Bool(beam.Reply(":0\r\n"), nil)
+	assert.Nil(err)
+	assert.False(b)
+
+	strs, err := Strings(beam.Reply("*3\r\n$3\r\nfoo\r\n$-1\r\n+bar\r\n"), nil)
+	assert.Nil(err)
+	assert.Equal([]string{"foo", "", "bar"}, strs)
+
+	strs, err = Strings(beam.Reply("*?\r\n:1\r\n:2\r\n.\r\n"), nil)
+	assert.Nil(err)
+	assert.Equal([]string{"1", "2"}, strs)
+
+	m, err := StringMap(beam.Reply("%2\r\n+first\r\n:1\r\n+second\r\n:2\r\n"), nil)
+	assert.Nil(err)
+	assert.Equal(map[string]string{"first": "1", "second": "2"}, m)
+
+	s, err = String(beam.Reply("|1\r\n+ttl\r\n:3600\r\n$3\r\nbar\r\n"), nil)
+	assert.Nil(err)
+	assert.Equal("bar", s)
+
+	values, err := Values(beam.Reply("*2\r\n$3\r\nfoo\r\n*1\r\n:1\r\n"), nil)
+	assert.Nil(err)
+	assert.Equal([]beam.Reply{beam.Reply("$3\r\nfoo\r\n"), beam.Reply("*1\r\n:1\r\n")}, values)
+
+	_, err = Values(beam.Reply("+OK\r\n"), nil)
+	assert.NotNil(err)
+}
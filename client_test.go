@@ -0,0 +1,446 @@
+package beam
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_Hooks(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		reason   DisconnectReason
+		cmdError error
+	)
+	handler := HandleFunc(func(request *Request) (Reply, error) {
+		if request.CommandStr() == "FAIL" {
+			return nil, errors.New("failed")
+		}
+		return NewSimpleStringsReply(request.GetAttr("user").(string)), nil
+	})
+	s := NewServer(handler, Config{
+		RWTimeout: time.Millisecond * 50,
+		OnConnect: func(client *Client) error {
+			client.SetAttr("user", "foo")
+			return nil
+		},
+		OnDisconnect: func(client *Client, r DisconnectReason) {
+			reason = r
+		},
+		OnCommandError: func(request *Request, err error) {
+			cmdError = err
+		},
+	})
+
+	conn, peer := net.Pipe()
+	s.startClient(s.createClient(conn, s.config.BufferSize))
+
+	r := bufio.NewReader(peer)
+	_, err := peer.Write([]byte("WHOAMI\r\n"))
+	assert.Nil(err)
+	line, err := r.ReadString('\n')
+	assert.Nil(err)
+	assert.Equal("+foo\r\n", line)
+
+	_, err = peer.Write([]byte("FAIL\r\n"))
+	assert.Nil(err)
+	line, err = r.ReadString('\n')
+	assert.Nil(err)
+	assert.Equal("-ERR internal server error\r\n", line)
+	assert.EqualError(cmdError, "failed")
+
+	peer.Close()
+	s.clientsWait.Wait()
+	assert.Equal(DisconnectClientClosed, reason)
+}
+
+func TestClient_OnConnectReject(t *testing.T) {
+	assert := assert.New(t)
+
+	var reason DisconnectReason
+	s := NewServer(HandleFunc(func(request *Request) (Reply, error) {
+		return NewSimpleStringsReply("OK"), nil
+	}), Config{
+		RWTimeout: time.Millisecond * 50,
+		OnConnect: func(client *Client) error {
+			return errors.New("max number of clients reached")
+		},
+		OnDisconnect: func(client *Client, r DisconnectReason) {
+			reason = r
+		},
+	})
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	s.startClient(s.createClient(conn, s.config.BufferSize))
+
+	line, err := bufio.NewReader(peer).ReadString('\n')
+	assert.Nil(err)
+	assert.Equal("-ERR max number of clients reached\r\n", line)
+
+	s.clientsWait.Wait()
+	assert.Equal(DisconnectRejected, reason)
+}
+
+func TestClient_ConcurrentAttrsAndStats(t *testing.T) {
+	assert := assert.New(t)
+	s := NewServer(HandleFunc(func(request *Request) (Reply, error) {
+		request.SetAttr("last", request.CommandStr())
+		return NewSimpleStringsReply("OK"), nil
+	}), Config{RWTimeout: time.Millisecond * 50})
+
+	conn, peer := net.Pipe()
+	client := s.createClient(conn, s.config.BufferSize)
+	s.startClient(client)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = client.Stats()
+			_ = client.Attrs()
+			_, _ = GetAttrAs[string](client, "last")
+			_ = len(s.Clients())
+		}
+	}()
+
+	r := bufio.NewReader(peer)
+	for i := 0; i < 10; i++ {
+		_, err := peer.Write([]byte("PING\r\n"))
+		assert.Nil(err)
+		_, err = r.ReadString('\n')
+		assert.Nil(err)
+	}
+	<-done
+
+	last, ok := GetAttrAs[string](client, "last")
+	assert.True(ok)
+	assert.Equal("PING", last)
+	_, ok = GetAttrAs[int](client, "last")
+	assert.False(ok)
+
+	peer.Close()
+	s.clientsWait.Wait()
+	stats := client.Stats()
+	assert.Equal(10, stats.Queries)
+	assert.Equal(60, stats.BytesIn)
+	assert.Equal(50, stats.BytesOut)
+}
+
+func TestClient_RecoverPanic(t *testing.T) {
+	assert := assert.New(t)
+
+	var cmdError error
+	s := NewServer(HandleFunc(func(request *Request) (Reply, error) {
+		if request.CommandStr() == "PANIC" {
+			var m map[string]int
+			m["foo"] = 1
+		}
+		return NewSimpleStringsReply("OK"), nil
+	}), Config{
+		RWTimeout: time.Millisecond * 50,
+		OnCommandError: func(request *Request, err error) {
+			cmdError = err
+		},
+	})
+
+	conn, peer := net.Pipe()
+	s.startClient(s.createClient(conn, s.config.BufferSize))
+
+	r := bufio.NewReader(peer)
+	_, err := peer.Write([]byte("PING\r\nPANIC\r\nPING\r\n"))
+	assert.Nil(err)
+	for _, expected := range []string{"+OK\r\n", "-ERR internal error\r\n", "+OK\r\n"} {
+		line, err := r.ReadString('\n')
+		assert.Nil(err)
+		assert.Equal(expected, line)
+	}
+
+	var perr *PanicError
+	assert.True(errors.As(cmdError, &perr))
+	assert.NotEmpty(perr.Stack)
+	assert.EqualValues(1, s.Stats().RecoveredPanics)
+
+	peer.Close()
+	s.clientsWait.Wait()
+}
+
+func TestClient_ProtocolError(t *testing.T) {
+	assert := assert.New(t)
+
+	var reason DisconnectReason
+	s := NewServer(HandleFunc(func(request *Request) (Reply, error) {
+		return NewSimpleStringsReply("OK"), nil
+	}), Config{
+		RWTimeout: time.Millisecond * 50,
+		OnDisconnect: func(client *Client, r DisconnectReason) {
+			reason = r
+		},
+	})
+
+	conn, peer := net.Pipe()
+	s.startClient(s.createClient(conn, s.config.BufferSize))
+
+	_, err := peer.Write([]byte("PING\r\n*1\r\n$-1\r\n"))
+	assert.Nil(err)
+	data, err := io.ReadAll(peer)
+	assert.Nil(err)
+	assert.Equal("+OK\r\n-ERR Protocol error: invalid bulk length\r\n", string(data))
+
+	s.clientsWait.Wait()
+	assert.Equal(DisconnectProtocolError, reason)
+}
+
+func TestClient_QueryTooLarge(t *testing.T) {
+	assert := assert.New(t)
+
+	var reason DisconnectReason
+	s := NewServer(HandleFunc(func(request *Request) (Reply, error) {
+		return NewSimpleStringsReply("OK"), nil
+	}), Config{
+		RWTimeout:  time.Millisecond * 50,
+		BufferSize: 64,
+		OnDisconnect: func(client *Client, r DisconnectReason) {
+			reason = r
+		},
+	})
+
+	conn, peer := net.Pipe()
+	s.startClient(s.createClient(conn, s.config.BufferSize))
+
+	// the argument is allowed by MaxBulkLength, but the query does not fit in the buffer.
+	go peer.Write([]byte("PING\r\n*1\r\n$100\r\n" + strings.Repeat("x", 100) + "\r\n"))
+	data, err := io.ReadAll(peer)
+	assert.Nil(err)
+	assert.Equal("+OK\r\n-ERR Protocol error: too big query\r\n", string(data))
+
+	s.clientsWait.Wait()
+	assert.Equal(DisconnectQueryTooLarge, reason)
+}
+
+func TestClient_ParallelPipeline(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		running    int32
+		maxRunning int32
+	)
+	mh := NewMappedHandler()
+	mh.SetFunc("GET", func(request *Request) (Reply, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond * 20)
+		return NewBulkStringsReply(request.ArgStr(0)), nil
+	})
+	mh.SetFunc("SET", func(request *Request) (Reply, error) {
+		if atomic.LoadInt32(&running) != 0 {
+			return NewErrorsReply("ERR running concurrently"), nil
+		}
+		return NewSimpleStringsReply("OK"), nil
+	})
+	mh.SetFlags("GET", FlagParallel)
+	s := NewServer(mh, Config{RWTimeout: time.Millisecond * 50, ParallelWorkers: 8})
+
+	conn, peer := net.Pipe()
+	s.startClient(s.createClient(conn, s.config.BufferSize))
+
+	var (
+		pipeline Querys
+		expected strings.Builder
+	)
+	for i := 0; i < 20; i++ {
+		if i == 10 {
+			// the command without the flag is not handled concurrently with the others.
+			pipeline = append(pipeline, NewQuery("SET", "key", "value"))
+			expected.WriteString("+OK\r\n")
+		}
+		key := strconv.Itoa(i)
+		pipeline = append(pipeline, NewQuery("GET", key))
+		expected.WriteString(string(NewBulkStringsReply(key)))
+	}
+
+	start := time.Now()
+	_, err := peer.Write([]byte(pipeline.Raw()))
+	assert.Nil(err)
+	buf := make([]byte, expected.Len())
+	_, err = io.ReadFull(peer, buf)
+	assert.Nil(err)
+	assert.Equal(expected.String(), string(buf))
+	assert.Less(time.Since(start), time.Millisecond*20*10)
+	assert.EqualValues(8, atomic.LoadInt32(&maxRunning))
+
+	peer.Close()
+	s.clientsWait.Wait()
+}
+
+func TestClient_Done(t *testing.T) {
+	assert := assert.New(t)
+
+	blocked := make(chan struct{})
+	s := NewServer(HandleFunc(func(request *Request) (Reply, error) {
+		close(blocked)
+		<-request.Done()
+		return NewErrorsReply("ERR server closed"), nil
+	}), Config{})
+
+	conn, peer := net.Pipe()
+	s.startClient(s.createClient(conn, s.config.BufferSize))
+	go io.Copy(io.Discard, peer)
+	_, err := peer.Write([]byte("BLOCK\r\n"))
+	assert.Nil(err)
+	<-blocked
+
+	// the blocking handler returns after the server is closed, so the client stops.
+	assert.Nil(s.Close())
+	s.clientsWait.Wait()
+	peer.Close()
+}
+
+func TestClient_DoneOnHangup(t *testing.T) {
+	assert := assert.New(t)
+
+	var reason DisconnectReason
+	blocked := make(chan struct{}, 1)
+	release := make(chan struct{})
+	returned := make(chan struct{})
+	s := NewServer(HandleFunc(func(request *Request) (Reply, error) {
+		switch request.CommandStr() {
+		case "BLOCK":
+			blocked <- struct{}{}
+			select {
+			case <-request.Done():
+			case <-release:
+			}
+		case "WAIT":
+			blocked <- struct{}{}
+			<-request.Done()
+			close(returned)
+		}
+		return NewSimpleStringsReply("OK"), nil
+	}), Config{
+		RWTimeout: time.Millisecond * 50,
+		OnDisconnect: func(client *Client, r DisconnectReason) {
+			reason = r
+		},
+	})
+
+	conn, peer := net.Pipe()
+	s.startClient(s.createClient(conn, s.config.BufferSize))
+	r := bufio.NewReader(peer)
+
+	// the queries sent while the handler blocks are read by the watcher and handled after it.
+	_, err := peer.Write([]byte("BLOCK\r\n"))
+	assert.Nil(err)
+	<-blocked
+	_, err = peer.Write([]byte("PING\r\nPI"))
+	assert.Nil(err)
+	close(release)
+	_, err = peer.Write([]byte("NG\r\n"))
+	assert.Nil(err)
+	for i := 0; i < 3; i++ {
+		line, err := r.ReadString('\n')
+		assert.Nil(err)
+		assert.Equal("+OK\r\n", line)
+	}
+
+	// the handler blocking on Done returns once the client hangs up.
+	_, err = peer.Write([]byte("WAIT\r\n"))
+	assert.Nil(err)
+	<-blocked
+	peer.Close()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Error("the handler is not returned")
+	}
+	s.clientsWait.Wait()
+	assert.Equal(DisconnectClientClosed, reason)
+}
+
+func TestClient_Close(t *testing.T) {
+	assert := assert.New(t)
+
+	var reason DisconnectReason
+	clients := make(chan *Client, 1)
+	s := NewServer(HandleFunc(func(request *Request) (Reply, error) {
+		clients <- request.Client
+		return NewSimpleStringsReply("OK"), nil
+	}), Config{
+		RWTimeout: time.Millisecond * 50,
+		OnDisconnect: func(client *Client, r DisconnectReason) {
+			reason = r
+		},
+	})
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	s.startClient(s.createClient(conn, s.config.BufferSize))
+	r := bufio.NewReader(peer)
+	_, err := peer.Write([]byte("PING\r\n"))
+	assert.Nil(err)
+	line, err := r.ReadString('\n')
+	assert.Nil(err)
+	assert.Equal("+OK\r\n", line)
+
+	client := <-clients
+	client.Close()
+	_, err = r.ReadString('\n')
+	assert.Equal(io.EOF, err)
+	s.clientsWait.Wait()
+	assert.Equal(DisconnectStopped, reason)
+	assert.Equal(ErrClientClosed, client.Send(NewSimpleStringsReply("OK")))
+}
+
+func BenchmarkClient_Pipeline(b *testing.B) {
+	var value []byte
+	mh := NewMappedHandler()
+	mh.SetFunc("GET", func(request *Request) (Reply, error) {
+		return NewBulkStringsReplyRaw(value), nil
+	})
+	mh.SetFunc("SET", func(request *Request) (Reply, error) {
+		value = request.Arg(1)
+		return NewSimpleStringsReply("OK"), nil
+	})
+	s := NewServer(mh, Config{})
+
+	conn, peer := net.Pipe()
+	s.startClient(s.createClient(conn, s.config.BufferSize))
+	defer func() {
+		peer.Close()
+		s.clientsWait.Wait()
+	}()
+
+	var pipeline Querys
+	for i := 0; i < 10; i++ {
+		pipeline = append(pipeline, NewQuery("SET", "key", "value"), NewQuery("GET", "key"))
+	}
+	raw := []byte(pipeline.Raw())
+	expected := len("+OK\r\n$5\r\nvalue\r\n") * 10
+	buf := make([]byte, expected)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := peer.Write(raw); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.ReadFull(peer, buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
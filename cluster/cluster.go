@@ -0,0 +1,275 @@
+// Package cluster makes the beam servers look like a redis cluster to the cluster clients.
+// The keys of the commands are mapped to the hash slots, and the commands for the slots which are
+// not served by this node are redirected with -MOVED or -ASK.
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/caeret/beam"
+)
+
+// Node is a node of the cluster.
+type Node struct {
+	// ID is the unique name of the node, redis uses 40 hex characters.
+	ID string
+	// Addr is the host:port which the clients connect to.
+	Addr string
+}
+
+// SlotRange is the inclusive range of the hash slots.
+type SlotRange struct {
+	Start int
+	End   int
+}
+
+// Shard is a master node with its replicas serving the slots.
+type Shard struct {
+	Master   Node
+	Replicas []Node
+	Slots    []SlotRange
+}
+
+// Config describes the cluster.
+type Config struct {
+	// Self is the ID of this node.
+	Self string
+	// Shards are the shards of the cluster, this node included.
+	Shards []Shard
+	// Commands provides the key positions of the commands, the default is DefaultCommands.
+	Commands CommandTable
+	// Exists checks if the key exists on this node. The keys of the slots migrating from this node
+	// are redirected with -ASK if they do not exist, all of them are redirected if Exists is nil.
+	Exists func(request *beam.Request, key []byte) bool
+}
+
+// Cluster keeps the slot ownership, it implements beam.Middleware to redirect the commands
+// and to reply the CLUSTER commands.
+type Cluster struct {
+	config Config
+	self   *node
+	nodes  []*node
+	byID   map[string]*node
+
+	mutex     sync.RWMutex
+	owners    [SlotCount]*node
+	migrating map[int]*node
+	importing map[int]*node
+}
+
+// node is a Node with its role and shard.
+type node struct {
+	Node
+	host   string
+	port   int
+	master *node
+	shard  int
+}
+
+// New creates a Cluster from the config.
+func New(config Config) (*Cluster, error) {
+	if config.Commands == nil {
+		config.Commands = DefaultCommands
+	}
+	c := &Cluster{
+		config:    config,
+		byID:      make(map[string]*node),
+		migrating: make(map[int]*node),
+		importing: make(map[int]*node),
+	}
+	for i, shard := range config.Shards {
+		master, err := c.addNode(shard.Master, nil, i)
+		if err != nil {
+			return nil, err
+		}
+		for _, replica := range shard.Replicas {
+			if _, err = c.addNode(replica, master, i); err != nil {
+				return nil, err
+			}
+		}
+		for _, r := range shard.Slots {
+			if r.Start < 0 || r.End >= SlotCount || r.Start > r.End {
+				return nil, fmt.Errorf("cluster: invalid slot range %d-%d", r.Start, r.End)
+			}
+			for slot := r.Start; slot <= r.End; slot++ {
+				if c.owners[slot] != nil {
+					return nil, fmt.Errorf("cluster: slot %d is assigned to both %s and %s", slot, c.owners[slot].ID, master.ID)
+				}
+				c.owners[slot] = master
+			}
+		}
+	}
+	c.self = c.byID[config.Self]
+	if c.self == nil {
+		return nil, fmt.Errorf("cluster: node %s is not in the shards", config.Self)
+	}
+	return c, nil
+}
+
+func (c *Cluster) addNode(n Node, master *node, shard int) (*node, error) {
+	if n.ID == "" {
+		return nil, errors.New("cluster: node id is empty")
+	}
+	if _, exist := c.byID[n.ID]; exist {
+		return nil, fmt.Errorf("cluster: duplicate node %s", n.ID)
+	}
+	host, port, err := net.SplitHostPort(n.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("cluster: invalid address of node %s: %w", n.ID, err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("cluster: invalid port of node %s: %w", n.ID, err)
+	}
+	nd := &node{Node: n, host: host, port: p, master: master, shard: shard}
+	c.nodes = append(c.nodes, nd)
+	c.byID[n.ID] = nd
+	return nd, nil
+}
+
+// Owner retrieves the master node serving the slot, false will be returned if the slot is not assigned.
+func (c *Cluster) Owner(slot int) (Node, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if n := c.owners[slot]; n != nil {
+		return n.Node, true
+	}
+	return Node{}, false
+}
+
+// AssignSlot assigns the slot to the master node, the migrating and importing states of the slot
+// are cleared, just like CLUSTER SETSLOT NODE.
+func (c *Cluster) AssignSlot(slot int, id string) error {
+	n, err := c.masterNode(slot, id)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.owners[slot] = n
+	delete(c.migrating, slot)
+	delete(c.importing, slot)
+	return nil
+}
+
+// MigrateSlot marks the slot of this node migrating to the master node, the missing keys of the slot
+// are redirected with -ASK.
+func (c *Cluster) MigrateSlot(slot int, id string) error {
+	n, err := c.masterNode(slot, id)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.owners[slot] != c.self {
+		return fmt.Errorf("cluster: slot %d is not served by this node", slot)
+	}
+	c.migrating[slot] = n
+	return nil
+}
+
+// ImportSlot marks the slot importing from the master node to this node, the commands of the slot
+// are served after ASKING.
+func (c *Cluster) ImportSlot(slot int, id string) error {
+	n, err := c.masterNode(slot, id)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.importing[slot] = n
+	return nil
+}
+
+func (c *Cluster) masterNode(slot int, id string) (*node, error) {
+	if slot < 0 || slot >= SlotCount {
+		return nil, fmt.Errorf("cluster: invalid slot %d", slot)
+	}
+	n := c.byID[id]
+	if n == nil {
+		return nil, fmt.Errorf("cluster: unknown node %s", id)
+	}
+	if n.master != nil {
+		return nil, fmt.Errorf("cluster: node %s is not a master", id)
+	}
+	return n, nil
+}
+
+// ranges retrieves the contiguous slot ranges of each master, the read lock must be held.
+func (c *Cluster) ranges() map[*node][]SlotRange {
+	ranges := make(map[*node][]SlotRange)
+	for slot := 0; slot < SlotCount; {
+		owner := c.owners[slot]
+		start := slot
+		for slot < SlotCount && c.owners[slot] == owner {
+			slot++
+		}
+		if owner != nil {
+			ranges[owner] = append(ranges[owner], SlotRange{Start: start, End: slot - 1})
+		}
+	}
+	return ranges
+}
+
+// route checks if the keys of the request are served by this node, the error reply will be returned if not.
+func (c *Cluster) route(request *beam.Request, asking bool) beam.Reply {
+	slot := -1
+	cross := false
+	var keys [][]byte
+	c.config.Commands.eachKey(request.Query, func(key []byte) bool {
+		s := Slot(key)
+		if slot >= 0 && s != slot {
+			cross = true
+			return false
+		}
+		slot = s
+		keys = append(keys, key)
+		return true
+	})
+	if cross {
+		return beam.NewErrorsReply("CROSSSLOT Keys in request don't hash to the same slot")
+	}
+	if slot < 0 {
+		return nil
+	}
+
+	c.mutex.RLock()
+	owner := c.owners[slot]
+	migrating := c.migrating[slot]
+	importing := c.importing[slot]
+	c.mutex.RUnlock()
+
+	switch {
+	case owner == nil:
+		return beam.NewErrorsReply("CLUSTERDOWN Hash slot not served")
+	case owner == c.self:
+		if migrating != nil && !c.allExist(request, keys) {
+			return redirect("ASK", slot, migrating)
+		}
+		return nil
+	case importing != nil && asking:
+		return nil
+	}
+	// the replicas redirect the clients to their masters too.
+	return redirect("MOVED", slot, owner)
+}
+
+func (c *Cluster) allExist(request *beam.Request, keys [][]byte) bool {
+	if c.config.Exists == nil {
+		return false
+	}
+	for _, key := range keys {
+		if !c.config.Exists(request, key) {
+			return false
+		}
+	}
+	return true
+}
+
+func redirect(kind string, slot int, n *node) beam.Reply {
+	return beam.NewErrorsReply(kind + " " + strconv.Itoa(slot) + " " + n.Addr)
+}
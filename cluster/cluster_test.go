@@ -0,0 +1,168 @@
+package cluster
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/caeret/beam"
+	"github.com/caeret/beam/beamtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func testShards() []Shard {
+	return []Shard{
+		{
+			Master:   Node{ID: "a", Addr: "127.0.0.1:7000"},
+			Replicas: []Node{{ID: "a1", Addr: "127.0.0.1:7003"}},
+			Slots:    []SlotRange{{Start: 0, End: 5460}},
+		},
+		{
+			Master: Node{ID: "b", Addr: "127.0.0.1:7001"},
+			Slots:  []SlotRange{{Start: 5461, End: 10922}},
+		},
+		{
+			Master: Node{ID: "c", Addr: "127.0.0.1:7002"},
+			Slots:  []SlotRange{{Start: 10923, End: 16383}},
+		},
+	}
+}
+
+func newTestServer(t *testing.T, config Config) (*Cluster, *beamtest.Conn) {
+	c, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mh := beam.NewMappedHandler()
+	mh.SetFunc("GET", func(request *beam.Request) (beam.Reply, error) {
+		return beam.NewBulkStringsReply("value of " + request.ArgStr(0)), nil
+	})
+	mh.SetFunc("MGET", func(request *beam.Request) (beam.Reply, error) {
+		return beam.NewArraysReply("v1", "v2"), nil
+	})
+	handler := beam.NewHandlerChain(mh)
+	handler.Add(c)
+	return c, beamtest.NewServer(t, handler, beam.Config{}).Dial()
+}
+
+func TestNew(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := New(Config{Self: "d", Shards: testShards()})
+	assert.EqualError(err, "cluster: node d is not in the shards")
+
+	shards := testShards()
+	shards[1].Slots = append(shards[1].Slots, SlotRange{Start: 100, End: 100})
+	_, err = New(Config{Self: "a", Shards: shards})
+	assert.EqualError(err, "cluster: slot 100 is assigned to both a and b")
+
+	shards = testShards()
+	shards[2].Master.Addr = "7002"
+	_, err = New(Config{Self: "a", Shards: shards})
+	assert.ErrorContains(err, "cluster: invalid address of node c")
+
+	c, err := New(Config{Self: "a", Shards: testShards()})
+	assert.Nil(err)
+	owner, ok := c.Owner(KeySlot("foo"))
+	assert.True(ok)
+	assert.Equal("c", owner.ID)
+	assert.Error(c.MigrateSlot(KeySlot("foo"), "b"))
+	assert.Error(c.AssignSlot(0, "a1"))
+}
+
+func TestCluster_Redirect(t *testing.T) {
+	shards := testShards()
+	shards[2].Slots = []SlotRange{{Start: 10923, End: 12000}}
+	keys := map[string]bool{"bar": true}
+	c, conn := newTestServer(t, Config{
+		Self:   "a",
+		Shards: shards,
+		Exists: func(request *beam.Request, key []byte) bool {
+			return keys[string(key)]
+		},
+	})
+
+	// bar is in slot 5061 of this node, and foo is in slot 12182.
+	beamtest.AssertBulkString(t, "value of bar", conn.Do("GET", "bar"))
+	beamtest.AssertError(t, "CLUSTERDOWN Hash slot not served", conn.Do("GET", "foo"))
+	beamtest.AssertError(t, "CROSSSLOT", conn.Do("MGET", "foo", "bar"))
+	beamtest.AssertStrings(t, []string{"v1", "v2"}, conn.Do("MGET", "{bar}1", "{bar}2"))
+	beamtest.AssertError(t, "ERR unknown command 'PING'", conn.Do("PING"))
+
+	assert.Nil(t, c.AssignSlot(KeySlot("foo"), "c"))
+	beamtest.AssertError(t, "MOVED 12182 127.0.0.1:7002", conn.Do("GET", "foo"))
+
+	// the missing keys of the migrating slot are redirected with ASK.
+	assert.Nil(t, c.MigrateSlot(KeySlot("bar"), "b"))
+	beamtest.AssertBulkString(t, "value of bar", conn.Do("GET", "bar"))
+	beamtest.AssertError(t, "ASK 5061 127.0.0.1:7001", conn.Do("GET", "{bar}1"))
+	beamtest.AssertError(t, "ASK 5061 127.0.0.1:7001", conn.Do("MGET", "bar", "{bar}1"))
+
+	// the keys of the importing slot are served after ASKING.
+	assert.Nil(t, c.ImportSlot(KeySlot("foo"), "c"))
+	beamtest.AssertError(t, "MOVED 12182 127.0.0.1:7002", conn.Do("GET", "foo"))
+	beamtest.AssertOK(t, conn.Do("ASKING"))
+	beamtest.AssertBulkString(t, "value of foo", conn.Do("GET", "foo"))
+	beamtest.AssertError(t, "MOVED 12182 127.0.0.1:7002", conn.Do("GET", "foo"))
+
+	assert.Nil(t, c.AssignSlot(KeySlot("bar"), "b"))
+	beamtest.AssertError(t, "MOVED 5061 127.0.0.1:7001", conn.Do("GET", "bar"))
+}
+
+func TestCluster_Replica(t *testing.T) {
+	_, conn := newTestServer(t, Config{Self: "a1", Shards: testShards()})
+	beamtest.AssertError(t, "MOVED 5061 127.0.0.1:7000", conn.Do("GET", "bar"))
+}
+
+func TestCluster_Commands(t *testing.T) {
+	assert := assert.New(t)
+	c, conn := newTestServer(t, Config{Self: "a", Shards: testShards()})
+
+	beamtest.AssertInteger(t, 12182, conn.Do("CLUSTER", "KEYSLOT", "foo"))
+	beamtest.AssertBulkString(t, "a", conn.Do("cluster", "myid"))
+	beamtest.AssertError(t, "ERR wrong number of arguments for 'cluster|keyslot' command", conn.Do("CLUSTER", "KEYSLOT"))
+	beamtest.AssertError(t, "ERR unknown subcommand 'foo'", conn.Do("CLUSTER", "foo"))
+
+	beamtest.AssertReply(t, beam.Reply(
+		"*3\r\n"+
+			"*4\r\n:0\r\n:5460\r\n*3\r\n$9\r\n127.0.0.1\r\n:7000\r\n$1\r\na\r\n*3\r\n$9\r\n127.0.0.1\r\n:7003\r\n$2\r\na1\r\n"+
+			"*3\r\n:5461\r\n:10922\r\n*3\r\n$9\r\n127.0.0.1\r\n:7001\r\n$1\r\nb\r\n"+
+			"*3\r\n:10923\r\n:16383\r\n*3\r\n$9\r\n127.0.0.1\r\n:7002\r\n$1\r\nc\r\n"),
+		conn.Do("CLUSTER", "SLOTS"))
+
+	shards, err := conn.Do("CLUSTER", "SHARDS").Array()
+	if assert.Nil(err) && assert.Len(shards, 3) {
+		shard, err := shards[0].Map()
+		assert.Nil(err)
+		slots, _ := shard["slots"].Array()
+		assert.Equal(":0\r\n:5460\r\n", beam.Replies(slots).Raw())
+		nodes, _ := shard["nodes"].Array()
+		if assert.Len(nodes, 2) {
+			replica, err := nodes[1].Map()
+			assert.Nil(err)
+			id, _ := replica["id"].Text()
+			role, _ := replica["role"].Text()
+			port, _ := replica["port"].Int()
+			assert.Equal("a1", id)
+			assert.Equal("replica", role)
+			assert.EqualValues(7003, port)
+		}
+	}
+
+	assert.Nil(c.MigrateSlot(100, "b"))
+	assert.Nil(c.ImportSlot(6000, "b"))
+	nodes, err := conn.Do("CLUSTER", "NODES").Text()
+	assert.Nil(err)
+	assert.Equal([]string{
+		"a 127.0.0.1:7000@17000 myself,master - 0 0 1 connected 0-5460 [100->-b] [6000-<-b]",
+		"a1 127.0.0.1:7003@17003 slave a 0 0 1 connected",
+		"b 127.0.0.1:7001@17001 master - 0 0 2 connected 5461-10922",
+		"c 127.0.0.1:7002@17002 master - 0 0 3 connected 10923-16383",
+		"",
+	}, strings.Split(nodes, "\n"))
+
+	info, err := conn.Do("CLUSTER", "INFO").Text()
+	assert.Nil(err)
+	assert.Contains(info, "cluster_state:ok\r\n")
+	assert.Contains(info, "cluster_known_nodes:4\r\n")
+	assert.Contains(info, "cluster_size:3\r\n")
+}
@@ -0,0 +1,150 @@
+package cluster
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/caeret/beam"
+)
+
+// KeySpec describes the positions of the keys in a command as the COMMAND of redis does,
+// the command itself is at position 0.
+type KeySpec struct {
+	// First is the position of the first key, 0 if the keys are not positional.
+	First int
+	// Last is the position of the last key, the negative one counts from the end, such as -1 for the
+	// last argument.
+	Last int
+	// Step is the distance between the keys, such as 2 for MSET.
+	Step int
+	// NumKeys is the position of the argument specifying the number of the keys following it, such as EVAL.
+	// The keys are in addition to the positional ones, such as the destination of ZUNIONSTORE.
+	NumKeys int
+	// Keyword is the argument followed by the keys and then as many values, such as "STREAMS" of XREAD.
+	Keyword string
+}
+
+// CommandTable maps the upper case command names to their key positions.
+type CommandTable map[string]KeySpec
+
+// Set sets the key positions of the command.
+func (t CommandTable) Set(command string, spec KeySpec) {
+	t[strings.ToUpper(command)] = spec
+}
+
+// Keys retrieves the keys of the query, nil will be returned if the command is unknown or has no key.
+func (t CommandTable) Keys(query beam.Query) (keys [][]byte) {
+	t.eachKey(query, func(key []byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return
+}
+
+func (t CommandTable) lookup(command []byte) (KeySpec, bool) {
+	// the upper case commands are looked up without allocation.
+	if spec, ok := t[string(command)]; ok {
+		return spec, true
+	}
+	spec, ok := t[strings.ToUpper(string(command))]
+	return spec, ok
+}
+
+// eachKey calls fn with each key of the query until false is returned.
+func (t CommandTable) eachKey(query beam.Query, fn func(key []byte) bool) {
+	spec, ok := t.lookup(query.Command)
+	if !ok {
+		return
+	}
+	// arg retrieves the argument at the position of the spec.
+	arg := func(pos int) []byte {
+		return query.Arguments[pos-1]
+	}
+	total := len(query.Arguments) + 1
+
+	if spec.First > 0 {
+		last := spec.Last
+		if last < 0 {
+			last += total
+		}
+		step := max(spec.Step, 1)
+		for pos := spec.First; pos <= last && pos < total; pos += step {
+			if !fn(arg(pos)) {
+				return
+			}
+		}
+	}
+	if spec.NumKeys > 0 && spec.NumKeys < total {
+		n, err := strconv.Atoi(string(arg(spec.NumKeys)))
+		if err != nil {
+			return
+		}
+		for pos := spec.NumKeys + 1; pos <= spec.NumKeys+n && pos < total; pos++ {
+			if !fn(arg(pos)) {
+				return
+			}
+		}
+	}
+	if spec.Keyword != "" {
+		for pos := 1; pos < total; pos++ {
+			if !strings.EqualFold(string(arg(pos)), spec.Keyword) {
+				continue
+			}
+			n := (total - pos - 1) / 2
+			for i := pos + 1; i <= pos+n; i++ {
+				if !fn(arg(i)) {
+					return
+				}
+			}
+			return
+		}
+	}
+}
+
+// DefaultCommands is the key positions of the redis commands.
+var DefaultCommands = func() CommandTable {
+	t := make(CommandTable)
+	set := func(spec KeySpec, commands ...string) {
+		for _, command := range commands {
+			t.Set(command, spec)
+		}
+	}
+	set(KeySpec{First: 1, Last: 1, Step: 1},
+		// keys and strings
+		"GET", "SET", "SETNX", "SETEX", "PSETEX", "APPEND", "STRLEN", "INCR", "DECR", "INCRBY", "DECRBY",
+		"INCRBYFLOAT", "GETSET", "GETDEL", "GETEX", "GETRANGE", "SETRANGE", "GETBIT", "SETBIT", "BITCOUNT",
+		"BITPOS", "EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT", "EXPIRETIME", "PEXPIRETIME", "TTL", "PTTL",
+		"PERSIST", "TYPE", "DUMP", "RESTORE", "SORT", "SORT_RO",
+		// hashes
+		"HSET", "HSETNX", "HGET", "HMSET", "HMGET", "HDEL", "HLEN", "HSTRLEN", "HEXISTS", "HKEYS", "HVALS",
+		"HGETALL", "HINCRBY", "HINCRBYFLOAT", "HRANDFIELD", "HSCAN",
+		// lists
+		"LPUSH", "RPUSH", "LPUSHX", "RPUSHX", "LPOP", "RPOP", "LLEN", "LINDEX", "LSET", "LRANGE", "LTRIM",
+		"LREM", "LINSERT", "LPOS",
+		// sets
+		"SADD", "SREM", "SCARD", "SISMEMBER", "SMISMEMBER", "SMEMBERS", "SPOP", "SRANDMEMBER", "SSCAN",
+		// sorted sets
+		"ZADD", "ZREM", "ZCARD", "ZSCORE", "ZMSCORE", "ZINCRBY", "ZRANK", "ZREVRANK", "ZRANGE", "ZREVRANGE",
+		"ZRANGEBYSCORE", "ZREVRANGEBYSCORE", "ZRANGEBYLEX", "ZREVRANGEBYLEX", "ZCOUNT", "ZLEXCOUNT",
+		"ZREMRANGEBYRANK", "ZREMRANGEBYSCORE", "ZREMRANGEBYLEX", "ZPOPMIN", "ZPOPMAX", "ZRANDMEMBER", "ZSCAN",
+		// streams
+		"XADD", "XLEN", "XRANGE", "XREVRANGE", "XDEL", "XTRIM", "XACK", "XPENDING", "XCLAIM", "XAUTOCLAIM",
+		"XSETID",
+		// others
+		"PFADD", "GEOADD", "GEODIST", "GEOPOS", "GEOHASH", "GEOSEARCH",
+	)
+	set(KeySpec{First: 1, Last: -1, Step: 1},
+		"DEL", "UNLINK", "EXISTS", "TOUCH", "MGET", "WATCH", "SDIFF", "SINTER", "SUNION", "SDIFFSTORE",
+		"SINTERSTORE", "SUNIONSTORE", "PFCOUNT", "PFMERGE")
+	set(KeySpec{First: 1, Last: -1, Step: 2}, "MSET", "MSETNX")
+	set(KeySpec{First: 1, Last: 2, Step: 1},
+		"RENAME", "RENAMENX", "COPY", "SMOVE", "LMOVE", "BLMOVE", "RPOPLPUSH", "BRPOPLPUSH", "ZRANGESTORE",
+		"GEOSEARCHSTORE")
+	set(KeySpec{First: 1, Last: -2, Step: 1}, "BLPOP", "BRPOP", "BZPOPMIN", "BZPOPMAX")
+	set(KeySpec{First: 2, Last: 2, Step: 1}, "XGROUP", "XINFO", "OBJECT")
+	set(KeySpec{NumKeys: 1}, "ZUNION", "ZINTER", "ZDIFF", "ZINTERCARD", "SINTERCARD", "LMPOP", "ZMPOP")
+	set(KeySpec{NumKeys: 2}, "EVAL", "EVALSHA", "EVAL_RO", "EVALSHA_RO", "FCALL", "FCALL_RO", "BLMPOP", "BZMPOP")
+	set(KeySpec{First: 1, Last: 1, Step: 1, NumKeys: 2}, "ZUNIONSTORE", "ZINTERSTORE", "ZDIFFSTORE")
+	set(KeySpec{Keyword: "STREAMS"}, "XREAD", "XREADGROUP")
+	return t
+}()
@@ -0,0 +1,43 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/caeret/beam"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandTable_Keys(t *testing.T) {
+	assert := assert.New(t)
+
+	strs := func(keys [][]byte) (s []string) {
+		for _, key := range keys {
+			s = append(s, string(key))
+		}
+		return
+	}
+	for _, c := range []struct {
+		query beam.Query
+		keys  []string
+	}{
+		{beam.NewQuery("GET", "foo"), []string{"foo"}},
+		{beam.NewQuery("get", "foo"), []string{"foo"}},
+		{beam.NewQuery("PING"), nil},
+		{beam.NewQuery("GET"), nil},
+		{beam.NewQuery("MGET", "a", "b", "c"), []string{"a", "b", "c"}},
+		{beam.NewQuery("MSET", "a", "1", "b", "2"), []string{"a", "b"}},
+		{beam.NewQuery("BLPOP", "a", "b", "0"), []string{"a", "b"}},
+		{beam.NewQuery("RENAME", "a", "b"), []string{"a", "b"}},
+		{beam.NewQuery("EVAL", "return 1", "2", "a", "b", "arg"), []string{"a", "b"}},
+		{beam.NewQuery("ZUNIONSTORE", "dst", "2", "a", "b", "WEIGHTS", "1", "2"), []string{"dst", "a", "b"}},
+		{beam.NewQuery("XREAD", "COUNT", "2", "STREAMS", "a", "b", "0", "0"), []string{"a", "b"}},
+		{beam.NewQuery("XGROUP", "CREATE", "a", "group", "$"), []string{"a"}},
+		{beam.NewQuery("EVAL", "return 1", "x"), nil},
+	} {
+		assert.Equal(c.keys, strs(DefaultCommands.Keys(c.query)), c.query.String())
+	}
+
+	table := CommandTable{}
+	table.Set("mycmd", KeySpec{First: 2, Last: 2, Step: 1})
+	assert.Equal([]string{"key"}, strs(table.Keys(beam.NewQuery("MYCMD", "sub", "key"))))
+}
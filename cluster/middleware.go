@@ -0,0 +1,250 @@
+package cluster
+
+import (
+	"bytes"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/caeret/beam"
+)
+
+// askingAttr marks the client sent ASKING for the next command.
+const askingAttr = "cluster.asking"
+
+// Do implements beam.Middleware, it replies CLUSTER and ASKING, and redirects the commands
+// with the keys not served by this node.
+func (c *Cluster) Do(request *beam.Request, next beam.Handler) (beam.Reply, error) {
+	switch {
+	case bytes.EqualFold(request.Command, []byte("CLUSTER")):
+		return c.cluster(request), nil
+	case bytes.EqualFold(request.Command, []byte("ASKING")):
+		request.SetAttr(askingAttr, true)
+		return beam.NewSimpleStringsReply("OK"), nil
+	}
+
+	// ASKING only affects the next command.
+	asking := request.HasAttr(askingAttr)
+	if asking {
+		request.DelAttr(askingAttr)
+	}
+	if reply := c.route(request, asking); reply != nil {
+		return reply, nil
+	}
+	return next.Handle(request)
+}
+
+func (c *Cluster) cluster(request *beam.Request) beam.Reply {
+	if request.Len() == 0 {
+		return wrongArgs("cluster")
+	}
+	sub := strings.ToUpper(request.ArgStr(0))
+	switch sub {
+	case "SLOTS", "SHARDS", "NODES", "INFO", "MYID":
+		if request.Len() != 1 {
+			return wrongArgs("cluster|" + strings.ToLower(sub))
+		}
+	case "KEYSLOT":
+		if request.Len() != 2 {
+			return wrongArgs("cluster|keyslot")
+		}
+		return beam.NewIntegersReply(Slot(request.Arg(1)))
+	default:
+		return beam.NewErrorsReply("ERR unknown subcommand '" + request.ArgStr(0) + "'. Try CLUSTER HELP.")
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	switch sub {
+	case "SLOTS":
+		return c.slotsReply()
+	case "SHARDS":
+		return c.shardsReply()
+	case "NODES":
+		return beam.NewBulkStringsReply(c.nodesInfo())
+	case "INFO":
+		return beam.NewBulkStringsReply(c.info())
+	}
+	return beam.NewBulkStringsReply(c.self.ID)
+}
+
+func wrongArgs(command string) beam.Reply {
+	return beam.NewErrorsReply("ERR wrong number of arguments for '" + command + "' command")
+}
+
+// slotsReply replies CLUSTER SLOTS, the read lock must be held.
+func (c *Cluster) slotsReply() beam.Reply {
+	type slotRange struct {
+		SlotRange
+		master *node
+	}
+	var all []slotRange
+	for master, ranges := range c.ranges() {
+		for _, r := range ranges {
+			all = append(all, slotRange{SlotRange: r, master: master})
+		}
+	}
+	slices.SortFunc(all, func(a, b slotRange) int {
+		return a.Start - b.Start
+	})
+
+	b := beam.AppendArraysHeader(nil, len(all))
+	for _, r := range all {
+		replicas := c.replicas(r.master)
+		b = beam.AppendArraysHeader(b, 3+len(replicas))
+		b = beam.AppendIntegers(b, int64(r.Start))
+		b = beam.AppendIntegers(b, int64(r.End))
+		b = appendSlotsNode(b, r.master)
+		for _, replica := range replicas {
+			b = appendSlotsNode(b, replica)
+		}
+	}
+	return b
+}
+
+func appendSlotsNode(b []byte, n *node) []byte {
+	b = beam.AppendArraysHeader(b, 3)
+	b = beam.AppendBulkStrings(b, n.host)
+	b = beam.AppendIntegers(b, int64(n.port))
+	return beam.AppendBulkStrings(b, n.ID)
+}
+
+// shardsReply replies CLUSTER SHARDS, the read lock must be held.
+func (c *Cluster) shardsReply() beam.Reply {
+	ranges := c.ranges()
+	masters := c.masters()
+	b := beam.AppendArraysHeader(nil, len(masters))
+	for _, master := range masters {
+		b = beam.AppendArraysHeader(b, 4)
+		b = beam.AppendBulkStrings(b, "slots")
+		b = beam.AppendArraysHeader(b, len(ranges[master])*2)
+		for _, r := range ranges[master] {
+			b = beam.AppendIntegers(b, int64(r.Start))
+			b = beam.AppendIntegers(b, int64(r.End))
+		}
+		replicas := c.replicas(master)
+		b = beam.AppendBulkStrings(b, "nodes")
+		b = beam.AppendArraysHeader(b, 1+len(replicas))
+		b = appendShardsNode(b, master)
+		for _, replica := range replicas {
+			b = appendShardsNode(b, replica)
+		}
+	}
+	return b
+}
+
+func appendShardsNode(b []byte, n *node) []byte {
+	role := "master"
+	if n.master != nil {
+		role = "replica"
+	}
+	b = beam.AppendArraysHeader(b, 14)
+	b = beam.AppendBulkStrings(b, "id")
+	b = beam.AppendBulkStrings(b, n.ID)
+	b = beam.AppendBulkStrings(b, "port")
+	b = beam.AppendIntegers(b, int64(n.port))
+	b = beam.AppendBulkStrings(b, "ip")
+	b = beam.AppendBulkStrings(b, n.host)
+	b = beam.AppendBulkStrings(b, "endpoint")
+	b = beam.AppendBulkStrings(b, n.host)
+	b = beam.AppendBulkStrings(b, "role")
+	b = beam.AppendBulkStrings(b, role)
+	b = beam.AppendBulkStrings(b, "replication-offset")
+	b = beam.AppendIntegers(b, 0)
+	b = beam.AppendBulkStrings(b, "health")
+	return beam.AppendBulkStrings(b, "online")
+}
+
+// nodesInfo formats CLUSTER NODES, the read lock must be held.
+func (c *Cluster) nodesInfo() string {
+	ranges := c.ranges()
+	var sb strings.Builder
+	for _, n := range c.nodes {
+		sb.WriteString(n.ID)
+		sb.WriteByte(' ')
+		sb.WriteString(n.host + ":" + strconv.Itoa(n.port) + "@" + strconv.Itoa(n.port+10000))
+		sb.WriteByte(' ')
+		if n == c.self {
+			sb.WriteString("myself,")
+		}
+		if n.master == nil {
+			sb.WriteString("master -")
+		} else {
+			sb.WriteString("slave " + n.master.ID)
+		}
+		sb.WriteString(" 0 0 " + strconv.Itoa(n.shard+1) + " connected")
+		for _, r := range ranges[n] {
+			sb.WriteByte(' ')
+			sb.WriteString(strconv.Itoa(r.Start))
+			if r.End != r.Start {
+				sb.WriteString("-" + strconv.Itoa(r.End))
+			}
+		}
+		if n == c.self {
+			for _, slot := range sortedSlots(c.migrating) {
+				sb.WriteString(" [" + strconv.Itoa(slot) + "->-" + c.migrating[slot].ID + "]")
+			}
+			for _, slot := range sortedSlots(c.importing) {
+				sb.WriteString(" [" + strconv.Itoa(slot) + "-<-" + c.importing[slot].ID + "]")
+			}
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// info formats CLUSTER INFO, the read lock must be held.
+func (c *Cluster) info() string {
+	assigned := 0
+	for _, owner := range c.owners {
+		if owner != nil {
+			assigned++
+		}
+	}
+	state := "ok"
+	if assigned < SlotCount {
+		state = "fail"
+	}
+	epoch := strconv.Itoa(len(c.masters()))
+	lines := []string{
+		"cluster_state:" + state,
+		"cluster_slots_assigned:" + strconv.Itoa(assigned),
+		"cluster_slots_ok:" + strconv.Itoa(assigned),
+		"cluster_slots_pfail:0",
+		"cluster_slots_fail:0",
+		"cluster_known_nodes:" + strconv.Itoa(len(c.nodes)),
+		"cluster_size:" + strconv.Itoa(len(c.ranges())),
+		"cluster_current_epoch:" + epoch,
+		"cluster_my_epoch:" + strconv.Itoa(c.self.shard+1),
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+func (c *Cluster) masters() []*node {
+	var masters []*node
+	for _, n := range c.nodes {
+		if n.master == nil {
+			masters = append(masters, n)
+		}
+	}
+	return masters
+}
+
+func (c *Cluster) replicas(master *node) []*node {
+	var replicas []*node
+	for _, n := range c.nodes {
+		if n.master == master {
+			replicas = append(replicas, n)
+		}
+	}
+	return replicas
+}
+
+func sortedSlots(m map[int]*node) []int {
+	slots := make([]int, 0, len(m))
+	for slot := range m {
+		slots = append(slots, slot)
+	}
+	slices.Sort(slots)
+	return slots
+}
@@ -0,0 +1,47 @@
+package cluster
+
+import "bytes"
+
+// SlotCount is the number of the hash slots.
+const SlotCount = 16384
+
+// Slot computes the hash slot of the key as redis cluster does. If the key contains a non-empty
+// hashtag, such as "{user1000}.following", only the hashtag is hashed, so that the related keys
+// are in the same slot.
+func Slot(key []byte) int {
+	if start := bytes.IndexByte(key, '{'); start >= 0 {
+		if end := bytes.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) & (SlotCount - 1)
+}
+
+// KeySlot computes the hash slot of the key string.
+func KeySlot(key string) int {
+	return Slot([]byte(key))
+}
+
+// crc16 implements CRC16-CCITT (XMODEM) used by redis cluster.
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^b]
+	}
+	return crc
+}
+
+var crc16Table = func() (table [256]uint16) {
+	for i := range table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return
+}()
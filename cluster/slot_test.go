@@ -0,0 +1,23 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlot(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.EqualValues(0x31c3, crc16([]byte("123456789")))
+	assert.Equal(12182, KeySlot("foo"))
+	assert.Equal(5061, KeySlot("bar"))
+	assert.Equal(0, KeySlot(""))
+
+	// only the hashtag is hashed.
+	assert.Equal(KeySlot("user1000"), KeySlot("{user1000}.following"))
+	assert.Equal(KeySlot("user1000"), KeySlot("foo{user1000}{bar}"))
+	// the empty hashtag is ignored.
+	assert.Equal(crc16([]byte("foo{}{bar}"))&(SlotCount-1), uint16(KeySlot("foo{}{bar}")))
+	assert.Equal(crc16([]byte("foo{bar"))&(SlotCount-1), uint16(KeySlot("foo{bar")))
+}
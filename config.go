@@ -1,6 +1,7 @@
 package beam
 
 import (
+	"log/slog"
 	"time"
 
 	"github.com/gaemma/logging"
@@ -8,10 +9,37 @@ import (
 
 // Config provides the configuration needs by the server.
 type Config struct {
-	Logger      logging.Logger
-	RWTimeout   time.Duration
-	IdleTimeout time.Duration
-	BufferSize  int
-	Network     string
-	Addr        string
+	// Logger is the printf-style logger, it is adapted by NewLoggingHandler.
+	Logger logging.Logger
+	// StructuredLogger receives the structured log records, it takes precedence over Logger.
+	StructuredLogger *slog.Logger
+	RWTimeout        time.Duration
+	IdleTimeout      time.Duration
+	// BufferSize is the size of the input buffer of a client, the default is 16KB. A query must fit in
+	// it, so the arguments allowed by QueryLimits.MaxBulkLength are also limited by it, and the larger
+	// query is replied with a protocol error before the client is closed.
+	BufferSize int
+	Network    string
+	Addr       string
+	// QueryLimits limits the size of the queries, the violations are replied with the protocol errors.
+	QueryLimits QueryLimits
+	// Engine selects how the server serves the connections, EngineGoroutine is the default.
+	Engine Engine
+	// EventLoops is the number of the event loops of EngineEventLoop, the default is GOMAXPROCS.
+	EventLoops int
+	// Workers is the number of the goroutines handling the queries with EngineEventLoop, the default is
+	// 8 * GOMAXPROCS. The queries ready while all of them are busy are handled by the extra goroutines.
+	Workers int
+	// ParallelWorkers limits the pipelined commands flagged with FlagParallel handled concurrently
+	// across the clients, the default is 8 * GOMAXPROCS.
+	ParallelWorkers int
+	// OutputBufferLimits limits the pending reply data of each ClientClass,
+	// the classes which are absent use the same defaults as redis.
+	OutputBufferLimits map[ClientClass]OutputBufferLimit
+	// OnConnect is called when a connection is accepted, the connection is rejected if an error is returned.
+	OnConnect func(client *Client) error
+	// OnDisconnect is called after a connection is closed, the rejected connections included.
+	OnDisconnect func(client *Client, reason DisconnectReason)
+	// OnCommandError is called when the handler fails to handle a request.
+	OnCommandError func(request *Request, err error)
 }
@@ -0,0 +1,222 @@
+package beam
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+var (
+	// ErrNilReply will be returned when accessing the value of a nil reply.
+	ErrNilReply = errors.New("nil reply")
+	// ErrReplyFormat will be returned when decoding a malformed reply.
+	ErrReplyFormat = errors.New("invalid reply format")
+)
+
+// MaxReplyDepth limits the nesting of the aggregate replies which are decoded, including the ones
+// read by the client package, so that a hostile peer can not exhaust the stack.
+const MaxReplyDepth = 128
+
+// ReplyError is the error carried by an error reply.
+type ReplyError string
+
+func (e ReplyError) Error() string {
+	return string(e)
+}
+
+// ReplyTypeError will be returned when the reply can not be accessed as the requested type.
+type ReplyTypeError struct {
+	Type ReplyType
+	Want string
+}
+
+func (e *ReplyTypeError) Error() string {
+	return "unexpected " + e.Type.String() + " reply, want " + e.Want
+}
+
+// replyValue is a decoded reply.
+type replyValue struct {
+	typ   ReplyType
+	data  []byte
+	elems []replyValue
+	raw   []byte
+	null  bool
+}
+
+// decodeReply decodes the complete reply in b.
+func decodeReply(b []byte) (replyValue, error) {
+	v, n, err := decodeReplyValue(b, 0)
+	if err == nil && n != len(b) {
+		err = ErrReplyFormat
+	}
+	return v, err
+}
+
+// decodeReplyValue decodes the reply at the start of b, depth is the number of the aggregates
+// enclosing it.
+func decodeReplyValue(b []byte, depth int) (v replyValue, n int, err error) {
+	end := bytes.Index(b, crlf)
+	if end < 1 {
+		return v, 0, ErrReplyFormat
+	}
+	v.typ = ReplyType(b[0])
+	v.data = b[1:end]
+	n = end + 2
+
+	switch v.typ {
+	case TypeSimpleStrings, TypeErrors, TypeDouble, TypeBigNumber:
+	case TypeIntegers:
+		if _, err = strconv.ParseInt(string(v.data), 10, 64); err != nil {
+			return v, 0, ErrReplyFormat
+		}
+	case TypeNull:
+		if len(v.data) != 0 {
+			return v, 0, ErrReplyFormat
+		}
+		v.null = true
+	case TypeBoolean:
+		if len(v.data) != 1 || (v.data[0] != 't' && v.data[0] != 'f') {
+			return v, 0, ErrReplyFormat
+		}
+	case TypeBulkStrings, TypeBlobError, TypeVerbatimString:
+		if string(v.data) == "?" {
+			v.data, n, err = decodeStreamedString(b, n)
+			if err != nil {
+				return v, 0, err
+			}
+			break
+		}
+		size, err := strconv.Atoi(string(v.data))
+		if err != nil || size < -1 || (size == -1 && v.typ != TypeBulkStrings) {
+			return v, 0, ErrReplyFormat
+		}
+		if size < 0 {
+			v.null = true
+			v.data = nil
+			break
+		}
+		if n+size+2 > len(b) || !bytes.Equal(b[n+size:n+size+2], crlf) {
+			return v, 0, ErrReplyFormat
+		}
+		v.data = b[n : n+size]
+		n += size + 2
+	case TypeArrays, TypeSet, TypePush, TypeMap, TypeAttribute:
+		if depth >= MaxReplyDepth {
+			return v, 0, ErrReplyFormat
+		}
+		streamed := string(v.data) == "?"
+		size := -1
+		if !streamed {
+			size, err = strconv.Atoi(string(v.data))
+			if err != nil || size < -1 || (size == -1 && v.typ != TypeArrays) {
+				return v, 0, ErrReplyFormat
+			}
+			if size < 0 {
+				v.null = true
+				break
+			}
+			if v.typ == TypeMap || v.typ == TypeAttribute {
+				size *= 2
+			}
+		}
+		for i := 0; streamed || i < size; i++ {
+			if streamed && bytes.HasPrefix(b[n:], []byte(".\r\n")) {
+				n += 3
+				break
+			}
+			elem, en, err := decodeReplyValue(b[n:], depth+1)
+			if err != nil {
+				return v, 0, err
+			}
+			v.elems = append(v.elems, elem)
+			n += en
+		}
+		if v.typ == TypeAttribute {
+			// the attribute is skipped and the actual reply is returned, the chained attributes
+			// are counted as the nesting so that they can not grow the stack without a bound.
+			actual, an, err := decodeReplyValue(b[n:], depth+1)
+			if err != nil {
+				return v, 0, err
+			}
+			return actual, n + an, nil
+		}
+	default:
+		return v, 0, ErrReplyFormat
+	}
+	v.raw = b[:n]
+	return v, n, nil
+}
+
+// decodeStreamedString decodes the chunks start from n until the zero length chunk.
+func decodeStreamedString(b []byte, n int) (data []byte, _ int, err error) {
+	data = []byte{}
+	for {
+		end := bytes.Index(b[n:], crlf)
+		if end < 1 || b[n] != ';' {
+			return nil, 0, ErrReplyFormat
+		}
+		size, err := strconv.Atoi(string(b[n+1 : n+end]))
+		if err != nil || size < 0 {
+			return nil, 0, ErrReplyFormat
+		}
+		n += end + 2
+		if size == 0 {
+			return data, n, nil
+		}
+		if n+size+2 > len(b) || !bytes.Equal(b[n+size:n+size+2], crlf) {
+			return nil, 0, ErrReplyFormat
+		}
+		data = append(data, b[n:n+size]...)
+		n += size + 2
+	}
+}
+
+// err returns the ReplyError if v is an error reply.
+func (v replyValue) err() error {
+	switch v.typ {
+	case TypeErrors, TypeBlobError:
+		return ReplyError(v.data)
+	}
+	return nil
+}
+
+// text retrieves the data of the string-like replies.
+func (v replyValue) text() ([]byte, error) {
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	if v.null {
+		return nil, ErrNilReply
+	}
+	switch v.typ {
+	case TypeSimpleStrings, TypeBulkStrings, TypeIntegers, TypeDouble, TypeBigNumber:
+		return v.data, nil
+	case TypeVerbatimString:
+		// skip the format, such as "txt:".
+		if len(v.data) >= 4 && v.data[3] == ':' {
+			return v.data[4:], nil
+		}
+		return v.data, nil
+	case TypeBoolean:
+		if v.data[0] == 't' {
+			return []byte("1"), nil
+		}
+		return []byte("0"), nil
+	}
+	return nil, &ReplyTypeError{Type: v.typ, Want: "string"}
+}
+
+// aggregate retrieves the elements of the aggregate replies.
+func (v replyValue) aggregate() ([]replyValue, error) {
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	if v.null {
+		return nil, ErrNilReply
+	}
+	switch v.typ {
+	case TypeArrays, TypeSet, TypePush, TypeMap:
+		return v.elems, nil
+	}
+	return nil, &ReplyTypeError{Type: v.typ, Want: "aggregate"}
+}
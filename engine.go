@@ -0,0 +1,60 @@
+package beam
+
+import (
+	"errors"
+	"net"
+)
+
+// Engine selects how the server serves the connections.
+type Engine int
+
+const (
+	// EngineGoroutine serves each connection with its own goroutines and read buffer.
+	EngineGoroutine Engine = iota
+	// EngineEventLoop multiplexes the connections over the epoll event loops with shared read buffers,
+	// and runs the handlers on a worker pool. It is only supported on linux, the connections which
+	// are not sockets are still served by EngineGoroutine.
+	EngineEventLoop
+)
+
+func (e Engine) String() string {
+	switch e {
+	case EngineGoroutine:
+		return "goroutine"
+	case EngineEventLoop:
+		return "eventloop"
+	}
+	return "unknown"
+}
+
+// ErrEngineNotSupported will be returned when the engine is not supported on the platform.
+var ErrEngineNotSupported = errors.New("beam: engine is not supported on this platform")
+
+// engine serves the accepted connections.
+type engine interface {
+	// serve takes over the connection.
+	serve(conn net.Conn)
+	// wait waits for the engine to be stopped after the server is closed.
+	wait()
+}
+
+func newEngine(s *Server) (engine, error) {
+	switch s.config.Engine {
+	case EngineGoroutine:
+		return goroutineEngine{s: s}, nil
+	case EngineEventLoop:
+		return newEventLoopEngine(s)
+	}
+	return nil, ErrEngineNotSupported
+}
+
+// goroutineEngine serves each connection with the goroutines.
+type goroutineEngine struct {
+	s *Server
+}
+
+func (e goroutineEngine) serve(conn net.Conn) {
+	e.s.startClient(e.s.createClient(conn, e.s.config.BufferSize))
+}
+
+func (e goroutineEngine) wait() {}
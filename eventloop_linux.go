@@ -0,0 +1,646 @@
+//go:build linux
+
+package beam
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// eventLoopReadBufferSize is the size of the read buffer shared by the connections of an event loop.
+	eventLoopReadBufferSize = 64 * 1024
+	// eventLoopTick bounds the wait of the events, so that the closing of the server and the idle
+	// connections are noticed.
+	eventLoopTick = 100 * time.Millisecond
+
+	epollRead   = syscall.EPOLLIN | syscall.EPOLLRDHUP
+	epollWrite  = syscall.EPOLLOUT
+	epollHangup = syscall.EPOLLRDHUP
+)
+
+var errManagedConn = errors.New("beam: the connection is managed by the event loop")
+
+// eventLoopEngine multiplexes the connections over the epoll event loops, the ready queries are
+// handled by a pool of workers, or the extra goroutines if all the workers are busy.
+type eventLoopEngine struct {
+	s        *Server
+	loops    []*eventLoop
+	next     uint32
+	tasks    chan *loopConn
+	loopWait sync.WaitGroup
+	workWait sync.WaitGroup
+}
+
+func newEventLoopEngine(s *Server) (engine, error) {
+	e := &eventLoopEngine{
+		s:     s,
+		tasks: make(chan *loopConn),
+	}
+	for i := 0; i < s.config.EventLoops; i++ {
+		loop, err := newEventLoop(e)
+		if err != nil {
+			for _, loop := range e.loops {
+				_ = syscall.Close(loop.epfd)
+			}
+			return nil, err
+		}
+		e.loops = append(e.loops, loop)
+	}
+	for _, loop := range e.loops {
+		e.loopWait.Add(1)
+		go protectCall(loop.run, s.logger)
+	}
+	for i := 0; i < s.config.Workers; i++ {
+		e.workWait.Add(1)
+		go protectCall(e.work, s.logger)
+	}
+	return e, nil
+}
+
+func (e *eventLoopEngine) serve(conn net.Conn) {
+	fd, err := detachConn(conn)
+	if err != nil {
+		// the connections which are not sockets, such as net.Pipe, are served by the goroutines.
+		e.s.logger.Debug("serve connection with goroutines", LogKeyRemoteAddr, conn.RemoteAddr().String(), LogKeyError, err)
+		goroutineEngine{s: e.s}.serve(conn)
+		return
+	}
+
+	loop := e.loops[atomic.AddUint32(&e.next, 1)%uint32(len(e.loops))]
+	lc := &loopConn{
+		fd:         fd,
+		loop:       loop,
+		localAddr:  conn.LocalAddr(),
+		remoteAddr: conn.RemoteAddr(),
+		lastActive: time.Now().UnixNano(),
+		// the OnConnect hook is called by a worker before any query is handled.
+		busy: true,
+	}
+	_ = conn.Close()
+
+	client := e.s.createClient(lc, 0)
+	client.wakeup = lc.flush
+	lc.client = client
+	e.s.addClient(client)
+	client.logger.Debug("handle new connection")
+
+	if err = loop.add(lc); err != nil {
+		client.logger.Error("fail to watch connection", LogKeyError, err)
+		lc.close(DisconnectReadError)
+	}
+	select {
+	case <-e.s.closeCh:
+		// the loops may have closed their connections before this one is added.
+		lc.close(DisconnectServerClosed)
+	default:
+	}
+	e.dispatch(lc)
+}
+
+// dispatch hands the connection to an idle worker without blocking. If all the workers are busy,
+// such as blocked by the commands like BLPOP, an extra goroutine handles the queries, so the loop
+// keeps reading the queries which unblock them.
+func (e *eventLoopEngine) dispatch(lc *loopConn) {
+	select {
+	case e.tasks <- lc:
+	default:
+		e.workWait.Add(1)
+		go protectCall(func() {
+			defer e.workWait.Done()
+			lc.work()
+		}, e.s.logger)
+	}
+}
+
+func (e *eventLoopEngine) wait() {
+	e.loopWait.Wait()
+	// no more task is dispatched after the loops are stopped, the workers finish the queued ones.
+	close(e.tasks)
+	e.workWait.Wait()
+}
+
+func (e *eventLoopEngine) work() {
+	defer e.workWait.Done()
+	for lc := range e.tasks {
+		lc.work()
+	}
+}
+
+// detachConn duplicates the file descriptor of the socket and closes the conn, so that the socket
+// is no longer managed by the runtime poller.
+func detachConn(conn net.Conn) (int, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return -1, errors.New("not a syscall.Conn")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	fd := -1
+	var dupErr error
+	err = raw.Control(func(s uintptr) {
+		fd, dupErr = syscall.Dup(int(s))
+	})
+	if err == nil {
+		err = dupErr
+	}
+	if err != nil {
+		return -1, err
+	}
+	syscall.CloseOnExec(fd)
+	if err = syscall.SetNonblock(fd, true); err != nil {
+		_ = syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// eventLoop waits for the events of its connections with epoll.
+type eventLoop struct {
+	e     *eventLoopEngine
+	epfd  int
+	buf   []byte
+	mutex sync.Mutex
+	conns map[int]*loopConn
+}
+
+func newEventLoop(e *eventLoopEngine) (*eventLoop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLoop{
+		e:     e,
+		epfd:  epfd,
+		buf:   make([]byte, eventLoopReadBufferSize),
+		conns: make(map[int]*loopConn),
+	}, nil
+}
+
+func (l *eventLoop) add(lc *loopConn) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	err := syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_ADD, lc.fd, &syscall.EpollEvent{Events: lc.events(), Fd: int32(lc.fd)})
+	if err != nil {
+		return err
+	}
+	l.conns[lc.fd] = lc
+	return nil
+}
+
+func (l *eventLoop) modify(lc *loopConn, events uint32) error {
+	return syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_MOD, lc.fd, &syscall.EpollEvent{Events: events, Fd: int32(lc.fd)})
+}
+
+// remove stops watching the connection, it must be called before the file descriptor is closed.
+func (l *eventLoop) remove(lc *loopConn) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.conns[lc.fd] == lc {
+		delete(l.conns, lc.fd)
+		_ = syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_DEL, lc.fd, nil)
+	}
+}
+
+func (l *eventLoop) get(fd int) *loopConn {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.conns[fd]
+}
+
+func (l *eventLoop) snapshot() []*loopConn {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	conns := make([]*loopConn, 0, len(l.conns))
+	for _, lc := range l.conns {
+		conns = append(conns, lc)
+	}
+	return conns
+}
+
+func (l *eventLoop) run() {
+	defer l.e.loopWait.Done()
+	defer syscall.Close(l.epfd)
+
+	events := make([]syscall.EpollEvent, 128)
+	lastSweep := time.Now()
+	for {
+		n, err := syscall.EpollWait(l.epfd, events, int(eventLoopTick/time.Millisecond))
+		if err != nil && err != syscall.EINTR {
+			l.e.s.logger.Error("fail to wait events", LogKeyError, err)
+			l.closeAll(DisconnectReadError)
+			return
+		}
+		for _, event := range events[:max(n, 0)] {
+			lc := l.get(int(event.Fd))
+			if lc == nil {
+				continue
+			}
+			if event.Events&epollWrite != 0 {
+				lc.flush()
+			}
+			if event.Events&(epollRead|syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
+				lc.read(l.buf)
+			}
+		}
+
+		select {
+		case <-l.e.s.closeCh:
+			l.closeAll(DisconnectServerClosed)
+			return
+		default:
+		}
+
+		if now := time.Now(); now.Sub(lastSweep) >= min(l.e.s.config.IdleTimeout, time.Second) {
+			lastSweep = now
+			l.closeIdle(now)
+		}
+	}
+}
+
+func (l *eventLoop) closeAll(reason DisconnectReason) {
+	for _, lc := range l.snapshot() {
+		lc.close(reason)
+	}
+}
+
+// closeIdle closes the connections which have not sent any query within the idle timeout.
+func (l *eventLoop) closeIdle(now time.Time) {
+	deadline := now.Add(-l.e.s.config.IdleTimeout).UnixNano()
+	for _, lc := range l.snapshot() {
+		if atomic.LoadInt64(&lc.lastActive) < deadline && !lc.isBusy() {
+			lc.client.logger.Debug("deadline exceeded")
+			lc.close(DisconnectIdleTimeout)
+		}
+	}
+}
+
+// loopConn is a connection served by an event loop, the reads are driven by the loop and the
+// replies are written by the goroutine sending them, or the loop once the socket is writable.
+type loopConn struct {
+	fd         int
+	loop       *eventLoop
+	client     *Client
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	lastActive int64
+
+	// mutex guards the input, the queries are handled by at most one worker at a time.
+	mutex     sync.Mutex
+	in        []byte
+	queries   Querys
+	err       error
+	busy      bool
+	connected bool
+	closed    bool
+
+	// fdMutex guards the usage of the file descriptor.
+	fdMutex  sync.Mutex
+	fdClosed bool
+	reading  bool
+	writing  bool
+	closing  bool
+}
+
+// read reads the data from the socket and dispatches the complete queries to the workers.
+func (lc *loopConn) read(buf []byte) {
+	lc.fdMutex.Lock()
+	if lc.fdClosed {
+		lc.fdMutex.Unlock()
+		return
+	}
+	n, err := syscall.Read(lc.fd, buf)
+	closing := lc.closing
+	lc.fdMutex.Unlock()
+
+	switch {
+	case err == syscall.EAGAIN || err == syscall.EINTR:
+		return
+	case err != nil:
+		lc.client.logger.Error("fail to read request", LogKeyError, err)
+		lc.close(DisconnectReadError)
+		return
+	case n == 0:
+		lc.client.logger.Debug("receive EOF")
+		lc.close(DisconnectClientClosed)
+		return
+	}
+	if closing {
+		// the data after the query closing the connection is discarded.
+		return
+	}
+	lc.client.updateStats(n, 0, 0)
+	atomic.StoreInt64(&lc.lastActive, time.Now().UnixNano())
+
+	lc.mutex.Lock()
+	if lc.closed || lc.err != nil {
+		lc.mutex.Unlock()
+		return
+	}
+	data := buf[:n]
+	if lc.busy {
+		// only the hangup is watched while busy, the data sent before it is kept until EOF is read.
+		lc.in = append(lc.in, data...)
+		lc.mutex.Unlock()
+		return
+	}
+	if len(lc.in) > 0 {
+		// the shared buffer is reused by the next read, the data is kept by the connection.
+		lc.in = append(lc.in, data...)
+		data = lc.in
+	}
+	lc.parse(data)
+	// the input only keeps the incomplete query after parsing, the error is replied after the
+	// complete queries are handled.
+	if size := len(lc.in); size >= lc.loop.e.s.config.BufferSize {
+		lc.client.logger.Warn("too large command data", LogKeyBytes, size)
+		lc.client.setReason(DisconnectQueryTooLarge)
+		lc.err = errQueryTooLarge
+		lc.in = nil
+	}
+	dispatch := len(lc.queries) > 0 || lc.err != nil
+	lc.busy = dispatch
+	lc.mutex.Unlock()
+
+	if dispatch {
+		lc.pause()
+		lc.loop.e.dispatch(lc)
+	}
+}
+
+// events returns the events to watch, the fdMutex must be held. The input is not watched while
+// the queries are handled, so the pipelining clients are throttled by the socket buffers rather
+// than buffered by the connection, but the hangup is still watched.
+func (lc *loopConn) events() uint32 {
+	events := uint32(epollHangup)
+	if lc.reading {
+		events |= syscall.EPOLLIN
+	}
+	if lc.writing {
+		events |= epollWrite
+	}
+	return events
+}
+
+// pause stops watching the input before the queries are dispatched to a worker.
+func (lc *loopConn) pause() {
+	lc.fdMutex.Lock()
+	defer lc.fdMutex.Unlock()
+	if lc.fdClosed || !lc.reading {
+		return
+	}
+	lc.reading = false
+	_ = lc.loop.modify(lc, lc.events())
+}
+
+// resume watches the input again once the queries are handled.
+func (lc *loopConn) resume() {
+	lc.fdMutex.Lock()
+	defer lc.fdMutex.Unlock()
+	lc.mutex.Lock()
+	busy := lc.busy
+	lc.mutex.Unlock()
+	if lc.fdClosed || lc.reading || busy {
+		return
+	}
+	lc.reading = true
+	_ = lc.loop.modify(lc, lc.events())
+}
+
+// parse reads the complete queries in data, the remaining data is kept in the input.
+// The protocol error is kept until the queries before it are handled. The mutex must be held.
+func (lc *loopConn) parse(data []byte) {
+	queries, l, err := ReadQueryLimits(data, lc.loop.e.s.config.QueryLimits)
+	lc.queries = queries
+	if err != nil {
+		lc.err = err
+		lc.in = nil
+		return
+	}
+	if len(l) == 0 {
+		lc.in = nil
+	} else {
+		lc.in = append(lc.in[:0], l...)
+	}
+}
+
+func (lc *loopConn) isBusy() bool {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	return lc.busy
+}
+
+// work handles the dispatched queries and the ones completed meanwhile, it is called by the workers.
+func (lc *loopConn) work() {
+	if !lc.connected {
+		lc.connected = true
+		if !lc.client.connect() {
+			lc.closeAfterFlush()
+			lc.done()
+			return
+		}
+	}
+
+	for {
+		lc.mutex.Lock()
+		queries := lc.queries
+		lc.queries = nil
+		lc.mutex.Unlock()
+
+		if len(queries) > 0 && !lc.client.process(queries) {
+			lc.closeAfterFlush()
+			lc.done()
+			return
+		}
+
+		lc.mutex.Lock()
+		if err := lc.err; err != nil {
+			lc.mutex.Unlock()
+			lc.client.protocolError(err)
+			lc.closeAfterFlush()
+			lc.done()
+			return
+		}
+		if lc.closed || len(lc.in) == 0 {
+			lc.mutex.Unlock()
+			lc.done()
+			return
+		}
+		lc.parse(lc.in)
+		if len(lc.queries) == 0 && lc.err == nil {
+			lc.mutex.Unlock()
+			lc.done()
+			return
+		}
+		lc.mutex.Unlock()
+	}
+}
+
+// done marks the connection idle, the client is finished if the connection has been closed meanwhile.
+func (lc *loopConn) done() {
+	lc.mutex.Lock()
+	lc.busy = false
+	finish := lc.closed
+	lc.mutex.Unlock()
+	if finish {
+		lc.finish()
+		return
+	}
+	lc.resume()
+}
+
+// flush writes the pending replies until the socket is not writable, the rest is written once the
+// loop is notified.
+func (lc *loopConn) flush() {
+	client := lc.client
+	ob := client.out
+
+	lc.fdMutex.Lock()
+	for !lc.fdClosed {
+		ob.mu.Lock()
+		if ob.buf == nil || len(*ob.buf) == 0 {
+			releaseBuffer(ob.buf)
+			ob.buf = nil
+			ob.mu.Unlock()
+
+			if lc.writing {
+				lc.writing = false
+				_ = lc.loop.modify(lc, lc.events())
+			}
+			closing := lc.closing
+			lc.fdMutex.Unlock()
+			if closing {
+				lc.close(DisconnectUnknown)
+			}
+			return
+		}
+
+		n, err := syscall.Write(lc.fd, *ob.buf)
+		exceeded := false
+		if n > 0 {
+			*ob.buf = (*ob.buf)[:copy(*ob.buf, (*ob.buf)[n:])]
+			ob.pending -= n
+			exceeded = client.outputLimitExceeded()
+		}
+		ob.mu.Unlock()
+
+		if n > 0 {
+			client.updateStats(0, n, 0)
+		}
+		if exceeded {
+			lc.fdMutex.Unlock()
+			client.disconnectForOutputLimit()
+			return
+		}
+		switch {
+		case err == syscall.EINTR:
+		case err == syscall.EAGAIN:
+			if !lc.writing {
+				lc.writing = true
+				_ = lc.loop.modify(lc, lc.events())
+			}
+			lc.fdMutex.Unlock()
+			return
+		case err != nil:
+			lc.fdMutex.Unlock()
+			client.logger.Error("fail to write response", LogKeyError, err)
+			lc.close(DisconnectWriteError)
+			return
+		}
+	}
+	lc.fdMutex.Unlock()
+}
+
+// closeAfterFlush stops accepting replies and closes the connection once the pending ones are written.
+func (lc *loopConn) closeAfterFlush() {
+	lc.client.closeOutput()
+	lc.fdMutex.Lock()
+	lc.closing = true
+	lc.fdMutex.Unlock()
+	lc.flush()
+}
+
+// close closes the socket once, the reason is kept if it has been set.
+func (lc *loopConn) close(reason DisconnectReason) {
+	if reason != DisconnectUnknown {
+		lc.client.setReason(reason)
+	}
+
+	lc.fdMutex.Lock()
+	if lc.fdClosed {
+		lc.fdMutex.Unlock()
+		return
+	}
+	lc.fdClosed = true
+	lc.loop.remove(lc)
+	err := syscall.Close(lc.fd)
+	lc.fdMutex.Unlock()
+
+	lc.client.logger.Debug("close connection")
+	if err != nil {
+		lc.client.logger.Warn("fail to close connection", LogKeyError, err)
+	}
+	lc.client.closeOutput()
+	// the handlers blocking on Done return without waiting for the client to be finished.
+	lc.client.markDone()
+
+	lc.mutex.Lock()
+	lc.closed = true
+	lc.in = nil
+	finish := !lc.busy
+	lc.mutex.Unlock()
+	if finish {
+		lc.finish()
+	}
+}
+
+// finish is called once after the connection is closed and no worker is handling its queries.
+func (lc *loopConn) finish() {
+	ob := lc.client.out
+	ob.mu.Lock()
+	releaseBuffer(ob.buf)
+	ob.buf = nil
+	ob.mu.Unlock()
+
+	lc.client.disconnected()
+	lc.loop.e.s.stopClient(lc.client)
+}
+
+func (lc *loopConn) Read([]byte) (int, error) {
+	return 0, errManagedConn
+}
+
+func (lc *loopConn) Write([]byte) (int, error) {
+	return 0, errManagedConn
+}
+
+func (lc *loopConn) Close() error {
+	lc.close(DisconnectUnknown)
+	return nil
+}
+
+func (lc *loopConn) LocalAddr() net.Addr {
+	return lc.localAddr
+}
+
+func (lc *loopConn) RemoteAddr() net.Addr {
+	return lc.remoteAddr
+}
+
+func (lc *loopConn) SetDeadline(time.Time) error {
+	return nil
+}
+
+func (lc *loopConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func (lc *loopConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
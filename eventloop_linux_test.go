@@ -0,0 +1,410 @@
+//go:build linux
+
+package beam
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func startEngineServer(tb testing.TB, handler Handler, config Config) (*Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	s := NewServer(handler, config)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = s.ServeListener(l)
+	}()
+	tb.Cleanup(func() {
+		_ = s.Close()
+		<-done
+	})
+	return s, l.Addr().String()
+}
+
+func echoHandler() Handler {
+	return HandleFunc(func(request *Request) (Reply, error) {
+		if request.Len() > 0 {
+			return NewBulkStringsReplyRaw(request.Arg(0)), nil
+		}
+		return NewSimpleStringsReply("PONG"), nil
+	})
+}
+
+func TestEventLoop_Serve(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		mutex   sync.Mutex
+		reasons []DisconnectReason
+	)
+	_, addr := startEngineServer(t, echoHandler(), Config{
+		Engine:     EngineEventLoop,
+		EventLoops: 2,
+		Workers:    4,
+		OnConnect: func(client *Client) error {
+			client.SetAttr("connected", true)
+			return nil
+		},
+		OnDisconnect: func(client *Client, reason DisconnectReason) {
+			mutex.Lock()
+			reasons = append(reasons, reason)
+			mutex.Unlock()
+		},
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if !assert.Nil(err) {
+		return
+	}
+	r := bufio.NewReader(conn)
+
+	_, err = conn.Write([]byte("PING\r\n"))
+	assert.Nil(err)
+	line, err := r.ReadString('\n')
+	assert.Nil(err)
+	assert.Equal("+PONG\r\n", line)
+
+	// the query split across the writes is buffered until it is complete.
+	raw := NewQuery("ECHO", "hello").Raw()
+	for i := 0; i < len(raw); i++ {
+		_, err = conn.Write([]byte{raw[i]})
+		assert.Nil(err)
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal("$5\r\nhello\r\n", readReply(r, 2))
+
+	// the pipelined replies keep the order of the queries.
+	var pipeline Querys
+	var expected strings.Builder
+	for i := 0; i < 1000; i++ {
+		value := strings.Repeat("v", i%50)
+		pipeline = append(pipeline, NewQuery("ECHO", value))
+		expected.WriteString(string(NewBulkStringsReply(value)))
+	}
+	_, err = conn.Write([]byte(pipeline.Raw()))
+	assert.Nil(err)
+	assert.Equal(expected.String(), readReply(r, 2000))
+
+	assert.Nil(conn.Close())
+	assert.Eventually(func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(reasons) == 1
+	}, time.Second, time.Millisecond*10)
+	assert.Equal([]DisconnectReason{DisconnectClientClosed}, reasons)
+}
+
+func TestEventLoop_LargeReply(t *testing.T) {
+	assert := assert.New(t)
+
+	value := strings.Repeat("x", 8*1024*1024)
+	_, addr := startEngineServer(t, HandleFunc(func(request *Request) (Reply, error) {
+		return NewBulkStringsReply(value), nil
+	}), Config{Engine: EngineEventLoop})
+
+	conn, err := net.Dial("tcp", addr)
+	if !assert.Nil(err) {
+		return
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET\r\nGET\r\n"))
+	assert.Nil(err)
+	expected := string(NewBulkStringsReply(value))
+	buf := make([]byte, len(expected)*2)
+	_, err = io.ReadFull(conn, buf)
+	assert.Nil(err)
+	assert.Equal(expected+expected, string(buf))
+}
+
+func TestEventLoop_Disconnect(t *testing.T) {
+	assert := assert.New(t)
+
+	reasons := make(chan DisconnectReason, 8)
+	s, addr := startEngineServer(t, HandleFunc(func(request *Request) (Reply, error) {
+		if request.CommandStr() == "QUIT" {
+			return nil, ErrHaltClient
+		}
+		return NewSimpleStringsReply("OK"), nil
+	}), Config{
+		Engine:      EngineEventLoop,
+		IdleTimeout: time.Millisecond * 200,
+		BufferSize:  1024,
+		OnConnect: func(client *Client) error {
+			if client.ID() == 4 {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		},
+		OnDisconnect: func(client *Client, reason DisconnectReason) {
+			reasons <- reason
+		},
+	})
+
+	expectClosed := func(conn net.Conn, replies string, reason DisconnectReason) {
+		defer conn.Close()
+		data, err := io.ReadAll(conn)
+		assert.Nil(err)
+		assert.Equal(replies, string(data))
+		select {
+		case r := <-reasons:
+			assert.Equal(reason, r)
+		case <-time.After(time.Second):
+			t.Error("client is not disconnected")
+		}
+	}
+
+	dial := func(data string) net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) > 0 {
+			_, err = conn.Write([]byte(data))
+			assert.Nil(err)
+		}
+		return conn
+	}
+
+	expectClosed(dial("PING\r\nQUIT\r\n"), "+OK\r\n-ERR connection is closed by the server\r\n", DisconnectHalted)
+	expectClosed(dial(""), "", DisconnectIdleTimeout)
+	expectClosed(dial("PING\r\n*1\r\n$2000\r\n"+strings.Repeat("x", 1024)), "+OK\r\n-ERR Protocol error: too big query\r\n", DisconnectQueryTooLarge)
+	expectClosed(dial(""), "-ERR unexpected EOF\r\n", DisconnectRejected)
+	expectClosed(dial("PING\r\n*1\r\n$x\r\nPING\r\n"), "+OK\r\n-ERR Protocol error: invalid bulk length\r\n", DisconnectProtocolError)
+
+	conn := dial("PING\r\n")
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.Nil(err)
+	assert.Equal("+OK\r\n", line)
+	assert.Nil(s.Close())
+	expectClosed(conn, "", DisconnectServerClosed)
+}
+
+func TestEventLoop_PipelineWhileBusy(t *testing.T) {
+	assert := assert.New(t)
+
+	_, addr := startEngineServer(t, HandleFunc(func(request *Request) (Reply, error) {
+		if request.CommandStr() == "SLOW" {
+			time.Sleep(time.Millisecond * 50)
+		}
+		return NewSimpleStringsReply("PONG"), nil
+	}), Config{Engine: EngineEventLoop, BufferSize: 1024})
+
+	conn, err := net.Dial("tcp", addr)
+	if !assert.Nil(err) {
+		return
+	}
+	defer conn.Close()
+
+	// the pipeline larger than the buffer is not read until the slow query is handled.
+	_, err = conn.Write([]byte("SLOW\r\n"))
+	assert.Nil(err)
+	time.Sleep(time.Millisecond * 10)
+	lines := 20 * 1024 / len("PING\r\n")
+	go func() {
+		_, _ = conn.Write([]byte(strings.Repeat("PING\r\n", lines)))
+	}()
+	assert.Equal(strings.Repeat("+PONG\r\n", lines+1), readReply(bufio.NewReader(conn), lines+1))
+}
+
+func TestEventLoop_BlockedWorkers(t *testing.T) {
+	assert := assert.New(t)
+
+	wake := make(chan struct{})
+	_, addr := startEngineServer(t, HandleFunc(func(request *Request) (Reply, error) {
+		switch request.CommandStr() {
+		case "BLOCK":
+			select {
+			case <-wake:
+			case <-request.Done():
+			}
+		case "WAKE":
+			close(wake)
+		}
+		return NewSimpleStringsReply("OK"), nil
+	}), Config{Engine: EngineEventLoop, EventLoops: 1, Workers: 2})
+
+	dial := func(query string) *bufio.Reader {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		_, err = conn.Write([]byte(query))
+		assert.Nil(err)
+		return bufio.NewReader(conn)
+	}
+
+	// more clients than the workers are blocked, the query waking them is still handled.
+	var blocked []*bufio.Reader
+	for i := 0; i < 4; i++ {
+		blocked = append(blocked, dial("BLOCK\r\n"))
+	}
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal("+OK\r\n", readReply(dial("WAKE\r\n"), 1))
+	for _, r := range blocked {
+		assert.Equal("+OK\r\n", readReply(r, 1))
+	}
+}
+
+func TestEventLoop_DoneOnHangup(t *testing.T) {
+	blocked := make(chan struct{})
+	returned := make(chan struct{})
+	_, addr := startEngineServer(t, HandleFunc(func(request *Request) (Reply, error) {
+		close(blocked)
+		<-request.Done()
+		close(returned)
+		return NewSimpleStringsReply("OK"), nil
+	}), Config{Engine: EngineEventLoop})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Write([]byte("BLOCK\r\n"))
+	assert.Nil(t, err)
+	<-blocked
+
+	// the handler blocking on Done returns once the client hangs up.
+	conn.Close()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Error("the handler is not returned")
+	}
+}
+
+// readReply reads the lines of the replies.
+func readReply(r *bufio.Reader, lines int) string {
+	var sb strings.Builder
+	for i := 0; i < lines; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		sb.WriteString(line)
+	}
+	return sb.String()
+}
+
+var benchmarkEngines = []Engine{EngineGoroutine, EngineEventLoop}
+
+// BenchmarkEngine_IdleConnections reports the memory held by each idle connection.
+func BenchmarkEngine_IdleConnections(b *testing.B) {
+	const conns = 1000
+	for _, engine := range benchmarkEngines {
+		b.Run(engine.String(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s, addr := startEngineServer(b, echoHandler(), Config{Engine: engine})
+
+				var before runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				clients := make([]net.Conn, conns)
+				for j := range clients {
+					conn, err := net.Dial("tcp", addr)
+					if err != nil {
+						b.Fatal(err)
+					}
+					clients[j] = conn
+				}
+				for len(s.Clients()) < conns {
+					time.Sleep(time.Millisecond)
+				}
+
+				var after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				// the client side of the connections is included in both engines.
+				b.ReportMetric(float64(after.HeapInuse+after.StackInuse-before.HeapInuse-before.StackInuse)/conns, "B/conn")
+
+				for _, conn := range clients {
+					_ = conn.Close()
+				}
+				_ = s.Close()
+			}
+		})
+	}
+}
+
+// BenchmarkEngine_Ping reports the p99 latency of PING with the concurrent connections.
+func BenchmarkEngine_Ping(b *testing.B) {
+	const conns = 64
+	for _, engine := range benchmarkEngines {
+		b.Run(engine.String(), func(b *testing.B) {
+			_, addr := startEngineServer(b, echoHandler(), Config{Engine: engine})
+
+			latencies := make([][]time.Duration, conns)
+			var wg sync.WaitGroup
+			b.ResetTimer()
+			for i := 0; i < conns; i++ {
+				conn, err := net.Dial("tcp", addr)
+				if err != nil {
+					b.Fatal(err)
+				}
+				wg.Add(1)
+				go func(i int, conn net.Conn) {
+					defer wg.Done()
+					defer conn.Close()
+					r := bufio.NewReader(conn)
+					for j := i; j < b.N; j += conns {
+						start := time.Now()
+						if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+							b.Error(err)
+							return
+						}
+						if _, err := r.ReadString('\n'); err != nil {
+							b.Error(err)
+							return
+						}
+						latencies[i] = append(latencies[i], time.Since(start))
+					}
+				}(i, conn)
+			}
+			wg.Wait()
+			b.StopTimer()
+
+			var all []time.Duration
+			for _, l := range latencies {
+				all = append(all, l...)
+			}
+			if len(all) > 0 {
+				sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+				b.ReportMetric(float64(all[len(all)*99/100].Microseconds()), "p99-us")
+			}
+		})
+	}
+}
+
+func TestEventLoop_ServeClosed(t *testing.T) {
+	assert := assert.New(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(echoHandler(), Config{Engine: EngineEventLoop, Workers: 2})
+	assert.NoError(s.Close())
+	assert.Equal(ErrServerClosed, s.ServeListener(l))
+
+	// the engine is stopped before ServeListener returns.
+	select {
+	case _, ok := <-s.engine.(*eventLoopEngine).tasks:
+		assert.False(ok)
+	default:
+		t.Fatal("the workers of the engine are still running")
+	}
+}
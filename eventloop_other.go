@@ -0,0 +1,7 @@
+//go:build !linux
+
+package beam
+
+func newEventLoopEngine(s *Server) (engine, error) {
+	return nil, ErrEngineNotSupported
+}
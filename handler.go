@@ -11,6 +11,21 @@ type Handler interface {
 	Handle(request *Request) (Reply, error)
 }
 
+// CommandFlags describes how the commands can be handled.
+type CommandFlags uint
+
+const (
+	// FlagParallel marks the command safe to be handled concurrently with the adjacent parallel commands
+	// of a pipeline, such as a read which does not depend on the other commands of the client.
+	FlagParallel CommandFlags = 1 << iota
+)
+
+// FlaggedHandler is implemented by the handlers which provide the flags of the commands.
+type FlaggedHandler interface {
+	Handler
+	Flags(request *Request) CommandFlags
+}
+
 type HandleFunc func(request *Request) (Reply, error)
 
 func (hf HandleFunc) Handle(request *Request) (Reply, error) {
@@ -58,14 +73,24 @@ func (hc *HandlerChain) Handle(request *Request) (Reply, error) {
 	return hc.handler.Handle(request)
 }
 
+// Flags retrieves the flags of the command from the chained handler if it is a FlaggedHandler.
+func (hc *HandlerChain) Flags(request *Request) CommandFlags {
+	if fh, ok := hc.list.Back().Value.(FlaggedHandler); ok {
+		return fh.Flags(request)
+	}
+	return 0
+}
+
 func NewMappedHandler() *MappedHandler {
 	mh := new(MappedHandler)
 	mh.handlers = make(map[string]Handler)
+	mh.flags = make(map[string]CommandFlags)
 	return mh
 }
 
 type MappedHandler struct {
 	handlers map[string]Handler
+	flags    map[string]CommandFlags
 }
 
 func (mh *MappedHandler) Set(command string, h Handler) {
@@ -76,7 +101,27 @@ func (mh *MappedHandler) SetFunc(command string, f func(request *Request) (Reply
 	mh.Set(command, HandleFunc(f))
 }
 
+// SetFlags sets the flags of the command.
+func (mh *MappedHandler) SetFlags(command string, flags CommandFlags) {
+	mh.flags[strings.ToUpper(command)] = flags
+}
+
+// Flags retrieves the flags of the command.
+func (mh *MappedHandler) Flags(request *Request) CommandFlags {
+	if flags, exist := mh.flags[string(request.Command)]; exist {
+		return flags
+	}
+	if _, exist := mh.handlers[string(request.Command)]; exist {
+		return 0
+	}
+	return mh.flags[strings.ToUpper(request.CommandStr())]
+}
+
 func (mh *MappedHandler) Handle(request *Request) (Reply, error) {
+	// the upper case commands are looked up without allocation.
+	if handler, exist := mh.handlers[string(request.Command)]; exist {
+		return handler.Handle(request)
+	}
 	command := strings.ToUpper(request.CommandStr())
 	if handler, exist := mh.handlers[command]; exist {
 		return handler.Handle(request)
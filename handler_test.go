@@ -61,3 +61,23 @@ func testMappedHandler(t *testing.T) {
 	assert.Nil(err)
 	assert.EqualValues([]byte("+BAZ"), reply)
 }
+
+func TestMappedHandler_Flags(t *testing.T) {
+	assert := assert.New(t)
+	mh := NewMappedHandler()
+	mh.SetFunc("GET", func(request *Request) (Reply, error) {
+		return NewSimpleStringsReply("OK"), nil
+	})
+	mh.SetFlags("get", FlagParallel)
+
+	assert.Equal(FlagParallel, mh.Flags(&Request{Query: Query{Command: []byte("GET")}}))
+	assert.Equal(FlagParallel, mh.Flags(&Request{Query: Query{Command: []byte("get")}}))
+	assert.Equal(CommandFlags(0), mh.Flags(&Request{Query: Query{Command: []byte("SET")}}))
+
+	chain := NewHandlerChain(mh)
+	chain.AddFunc(func(request *Request, next Handler) (Reply, error) {
+		return next.Handle(request)
+	})
+	assert.Equal(FlagParallel, chain.Flags(&Request{Query: Query{Command: []byte("GET")}}))
+	assert.Equal(CommandFlags(0), NewHandlerChain(HandleFunc(nil)).Flags(&Request{}))
+}
@@ -0,0 +1,111 @@
+package beam
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/gaemma/logging"
+)
+
+// The attribute keys of the structured log records emitted by beam.
+const (
+	LogKeyClientID   = "client_id"
+	LogKeyRemoteAddr = "remote_addr"
+	LogKeyAddr       = "addr"
+	LogKeyCommand    = "command"
+	LogKeyQueries    = "queries"
+	LogKeyReplies    = "replies"
+	LogKeyLatency    = "latency"
+	LogKeyBytes      = "bytes"
+	LogKeyClass      = "class"
+	LogKeyReason     = "reason"
+	LogKeyPanic      = "panic"
+	LogKeyStack      = "stack"
+	LogKeyError      = "error"
+	LogKeyOffset     = "offset"
+)
+
+// NewLoggingHandler creates a slog.Handler which writes the records to the printf-style logging.Logger,
+// the attributes are appended to the message as key=value pairs.
+func NewLoggingHandler(logger logging.Logger) slog.Handler {
+	return &loggingHandler{logger: logger}
+}
+
+type loggingHandler struct {
+	logger logging.Logger
+	prefix string
+	attrs  string
+}
+
+func (h *loggingHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *loggingHandler) Handle(_ context.Context, record slog.Record) error {
+	var sb strings.Builder
+	sb.WriteString(record.Message)
+	sb.WriteString(h.attrs)
+	record.Attrs(func(attr slog.Attr) bool {
+		writeLoggingAttr(&sb, h.prefix, attr)
+		return true
+	})
+	msg := sb.String()
+
+	switch {
+	case record.Level >= slog.LevelError:
+		h.logger.Error("%s", msg)
+	case record.Level >= slog.LevelWarn:
+		h.logger.Warning("%s", msg)
+	case record.Level >= slog.LevelInfo:
+		h.logger.Info("%s", msg)
+	default:
+		h.logger.Debug("%s", msg)
+	}
+	return nil
+}
+
+func (h *loggingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var sb strings.Builder
+	sb.WriteString(h.attrs)
+	for _, attr := range attrs {
+		writeLoggingAttr(&sb, h.prefix, attr)
+	}
+	return &loggingHandler{logger: h.logger, prefix: h.prefix, attrs: sb.String()}
+}
+
+func (h *loggingHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &loggingHandler{logger: h.logger, prefix: h.prefix + name + ".", attrs: h.attrs}
+}
+
+func writeLoggingAttr(sb *strings.Builder, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, a := range attr.Value.Group() {
+			writeLoggingAttr(sb, prefix, a)
+		}
+		return
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(prefix)
+	sb.WriteString(attr.Key)
+	sb.WriteByte('=')
+	sb.WriteString(escapeCrlf(attr.Value.String()))
+}
+
+// discardHandler drops all the records.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
@@ -0,0 +1,89 @@
+package beam
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordLogger struct {
+	lines []string
+}
+
+func (l *recordLogger) Debug(format string, args ...interface{}) {
+	l.lines = append(l.lines, "DEBUG "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordLogger) Info(format string, args ...interface{}) {
+	l.lines = append(l.lines, "INFO "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordLogger) Warning(format string, args ...interface{}) {
+	l.lines = append(l.lines, "WARNING "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordLogger) Error(format string, args ...interface{}) {
+	l.lines = append(l.lines, "ERROR "+fmt.Sprintf(format, args...))
+}
+
+func TestLoggingHandler(t *testing.T) {
+	assert := assert.New(t)
+	rl := new(recordLogger)
+	logger := slog.New(NewLoggingHandler(rl))
+
+	logger.With(LogKeyClientID, 1).Debug("read queries", LogKeyQueries, Querys{NewQuery("GET", "100%")})
+	logger.WithGroup("conn").Warn("write timeout", LogKeyBytes, 10)
+	logger.Error("fail", slog.Group("req", LogKeyCommand, "GET"))
+	logger.Info("boot")
+
+	assert.Equal([]string{
+		"DEBUG read queries client_id=1 queries=*2\\r\\n$3\\r\\nGET\\r\\n$4\\r\\n100%\\r\\n",
+		"WARNING write timeout conn.bytes=10",
+		"ERROR fail req.command=GET",
+		"INFO boot",
+	}, rl.lines)
+}
+
+func TestServer_StructuredLogger(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	s := NewServer(HandleFunc(func(request *Request) (Reply, error) {
+		return NewSimpleStringsReply("OK"), nil
+	}), Config{
+		RWTimeout:        time.Millisecond * 50,
+		StructuredLogger: slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+
+	conn, peer := net.Pipe()
+	client := s.createClient(conn, s.config.BufferSize)
+	s.startClient(client)
+
+	_, err := peer.Write([]byte("PING\r\n"))
+	assert.Nil(err)
+	_, err = bufio.NewReader(peer).ReadString('\n')
+	assert.Nil(err)
+	peer.Close()
+	s.clientsWait.Wait()
+
+	var handled bool
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var record map[string]interface{}
+		assert.Nil(dec.Decode(&record))
+		assert.EqualValues(client.ID(), record[LogKeyClientID])
+		assert.Contains(record, LogKeyRemoteAddr)
+		if record["msg"] == "handle query" {
+			handled = true
+			assert.Equal("PING", record[LogKeyCommand])
+			assert.Contains(record, LogKeyLatency)
+		}
+	}
+	assert.True(handled)
+}
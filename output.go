@@ -0,0 +1,119 @@
+package beam
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrOutputBufferLimit will be returned when the pending output of a client exceeds its limit.
+	ErrOutputBufferLimit = errors.New("output buffer limit exceeded")
+	// ErrClientClosed will be returned when writing to a closed client.
+	ErrClientClosed = errors.New("client is closed")
+)
+
+// ClientClass classifies the clients for the output buffer limits.
+type ClientClass int
+
+const (
+	// ClientClassNormal is the class of the ordinary clients.
+	ClientClassNormal ClientClass = iota
+	// ClientClassReplica is the class of the clients which receive the replication stream.
+	ClientClassReplica
+	// ClientClassPubSub is the class of the clients which subscribe to channels.
+	ClientClassPubSub
+)
+
+func (cc ClientClass) String() string {
+	switch cc {
+	case ClientClassNormal:
+		return "normal"
+	case ClientClassReplica:
+		return "replica"
+	case ClientClassPubSub:
+		return "pubsub"
+	}
+	return "unknown"
+}
+
+// OutputBufferLimit limits the reply data pending for a client, the zero value means no limit.
+// The client is disconnected once the pending data reaches HardLimit, or stays above SoftLimit
+// for SoftSeconds.
+type OutputBufferLimit struct {
+	HardLimit   int
+	SoftLimit   int
+	SoftSeconds time.Duration
+}
+
+// defaultOutputBufferLimits are the same as the defaults of redis.
+var defaultOutputBufferLimits = map[ClientClass]OutputBufferLimit{
+	ClientClassNormal:  {},
+	ClientClassReplica: {HardLimit: 256 << 20, SoftLimit: 64 << 20, SoftSeconds: time.Minute},
+	ClientClassPubSub:  {HardLimit: 32 << 20, SoftLimit: 8 << 20, SoftSeconds: time.Minute},
+}
+
+// exceeded checks the pending size against the limit, softSince records when the soft limit was reached.
+func (l OutputBufferLimit) exceeded(size int, softSince *time.Time, now time.Time) bool {
+	if l.HardLimit > 0 && size >= l.HardLimit {
+		return true
+	}
+	if l.SoftLimit > 0 && size >= l.SoftLimit {
+		if softSince.IsZero() {
+			*softSince = now
+		}
+		return now.Sub(*softSince) >= l.SoftSeconds
+	}
+	*softSince = time.Time{}
+	return false
+}
+
+// outputBuffer holds the reply data which has not been written to the connection.
+type outputBuffer struct {
+	mu        sync.Mutex
+	buf       *[]byte
+	pending   int
+	softSince time.Time
+	closed    bool
+	killed    bool
+	notify    chan struct{}
+}
+
+func newOutputBuffer() *outputBuffer {
+	ob := new(outputBuffer)
+	ob.notify = make(chan struct{}, 1)
+	return ob
+}
+
+// maxPooledBufferSize limits the capacity of the buffers returned to the pool,
+// so that the pool does not hold the buffers grown by the huge replies.
+const maxPooledBufferSize = 64 * 1024
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 4096)
+		return &b
+	},
+}
+
+// acquireBuffer retrieves an empty buffer from the pool.
+func acquireBuffer() *[]byte {
+	return bufferPool.Get().(*[]byte)
+}
+
+// releaseBuffer returns the buffer to the pool.
+func releaseBuffer(b *[]byte) {
+	if b == nil || cap(*b) > maxPooledBufferSize {
+		return
+	}
+	*b = (*b)[:0]
+	bufferPool.Put(b)
+}
+
+// wakeup notifies the writer that there is data to be written.
+func (ob *outputBuffer) wakeup() {
+	select {
+	case ob.notify <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,58 @@
+package beam
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputBufferLimit_exceeded(t *testing.T) {
+	assert := assert.New(t)
+	var softSince time.Time
+	now := time.Now()
+
+	limit := OutputBufferLimit{}
+	assert.False(limit.exceeded(1<<30, &softSince, now))
+
+	limit = OutputBufferLimit{HardLimit: 100, SoftLimit: 50, SoftSeconds: time.Second}
+	assert.True(limit.exceeded(100, &softSince, now))
+	assert.False(limit.exceeded(60, &softSince, now))
+	assert.Equal(now, softSince)
+	assert.False(limit.exceeded(60, &softSince, now.Add(time.Millisecond*500)))
+	assert.True(limit.exceeded(60, &softSince, now.Add(time.Second)))
+
+	assert.False(limit.exceeded(10, &softSince, now.Add(time.Second)))
+	assert.True(softSince.IsZero())
+}
+
+func TestClient_OutputBufferLimit(t *testing.T) {
+	assert := assert.New(t)
+	handler := HandleFunc(func(request *Request) (Reply, error) {
+		return NewBulkStringsReply(strings.Repeat("x", 64)), nil
+	})
+	s := NewServer(handler, Config{
+		RWTimeout: time.Millisecond * 50,
+		OutputBufferLimits: map[ClientClass]OutputBufferLimit{
+			ClientClassNormal: {HardLimit: 256},
+		},
+	})
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	client := s.createClient(conn, s.config.BufferSize)
+	s.startClient(client)
+
+	// the peer never reads the replies.
+	for i := 0; i < 8; i++ {
+		if _, err := peer.Write([]byte("GET foo\r\n")); err != nil {
+			break
+		}
+	}
+
+	s.clientsWait.Wait()
+	assert.EqualValues(1, s.Stats().OutputBufferLimitDisconnections)
+	assert.Equal(ErrClientClosed, client.Send(NewSimpleStringsReply("OK")))
+}
@@ -0,0 +1,284 @@
+// Package proxy forwards the commands to the upstream RESP servers, the raw replies of the upstreams
+// are sent back to the clients as they are.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caeret/beam"
+	"github.com/caeret/beam/client"
+	"github.com/caeret/beam/cluster"
+)
+
+// Upstream is a master server with its read replicas.
+type Upstream struct {
+	// Name identifies the upstream in Routes.
+	Name string
+	// Addr is the address of the master.
+	Addr string
+	// Replicas are the addresses of the replicas serving the read commands.
+	Replicas []string
+}
+
+// Config configures the Proxy.
+type Config struct {
+	// Upstreams are the upstreams which the keys are sharded to by their hash slots.
+	Upstreams []Upstream
+	// Options configures the connection pools, Addr is replaced with the address of each server.
+	Options client.Options
+	// Commands provides the key positions of the commands, the default is cluster.DefaultCommands.
+	Commands cluster.CommandTable
+	// Routes maps the upper case commands to the names of the upstreams, they take precedence over
+	// the keys. The commands without keys or routes are sent to the first upstream.
+	Routes map[string]string
+	// ReadCommands are the upper case commands sent to the replicas, the default is DefaultReadCommands.
+	ReadCommands map[string]bool
+	// Timeout limits the time of forwarding a command, the default is 5 seconds.
+	Timeout time.Duration
+	// HealthCheckInterval is the interval of pinging the servers, the default is 1 second.
+	HealthCheckInterval time.Duration
+}
+
+// DefaultReadCommands are the read-only commands which can be served by the replicas.
+var DefaultReadCommands = func() map[string]bool {
+	m := make(map[string]bool)
+	for _, command := range []string{
+		"GET", "MGET", "STRLEN", "GETRANGE", "GETBIT", "BITCOUNT", "BITPOS", "EXISTS", "TTL", "PTTL",
+		"EXPIRETIME", "PEXPIRETIME", "TYPE", "DUMP", "SORT_RO",
+		"HGET", "HMGET", "HLEN", "HSTRLEN", "HEXISTS", "HKEYS", "HVALS", "HGETALL", "HRANDFIELD", "HSCAN",
+		"LLEN", "LINDEX", "LRANGE", "LPOS",
+		"SCARD", "SISMEMBER", "SMISMEMBER", "SMEMBERS", "SRANDMEMBER", "SSCAN", "SDIFF", "SINTER", "SUNION",
+		"SINTERCARD",
+		"ZCARD", "ZSCORE", "ZMSCORE", "ZRANK", "ZREVRANK", "ZRANGE", "ZREVRANGE", "ZRANGEBYSCORE",
+		"ZREVRANGEBYSCORE", "ZRANGEBYLEX", "ZREVRANGEBYLEX", "ZCOUNT", "ZLEXCOUNT", "ZRANDMEMBER", "ZSCAN",
+		"ZUNION", "ZINTER", "ZDIFF", "ZINTERCARD",
+		"XLEN", "XRANGE", "XREVRANGE", "XPENDING", "XINFO",
+		"PFCOUNT", "GEODIST", "GEOPOS", "GEOHASH", "GEOSEARCH",
+		"EVAL_RO", "EVALSHA_RO", "FCALL_RO",
+	} {
+		m[command] = true
+	}
+	return m
+}()
+
+// unsupported are the commands depending on the state of the connection, which can not be
+// forwarded through the pooled connections.
+var unsupported = map[string]bool{
+	"MULTI": true, "EXEC": true, "DISCARD": true, "WATCH": true, "UNWATCH": true, "SELECT": true,
+	"SUBSCRIBE": true, "PSUBSCRIBE": true, "SSUBSCRIBE": true, "UNSUBSCRIBE": true, "PUNSUBSCRIBE": true,
+	"SUNSUBSCRIBE": true, "MONITOR": true, "CLIENT": true, "AUTH": true, "HELLO": true, "RESET": true,
+	"QUIT": true, "BLPOP": true, "BRPOP": true, "BLMOVE": true, "BRPOPLPUSH": true, "BLMPOP": true,
+	"BZPOPMIN": true, "BZPOPMAX": true, "BZMPOP": true, "XREAD": true, "XREADGROUP": true,
+}
+
+// Proxy is a beam.Handler forwarding the commands to the upstreams.
+type Proxy struct {
+	config    Config
+	upstreams []*upstream
+	byName    map[string]*upstream
+
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+type upstream struct {
+	name     string
+	master   *server
+	replicas []*server
+	next     atomic.Uint32
+}
+
+// server is a master or replica with its connection pool.
+type server struct {
+	addr    string
+	pool    *client.Client
+	healthy atomic.Bool
+}
+
+// New creates a Proxy and starts the health checks of the servers.
+func New(config Config) (*Proxy, error) {
+	if len(config.Upstreams) == 0 {
+		return nil, errors.New("proxy: no upstream")
+	}
+	if config.Commands == nil {
+		config.Commands = cluster.DefaultCommands
+	}
+	if config.ReadCommands == nil {
+		config.ReadCommands = DefaultReadCommands
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Second * 5
+	}
+	if config.HealthCheckInterval <= 0 {
+		config.HealthCheckInterval = time.Second
+	}
+	p := &Proxy{
+		config:  config,
+		byName:  make(map[string]*upstream),
+		closeCh: make(chan struct{}),
+	}
+	for _, u := range config.Upstreams {
+		if u.Addr == "" {
+			return nil, fmt.Errorf("proxy: address of upstream %s is empty", u.Name)
+		}
+		if _, exist := p.byName[u.Name]; exist {
+			return nil, fmt.Errorf("proxy: duplicate upstream %s", u.Name)
+		}
+		up := &upstream{name: u.Name, master: p.newServer(u.Addr)}
+		for _, addr := range u.Replicas {
+			up.replicas = append(up.replicas, p.newServer(addr))
+		}
+		p.upstreams = append(p.upstreams, up)
+		p.byName[u.Name] = up
+	}
+	for command, name := range config.Routes {
+		if p.byName[name] == nil {
+			p.Close()
+			return nil, fmt.Errorf("proxy: unknown upstream %s of command %s", name, command)
+		}
+	}
+
+	p.wg.Add(1)
+	go p.healthCheck()
+	return p, nil
+}
+
+func (p *Proxy) newServer(addr string) *server {
+	opts := p.config.Options
+	opts.Addr = addr
+	s := &server{addr: addr, pool: client.New(opts)}
+	s.healthy.Store(true)
+	return s
+}
+
+// Close stops the health checks and closes the connections to the upstreams.
+func (p *Proxy) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closeCh)
+		p.wg.Wait()
+		for _, up := range p.upstreams {
+			up.master.pool.Close()
+			for _, replica := range up.replicas {
+				replica.pool.Close()
+			}
+		}
+	})
+	return nil
+}
+
+// Handle implements beam.Handler, the command is forwarded to the upstream which its keys are
+// routed to, the read commands are forwarded to the healthy replicas if there is any.
+func (p *Proxy) Handle(request *beam.Request) (beam.Reply, error) {
+	command := strings.ToUpper(request.CommandStr())
+	if unsupported[command] {
+		return beam.NewErrorsReply("ERR command '" + request.CommandStr() + "' is not supported by the proxy"), nil
+	}
+	up, reply := p.route(command, request.Query)
+	if reply != nil {
+		return reply, nil
+	}
+	s := up.master
+	if p.config.ReadCommands[command] {
+		s = up.replica()
+	}
+	if !s.healthy.Load() {
+		return beam.NewErrorsReply("ERR upstream " + s.addr + " is unavailable"), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.Timeout)
+	defer cancel()
+	reply, err := s.pool.DoQuery(ctx, request.Query)
+	var replyErr client.Error
+	if err != nil && !errors.As(err, &replyErr) {
+		return beam.NewErrorsReply("ERR upstream " + s.addr + ": " + err.Error()), nil
+	}
+	// the error replies of the upstream are forwarded as they are.
+	return reply, nil
+}
+
+// Flags implements beam.FlaggedHandler, the read commands of a pipeline are forwarded concurrently.
+func (p *Proxy) Flags(request *beam.Request) beam.CommandFlags {
+	if p.config.ReadCommands[strings.ToUpper(request.CommandStr())] {
+		return beam.FlagParallel
+	}
+	return 0
+}
+
+// route finds the upstream of the command, the error reply will be returned if the keys are
+// routed to different upstreams.
+func (p *Proxy) route(command string, query beam.Query) (*upstream, beam.Reply) {
+	if name, ok := p.config.Routes[command]; ok {
+		return p.byName[name], nil
+	}
+	var up *upstream
+	for _, key := range p.config.Commands.Keys(query) {
+		u := p.upstreams[cluster.Slot(key)%len(p.upstreams)]
+		if up != nil && u != up {
+			return nil, beam.NewErrorsReply("CROSSSLOT Keys in request don't hash to the same upstream")
+		}
+		up = u
+	}
+	if up == nil {
+		up = p.upstreams[0]
+	}
+	return up, nil
+}
+
+// replica picks a healthy replica in turn, the master will be returned if there is none.
+func (up *upstream) replica() *server {
+	n := len(up.replicas)
+	if n == 0 {
+		return up.master
+	}
+	start := int(up.next.Add(1))
+	for i := 0; i < n; i++ {
+		if s := up.replicas[(start+i)%n]; s.healthy.Load() {
+			return s
+		}
+	}
+	return up.master
+}
+
+// healthCheck pings the servers periodically, the servers failing to reply PONG are marked
+// unavailable until they reply again.
+func (p *Proxy) healthCheck() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.config.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.closeCh:
+			return
+		case <-ticker.C:
+		}
+		var wg sync.WaitGroup
+		for _, up := range p.upstreams {
+			for _, s := range append([]*server{up.master}, up.replicas...) {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					s.healthy.Store(p.ping(s))
+				}()
+			}
+		}
+		wg.Wait()
+	}
+}
+
+func (p *Proxy) ping(s *server) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.HealthCheckInterval)
+	defer cancel()
+	reply, err := s.pool.Do(ctx, "PING")
+	if err != nil {
+		return false
+	}
+	text, err := reply.Text()
+	return err == nil && text == "PONG"
+}
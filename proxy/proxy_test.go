@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/caeret/beam"
+	"github.com/caeret/beam/beamtest"
+	"github.com/caeret/beam/cluster"
+	"github.com/stretchr/testify/assert"
+)
+
+// startUpstream starts a server replying with its name, the returned function stops it.
+func startUpstream(t *testing.T, name string) (addr string, stop func()) {
+	handler := beam.NewMappedHandler()
+	handler.SetFunc("PING", func(request *beam.Request) (beam.Reply, error) {
+		return beam.NewSimpleStringsReply("PONG"), nil
+	})
+	handler.SetFunc("GET", func(request *beam.Request) (beam.Reply, error) {
+		return beam.NewBulkStringsReply(name + ":" + request.ArgStr(0)), nil
+	})
+	handler.SetFunc("MGET", func(request *beam.Request) (beam.Reply, error) {
+		return beam.NewArraysReply(name), nil
+	})
+	handler.SetFunc("SET", func(request *beam.Request) (beam.Reply, error) {
+		return beam.NewSimpleStringsReply(name), nil
+	})
+	handler.SetFunc("INFO", func(request *beam.Request) (beam.Reply, error) {
+		return beam.NewBulkStringsReply(name), nil
+	})
+	handler.SetFunc("NESTED", func(request *beam.Request) (beam.Reply, error) {
+		return beam.Reply("*2\r\n*1\r\n:1\r\n$-1\r\n"), nil
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := beam.NewServer(handler, beam.Config{})
+	go s.ServeListener(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String(), func() { s.Close() }
+}
+
+func newTestProxy(t *testing.T, config Config) *beamtest.Conn {
+	p, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return beamtest.NewServer(t, p, beam.Config{}).Dial()
+}
+
+// keyOf finds a key routed to the upstream at index i of n upstreams.
+func keyOf(i, n int) string {
+	for j := 0; ; j++ {
+		key := "key" + strconv.Itoa(j)
+		if cluster.KeySlot(key)%n == i {
+			return key
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := New(Config{})
+	assert.EqualError(err, "proxy: no upstream")
+	_, err = New(Config{Upstreams: []Upstream{{Name: "a", Addr: "127.0.0.1:1"}, {Name: "a", Addr: "127.0.0.1:2"}}})
+	assert.EqualError(err, "proxy: duplicate upstream a")
+	_, err = New(Config{
+		Upstreams: []Upstream{{Name: "a", Addr: "127.0.0.1:1"}},
+		Routes:    map[string]string{"INFO": "b"},
+	})
+	assert.EqualError(err, "proxy: unknown upstream b of command INFO")
+}
+
+func TestProxy_Route(t *testing.T) {
+	a, _ := startUpstream(t, "a")
+	b, _ := startUpstream(t, "b")
+	conn := newTestProxy(t, Config{
+		Upstreams: []Upstream{{Name: "a", Addr: a}, {Name: "b", Addr: b}},
+		Routes:    map[string]string{"INFO": "b"},
+	})
+
+	keyA, keyB := keyOf(0, 2), keyOf(1, 2)
+	beamtest.AssertBulkString(t, "a:"+keyA, conn.Do("GET", keyA))
+	beamtest.AssertBulkString(t, "b:"+keyB, conn.Do("get", keyB))
+	beamtest.AssertSimpleString(t, "b", conn.Do("SET", keyB, "v"))
+	beamtest.AssertStrings(t, []string{"b"}, conn.Do("MGET", "{"+keyB+"}1", "{"+keyB+"}2"))
+	beamtest.AssertError(t, "CROSSSLOT", conn.Do("MGET", keyA, keyB))
+
+	// the commands without keys are sent to the routed or the first upstream.
+	beamtest.AssertBulkString(t, "b", conn.Do("INFO"))
+	beamtest.AssertSimpleString(t, "PONG", conn.Do("PING"))
+
+	// the replies of the upstreams are forwarded as they are.
+	beamtest.AssertReply(t, beam.Reply("*2\r\n*1\r\n:1\r\n$-1\r\n"), conn.Do("NESTED"))
+	beamtest.AssertError(t, "ERR unknown command 'FOO'", conn.Do("FOO", keyA))
+	beamtest.AssertError(t, "ERR command 'multi' is not supported by the proxy", conn.Do("multi"))
+}
+
+func TestProxy_ReadWriteSplitting(t *testing.T) {
+	master, _ := startUpstream(t, "master")
+	replica1, stop1 := startUpstream(t, "replica1")
+	replica2, stop2 := startUpstream(t, "replica2")
+	conn := newTestProxy(t, Config{
+		Upstreams:           []Upstream{{Name: "a", Addr: master, Replicas: []string{replica1, replica2}}},
+		HealthCheckInterval: time.Millisecond * 20,
+	})
+
+	beamtest.AssertSimpleString(t, "master", conn.Do("SET", "foo", "bar"))
+	seen := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		v, err := conn.Do("GET", "foo").Text()
+		assert.Nil(t, err)
+		seen[v] = true
+	}
+	assert.Equal(t, map[string]bool{"replica1:foo": true, "replica2:foo": true}, seen)
+
+	// the read commands fall back to the master without healthy replicas.
+	stop1()
+	stop2()
+	assert.Eventually(t, func() bool {
+		v, _ := conn.Do("GET", "foo").Text()
+		return v == "master:foo"
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestProxy_HealthCheck(t *testing.T) {
+	a, stop := startUpstream(t, "a")
+	conn := newTestProxy(t, Config{
+		Upstreams:           []Upstream{{Name: "a", Addr: a}},
+		HealthCheckInterval: time.Millisecond * 20,
+	})
+
+	beamtest.AssertBulkString(t, "a:foo", conn.Do("GET", "foo"))
+	stop()
+	assert.Eventually(t, func() bool {
+		return conn.Do("GET", "foo").Err() == beam.ReplyError("ERR upstream "+a+" is unavailable")
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestProxy_Pipeline(t *testing.T) {
+	a, _ := startUpstream(t, "a")
+	conn := newTestProxy(t, Config{Upstreams: []Upstream{{Name: "a", Addr: a}}})
+
+	queries := make([]beam.Query, 16)
+	for i := range queries {
+		queries[i] = beam.NewQuery("GET", strconv.Itoa(i))
+	}
+	conn.Send(queries...)
+	for i := 0; i < 16; i++ {
+		beamtest.AssertBulkString(t, "a:"+strconv.Itoa(i), conn.Read())
+	}
+}
@@ -4,14 +4,99 @@ import (
 	"bytes"
 	"errors"
 	"io"
-	"strconv"
-	"strings"
 )
 
 var (
+	// ErrFormat matches the errors returned if the query violates the protocol, the *ProtocolError
+	// unwraps to it.
 	ErrFormat = errors.New("invalid format")
+	// ErrUnbalancedQuotes matches the error returned if the quotes of an inline query are not balanced.
+	ErrUnbalancedQuotes error = &ProtocolError{Msg: "unbalanced quotes in request"}
+	// errQueryTooLarge is replied if the incomplete query fills the input buffer of the client.
+	errQueryTooLarge = &ProtocolError{Msg: "too big query"}
 )
 
+// ProtocolError will be returned if the query violates the protocol, it is replied to the client
+// before the connection is closed.
+type ProtocolError struct {
+	// Msg describes the error as redis does, such as "expected '$', got 'x'".
+	Msg string
+	// Expected is the expected token if an unexpected one is read.
+	Expected string
+	// Offset is the position of the error in the data passed to ReadQuery.
+	Offset int
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.Msg
+}
+
+// Unwrap returns ErrFormat, so that the callers checking errors.Is(err, ErrFormat) still match.
+func (e *ProtocolError) Unwrap() error {
+	return ErrFormat
+}
+
+// Is reports whether target is a *ProtocolError with the same message, regardless of the offset.
+func (e *ProtocolError) Is(target error) bool {
+	t, ok := target.(*ProtocolError)
+	return ok && t.Msg == e.Msg
+}
+
+// unexpectedError creates the *ProtocolError for the unexpected byte c at offset.
+func unexpectedError(expected string, c byte, offset int) *ProtocolError {
+	return &ProtocolError{
+		Msg:      "expected '" + expected + "', got '" + quoteByte(c) + "'",
+		Expected: expected,
+		Offset:   offset,
+	}
+}
+
+// quoteByte formats c for the error reply, the control characters are escaped so that the reply
+// is not broken.
+func quoteByte(c byte) string {
+	switch c {
+	case '\r':
+		return "\\r"
+	case '\n':
+		return "\\n"
+	}
+	if c < ' ' || c > '~' {
+		const hex = "0123456789abcdef"
+		return string([]byte{'\\', 'x', hex[c>>4], hex[c&0xf]})
+	}
+	return string(c)
+}
+
+// QueryLimits limits the size of the queries, the zero fields use the same defaults as redis.
+type QueryLimits struct {
+	// MaxMultiBulkLength limits the number of the arguments of a multibulk query.
+	MaxMultiBulkLength int
+	// MaxBulkLength limits the length of an argument of a multibulk query.
+	MaxBulkLength int
+	// MaxInlineLength limits the length of an inline query, and the length lines of a multibulk query.
+	MaxInlineLength int
+}
+
+// DefaultQueryLimits is used by ReadQuery.
+var DefaultQueryLimits = QueryLimits{
+	MaxMultiBulkLength: 1024 * 1024,
+	MaxBulkLength:      512 * 1024 * 1024,
+	MaxInlineLength:    64 * 1024,
+}
+
+func (limits QueryLimits) withDefaults() QueryLimits {
+	if limits.MaxMultiBulkLength <= 0 {
+		limits.MaxMultiBulkLength = DefaultQueryLimits.MaxMultiBulkLength
+	}
+	if limits.MaxBulkLength <= 0 {
+		limits.MaxBulkLength = DefaultQueryLimits.MaxBulkLength
+	}
+	if limits.MaxInlineLength <= 0 {
+		limits.MaxInlineLength = DefaultQueryLimits.MaxInlineLength
+	}
+	return limits
+}
+
 // Querys is a Query list.
 type Querys []Query
 
@@ -21,11 +106,15 @@ func (qs Querys) String() string {
 
 // Raw formats the Query to redis binary protocol.
 func (qs Querys) Raw() string {
-	strs := make([]string, len(qs))
-	for i, req := range qs {
-		strs[i] = req.Raw()
+	var size int
+	for _, query := range qs {
+		size += query.rawSize()
+	}
+	b := make([]byte, 0, size)
+	for _, query := range qs {
+		b = query.AppendRaw(b)
 	}
-	return strings.Join(strs, "")
+	return unsafeString(b)
 }
 
 // NewQuery creates new Query from string arguments.
@@ -74,53 +163,69 @@ func (query Query) Len() int {
 
 // Raw formats the Query to redis binary protocol.
 func (query Query) Raw() string {
-	raw := "*" + strconv.Itoa(query.Len()+1) + string(crlf)
-	raw += "$" + strconv.Itoa(len(query.Command)) + string(crlf)
-	raw += string(query.Command) + string(crlf)
+	return unsafeString(query.AppendRaw(make([]byte, 0, query.rawSize())))
+}
+
+// AppendRaw appends the Query in redis binary protocol to b.
+func (query Query) AppendRaw(b []byte) []byte {
+	b = appendHeader(b, ArraysReplyPrefix, query.Len()+1)
+	b = AppendBulkStringsRaw(b, query.Command)
+	for _, elem := range query.Arguments {
+		b = appendHeader(b, BulkStringsReplyPrefix, len(elem))
+		b = append(b, elem...)
+		b = append(b, '\r', '\n')
+	}
+	return b
+}
+
+// rawSize calculates the size of the Query in redis binary protocol.
+func (query Query) rawSize() int {
+	size := headerSize(query.Len()+1) + bulkStringsSize(len(query.Command))
 	for _, elem := range query.Arguments {
-		raw += "$" + strconv.Itoa(len(elem)) + string(crlf)
-		raw += string(elem) + string(crlf)
+		size += bulkStringsSize(len(elem))
 	}
-	return raw
+	return size
 }
 
-// ReadQuery parses querys from b, and the left bytes l will be returned.
-// ErrFormat will be returned if there is invalid protocol sequence.
+// ReadQuery parses querys from b with DefaultQueryLimits, and the left bytes l will be returned.
+// A *ProtocolError will be returned if there is invalid protocol sequence, the querys before it are
+// still returned.
 func ReadQuery(b []byte) (querys []Query, l []byte, err error) {
-	buffer := bytes.NewBuffer(b)
+	return ReadQueryLimits(b, DefaultQueryLimits)
+}
+
+// ReadQueryLimits parses querys from b as ReadQuery, a *ProtocolError will be returned if the queries
+// exceed the limits.
+func ReadQueryLimits(b []byte, limits QueryLimits) (querys []Query, l []byte, err error) {
 	// assumes that each query needs 32 bytes.
 	querys = make([]Query, 0, len(b)/32)
 
-	defer func() {
-		if err == io.EOF {
-			err = nil
-		} else {
-			l = nil
-		}
-	}()
-
-	for err == nil {
-		l = buffer.Bytes()
-		if len(l) == 0 {
-			err = io.EOF
-			break
-		}
-
+	var offset int
+	for len(b) > 0 {
 		var (
 			query Query
+			n     int
 			empty bool
 		)
 
-		if l[0] == '*' {
-			query, err = readMultiBulkQuery(buffer)
+		if b[0] == '*' {
+			query, n, empty, err = readMultiBulkQuery(b, &limits)
 		} else {
-			query, empty, err = readInlineQuery(buffer)
+			query, n, empty, err = readInlineQuery(b, &limits)
 		}
 
+		if err == io.EOF {
+			return querys, b, nil
+		}
 		if err != nil {
-			return
+			if perr, ok := err.(*ProtocolError); ok {
+				perr.Offset += offset
+			}
+			return querys, nil, err
 		}
 
+		b = b[n:]
+		offset += n
 		if empty {
 			continue
 		}
@@ -128,22 +233,28 @@ func ReadQuery(b []byte) (querys []Query, l []byte, err error) {
 		querys = append(querys, query)
 	}
 
-	return
+	return querys, b, nil
 }
 
-func readInlineQuery(buffer *bytes.Buffer) (query Query, empty bool, err error) {
-	b, err := buffer.ReadBytes('\n')
-	if err != nil {
+func readInlineQuery(b []byte, limits *QueryLimits) (query Query, n int, empty bool, err error) {
+	idx := bytes.IndexByte(b, '\n')
+	if idx < 0 || idx > limits.MaxInlineLength {
+		err = io.EOF
+		if len(b) > limits.MaxInlineLength {
+			err = &ProtocolError{Msg: "too big inline request", Offset: limits.MaxInlineLength}
+		}
 		return
 	}
+	n = idx + 1
 
-	args := bytes.Fields(b)
+	args, err := splitArgs(b[:n])
+	if err != nil {
+		return
+	}
 
 	if len(args) > 0 {
 		query.Command = args[0]
-		for _, arg := range args[1:] {
-			query.Arguments = append(query.Arguments, arg)
-		}
+		query.Arguments = args[1:]
 	} else {
 		empty = true
 	}
@@ -151,95 +262,209 @@ func readInlineQuery(buffer *bytes.Buffer) (query Query, empty bool, err error)
 	return
 }
 
-func readMultiBulkQuery(buffer *bytes.Buffer) (query Query, err error) {
-	var cnt int
-	cnt, err = readArgsCount(buffer)
+func readMultiBulkQuery(b []byte, limits *QueryLimits) (query Query, n int, empty bool, err error) {
+	cnt, n, err := readPrefixedInt('*', b, limits.MaxInlineLength)
+	switch {
+	case err == errTooBigLine:
+		err = &ProtocolError{Msg: "too big mbulk count string", Offset: limits.MaxInlineLength}
+	case err == ErrFormat || (err == nil && cnt > limits.MaxMultiBulkLength):
+		err = &ProtocolError{Msg: "invalid multibulk length", Offset: 1}
+	}
 	if err != nil {
 		return
 	}
+	if cnt <= 0 {
+		empty = true
+		return
+	}
 
-	query.Arguments = make([][]byte, 0, cnt-1)
-
+	var size int
+	// the declared count is not trusted for the allocation before the arguments are received.
+	args := make([][]byte, 0, min(cnt, 1024))
 	for i := 0; i < cnt; i++ {
-		var argLength int
-		argLength, err = readArgLength(buffer)
+		var (
+			argLength int
+			m         int
+		)
+		argLength, m, err = readPrefixedInt('$', b[n:], limits.MaxInlineLength)
+		switch {
+		case err == errTooBigLine:
+			err = &ProtocolError{Msg: "too big bulk count string", Offset: n + limits.MaxInlineLength}
+		case err == errPrefix:
+			err = unexpectedError("$", b[n], n)
+		case err == ErrFormat || (err == nil && (argLength < 0 || argLength > limits.MaxBulkLength)):
+			err = &ProtocolError{Msg: "invalid bulk length", Offset: n + 1}
+		}
 		if err != nil {
 			return
 		}
-		var arg []byte
-		arg, err = readArgLine(argLength, buffer)
-		if err != nil {
+		n += m
+		if len(b)-n < argLength+2 {
+			err = io.EOF
 			return
 		}
-
-		if i == 0 {
-			query.Command = arg
-		} else {
-			query.Arguments = append(query.Arguments, arg)
+		if b[n+argLength] != '\r' || b[n+argLength+1] != '\n' {
+			err = &ProtocolError{Msg: "expected CRLF after the bulk string", Expected: "\r\n", Offset: n + argLength}
+			return
 		}
+		args = append(args, b[n:n+argLength])
+		n += argLength + 2
+		size += argLength
 	}
 
-	return
-}
-
-// readArgsCount reads arguments count from buffer.
-func readArgsCount(buffer *bytes.Buffer) (n int, err error) {
-	argsCount, err := readPrefixedLine('*', buffer)
-	if err != nil {
-		return
+	// copies the arguments into one allocation, since b is reused by the caller.
+	data := make([]byte, size)
+	var offset int
+	for i, arg := range args {
+		end := offset + copy(data[offset:], arg)
+		args[i] = data[offset:end:end]
+		offset = end
 	}
-	n, err = strconv.Atoi(string(argsCount))
-	return
-}
 
-// readArgLength reads the length of argument from buffer.
-func readArgLength(buffer *bytes.Buffer) (n int, err error) {
-	argLength, err := readPrefixedLine('$', buffer)
-	if err != nil {
-		return
-	}
-	n, err = strconv.Atoi(string(argLength))
+	query.Command = args[0]
+	query.Arguments = args[1:]
 	return
 }
 
-// readPrefixedLine reads the line starts by the specified prefix and ends with crlf.
-func readPrefixedLine(prefix byte, buffer *bytes.Buffer) (line []byte, err error) {
-	b, err := buffer.ReadByte()
-	if err != nil {
+var (
+	errPrefix     = errors.New("unexpected prefix")
+	errTooBigLine = errors.New("too big line")
+)
+
+// readPrefixedInt reads the integer in the line starts by the specified prefix and ends with crlf,
+// n is the length of the line. errPrefix will be returned if the line does not start with the prefix,
+// and errTooBigLine if the line is longer than maxLength.
+func readPrefixedInt(prefix byte, b []byte, maxLength int) (v int, n int, err error) {
+	if len(b) == 0 {
+		err = io.EOF
 		return
 	}
-	if b != prefix {
-		err = ErrFormat
+	if b[0] != prefix {
+		err = errPrefix
 		return
 	}
-	line, err = buffer.ReadBytes('\n')
-	if err != nil {
+	idx := bytes.IndexByte(b, '\n')
+	if idx < 0 || idx > maxLength {
+		err = io.EOF
+		if len(b) > maxLength {
+			err = errTooBigLine
+		}
 		return
 	}
-	if !bytes.HasSuffix(line, crlf) {
+	if idx < 2 || b[idx-1] != '\r' {
 		err = ErrFormat
 		return
 	}
-	line = line[:len(line)-2]
-	return
+	v, err = parseInt(b[1 : idx-1])
+	return v, idx + 1, err
 }
 
-// readArgLine reads the line with specified length.
-func readArgLine(length int, buffer *bytes.Buffer) (line []byte, err error) {
-	line = make([]byte, length+2)
-	n, err := buffer.Read(line)
-	if err != nil {
-		return
+// splitArgs splits the inline query line to the arguments with the same rules as sdssplitargs of redis,
+// the arguments can be quoted with the escapes such as "\n" and "\x41" in the double quotes.
+// The arguments do not refer to line, which is reused by the caller.
+func splitArgs(line []byte) (args [][]byte, err error) {
+	// the decoded arguments are never longer than the line.
+	buf := make([]byte, 0, len(line))
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		start := len(buf)
+		inq, insq := false, false
+	arg:
+		for ; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case inq:
+				switch {
+				case c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]):
+					buf = append(buf, hexDigitValue(line[i+2])<<4|hexDigitValue(line[i+3]))
+					i += 3
+				case c == '\\' && i+1 < len(line):
+					i++
+					switch c = line[i]; c {
+					case 'n':
+						c = '\n'
+					case 'r':
+						c = '\r'
+					case 't':
+						c = '\t'
+					case 'b':
+						c = '\b'
+					case 'a':
+						c = '\a'
+					}
+					buf = append(buf, c)
+				case c == '"':
+					// the closing quote must be followed by a space or nothing.
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, unbalancedQuotesError(i + 1)
+					}
+					inq = false
+					i++
+					break arg
+				default:
+					buf = append(buf, c)
+				}
+			case insq:
+				switch {
+				case c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					buf = append(buf, '\'')
+					i++
+				case c == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, unbalancedQuotesError(i + 1)
+					}
+					insq = false
+					i++
+					break arg
+				default:
+					buf = append(buf, c)
+				}
+			case isSpace(c):
+				break arg
+			case c == '"':
+				inq = true
+			case c == '\'':
+				insq = true
+			default:
+				buf = append(buf, c)
+			}
+		}
+		if inq || insq {
+			return nil, unbalancedQuotesError(i)
+		}
+		args = append(args, buf[start:len(buf):len(buf)])
 	}
+}
+
+func unbalancedQuotesError(offset int) error {
+	return &ProtocolError{Msg: "unbalanced quotes in request", Offset: offset}
+}
 
-	if n != len(line) {
-		return nil, io.EOF
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\n', '\r', '\t', '\v', '\f':
+		return true
 	}
+	return false
+}
 
-	if !bytes.HasSuffix(line, crlf) {
-		err = ErrFormat
-		return
+func isHexDigit(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func hexDigitValue(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
 	}
-	line = line[:length]
-	return
+	return c - 'A' + 10
 }
@@ -1,6 +1,8 @@
 package beam
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -35,6 +37,36 @@ func TestReadInlineQuery(t *testing.T) {
 	assert.Equal([]byte("GET"), l)
 }
 
+func TestReadInlineQuery_Quotes(t *testing.T) {
+	assert := assert.New(t)
+
+	for line, expected := range map[string]Query{
+		"SET key \"hello world\"\r\n":            NewQuery("SET", "key", "hello world"),
+		"SET key 'hello world'\r\n":              NewQuery("SET", "key", "hello world"),
+		"SET key \"a\\nb\\r\\t\\x41\\\"\\\\\"\n": NewQuery("SET", "key", "a\nb\r\tA\"\\"),
+		"SET key \"\\xZZ\"\n":                    NewQuery("SET", "key", "xZZ"),
+		"SET key 'it\\'s \\n'\n":                 NewQuery("SET", "key", "it's \\n"),
+		"SET key \"\"\n":                         NewQuery("SET", "key", ""),
+		"SET k\"e y\" v\n":                       NewQuery("SET", "ke y", "v"),
+		"  GET\t foo  \r\n":                      NewQuery("GET", "foo"),
+	} {
+		querys, l, err := ReadQuery([]byte(line))
+		assert.Nil(err, line)
+		assert.Equal([]Query{expected}, querys, line)
+		assert.Empty(l)
+	}
+
+	for _, line := range []string{
+		"SET key \"hello\n",
+		"SET key 'hello\n",
+		"SET key \"hello\"world\n",
+		"SET key 'hello'world\n",
+	} {
+		_, _, err := ReadQuery([]byte(line))
+		assert.ErrorIs(err, ErrUnbalancedQuotes, line)
+	}
+}
+
 func TestReadMultiBuckQuery(t *testing.T) {
 	assert := assert.New(t)
 	var (
@@ -56,8 +88,14 @@ func TestReadMultiBuckQuery(t *testing.T) {
 		querys)
 
 	b = []byte("*2\r\nhello")
+	_, l, err = ReadQuery(b)
+	assert.ErrorIs(err, ErrFormat)
+	assert.Empty(l)
+
+	b = []byte("PING\r\n*2\r\nhello")
 	querys, l, err = ReadQuery(b)
-	assert.Equal(ErrFormat, err)
+	assert.Equal([]Query{NewQuery("PING")}, querys)
+	assert.Equal(&ProtocolError{Msg: "expected '$', got 'h'", Expected: "$", Offset: 10}, err)
 	assert.Empty(l)
 
 	b = []byte("*1\r\n$4\r\nPING\r\n*2\r\n$3GET\r")
@@ -72,6 +110,92 @@ func TestReadMultiBuckQuery(t *testing.T) {
 	assert.Equal(b, l)
 }
 
+func TestProtocolError(t *testing.T) {
+	assert := assert.New(t)
+
+	for b, expected := range map[string]*ProtocolError{
+		"*1\r\n\r\n":             {Msg: "expected '$', got '\\r'", Expected: "$", Offset: 4},
+		"*1\r\n\x00\r\n":         {Msg: "expected '$', got '\\x00'", Expected: "$", Offset: 4},
+		"*1\r\n$3\r\nGETxx":      {Msg: "expected CRLF after the bulk string", Expected: "\r\n", Offset: 11},
+		"*1\r\n$a\r\n":           {Msg: "invalid bulk length", Offset: 5},
+		"PING\r\n*x\r\n":         {Msg: "invalid multibulk length", Offset: 7},
+		"PING\r\nSET \"a\"b\r\n": {Msg: "unbalanced quotes in request", Offset: 13},
+	} {
+		_, _, err := ReadQuery([]byte(b))
+		assert.Equal(expected, err, b)
+	}
+}
+
+func TestReadQueryLimits(t *testing.T) {
+	assert := assert.New(t)
+	limits := QueryLimits{MaxMultiBulkLength: 2, MaxBulkLength: 3, MaxInlineLength: 16}
+
+	for b, msg := range map[string]string{
+		"*-5\r\n":                            "",
+		"*3\r\n":                             "invalid multibulk length",
+		"*x\r\n":                             "invalid multibulk length",
+		"*1000\r\n":                          "invalid multibulk length",
+		"*1\r\n$4\r\n":                       "invalid bulk length",
+		"*1\r\n$-1\r\n":                      "invalid bulk length",
+		"*1\r\n$1":                           "",
+		"*1\r\n$1" + strings.Repeat("1", 16): "too big bulk count string",
+		"*1" + strings.Repeat("1", 16):       "too big mbulk count string",
+		"GET " + strings.Repeat("x", 12):     "",
+		"GET " + strings.Repeat("x", 13):     "too big inline request",
+		"GET \"foo\n":                        "unbalanced quotes in request",
+	} {
+		_, _, err := ReadQueryLimits([]byte(b), limits)
+		if msg == "" {
+			assert.Nil(err, b)
+			continue
+		}
+		var perr *ProtocolError
+		if assert.ErrorAs(err, &perr, b) {
+			assert.Equal(msg, perr.Msg, b)
+			assert.Equal("Protocol error: "+msg, err.Error())
+		}
+	}
+
+	_, _, err := ReadQuery([]byte("*99999999999999999999\r\n"))
+	assert.EqualError(err, "Protocol error: invalid multibulk length")
+
+	// the declared count is not allocated before the arguments are received.
+	allocs := testing.AllocsPerRun(10, func() {
+		_, _, _ = ReadQuery([]byte("*1048576\r\n$3\r\nGET\r\n"))
+	})
+	assert.LessOrEqual(allocs, 2.0)
+}
+
+func FuzzReadQuery(f *testing.F) {
+	for _, seed := range []string{
+		"PING\r\n",
+		"SET key \"hello \\x41\\n\" 'it\\'s'\r\n",
+		"*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n",
+		"*1\r\n$4\r\nPING\r\n*2\r\n$3GET\r",
+		"*-5\r\n",
+		"*2\r\nhello",
+		"*1\r\n$-1\r\n",
+		"*1048577\r\n",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		querys, l, err := ReadQuery(b)
+		if err != nil {
+			return
+		}
+		if !bytes.HasSuffix(b, l) {
+			t.Fatalf("left bytes %q is not the suffix of %q", l, b)
+		}
+		// the parsed queries are the same after they are encoded again.
+		raw := Querys(querys).Raw()
+		again, l, err := ReadQuery([]byte(raw))
+		if err != nil || len(l) != 0 || Querys(again).Raw() != raw {
+			t.Fatalf("fail to parse %q again: %v", raw, err)
+		}
+	})
+}
+
 func TestQuery_String(t *testing.T) {
 	assert := assert.New(t)
 	req1 := NewQuery("GET", "bar")
@@ -96,3 +220,11 @@ func TestNewQuery(t *testing.T) {
 	assert.Equal(query.Len(), 0)
 	assert.Equal([]byte("PING"), query.Command)
 }
+
+func BenchmarkQuery_Raw(b *testing.B) {
+	query := NewQuery("SET", "key", "value")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = query.Raw()
+	}
+}
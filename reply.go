@@ -1,7 +1,6 @@
 package beam
 
 import (
-	"bytes"
 	"strconv"
 	"strings"
 )
@@ -14,6 +13,67 @@ const (
 	ArraysReplyPrefix        = '*'
 )
 
+// RESP3 reply prefixes.
+const (
+	NullReplyPrefix           = '_'
+	DoubleReplyPrefix         = ','
+	BooleanReplyPrefix        = '#'
+	BlobErrorReplyPrefix      = '!'
+	VerbatimStringReplyPrefix = '='
+	BigNumberReplyPrefix      = '('
+	MapReplyPrefix            = '%'
+	SetReplyPrefix            = '~'
+	AttributeReplyPrefix      = '|'
+	PushReplyPrefix           = '>'
+)
+
+// ReplyType is the type of a Reply, which is identified by its prefix.
+type ReplyType byte
+
+const (
+	TypeInvalid        ReplyType = 0
+	TypeSimpleStrings  ReplyType = SimpleStringsReplyPrefix
+	TypeErrors         ReplyType = ErrorsReplyPrefix
+	TypeIntegers       ReplyType = IntegersReplyPrefix
+	TypeBulkStrings    ReplyType = BulkStringsReplyPrefix
+	TypeArrays         ReplyType = ArraysReplyPrefix
+	TypeNull           ReplyType = NullReplyPrefix
+	TypeDouble         ReplyType = DoubleReplyPrefix
+	TypeBoolean        ReplyType = BooleanReplyPrefix
+	TypeBlobError      ReplyType = BlobErrorReplyPrefix
+	TypeVerbatimString ReplyType = VerbatimStringReplyPrefix
+	TypeBigNumber      ReplyType = BigNumberReplyPrefix
+	TypeMap            ReplyType = MapReplyPrefix
+	TypeSet            ReplyType = SetReplyPrefix
+	TypeAttribute      ReplyType = AttributeReplyPrefix
+	TypePush           ReplyType = PushReplyPrefix
+)
+
+var replyTypeNames = map[ReplyType]string{
+	TypeSimpleStrings:  "simple string",
+	TypeErrors:         "error",
+	TypeIntegers:       "integer",
+	TypeBulkStrings:    "bulk string",
+	TypeArrays:         "array",
+	TypeNull:           "null",
+	TypeDouble:         "double",
+	TypeBoolean:        "boolean",
+	TypeBlobError:      "blob error",
+	TypeVerbatimString: "verbatim string",
+	TypeBigNumber:      "big number",
+	TypeMap:            "map",
+	TypeSet:            "set",
+	TypeAttribute:      "attribute",
+	TypePush:           "push",
+}
+
+func (t ReplyType) String() string {
+	if name, ok := replyTypeNames[t]; ok {
+		return name
+	}
+	return "invalid"
+}
+
 // Replies is a Reply list.
 type Replies []Reply
 
@@ -23,11 +83,16 @@ func (rs Replies) String() string {
 
 // Raw formats the reply to redis binary protocol.
 func (rs Replies) Raw() string {
-	strs := make([]string, len(rs))
-	for i, resp := range rs {
-		strs[i] = resp.Raw()
+	var size int
+	for _, r := range rs {
+		size += len(r)
+	}
+	var sb strings.Builder
+	sb.Grow(size)
+	for _, r := range rs {
+		sb.Write(r)
 	}
-	return strings.Join(strs, "")
+	return sb.String()
 }
 
 // Reply represents the redis reply.
@@ -42,77 +107,279 @@ func (r Reply) Raw() string {
 	return string(r)
 }
 
+// Type retrieves the ReplyType by the prefix of the reply.
+func (r Reply) Type() ReplyType {
+	if len(r) == 0 {
+		return TypeInvalid
+	}
+	t := ReplyType(r[0])
+	if _, ok := replyTypeNames[t]; !ok {
+		return TypeInvalid
+	}
+	return t
+}
+
+// IsError checks if the reply is an error reply.
+func (r Reply) IsError() bool {
+	t := r.Type()
+	return t == TypeErrors || t == TypeBlobError
+}
+
+// IsNil checks if the reply is a nil bulk string, nil array or RESP3 null.
+func (r Reply) IsNil() bool {
+	switch string(r) {
+	case "$-1\r\n", "*-1\r\n", "_\r\n":
+		return true
+	}
+	return false
+}
+
+// Validate checks if the reply is one complete reply.
+func (r Reply) Validate() error {
+	_, err := decodeReply(r)
+	return err
+}
+
+// Err retrieves the ReplyError of the error reply, nil will be returned for the other replies.
+func (r Reply) Err() error {
+	if !r.IsError() {
+		return nil
+	}
+	v, err := decodeReply(r)
+	if err != nil {
+		return err
+	}
+	return v.err()
+}
+
+// Bytes retrieves the data of the string-like replies, such as the simple strings, bulk strings and integers.
+// ErrNilReply will be returned for the nil replies, and ReplyError will be returned for the error replies.
+func (r Reply) Bytes() ([]byte, error) {
+	v, err := decodeReply(r)
+	if err != nil {
+		return nil, err
+	}
+	return v.text()
+}
+
+// Text retrieves the data of the string-like replies as a string.
+func (r Reply) Text() (string, error) {
+	b, err := r.Bytes()
+	return string(b), err
+}
+
+// Int retrieves the integer of the reply, the string-like replies are parsed.
+func (r Reply) Int() (int64, error) {
+	b, err := r.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(b), 10, 64)
+}
+
+// Float retrieves the float of the reply, the string-like replies are parsed.
+func (r Reply) Float() (float64, error) {
+	b, err := r.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(b), 64)
+}
+
+// Bool retrieves the boolean of the reply, the integer replies are true if they are not zero.
+func (r Reply) Bool() (bool, error) {
+	v, err := decodeReply(r)
+	if err != nil {
+		return false, err
+	}
+	b, err := v.text()
+	if err != nil {
+		return false, err
+	}
+	switch v.typ {
+	case TypeBoolean, TypeIntegers:
+		return string(b) != "0", nil
+	}
+	return strconv.ParseBool(string(b))
+}
+
+// Array retrieves the elements of the aggregate replies, the maps are flattened to the key value pairs.
+func (r Reply) Array() ([]Reply, error) {
+	v, err := decodeReply(r)
+	if err != nil {
+		return nil, err
+	}
+	elems, err := v.aggregate()
+	if err != nil {
+		return nil, err
+	}
+	replies := make([]Reply, len(elems))
+	for i, elem := range elems {
+		replies[i] = elem.raw
+	}
+	return replies, nil
+}
+
+// Map retrieves the map reply, or the array reply with key value pairs. The keys must be string-like.
+func (r Reply) Map() (map[string]Reply, error) {
+	v, err := decodeReply(r)
+	if err != nil {
+		return nil, err
+	}
+	elems, err := v.aggregate()
+	if err != nil {
+		return nil, err
+	}
+	if len(elems)%2 != 0 {
+		return nil, &ReplyTypeError{Type: v.typ, Want: "map"}
+	}
+	m := make(map[string]Reply, len(elems)/2)
+	for i := 0; i < len(elems); i += 2 {
+		key, err := elems[i].text()
+		if err != nil {
+			return nil, err
+		}
+		m[string(key)] = elems[i+1].raw
+	}
+	return m, nil
+}
+
 // NewSimpleStringsReply creates the response for simple strings reply.
 func NewSimpleStringsReply(data string) Reply {
-	return createSimpleReply(SimpleStringsReplyPrefix, data)
+	return AppendSimpleStrings(make([]byte, 0, len(data)+3), data)
 }
 
 // NewErrorsReply creates the response for errors reply.
 func NewErrorsReply(data string) Reply {
-	return createSimpleReply(ErrorsReplyPrefix, data)
+	return AppendErrors(make([]byte, 0, len(data)+3), data)
 }
 
 // NewIntegersReply creates the response for integers reply.
 func NewIntegersReply(data int) Reply {
-	return createSimpleReply(IntegersReplyPrefix, strconv.Itoa(data))
+	return AppendIntegers(make([]byte, 0, 23), int64(data))
 }
 
 // NewBulkStringsReply creates the response for binary-safe strings reply.
 func NewBulkStringsReply(data string) Reply {
-	return createBuckStrings([]byte(data))
+	return AppendBulkStrings(make([]byte, 0, bulkStringsSize(len(data))), data)
 }
 
 // NewBulkStringsReplyRaw creates the response for binary-safe strings reply with raw bytes, if the data is nil, the response replies "$-1\r\n".
 func NewBulkStringsReplyRaw(data []byte) Reply {
-	return createBuckStrings(data)
+	if data == nil {
+		return AppendBulkStringsRaw(make([]byte, 0, 5), nil)
+	}
+	return AppendBulkStringsRaw(make([]byte, 0, bulkStringsSize(len(data))), data)
 }
 
 // NewArraysReply creates the response for arrays reply.
 func NewArraysReply(data ...string) Reply {
-	buffer := make([][]byte, 3+len(data))
-	buffer[0] = []byte{ArraysReplyPrefix}
-	buffer[1] = []byte(strconv.Itoa(len(data)))
-	buffer[2] = crlf
-	for i, elem := range data {
-		buffer[3+i] = createBuckStrings([]byte(elem))
+	size := headerSize(len(data))
+	for _, elem := range data {
+		size += bulkStringsSize(len(elem))
+	}
+	b := AppendArraysHeader(make([]byte, 0, size), len(data))
+	for _, elem := range data {
+		b = AppendBulkStrings(b, elem)
 	}
-	return bytes.Join(buffer, nil)
+	return b
 }
 
 // NewArraysReplyRaw creates the response for arrays reply with raw bytes, if the bytes in data is nil, the bulk string in the response replies "$-1\r\n".
 func NewArraysReplyRaw(data ...[]byte) Reply {
-	buffer := make([][]byte, 3+len(data))
-	buffer[0] = []byte{ArraysReplyPrefix}
-	buffer[1] = []byte(strconv.Itoa(len(data)))
-	buffer[2] = crlf
-	for i, elem := range data {
-		buffer[3+i] = createBuckStrings(elem)
+	size := headerSize(len(data))
+	for _, elem := range data {
+		if elem == nil {
+			size += 5
+		} else {
+			size += bulkStringsSize(len(elem))
+		}
+	}
+	b := AppendArraysHeader(make([]byte, 0, size), len(data))
+	for _, elem := range data {
+		b = AppendBulkStringsRaw(b, elem)
+	}
+	return b
+}
+
+// NewNestedArraysReply creates the response for arrays reply whose elements are the replies, such as the
+// arrays, so the nested replies are built from the inner ones.
+func NewNestedArraysReply(elems ...Reply) Reply {
+	size := headerSize(len(elems))
+	for _, elem := range elems {
+		size += len(elem)
+	}
+	b := AppendArraysHeader(make([]byte, 0, size), len(elems))
+	for _, elem := range elems {
+		b = append(b, elem...)
 	}
-	return bytes.Join(buffer, nil)
+	return b
+}
+
+// AppendSimpleStrings appends the simple strings reply to b.
+func AppendSimpleStrings(b []byte, data string) []byte {
+	b = append(b, SimpleStringsReplyPrefix)
+	b = append(b, data...)
+	return append(b, '\r', '\n')
 }
 
-func createSimpleReply(prefix byte, data string) []byte {
-	buffer := make([][]byte, 3)
-	buffer[0] = []byte{prefix}
-	buffer[1] = []byte(data)
-	buffer[2] = crlf
-	return bytes.Join(buffer, nil)
+// AppendErrors appends the errors reply to b.
+func AppendErrors(b []byte, data string) []byte {
+	b = append(b, ErrorsReplyPrefix)
+	b = append(b, data...)
+	return append(b, '\r', '\n')
 }
 
-func createBuckStrings(data []byte) []byte {
-	var buffer [][]byte
+// AppendIntegers appends the integers reply to b.
+func AppendIntegers(b []byte, data int64) []byte {
+	b = append(b, IntegersReplyPrefix)
+	b = strconv.AppendInt(b, data, 10)
+	return append(b, '\r', '\n')
+}
+
+// AppendBulkStrings appends the binary-safe strings reply to b.
+func AppendBulkStrings(b []byte, data string) []byte {
+	b = appendHeader(b, BulkStringsReplyPrefix, len(data))
+	b = append(b, data...)
+	return append(b, '\r', '\n')
+}
+
+// AppendBulkStringsRaw appends the binary-safe strings reply with raw bytes to b, if the data is nil, "$-1\r\n" is appended.
+func AppendBulkStringsRaw(b []byte, data []byte) []byte {
 	if data == nil {
-		buffer = make([][]byte, 3)
-		buffer[0] = []byte{BulkStringsReplyPrefix}
-		buffer[1] = []byte("-1")
-		buffer[2] = crlf
-	} else {
-		buffer = make([][]byte, 5)
-		buffer[0] = []byte{BulkStringsReplyPrefix}
-		buffer[1] = []byte(strconv.Itoa(len(data)))
-		buffer[2] = crlf
-		buffer[3] = []byte(data)
-		buffer[4] = crlf
-	}
-	return bytes.Join(buffer, nil)
+		return append(b, "$-1\r\n"...)
+	}
+	b = appendHeader(b, BulkStringsReplyPrefix, len(data))
+	b = append(b, data...)
+	return append(b, '\r', '\n')
+}
+
+// AppendArraysHeader appends the header of the arrays reply with n elements to b, the elements should be
+// appended after it. If n is negative, the nil array "*-1\r\n" is appended.
+func AppendArraysHeader(b []byte, n int) []byte {
+	if n < 0 {
+		return append(b, "*-1\r\n"...)
+	}
+	return appendHeader(b, ArraysReplyPrefix, n)
+}
+
+func appendHeader(b []byte, prefix byte, n int) []byte {
+	b = append(b, prefix)
+	b = strconv.AppendInt(b, int64(n), 10)
+	return append(b, '\r', '\n')
+}
+
+// headerSize calculates the size of the header with the length n.
+func headerSize(n int) int {
+	size := 4
+	for n >= 10 {
+		size++
+		n /= 10
+	}
+	return size
+}
+
+// bulkStringsSize calculates the size of the bulk strings reply with n bytes data.
+func bulkStringsSize(n int) int {
+	return headerSize(n) + n + 2
 }
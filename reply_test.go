@@ -1,6 +1,7 @@
 package beam
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -61,3 +62,152 @@ func TestNewArraysReplyRaw(t *testing.T) {
 	resp = NewArraysReplyRaw([]byte("foo"), nil)
 	assert.Equal(Reply("*2\r\n$3\r\nfoo\r\n$-1\r\n"), resp)
 }
+
+func TestNewNestedArraysReply(t *testing.T) {
+	assert := assert.New(t)
+
+	var resp Reply
+	resp = NewNestedArraysReply(NewBulkStringsReply("foo"), NewArraysReply("bar", "baz"), NewNestedArraysReply(), AppendArraysHeader(nil, -1))
+	assert.Equal(Reply("*4\r\n$3\r\nfoo\r\n*2\r\n$3\r\nbar\r\n$3\r\nbaz\r\n*0\r\n*-1\r\n"), resp)
+	assert.Equal(len(resp), cap(resp))
+	assert.Nil(resp.Validate())
+}
+
+func TestAppendReplies(t *testing.T) {
+	assert := assert.New(t)
+
+	b := AppendArraysHeader(nil, 5)
+	b = AppendSimpleStrings(b, "OK")
+	b = AppendErrors(b, "ERR failed")
+	b = AppendIntegers(b, -10)
+	b = AppendBulkStrings(b, "foo")
+	b = AppendBulkStringsRaw(b, nil)
+	assert.Equal("*5\r\n+OK\r\n-ERR failed\r\n:-10\r\n$3\r\nfoo\r\n$-1\r\n", string(b))
+	assert.Equal("*-1\r\n", string(AppendArraysHeader(nil, -1)))
+
+	reply := NewBulkStringsReply(string(make([]byte, 1234)))
+	assert.Equal(len(reply), cap(reply))
+	assert.Nil(reply.Validate())
+}
+
+func TestReply_Type(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(TypeSimpleStrings, NewSimpleStringsReply("OK").Type())
+	assert.Equal(TypeErrors, NewErrorsReply("ERR").Type())
+	assert.Equal(TypeIntegers, NewIntegersReply(1).Type())
+	assert.Equal(TypeBulkStrings, NewBulkStringsReply("foo").Type())
+	assert.Equal(TypeArrays, NewArraysReply("foo").Type())
+	assert.Equal(TypeMap, Reply("%0\r\n").Type())
+	assert.Equal(TypeInvalid, Reply("").Type())
+	assert.Equal(TypeInvalid, Reply("?").Type())
+	assert.Equal("bulk string", TypeBulkStrings.String())
+
+	assert.True(NewErrorsReply("ERR failed").IsError())
+	assert.True(Reply("!6\r\nfailed\r\n").IsError())
+	assert.False(NewSimpleStringsReply("OK").IsError())
+
+	assert.True(NewBulkStringsReplyRaw(nil).IsNil())
+	assert.True(Reply("*-1\r\n").IsNil())
+	assert.True(Reply("_\r\n").IsNil())
+	assert.False(NewBulkStringsReply("").IsNil())
+}
+
+func TestReply_Accessors(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(NewSimpleStringsReply("OK").Err())
+	assert.Equal(ReplyError("ERR failed"), NewErrorsReply("ERR failed").Err())
+	assert.Equal(ReplyError("SYNTAX invalid syntax"), Reply("!21\r\nSYNTAX invalid syntax\r\n").Err())
+
+	n, err := NewIntegersReply(-42).Int()
+	assert.Nil(err)
+	assert.EqualValues(-42, n)
+	n, err = NewBulkStringsReply("10").Int()
+	assert.Nil(err)
+	assert.EqualValues(10, n)
+	_, err = NewErrorsReply("ERR failed").Int()
+	assert.Equal(ReplyError("ERR failed"), err)
+
+	b, err := NewBulkStringsReply("foo").Bytes()
+	assert.Nil(err)
+	assert.Equal([]byte("foo"), b)
+	_, err = NewBulkStringsReplyRaw(nil).Bytes()
+	assert.Equal(ErrNilReply, err)
+	s, err := Reply("=15\r\ntxt:Some string\r\n").Text()
+	assert.Nil(err)
+	assert.Equal("Some string", s)
+	s, err = Reply("$?\r\n;4\r\nHell\r\n;1\r\no\r\n;0\r\n").Text()
+	assert.Nil(err)
+	assert.Equal("Hello", s)
+	_, err = NewArraysReply("foo").Text()
+	assert.IsType(&ReplyTypeError{}, err)
+
+	f, err := Reply(",3.14\r\n").Float()
+	assert.Nil(err)
+	assert.Equal(3.14, f)
+
+	ok, err := Reply("#t\r\n").Bool()
+	assert.Nil(err)
+	assert.True(ok)
+	ok, err = NewIntegersReply(0).Bool()
+	assert.Nil(err)
+	assert.False(ok)
+
+	elems, err := Reply("*2\r\n$3\r\nfoo\r\n*1\r\n:1\r\n").Array()
+	assert.Nil(err)
+	assert.Equal([]Reply{NewBulkStringsReply("foo"), Reply("*1\r\n:1\r\n")}, elems)
+	_, err = Reply("*-1\r\n").Array()
+	assert.Equal(ErrNilReply, err)
+	_, err = NewSimpleStringsReply("OK").Array()
+	assert.IsType(&ReplyTypeError{}, err)
+
+	m, err := Reply("%2\r\n+first\r\n:1\r\n+second\r\n$-1\r\n").Map()
+	assert.Nil(err)
+	assert.Equal(map[string]Reply{"first": NewIntegersReply(1), "second": NewBulkStringsReplyRaw(nil)}, m)
+
+	s, err = Reply("|1\r\n+ttl\r\n:3600\r\n$3\r\nbar\r\n").Text()
+	assert.Nil(err)
+	assert.Equal("bar", s)
+
+	for _, invalid := range []string{"", "+OK", ":abc\r\n", "$3\r\nfo\r\n", "*2\r\n:1\r\n", "+OK\r\n+OK\r\n",
+		"$?\r\n;1\r\naXX;0\r\n"} {
+		assert.Equal(ErrReplyFormat, Reply(invalid).Validate(), invalid)
+	}
+}
+
+func TestReply_MaxDepth(t *testing.T) {
+	assert := assert.New(t)
+
+	nested := func(depth int) Reply {
+		return Reply(strings.Repeat("*1\r\n", depth) + ":1\r\n")
+	}
+	assert.Nil(nested(MaxReplyDepth).Validate())
+	assert.Equal(ErrReplyFormat, nested(MaxReplyDepth+1).Validate())
+	assert.Equal(ErrReplyFormat, nested(1000000).Validate())
+	assert.Equal(ErrReplyFormat, Reply(strings.Repeat("*?\r\n", MaxReplyDepth+1)).Validate())
+	assert.Equal(ErrReplyFormat, Reply(strings.Repeat("|0\r\n", 1000000)+":1\r\n").Validate())
+}
+
+func BenchmarkNewBulkStringsReply(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = NewBulkStringsReply("value")
+	}
+}
+
+func BenchmarkNewArraysReplyRaw(b *testing.B) {
+	elems := [][]byte{[]byte("foo"), nil, []byte("bar")}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = NewArraysReplyRaw(elems...)
+	}
+}
+
+func BenchmarkReplies_Raw(b *testing.B) {
+	replies := Replies{NewSimpleStringsReply("OK"), NewBulkStringsReply("value"), NewIntegersReply(1)}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = replies.Raw()
+	}
+}
@@ -2,11 +2,12 @@ package beam
 
 import (
 	"errors"
+	"log/slog"
 	"net"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/gaemma/logging"
 )
 
 const defaultBufferSize = 16 * 1024
@@ -31,29 +32,77 @@ func NewServer(handler Handler, config Config) *Server {
 	if len(config.Network) == 0 {
 		config.Network = "tcp"
 	}
+	if config.EventLoops <= 0 {
+		config.EventLoops = runtime.GOMAXPROCS(0)
+	}
+	if config.Workers <= 0 {
+		config.Workers = runtime.GOMAXPROCS(0) * 8
+	}
+	if config.ParallelWorkers <= 0 {
+		config.ParallelWorkers = runtime.GOMAXPROCS(0) * 8
+	}
+	config.QueryLimits = config.QueryLimits.withDefaults()
+	limits := make(map[ClientClass]OutputBufferLimit, len(defaultOutputBufferLimits))
+	for class, limit := range defaultOutputBufferLimits {
+		limits[class] = limit
+	}
+	for class, limit := range config.OutputBufferLimits {
+		limits[class] = limit
+	}
+	config.OutputBufferLimits = limits
 	s := new(Server)
 	s.handler = handler
+	s.flagged, _ = handler.(FlaggedHandler)
+	s.parallel = make(chan struct{}, config.ParallelWorkers)
 	s.config = config
-	if config.Logger == nil {
-		s.logger = logging.NewNopLogger()
-	} else {
-		s.logger = config.Logger
+	switch {
+	case config.StructuredLogger != nil:
+		s.logger = config.StructuredLogger
+	case config.Logger != nil:
+		s.logger = slog.New(NewLoggingHandler(config.Logger))
+	default:
+		s.logger = slog.New(discardHandler{})
 	}
 	s.closeCh = make(chan struct{})
-	s.clients = make(map[string]*Client)
+	s.clients = make(map[uint64]*Client)
 	return s
 }
 
+// ServerStats contains the statistics data of the server.
+type ServerStats struct {
+	OutputBufferLimitDisconnections int64
+	RecoveredPanics                 int64
+}
+
+type serverStats struct {
+	outputBufferLimitDisconnections int64
+	recoveredPanics                 int64
+}
+
 // Server is a redis protocol supported engine.
 type Server struct {
-	config       Config
-	logger       logging.Logger
-	handler      Handler
-	listener     net.Listener
-	clientsWait  sync.WaitGroup
-	closeCh      chan struct{}
-	clients      map[string]*Client
-	clientsMutex sync.RWMutex
+	config        Config
+	logger        *slog.Logger
+	nextClientID  uint64
+	handler       Handler
+	flagged       FlaggedHandler
+	parallel      chan struct{}
+	listener      net.Listener
+	listenerMutex sync.Mutex
+	engine        engine
+	clientsWait   sync.WaitGroup
+	closeCh       chan struct{}
+	clients       map[uint64]*Client
+	clientsMutex  sync.RWMutex
+	stats         serverStats
+}
+
+// Stats retrieves the ServerStats value.
+func (s *Server) Stats() ServerStats {
+	return ServerStats{
+		OutputBufferLimitDisconnections: atomic.LoadInt64(&s.stats.outputBufferLimitDisconnections),
+		RecoveredPanics:                 atomic.LoadInt64(&s.stats.recoveredPanics),
+	}
 }
 
 // Serve runs the server engine on the given addr. if beam server is closed, ErrServerClosed will be retuend.
@@ -62,14 +111,29 @@ func (s *Server) Serve() error {
 	if err != nil {
 		return err
 	}
+	return s.ServeListener(l)
+}
+
+// ServeListener runs the server engine on the given listener, which is closed when the server is closed.
+func (s *Server) ServeListener(l net.Listener) (err error) {
+	s.listenerMutex.Lock()
 	s.listener = l
+	s.listenerMutex.Unlock()
 
-	s.logger.Info("boot the beam server \"%s\".", l.Addr())
+	s.engine, err = newEngine(s)
+	if err != nil {
+		_ = l.Close()
+		return err
+	}
+
+	s.logger.Info("boot the beam server", LogKeyAddr, l.Addr().String())
 
 	sleep := time.Second
 	for {
 		if s.closed() {
-			return ErrServerClosed
+			_ = l.Close()
+			err = ErrServerClosed
+			break
 		}
 
 		var conn net.Conn
@@ -88,26 +152,32 @@ func (s *Server) Serve() error {
 		}
 		sleep = time.Second
 
-		client := s.createClient(conn, s.config.BufferSize)
-		s.startClient(client)
+		s.engine.serve(conn)
 	}
 
+	s.engine.wait()
 	s.clientsWait.Wait()
 	return err
 }
 
 func (s *Server) startClient(client *Client) {
+	s.addClient(client)
+	go protectCall(client.run, s.logger)
+}
+
+// addClient tracks the client until stopClient is called.
+func (s *Server) addClient(client *Client) {
 	s.clientsWait.Add(1)
 	s.clientsMutex.Lock()
-	s.clients[client.conn.RemoteAddr().String()] = client
+	s.clients[client.id] = client
 	s.clientsMutex.Unlock()
-	go protectCall(client.run, s.logger)
 }
 
 func (s *Server) stopClient(client *Client) {
+	client.markDone()
 	s.clientsWait.Done()
 	s.clientsMutex.Lock()
-	delete(s.clients, client.conn.RemoteAddr().String())
+	delete(s.clients, client.id)
 	s.clientsMutex.Unlock()
 }
 
@@ -117,10 +187,20 @@ func (s *Server) Close() error {
 	case <-s.closeCh:
 		return nil
 	default:
-		s.logger.Info("server is closed.")
+		s.logger.Info("server is closed")
 		close(s.closeCh)
-		err := s.listener.Close()
-		return err
+		// the handlers blocking on Done return, so the clients can be closed.
+		s.clientsMutex.RLock()
+		for _, client := range s.clients {
+			client.markDone()
+		}
+		s.clientsMutex.RUnlock()
+		s.listenerMutex.Lock()
+		defer s.listenerMutex.Unlock()
+		if s.listener == nil {
+			return nil
+		}
+		return s.listener.Close()
 	}
 }
 
@@ -133,12 +213,37 @@ func (s *Server) closed() bool {
 	}
 }
 
+// Clients retrieves the connected clients.
+func (s *Server) Clients() []*Client {
+	s.clientsMutex.RLock()
+	defer s.clientsMutex.RUnlock()
+	clients := make([]*Client, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// outputBufferLimit retrieves the OutputBufferLimit of the given ClientClass.
+func (s *Server) outputBufferLimit(class ClientClass) OutputBufferLimit {
+	return s.config.OutputBufferLimits[class]
+}
+
 func (s *Server) createClient(conn net.Conn, bufferSize int) *Client {
 	c := new(Client)
 	c.s = s
+	c.id = atomic.AddUint64(&s.nextClientID, 1)
+	c.logger = s.logger.With(LogKeyClientID, c.id, LogKeyRemoteAddr, conn.RemoteAddr().String())
 	c.conn = conn
-	c.b = make([]byte, bufferSize)
+	if bufferSize > 0 {
+		c.b = make([]byte, bufferSize)
+	}
 	c.stats = new(ClientStats)
 	c.attributes = make(map[string]interface{})
+	c.closeCh = make(chan struct{})
+	c.done = make(chan struct{})
+	c.out = newOutputBuffer()
+	c.wakeup = c.out.wakeup
+	c.writerDone = make(chan struct{})
 	return c
 }
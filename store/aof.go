@@ -0,0 +1,424 @@
+package store
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/caeret/beam"
+)
+
+// FsyncPolicy is the policy of syncing the append-only file to the disk.
+type FsyncPolicy int
+
+const (
+	// FsyncEverysec syncs the file every second in the background, at most a second of the writes
+	// are lost if the system crashes.
+	FsyncEverysec FsyncPolicy = iota
+	// FsyncAlways syncs the file before replying the write commands.
+	FsyncAlways
+	// FsyncNo leaves the syncing to the operating system.
+	FsyncNo
+)
+
+// rewriteBatchSize is the max number of the items added by a command of the rewritten file.
+const rewriteBatchSize = 64
+
+// appendOnlyFile logs the write commands in the redis protocol, the commands are written to the
+// file before they are replied, and they are replayed when the store is opened.
+type appendOnlyFile struct {
+	path string
+	file *os.File
+	// size is the size of the file, baseSize is the size after the last rewrite.
+	size     int64
+	baseSize int64
+	// buf is the commands not written yet, which are retried if the write fails.
+	buf []byte
+	// err is the error of the last write, the write commands are rejected until it is retried
+	// successfully.
+	err error
+	// dirty is set if the file is written after it is synced.
+	dirty bool
+	// rewriting is set while the file is rewritten in the background, the commands logged during it
+	// are also kept in rewriteBuf and appended to the rewritten file.
+	rewriting  bool
+	rewriteBuf []byte
+	// stale is set if the keys are replaced by the snapshot of the primary during the rewrite, the
+	// rewritten file is discarded and the rewrite is started again.
+	stale bool
+}
+
+// loadAppendOnlyFile opens the append-only file and replays its commands, the file is created if it
+// does not exist. The incomplete command at the end, which is written partially before a crash, is
+// truncated.
+func (s *Store) loadAppendOnlyFile(path string) (*appendOnlyFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	size, err := s.replay(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("store: fail to load the append-only file %s: %w", path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if info.Size() > size {
+		s.config.Logger.Warn("truncate the incomplete command of the append-only file", "path", path,
+			beam.LogKeyOffset, size, beam.LogKeyBytes, info.Size()-size)
+		if err := file.Truncate(size); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+	return &appendOnlyFile{path: path, file: file, size: size, baseSize: size}, nil
+}
+
+// replay executes the commands read from r, the size of the complete commands will be returned.
+func (s *Store) replay(r io.Reader) (int64, error) {
+	s.loading = true
+	defer func() { s.loading = false }()
+	var (
+		buf  []byte
+		size int64
+	)
+	for {
+		buf = slices.Grow(buf, 64*1024)
+		n, err := r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		queries, left, perr := beam.ReadQuery(buf)
+		for _, q := range queries {
+			reply, _ := s.Handle(beam.NewRequest(nil, q))
+			if err := reply.Err(); err != nil {
+				return size, fmt.Errorf("fail to replay %s: %w", q.CommandStr(), err)
+			}
+		}
+		if perr != nil {
+			var protocolErr *beam.ProtocolError
+			if errors.As(perr, &protocolErr) {
+				return size, fmt.Errorf("%w at offset %d", perr, size+int64(protocolErr.Offset))
+			}
+			return size, perr
+		}
+		size += int64(len(buf) - len(left))
+		buf = append(buf[:0], left...)
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil {
+			return size, err
+		}
+	}
+}
+
+// feed logs the commands executed with the lock held to the file and the replicas, they are
+// written to the file at once and synced with FsyncAlways.
+func (s *Store) feed(queries []beam.Query) {
+	if len(queries) == 0 {
+		return
+	}
+	s.replicate(queries)
+	if s.aof == nil {
+		return
+	}
+	aof := s.aof
+	for _, q := range queries {
+		aof.buf = q.AppendRaw(aof.buf)
+		if aof.rewriting {
+			aof.rewriteBuf = q.AppendRaw(aof.rewriteBuf)
+		}
+	}
+	aof.flush(s.config.AppendFsync == FsyncAlways)
+}
+
+// flush writes the buffered commands to the file, the partially written command is truncated so the
+// file can still be loaded, or it is left to be completed by the retry.
+func (aof *appendOnlyFile) flush(sync bool) {
+	if len(aof.buf) == 0 {
+		return
+	}
+	n, err := aof.file.Write(aof.buf)
+	if err != nil {
+		if n > 0 {
+			if terr := aof.file.Truncate(aof.size); terr != nil {
+				aof.size += int64(n)
+				aof.buf = aof.buf[:copy(aof.buf, aof.buf[n:])]
+			}
+		}
+		aof.err = err
+		return
+	}
+	aof.size += int64(n)
+	aof.buf = aof.buf[:0]
+	aof.err = nil
+	aof.dirty = true
+	if sync {
+		if err := aof.file.Sync(); err != nil {
+			aof.err = err
+			return
+		}
+		aof.dirty = false
+	}
+}
+
+// close writes the buffered commands and syncs the file before closing it.
+func (aof *appendOnlyFile) close() error {
+	aof.flush(true)
+	if err := aof.file.Close(); err != nil {
+		return err
+	}
+	return aof.err
+}
+
+// appendOnlyFileCron retries the failed writes, syncs the file with FsyncEverysec and rewrites the
+// file automatically every second.
+func (s *Store) appendOnlyFileCron() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closeCh:
+			return
+		case <-ticker.C:
+		}
+		s.mutex.Lock()
+		aof := s.aof
+		aof.flush(false)
+		// the file is synced without the lock, so the commands are not blocked by the slow disk.
+		var file *os.File
+		if s.config.AppendFsync == FsyncEverysec && aof.dirty {
+			file = aof.file
+			aof.dirty = false
+		}
+		if s.config.AutoRewritePercentage > 0 && !aof.rewriting && aof.size >= s.config.AutoRewriteMinSize &&
+			(aof.size-aof.baseSize)*100 >= aof.baseSize*int64(s.config.AutoRewritePercentage) {
+			s.config.Logger.Info("rewrite the append-only file automatically", "path", aof.path, beam.LogKeyBytes, aof.size)
+			s.rewriteAppendOnlyFile()
+		}
+		s.mutex.Unlock()
+
+		// the file may have been replaced by the rewrite and closed, it is synced before that.
+		if file != nil {
+			if err := file.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+				s.config.Logger.Error("fail to sync the append-only file", "path", aof.path, beam.LogKeyError, err)
+			}
+		}
+	}
+}
+
+// bgrewriteaofCommand implements BGREWRITEAOF.
+func bgrewriteaofCommand(c *call) beam.Reply {
+	if c.aof == nil {
+		return beam.NewErrorsReply("ERR Append only file is not enabled")
+	}
+	if c.aof.rewriting {
+		return beam.NewErrorsReply("ERR Background append only file rewriting already in progress")
+	}
+	c.rewriteAppendOnlyFile()
+	return beam.NewSimpleStringsReply("Background append only file rewriting started")
+}
+
+// rewriteAppendOnlyFile rewrites the file in the background with the commands creating the keys at
+// the moment, the commands logged during the rewrite are appended to the new file, then it replaces
+// the current one.
+func (s *Store) rewriteAppendOnlyFile() {
+	aof := s.aof
+	keys := s.snapshot()
+	aof.rewriting = true
+	aof.rewriteBuf = nil
+	now := s.now()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		tmp := aof.path + ".rewrite"
+		file, err := writeSnapshotFile(tmp, keys, now)
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.releaseSnapshot()
+		if aof.stale {
+			if file != nil {
+				_ = file.Close()
+			}
+			_ = os.Remove(tmp)
+			aof.stale = false
+			s.rewriteAppendOnlyFile()
+			return
+		}
+		if err == nil {
+			err = aof.replace(file)
+		}
+		aof.rewriting = false
+		aof.rewriteBuf = nil
+		if err != nil {
+			if file != nil {
+				_ = file.Close()
+			}
+			_ = os.Remove(tmp)
+			s.config.Logger.Error("fail to rewrite the append-only file", "path", aof.path, beam.LogKeyError, err)
+			return
+		}
+		s.config.Logger.Info("rewrite the append-only file", "path", aof.path, beam.LogKeyBytes, aof.size)
+	}()
+}
+
+// rewriteAfterSync rewrites the file with the keys replaced by the snapshot of the primary.
+func (s *Store) rewriteAfterSync() {
+	if s.aof.rewriting {
+		s.aof.stale = true
+		return
+	}
+	s.rewriteAppendOnlyFile()
+}
+
+// writeSnapshotFile writes the commands creating the keys to the file, which is synced and opened
+// for appending.
+func writeSnapshotFile(path string, keys *dict[*entry], now int64) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	w := &rewriter{w: bufio.NewWriterSize(file, 64*1024)}
+	keys.each(func(key string, e *entry) bool {
+		if e.expireAt > 0 && e.expireAt <= now {
+			return true
+		}
+		w.rewrite([]byte(key), e)
+		return w.err == nil
+	})
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	if w.err == nil {
+		w.err = file.Sync()
+	}
+	return file, w.err
+}
+
+// replace appends the commands logged during the rewrite to the rewritten file, and replaces the
+// current file with it.
+func (aof *appendOnlyFile) replace(file *os.File) error {
+	if _, err := file.Write(aof.rewriteBuf); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), aof.path); err != nil {
+		return err
+	}
+	_ = aof.file.Close()
+	aof.file = file
+	aof.size, aof.baseSize = info.Size(), info.Size()
+	// the failed writes are included in the rewritten file.
+	aof.buf = aof.buf[:0]
+	aof.err = nil
+	aof.dirty = false
+	return nil
+}
+
+// rewriter writes the commands creating the keys.
+type rewriter struct {
+	w   *bufio.Writer
+	buf []byte
+	err error
+}
+
+func (w *rewriter) emit(command string, args ...[]byte) {
+	if w.err != nil {
+		return
+	}
+	w.buf = query(command, args...).AppendRaw(w.buf[:0])
+	_, w.err = w.w.Write(w.buf)
+}
+
+// emitBatches emits the commands adding the items in batches, so the commands of the large keys are
+// not too long.
+func (w *rewriter) emitBatches(command string, key []byte, items [][]byte, itemSize int) {
+	batch := rewriteBatchSize * itemSize
+	for len(items) > 0 {
+		n := min(len(items), batch)
+		w.emit(command, append([][]byte{key}, items[:n]...)...)
+		items = items[n:]
+	}
+}
+
+// rewrite emits the commands creating the key with its expiry.
+func (w *rewriter) rewrite(key []byte, e *entry) {
+	switch v := e.value.(type) {
+	case []byte:
+		w.emit("SET", key, v)
+	case *list:
+		items := make([][]byte, v.len())
+		for i := range items {
+			items[i] = v.at(i)
+		}
+		w.emitBatches("RPUSH", key, items, 1)
+	case *hash:
+		items := make([][]byte, 0, v.len()*2)
+		v.each(func(field string, value []byte) bool {
+			items = append(items, []byte(field), value)
+			return true
+		})
+		w.emitBatches("HSET", key, items, 2)
+	case *set:
+		items := make([][]byte, 0, v.len())
+		v.each(func(member string, _ struct{}) bool {
+			items = append(items, []byte(member))
+			return true
+		})
+		w.emitBatches("SADD", key, items, 1)
+	case *zset:
+		items := make([][]byte, 0, v.len()*2)
+		v.scores.each(func(member string, score float64) bool {
+			items = append(items, formatScore(score), []byte(member))
+			return true
+		})
+		w.emitBatches("ZADD", key, items, 2)
+	case *stream:
+		w.rewriteStream(key, v)
+	}
+	if e.expireAt > 0 {
+		w.emit("PEXPIREAT", key, strconv.AppendInt(nil, e.expireAt, 10))
+	}
+}
+
+// rewriteStream emits the entries of the stream and its metadata, then the consumer groups with
+// their consumers and pending entries.
+func (w *rewriter) rewriteStream(key []byte, s *stream) {
+	if s.len() == 0 {
+		// the empty stream is created by adding an entry and trimming it.
+		w.emit("XADD", key, []byte("MAXLEN"), []byte("0"), []byte("0-1"), []byte("x"), []byte("y"))
+	}
+	for _, e := range s.entries {
+		w.emit("XADD", append([][]byte{key, e.id.append(nil)}, e.fields...)...)
+	}
+	w.emit("XSETID", key, s.lastID.append(nil), []byte("ENTRIESADDED"), strconv.AppendInt(nil, s.entriesAdded, 10),
+		[]byte("MAXDELETEDID"), s.maxDeletedID.append(nil))
+	for _, g := range sortedGroups(s) {
+		name := []byte(g.name)
+		w.emit("XGROUP", []byte("CREATE"), key, name, g.lastID.append(nil),
+			[]byte("ENTRIESREAD"), strconv.AppendInt(nil, g.entriesRead, 10))
+		for _, cons := range sortedConsumers(g) {
+			w.emit("XGROUP", []byte("CREATECONSUMER"), key, name, []byte(cons.name))
+		}
+		for _, pe := range sortedPending(g.pending) {
+			w.emit("XCLAIM", key, name, []byte(pe.consumer.name), []byte("0"), pe.id.append(nil),
+				[]byte("TIME"), strconv.AppendInt(nil, pe.deliveryTime, 10),
+				[]byte("RETRYCOUNT"), strconv.AppendInt(nil, pe.deliveryCount, 10), []byte("FORCE"), []byte("JUSTID"))
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caeret/beam"
+	"github.com/caeret/beam/beamtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// readAppendOnlyFile reads the commands of the file, the arguments are joined by spaces.
+func readAppendOnlyFile(t *testing.T, path string) []string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries, left, err := beam.ReadQuery(b)
+	if err != nil || len(left) > 0 {
+		t.Fatalf("invalid append-only file: %v, %q", err, left)
+	}
+	commands := make([]string, len(queries))
+	for i, query := range queries {
+		args := []string{query.CommandStr()}
+		for _, arg := range query.Arguments {
+			args = append(args, string(arg))
+		}
+		commands[i] = strings.Join(args, " ")
+	}
+	return commands
+}
+
+// reopen closes the store and opens the append-only file with a new store at the same time.
+func reopen(t *testing.T, s *Store, clk *clock) (*Store, *clock, *beamtest.Conn) {
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, clk2, conn := newTestStore(t, s.config)
+	clk2.Store(clk.Load())
+	return s, clk2, conn
+}
+
+func TestStore_AppendOnlyFile(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	s, clk, conn := newTestStore(t, Config{AppendOnlyFile: path, ActiveExpireInterval: time.Hour})
+
+	beamtest.AssertOK(t, conn.Do("SET", "foo", "bar", "EX", "10"))
+	beamtest.AssertInteger(t, 1, conn.Do("EXPIRE", "foo", "20", "GT"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXPIRE", "foo", "10", "GT"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXPIRE", "missing", "10"))
+	beamtest.AssertInteger(t, 3, conn.Do("SADD", "s", "a", "b", "c"))
+	popped, err := beam.Reply(conn.Do("SPOP", "s")).Text()
+	assert.NoError(err)
+	beamtest.AssertBulkString(t, "1700000000000-0", conn.Do("XADD", "x", "MAXLEN", "10", "*", "f", "v"))
+	beamtest.AssertBulkString(t, "1700000000000-1", conn.Do("XADD", "x", "1700000000000-*", "f", "v"))
+	beamtest.AssertInteger(t, 2, conn.Do("RPUSH", "l", "a", "b"))
+	beamtest.AssertStrings(t, []string{"l", "b"}, conn.Do("BRPOP", "l", "0"))
+	beamtest.AssertError(t, "WRONGTYPE", conn.Do("LPUSH", "foo", "a"))
+	beamtest.AssertBulkString(t, "bar", conn.Do("GET", "foo"))
+
+	// the blocked client is logged when it is served.
+	other := beamtest.NewServer(t, s, beam.Config{}).Dial()
+	other.Send(beam.NewQuery("BLMOVE", "q", "l", "LEFT", "RIGHT", "0"))
+	waitBlocked(t, s, "q", 1)
+	beamtest.AssertInteger(t, 1, conn.Do("LPUSH", "q", "c"))
+	beamtest.AssertBulkString(t, "c", other.Read())
+
+	// the expired key is deleted.
+	beamtest.AssertOK(t, conn.Do("SET", "tmp", "1", "PX", "10"))
+	clk.advance(time.Millisecond * 10)
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "tmp"))
+
+	assert.Equal([]string{
+		"SET foo bar PXAT 1700000010000",
+		"PEXPIREAT foo 1700000020000",
+		"SADD s a b c",
+		"SREM s " + popped,
+		"XADD x MAXLEN 10 1700000000000-0 f v",
+		"XADD x 1700000000000-1 f v",
+		"RPUSH l a b",
+		"RPOP l",
+		"LPUSH q c",
+		"LMOVE q l LEFT RIGHT",
+		"SET tmp 1 PXAT 1700000000010",
+		"DEL tmp",
+	}, readAppendOnlyFile(t, path))
+
+	_, _, conn = reopen(t, s, clk)
+	beamtest.AssertBulkString(t, "bar", conn.Do("GET", "foo"))
+	beamtest.AssertInteger(t, 19990, conn.Do("PTTL", "foo"))
+	beamtest.AssertInteger(t, 2, conn.Do("SCARD", "s"))
+	beamtest.AssertInteger(t, 0, conn.Do("SISMEMBER", "s", popped))
+	beamtest.AssertInteger(t, 2, conn.Do("XLEN", "x"))
+	beamtest.AssertStrings(t, []string{"a", "c"}, conn.Do("LRANGE", "l", "0", "-1"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "tmp", "q"))
+}
+
+func TestStore_AppendOnlyFileGroups(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	s, clk, conn := newTestStore(t, Config{AppendOnlyFile: path})
+
+	beamtest.AssertBulkString(t, "1-0", conn.Do("XADD", "x", "1-0", "f", "v"))
+	beamtest.AssertBulkString(t, "2-0", conn.Do("XADD", "x", "2-0", "f", "v"))
+	beamtest.AssertOK(t, conn.Do("XGROUP", "CREATE", "x", "g", "0"))
+	conn.Do("XREADGROUP", "GROUP", "g", "alice", "COUNT", "1", "STREAMS", "x", "0")
+	conn.Do("XREADGROUP", "GROUP", "g", "alice", "COUNT", "1", "STREAMS", "x", ">")
+	clk.advance(time.Second)
+	conn.Do("XCLAIM", "x", "g", "bob", "0", "1-0")
+	beamtest.AssertInteger(t, 1, conn.Do("XDEL", "x", "1-0"))
+	conn.Do("XAUTOCLAIM", "x", "g", "bob", "0", "0")
+
+	assert.Equal([]string{
+		"XADD x 1-0 f v",
+		"XADD x 2-0 f v",
+		"XGROUP CREATE x g 0",
+		"XGROUP CREATECONSUMER x g alice",
+		"XCLAIM x g alice 0 1-0 TIME 1700000000000 RETRYCOUNT 1 FORCE JUSTID",
+		"XGROUP SETID x g 1-0 ENTRIESREAD 1",
+		"XGROUP CREATECONSUMER x g bob",
+		"XCLAIM x g bob 0 1-0 TIME 1700000001000 RETRYCOUNT 2 FORCE JUSTID",
+		"XDEL x 1-0",
+		"XACK x g 1-0",
+	}, readAppendOnlyFile(t, path))
+
+	_, _, conn = reopen(t, s, clk)
+	info, err := beam.Reply(conn.Do("XINFO", "GROUPS", "x")).Array()
+	assert.NoError(err)
+	if assert.Len(info, 1) {
+		g, _ := info[0].Map()
+		id, _ := g["last-delivered-id"].Text()
+		assert.Equal("1-0", id)
+		n, _ := g["consumers"].Int()
+		assert.EqualValues(2, n)
+		n, _ = g["pending"].Int()
+		assert.Zero(n)
+	}
+}
+
+func TestStore_AppendOnlyFileLoad(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "appendonly.aof")
+	complete := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\nSET b 2\r\n"
+
+	// the incomplete command at the end is truncated.
+	assert.NoError(os.WriteFile(path, []byte(complete+"*3\r\n$3\r\nSET\r\n$1\r\nc"), 0o644))
+	s, _, conn := newTestStore(t, Config{AppendOnlyFile: path})
+	beamtest.AssertBulkString(t, "1", conn.Do("GET", "a"))
+	beamtest.AssertBulkString(t, "2", conn.Do("GET", "b"))
+	beamtest.AssertNil(t, conn.Do("GET", "c"))
+	beamtest.AssertOK(t, conn.Do("SET", "c", "3"))
+	assert.NoError(s.Close())
+	assert.Equal([]string{"SET a 1", "SET b 2", "SET c 3"}, readAppendOnlyFile(t, path))
+
+	for _, data := range []string{
+		complete + "*1\r\n$3\r\nFOO\r\n",
+		complete + "*x\r\n",
+		"*1\r\n$3\r\nSET\r\n",
+	} {
+		assert.NoError(os.WriteFile(path, []byte(data), 0o644))
+		_, err := Open(Config{AppendOnlyFile: path})
+		assert.Error(err, data)
+	}
+	_, err := Open(Config{AppendOnlyFile: filepath.Join(dir, "missing", "appendonly.aof")})
+	assert.Error(err)
+}
+
+func TestStore_AppendOnlyFileError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	s, _, conn := newTestStore(t, Config{AppendOnlyFile: path, AppendFsync: FsyncAlways})
+
+	beamtest.AssertOK(t, conn.Do("SET", "a", "1"))
+	s.mutex.Lock()
+	file := s.aof.file
+	s.aof.file, _ = os.Open(path)
+	s.mutex.Unlock()
+
+	// the write commands are rejected until the write succeeds.
+	beamtest.AssertOK(t, conn.Do("SET", "b", "2"))
+	beamtest.AssertError(t, "MISCONF Errors writing to the AOF file", conn.Do("SET", "c", "3"))
+	beamtest.AssertBulkString(t, "2", conn.Do("GET", "b"))
+
+	s.mutex.Lock()
+	s.aof.file.Close()
+	s.aof.file = file
+	s.aof.flush(true)
+	s.mutex.Unlock()
+	beamtest.AssertOK(t, conn.Do("SET", "c", "3"))
+	assert.Equal(t, []string{"SET a 1", "SET b 2", "SET c 3"}, readAppendOnlyFile(t, path))
+}
+
+// fillKeys creates the keys of all the types for the persistence tests, "tmp" expires in 1ms.
+func fillKeys(conn *beamtest.Conn) {
+	for i := range 100 {
+		conn.Do("RPUSH", "l", strings.Repeat("x", i))
+		conn.Do("HSET", "h", "f"+strings.Repeat("x", i), "v")
+		conn.Do("ZADD", "z", "1.5", "m"+strings.Repeat("x", i))
+	}
+	conn.Do("ZADD", "z", "-inf", "min")
+	conn.Do("SADD", "s", "a", "b")
+	conn.Do("SET", "foo", "bar", "PX", "1000")
+	conn.Do("SET", "tmp", "1", "PX", "1")
+	conn.Do("XADD", "x", "1-0", "f", "v")
+	conn.Do("XADD", "x", "2-0", "f", "v")
+	conn.Do("XADD", "x", "3-0", "f", "v")
+	conn.Do("XDEL", "x", "3-0")
+	conn.Do("XGROUP", "CREATE", "x", "g", "0")
+	conn.Do("XGROUP", "CREATECONSUMER", "x", "g", "idle")
+	conn.Do("XREADGROUP", "GROUP", "g", "alice", "COUNT", "1", "STREAMS", "x", ">")
+	conn.Do("XGROUP", "CREATE", "empty", "g", "$", "MKSTREAM")
+}
+
+// readKeys reads the keys created by fillKeys, the replies do not depend on the order of the
+// elements in the hash tables.
+func readKeys(conn *beamtest.Conn) []string {
+	reads := [][]string{
+		{"LRANGE", "l", "0", "-1"},
+		{"HLEN", "h"},
+		{"HGET", "h", "fxx"},
+		{"ZRANGE", "z", "0", "-1", "WITHSCORES"},
+		{"SCARD", "s"},
+		{"PTTL", "foo"},
+		{"XINFO", "STREAM", "x"},
+		{"XINFO", "GROUPS", "x"},
+		{"XPENDING", "x", "g", "-", "+", "10"},
+		{"XINFO", "STREAM", "empty"},
+	}
+	replies := make([]string, len(reads))
+	for i, read := range reads {
+		replies[i] = beam.Reply(conn.Do(read[0], read[1:]...)).String()
+	}
+	return replies
+}
+
+func TestStore_BGRewriteAOF(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	s, clk, conn := newTestStore(t, Config{AppendOnlyFile: path, ActiveExpireInterval: time.Hour})
+
+	_, _, memory := newTestStore(t, Config{})
+	beamtest.AssertError(t, "ERR Append only file is not enabled", memory.Do("BGREWRITEAOF"))
+
+	fillKeys(conn)
+	clk.advance(time.Millisecond)
+
+	before := readKeys(conn)
+
+	beamtest.AssertSimpleString(t, "Background append only file rewriting started", conn.Do("BGREWRITEAOF"))
+	// the commands during the rewrite are appended to the new file.
+	beamtest.AssertOK(t, conn.Do("SET", "new", "1"))
+	beamtest.AssertInteger(t, 1, conn.Do("SADD", "s", "c"))
+	assert.Eventually(func() bool {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return !s.aof.rewriting
+	}, time.Second*5, time.Millisecond*10)
+	beamtest.AssertInteger(t, 1, conn.Do("SREM", "s", "c"))
+
+	commands := readAppendOnlyFile(t, path)
+	assert.Contains(commands, "SET foo bar")
+	assert.Contains(commands, "PEXPIREAT foo 1700000001000")
+	assert.Contains(commands, "XSETID x 3-0 ENTRIESADDED 3 MAXDELETEDID 3-0")
+	assert.Contains(commands, "XADD empty MAXLEN 0 0-1 x y")
+	assert.NotContains(commands, "SET tmp 1")
+	assert.Equal("SREM s c", commands[len(commands)-1])
+
+	_, _, conn = reopen(t, s, clk)
+	assert.Equal(before, readKeys(conn))
+	beamtest.AssertBulkString(t, "1", conn.Do("GET", "new"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "tmp"))
+}
+
+func TestStore_Snapshot(t *testing.T) {
+	assert := assert.New(t)
+	s, _, conn := newTestStore(t, Config{})
+
+	conn.Do("SET", "a", "1")
+	conn.Do("RPUSH", "l", "a", "b")
+	conn.Do("XADD", "x", "1-0", "f", "v")
+	conn.Do("XGROUP", "CREATE", "x", "g", "0")
+	s.mutex.Lock()
+	keys := s.snapshot()
+	s.mutex.Unlock()
+
+	conn.Do("SET", "a", "2")
+	conn.Do("LPOP", "l")
+	conn.Do("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "x", ">")
+	conn.Do("SET", "b", "1")
+	beamtest.AssertInteger(t, 1, conn.Do("DEL", "x"))
+
+	assert.Equal(3, keys.len())
+	a, _ := keys.get("a")
+	assert.Equal([]byte("1"), a.value)
+	l, _ := keys.get("l")
+	assert.Equal(2, l.value.(*list).len())
+	x, _ := keys.get("x")
+	assert.Empty(x.value.(*stream).groups["g"].pending)
+
+	s.mutex.Lock()
+	s.releaseSnapshot()
+	s.mutex.Unlock()
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"math"
+	"slices"
+	"time"
+
+	"github.com/caeret/beam"
+)
+
+// waiter is a client blocked on the keys, the waiters of a key are served in FIFO order when the
+// key is ready.
+type waiter struct {
+	keys [][]byte
+	// serve tries to serve the waiter with the ready key, nil will be returned if it can not be served.
+	serve func(c *call, key []byte) beam.Reply
+	// timeout is the max time of blocking, 0 blocks forever.
+	timeout time.Duration
+	// timeoutReply is replied if the waiter is not served before the timeout.
+	timeoutReply beam.Reply
+	// reply is the reply of serve, it is set before done is closed.
+	reply beam.Reply
+	done  chan struct{}
+}
+
+// parseTimeout parses the timeout of the blocking commands in seconds.
+func parseTimeout(b []byte) (time.Duration, beam.Reply) {
+	f, ok := parseFloat(b)
+	if !ok || f > math.MaxInt64/float64(time.Second) {
+		return 0, beam.NewErrorsReply("ERR timeout is not a float or out of range")
+	}
+	if f < 0 {
+		return 0, beam.NewErrorsReply("ERR timeout is negative")
+	}
+	return time.Duration(f * float64(time.Second)), nil
+}
+
+// block blocks the client on the keys after the command, the keys are copied.
+func (c *call) block(w *waiter) {
+	keys := make([][]byte, len(w.keys))
+	for i, key := range w.keys {
+		keys[i] = clone(key)
+	}
+	w.keys = keys
+	w.done = make(chan struct{})
+	c.blocked = w
+}
+
+// signal marks the key ready if there are clients blocked on it.
+func (c *call) signal(key []byte) {
+	if _, ok := c.waiters[string(key)]; ok {
+		c.ready = append(c.ready, clone(key))
+	}
+}
+
+// serveReady serves the waiters of the ready keys in FIFO order, the keys may become ready again
+// while serving such as the destination of BLMOVE. The waiters which can not be served are skipped,
+// such as XREAD waiting for the entries after a future ID.
+func (c *call) serveReady() {
+	for len(c.ready) > 0 {
+		key := c.ready[0]
+		c.ready = c.ready[1:]
+		// the queue is copied since the served waiters are removed from it.
+		for _, w := range slices.Clone(c.waiters[string(key)]) {
+			reply := w.serve(c, key)
+			if reply == nil {
+				continue
+			}
+			w.reply = reply
+			c.unblock(w)
+			close(w.done)
+		}
+	}
+}
+
+// register adds the waiter to the queues of its keys.
+func (s *Store) register(w *waiter) {
+	for _, key := range w.keys {
+		s.waiters[string(key)] = append(s.waiters[string(key)], w)
+	}
+}
+
+// unblock removes the waiter from the queues of its keys.
+func (s *Store) unblock(w *waiter) {
+	for _, key := range w.keys {
+		waiters := s.waiters[string(key)]
+		for i, other := range waiters {
+			if other == w {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(s.waiters, string(key))
+		} else {
+			s.waiters[string(key)] = waiters
+		}
+	}
+}
+
+// wait waits until the waiter is served, the timeout reply will be returned after the timeout,
+// or the client or the store is closed.
+func (s *Store) wait(request *beam.Request, w *waiter) beam.Reply {
+	var timeout <-chan time.Time
+	if w.timeout > 0 {
+		timer := time.NewTimer(w.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var done <-chan struct{}
+	if request.Client != nil {
+		done = request.Done()
+	}
+	select {
+	case <-w.done:
+		return w.reply
+	case <-timeout:
+	case <-done:
+	case <-s.closeCh:
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-w.done:
+		// served before the lock is acquired.
+		return w.reply
+	default:
+	}
+	s.unblock(w)
+	return w.timeoutReply
+}
@@ -0,0 +1,211 @@
+package store
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/caeret/beam"
+)
+
+// command is a redis command implemented by the store.
+type command struct {
+	// name is the lower case name used in the error replies.
+	name string
+	// arity is the number of the arguments with the command itself as redis does, the negative
+	// one is the minimum.
+	arity int
+	flags commandFlags
+	fn    func(c *call) beam.Reply
+}
+
+// commandFlags describes how the command accesses the keyspace.
+type commandFlags int
+
+const (
+	// cmdWrite marks the commands which may modify the keyspace, they are propagated to the
+	// append-only file.
+	cmdWrite commandFlags = 1 << iota
+	// cmdReadOnly marks the commands which only read the keyspace.
+	cmdReadOnly
+	// cmdAdmin marks the commands which manage the store instead of the keys.
+	cmdAdmin
+)
+
+// commands maps the upper case command names to the commands, it is filled by init since the
+// replica looks up the commands of the replication stream.
+var commands = make(map[string]*command)
+
+func init() {
+	for _, cmd := range []*command{
+		// strings
+		{"get", 2, cmdReadOnly, getCommand},
+		{"set", -3, cmdWrite, setCommand},
+		{"mget", -2, cmdReadOnly, mgetCommand},
+		{"mset", -3, cmdWrite, msetCommand},
+		{"incr", 2, cmdWrite, incrCommand},
+		{"decr", 2, cmdWrite, decrCommand},
+		{"incrby", 3, cmdWrite, incrbyCommand},
+		{"decrby", 3, cmdWrite, decrbyCommand},
+		{"incrbyfloat", 3, cmdWrite, incrbyfloatCommand},
+		{"append", 3, cmdWrite, appendCommand},
+		{"getrange", 4, cmdReadOnly, getrangeCommand},
+		{"setrange", 4, cmdWrite, setrangeCommand},
+		{"strlen", 2, cmdReadOnly, strlenCommand},
+		// hashes
+		{"hset", -4, cmdWrite, hsetCommand},
+		{"hmset", -4, cmdWrite, hsetCommand},
+		{"hsetnx", 4, cmdWrite, hsetnxCommand},
+		{"hget", 3, cmdReadOnly, hgetCommand},
+		{"hmget", -3, cmdReadOnly, hmgetCommand},
+		{"hdel", -3, cmdWrite, hdelCommand},
+		{"hgetall", 2, cmdReadOnly, hgetallCommand},
+		{"hkeys", 2, cmdReadOnly, hkeysCommand},
+		{"hvals", 2, cmdReadOnly, hvalsCommand},
+		{"hlen", 2, cmdReadOnly, hlenCommand},
+		{"hexists", 3, cmdReadOnly, hexistsCommand},
+		{"hstrlen", 3, cmdReadOnly, hstrlenCommand},
+		{"hincrby", 4, cmdWrite, hincrbyCommand},
+		{"hincrbyfloat", 4, cmdWrite, hincrbyfloatCommand},
+		{"hrandfield", -2, cmdReadOnly, hrandfieldCommand},
+		{"hscan", -3, cmdReadOnly, hscanCommand},
+		// lists
+		{"lpush", -3, cmdWrite, lpushCommand},
+		{"rpush", -3, cmdWrite, rpushCommand},
+		{"lpushx", -3, cmdWrite, lpushxCommand},
+		{"rpushx", -3, cmdWrite, rpushxCommand},
+		{"lpop", -2, cmdWrite, lpopCommand},
+		{"rpop", -2, cmdWrite, rpopCommand},
+		{"lrange", 4, cmdReadOnly, lrangeCommand},
+		{"lindex", 3, cmdReadOnly, lindexCommand},
+		{"lset", 4, cmdWrite, lsetCommand},
+		{"linsert", 5, cmdWrite, linsertCommand},
+		{"lrem", 4, cmdWrite, lremCommand},
+		{"ltrim", 4, cmdWrite, ltrimCommand},
+		{"llen", 2, cmdReadOnly, llenCommand},
+		{"lpos", -3, cmdReadOnly, lposCommand},
+		{"lmove", 5, cmdWrite, lmoveCommand},
+		{"rpoplpush", 3, cmdWrite, rpoplpushCommand},
+		{"blpop", -3, cmdWrite, blpopCommand},
+		{"brpop", -3, cmdWrite, brpopCommand},
+		{"blmove", 6, cmdWrite, blmoveCommand},
+		{"brpoplpush", 4, cmdWrite, brpoplpushCommand},
+		// sets
+		{"sadd", -3, cmdWrite, saddCommand},
+		{"srem", -3, cmdWrite, sremCommand},
+		{"smembers", 2, cmdReadOnly, smembersCommand},
+		{"sismember", 3, cmdReadOnly, sismemberCommand},
+		{"smismember", -3, cmdReadOnly, smismemberCommand},
+		{"scard", 2, cmdReadOnly, scardCommand},
+		{"spop", -2, cmdWrite, spopCommand},
+		{"srandmember", -2, cmdReadOnly, srandmemberCommand},
+		{"sinter", -2, cmdReadOnly, sinterCommand},
+		{"sunion", -2, cmdReadOnly, sunionCommand},
+		{"sdiff", -2, cmdReadOnly, sdiffCommand},
+		{"sinterstore", -3, cmdWrite, sinterstoreCommand},
+		{"sunionstore", -3, cmdWrite, sunionstoreCommand},
+		{"sdiffstore", -3, cmdWrite, sdiffstoreCommand},
+		{"sscan", -3, cmdReadOnly, sscanCommand},
+		// sorted sets
+		{"zadd", -4, cmdWrite, zaddCommand},
+		{"zincrby", 4, cmdWrite, zincrbyCommand},
+		{"zrem", -3, cmdWrite, zremCommand},
+		{"zcard", 2, cmdReadOnly, zcardCommand},
+		{"zscore", 3, cmdReadOnly, zscoreCommand},
+		{"zmscore", -3, cmdReadOnly, zmscoreCommand},
+		{"zrank", -3, cmdReadOnly, zrankCommand},
+		{"zrevrank", -3, cmdReadOnly, zrevrankCommand},
+		{"zcount", 4, cmdReadOnly, zcountCommand},
+		{"zlexcount", 4, cmdReadOnly, zlexcountCommand},
+		{"zrange", -4, cmdReadOnly, zrangeCommand},
+		{"zrevrange", -4, cmdReadOnly, zrevrangeCommand},
+		{"zrangebyscore", -4, cmdReadOnly, zrangebyscoreCommand},
+		{"zrevrangebyscore", -4, cmdReadOnly, zrevrangebyscoreCommand},
+		{"zrangebylex", -4, cmdReadOnly, zrangebylexCommand},
+		{"zrevrangebylex", -4, cmdReadOnly, zrevrangebylexCommand},
+		{"zpopmin", -2, cmdWrite, zpopminCommand},
+		{"zpopmax", -2, cmdWrite, zpopmaxCommand},
+		{"zunionstore", -4, cmdWrite, zunionstoreCommand},
+		{"zinterstore", -4, cmdWrite, zinterstoreCommand},
+		{"zscan", -3, cmdReadOnly, zscanCommand},
+		// streams
+		{"xadd", -5, cmdWrite, xaddCommand},
+		{"xlen", 2, cmdReadOnly, xlenCommand},
+		{"xrange", -4, cmdReadOnly, xrangeCommand},
+		{"xrevrange", -4, cmdReadOnly, xrevrangeCommand},
+		{"xdel", -3, cmdWrite, xdelCommand},
+		{"xtrim", -4, cmdWrite, xtrimCommand},
+		{"xsetid", -3, cmdWrite, xsetidCommand},
+		{"xread", -4, cmdReadOnly, xreadCommand},
+		{"xgroup", -2, cmdWrite, xgroupCommand},
+		{"xreadgroup", -7, cmdWrite, xreadgroupCommand},
+		{"xack", -4, cmdWrite, xackCommand},
+		{"xpending", -3, cmdReadOnly, xpendingCommand},
+		{"xclaim", -6, cmdWrite, xclaimCommand},
+		{"xautoclaim", -6, cmdWrite, xautoclaimCommand},
+		{"xinfo", -2, cmdReadOnly, xinfoCommand},
+		// keys
+		{"del", -2, cmdWrite, delCommand},
+		{"exists", -2, cmdReadOnly, existsCommand},
+		{"type", 2, cmdReadOnly, typeCommand},
+		{"expire", -3, cmdWrite, expireCommand},
+		{"pexpire", -3, cmdWrite, pexpireCommand},
+		{"expireat", -3, cmdWrite, expireatCommand},
+		{"pexpireat", -3, cmdWrite, pexpireatCommand},
+		{"ttl", 2, cmdReadOnly, ttlCommand},
+		{"pttl", 2, cmdReadOnly, pttlCommand},
+		{"expiretime", 2, cmdReadOnly, expiretimeCommand},
+		{"pexpiretime", 2, cmdReadOnly, pexpiretimeCommand},
+		{"persist", 2, cmdWrite, persistCommand},
+		{"rename", 3, cmdWrite, renameCommand},
+		{"renamenx", 3, cmdWrite, renamenxCommand},
+		{"scan", -2, cmdReadOnly, scanCommand},
+		// persistence
+		{"bgrewriteaof", 1, cmdAdmin, bgrewriteaofCommand},
+		{"save", 1, cmdAdmin, saveCommand},
+		{"bgsave", -1, cmdAdmin, bgsaveCommand},
+		{"lastsave", 1, cmdAdmin, lastsaveCommand},
+		// replication
+		{"replicaof", 3, cmdAdmin, replicaofCommand},
+		{"slaveof", 3, cmdAdmin, replicaofCommand},
+		{"psync", 3, cmdAdmin, psyncCommand},
+		{"sync", 1, cmdAdmin, syncCommand},
+		{"replconf", -1, cmdAdmin, replconfCommand},
+		{"role", 1, cmdAdmin, roleCommand},
+		{"info", -1, cmdAdmin, infoCommand},
+		{"ping", -1, cmdAdmin, pingCommand},
+	} {
+		commands[strings.ToUpper(cmd.name)] = cmd
+	}
+}
+
+// parseInt parses the integer as strictly as redis, the plus sign, spaces and leading zeros are not allowed.
+func parseInt(b []byte) (int64, bool) {
+	if len(b) == 0 || len(b) > 20 || b[0] == '+' || (len(b) > 1 && b[0] == '0') || (len(b) > 1 && b[0] == '-' && b[1] == '0') {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	return n, err == nil
+}
+
+// parseFloat parses the float, NaN is not allowed.
+func parseFloat(b []byte) (float64, bool) {
+	if len(b) == 0 || isSpace(b[0]) || isSpace(b[len(b)-1]) {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(string(b), 64)
+	return f, err == nil && !math.IsNaN(f)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\v' || c == '\f'
+}
+
+// formatFloat formats the float without the exponent as the INCRBYFLOAT of redis does.
+func formatFloat(f float64) []byte {
+	return strconv.AppendFloat(nil, f, 'f', -1, 64)
+}
+
+func integer(n int64) beam.Reply {
+	return beam.AppendIntegers(nil, n)
+}
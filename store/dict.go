@@ -0,0 +1,191 @@
+package store
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"math/rand/v2"
+)
+
+// dictMinSize is the minimum number of the buckets of the non-empty dict.
+const dictMinSize = 4
+
+// dictSeed is the seed of the hashes, it is random in every process as the maps of go.
+var dictSeed = maphash.MakeSeed()
+
+// dict is a chained hash table whose size is a power of two, it grows when the entries are more
+// than the buckets and shrinks when they are less than an eighth. Unlike the maps of go, it is
+// scanned with the cursors as redis does, so the SCAN family can be resumed across the calls while
+// the table is modified. The zero value is an empty dict, and a nil dict can be read as an empty
+// one like the nil maps.
+type dict[V any] struct {
+	buckets []*dictEntry[V]
+	used    int
+}
+
+type dictEntry[V any] struct {
+	key   string
+	value V
+	next  *dictEntry[V]
+}
+
+func (d *dict[V]) len() int {
+	if d == nil {
+		return 0
+	}
+	return d.used
+}
+
+func (d *dict[V]) index(key string) uint64 {
+	return maphash.String(dictSeed, key) & uint64(len(d.buckets)-1)
+}
+
+func (d *dict[V]) find(key string) *dictEntry[V] {
+	if d.len() == 0 {
+		return nil
+	}
+	for e := d.buckets[d.index(key)]; e != nil; e = e.next {
+		if e.key == key {
+			return e
+		}
+	}
+	return nil
+}
+
+// get retrieves the value of the key, false will be returned if it does not exist.
+func (d *dict[V]) get(key string) (V, bool) {
+	if e := d.find(key); e != nil {
+		return e.value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// has reports whether the key exists.
+func (d *dict[V]) has(key string) bool {
+	return d.find(key) != nil
+}
+
+// put sets the value of the key, true will be returned if the key is added.
+func (d *dict[V]) put(key string, value V) bool {
+	if e := d.find(key); e != nil {
+		e.value = value
+		return false
+	}
+	if d.used >= len(d.buckets) {
+		d.resize(max(len(d.buckets)*2, dictMinSize))
+	}
+	i := d.index(key)
+	d.buckets[i] = &dictEntry[V]{key: key, value: value, next: d.buckets[i]}
+	d.used++
+	return true
+}
+
+// delete deletes the key, false will be returned if it does not exist.
+func (d *dict[V]) delete(key string) bool {
+	if d.len() == 0 {
+		return false
+	}
+	i := d.index(key)
+	for p := &d.buckets[i]; *p != nil; p = &(*p).next {
+		if (*p).key == key {
+			*p = (*p).next
+			d.used--
+			d.shrink()
+			return true
+		}
+	}
+	return false
+}
+
+// shrink shrinks the table to the smallest size holding the entries if it is sparse.
+func (d *dict[V]) shrink() {
+	if d.used == 0 {
+		d.buckets = nil
+		return
+	}
+	if len(d.buckets) > dictMinSize && d.used*8 < len(d.buckets) {
+		d.resize(max(1<<bits.Len(uint(d.used-1)), dictMinSize))
+	}
+}
+
+// resize rehashes the entries into the buckets of the size at once, the order of the entries in the
+// buckets is not kept.
+func (d *dict[V]) resize(size int) {
+	buckets := d.buckets
+	d.buckets = make([]*dictEntry[V], size)
+	for _, e := range buckets {
+		for e != nil {
+			next := e.next
+			i := d.index(e.key)
+			e.next = d.buckets[i]
+			d.buckets[i] = e
+			e = next
+		}
+	}
+}
+
+// each calls fn for the entries until it returns false, the dict must not be modified by fn.
+func (d *dict[V]) each(fn func(key string, value V) bool) {
+	if d == nil {
+		return
+	}
+	for _, e := range d.buckets {
+		for ; e != nil; e = e.next {
+			if !fn(e.key, e.value) {
+				return
+			}
+		}
+	}
+}
+
+// keys retrieves all the keys.
+func (d *dict[V]) keys() []string {
+	keys := make([]string, 0, d.len())
+	d.each(func(key string, _ V) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// random retrieves a random entry, false will be returned if the dict is empty. The entries in the
+// longer chains are less likely to be picked as redis does.
+func (d *dict[V]) random() (string, V, bool) {
+	if d.len() == 0 {
+		var zero V
+		return "", zero, false
+	}
+	// the load factor is at least an eighth, so an entry is found quickly.
+	var e *dictEntry[V]
+	for e == nil {
+		e = d.buckets[rand.IntN(len(d.buckets))]
+	}
+	n := 0
+	for p := e; p != nil; p = p.next {
+		n++
+	}
+	for i := rand.IntN(n); i > 0; i-- {
+		e = e.next
+	}
+	return e.key, e.value, true
+}
+
+// scan calls fn for the entries in the bucket of the cursor and returns the next cursor, 0 will be
+// returned when the iteration is done. The cursor is incremented from the highest bit of the mask
+// as redis does, so the buckets visited before the table grows or shrinks map to the buckets
+// visited after it, and an entry existing during the whole iteration is returned at least once.
+// The dict must not be modified by fn.
+func (d *dict[V]) scan(cursor uint64, fn func(key string, value V)) uint64 {
+	if d.len() == 0 {
+		return 0
+	}
+	mask := uint64(len(d.buckets) - 1)
+	for e := d.buckets[cursor&mask]; e != nil; e = e.next {
+		fn(e.key, e.value)
+	}
+	// the reversed cursor is incremented with the bits out of the mask set.
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDict(t *testing.T) {
+	assert := assert.New(t)
+
+	var nilDict *dict[int]
+	assert.Zero(nilDict.len())
+	_, ok := nilDict.get("a")
+	assert.False(ok)
+	assert.False(nilDict.delete("a"))
+	assert.Zero(nilDict.scan(0, func(string, int) {}))
+
+	var d dict[int]
+	for i := range 1000 {
+		assert.True(d.put(strconv.Itoa(i), i))
+	}
+	assert.False(d.put("0", -1))
+	assert.Equal(1000, d.len())
+	assert.Equal(1024, len(d.buckets))
+	v, ok := d.get("0")
+	assert.True(ok)
+	assert.Equal(-1, v)
+	assert.Len(d.keys(), 1000)
+	_, _, ok = d.random()
+	assert.True(ok)
+
+	for i := range 990 {
+		assert.True(d.delete(strconv.Itoa(i)))
+	}
+	assert.False(d.delete("0"))
+	assert.Equal(10, d.len())
+	// the table shrinks to the smallest size holding the entries.
+	assert.Equal(16, len(d.buckets))
+	for i := 990; i < 1000; i++ {
+		v, ok := d.get(strconv.Itoa(i))
+		assert.True(ok)
+		assert.Equal(i, v)
+	}
+	for i := 990; i < 1000; i++ {
+		d.delete(strconv.Itoa(i))
+	}
+	assert.Nil(d.buckets)
+}
+
+func TestDict_Scan(t *testing.T) {
+	assert := assert.New(t)
+
+	// scan visits the keys existing during the whole iteration, while the table grows and shrinks
+	// between the calls.
+	for _, resize := range []func(d *dict[int], step int){
+		func(d *dict[int], step int) {
+			// the table stops growing, so the iteration can be finished.
+			if step < 100 {
+				for i := range 50 {
+					d.put("grow:"+strconv.Itoa(step*50+i), 0)
+				}
+			}
+		},
+		func(d *dict[int], step int) {
+			for i := range 50 {
+				d.delete("shrink:" + strconv.Itoa(step*50+i))
+			}
+		},
+	} {
+		var d dict[int]
+		for i := range 100 {
+			d.put(strconv.Itoa(i), i)
+		}
+		for i := range 2000 {
+			d.put("shrink:"+strconv.Itoa(i), i)
+		}
+		seen := make(map[string]int)
+		cursor, step := uint64(0), 0
+		for {
+			cursor = d.scan(cursor, func(key string, _ int) {
+				seen[key]++
+			})
+			if cursor == 0 {
+				break
+			}
+			resize(&d, step)
+			step++
+		}
+		for i := range 100 {
+			assert.Contains(seen, strconv.Itoa(i))
+		}
+	}
+
+	// the keys are visited exactly once if the table is not resized.
+	var d dict[int]
+	for i := range 100 {
+		d.put(strconv.Itoa(i), i)
+	}
+	seen := make(map[string]int)
+	for cursor := d.scan(0, func(key string, _ int) { seen[key]++ }); cursor != 0; {
+		cursor = d.scan(cursor, func(key string, _ int) { seen[key]++ })
+	}
+	assert.Len(seen, 100)
+	for _, n := range seen {
+		assert.Equal(1, n)
+	}
+}
@@ -0,0 +1,86 @@
+package store
+
+// matchGlob reports whether s matches the glob-style pattern as the MATCH of redis:
+// '*' matches any sequence, '?' matches any byte, "[abc]", "[^abc]" and "[a-z]" match the classes,
+// and '\' escapes the next byte.
+func matchGlob(pattern, s []byte) bool {
+	px, sx := 0, 0
+	// the position after the last '*' and the next position of s it tries, for backtracking.
+	starPx, starSx := -1, -1
+	for px < len(pattern) || sx < len(s) {
+		if px < len(pattern) {
+			switch c := pattern[px]; c {
+			case '*':
+				starPx, starSx = px+1, sx+1
+				px++
+				continue
+			case '?':
+				if sx < len(s) {
+					px++
+					sx++
+					continue
+				}
+			case '[':
+				if sx < len(s) {
+					if n, ok := matchClass(pattern[px:], s[sx]); ok {
+						px += n
+						sx++
+						continue
+					}
+				}
+			case '\\':
+				if px+1 < len(pattern) {
+					c = pattern[px+1]
+					px++
+				}
+				fallthrough
+			default:
+				if sx < len(s) && s[sx] == c {
+					px++
+					sx++
+					continue
+				}
+			}
+		}
+		// the last '*' takes one more byte and the rest is matched again.
+		if starPx >= 0 && starSx <= len(s) {
+			px, sx = starPx, starSx
+			starSx++
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// matchClass matches c with the class at the beginning of the pattern, the length of the class is
+// returned. The class without ']' lasts to the end of the pattern.
+func matchClass(pattern []byte, c byte) (int, bool) {
+	i := 1
+	not := i < len(pattern) && pattern[i] == '^'
+	if not {
+		i++
+	}
+	matched := false
+	for ; i < len(pattern) && pattern[i] != ']'; i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			matched = matched || pattern[i] == c
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (c >= lo && c <= hi)
+			i += 2
+		default:
+			matched = matched || pattern[i] == c
+		}
+	}
+	if i < len(pattern) {
+		// skip ']'
+		i++
+	}
+	return i, matched != not
+}
@@ -0,0 +1,306 @@
+package store
+
+import (
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+
+	"github.com/caeret/beam"
+)
+
+// hash is the value of the hash keys.
+type hash = dict[[]byte]
+
+// lookupHash retrieves the hash of the key, the error reply will be returned if the key holds
+// another type.
+func (c *call) lookupHash(key []byte) (*hash, beam.Reply) {
+	e := c.lookup(key)
+	if e == nil {
+		return nil, nil
+	}
+	h, ok := e.value.(*hash)
+	if !ok {
+		return nil, wrongTypeReply
+	}
+	return h, nil
+}
+
+// lookupOrCreateHash retrieves the hash of the key, the empty hash is created if the key does not exist.
+func (c *call) lookupOrCreateHash(key []byte) (*hash, beam.Reply) {
+	h, reply := c.lookupHash(key)
+	if reply != nil || h != nil {
+		return h, reply
+	}
+	h = new(hash)
+	c.set(key, h)
+	return h, nil
+}
+
+func hsetCommand(c *call) beam.Reply {
+	if len(c.args)%2 != 1 {
+		return wrongArgs(c.name)
+	}
+	h, reply := c.lookupOrCreateHash(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	n := 0
+	for i := 1; i < len(c.args); i += 2 {
+		if h.put(string(c.args[i]), clone(c.args[i+1])) {
+			n++
+		}
+	}
+	if c.name == "hmset" {
+		return okReply
+	}
+	return integer(int64(n))
+}
+
+func hsetnxCommand(c *call) beam.Reply {
+	h, reply := c.lookupOrCreateHash(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	if h.has(string(c.args[1])) {
+		return integer(0)
+	}
+	h.put(string(c.args[1]), clone(c.args[2]))
+	return integer(1)
+}
+
+func hgetCommand(c *call) beam.Reply {
+	h, reply := c.lookupHash(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	value, _ := h.get(string(c.args[1]))
+	return beam.NewBulkStringsReplyRaw(value)
+}
+
+func hmgetCommand(c *call) beam.Reply {
+	h, reply := c.lookupHash(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	values := make([][]byte, len(c.args)-1)
+	for i, field := range c.args[1:] {
+		values[i], _ = h.get(string(field))
+	}
+	return beam.NewArraysReplyRaw(values...)
+}
+
+func hdelCommand(c *call) beam.Reply {
+	h, reply := c.lookupHash(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	n := 0
+	for _, field := range c.args[1:] {
+		if h.delete(string(field)) {
+			n++
+		}
+	}
+	// the key is removed with the last field.
+	if h != nil && h.len() == 0 {
+		c.remove(c.args[0])
+	}
+	return integer(int64(n))
+}
+
+func hgetallCommand(c *call) beam.Reply {
+	return c.hashReply(true, true)
+}
+
+func hkeysCommand(c *call) beam.Reply {
+	return c.hashReply(true, false)
+}
+
+func hvalsCommand(c *call) beam.Reply {
+	return c.hashReply(false, true)
+}
+
+// hashReply replies the fields and/or the values of the hash.
+func (c *call) hashReply(fields, values bool) beam.Reply {
+	h, reply := c.lookupHash(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	n := h.len()
+	if fields && values {
+		n *= 2
+	}
+	b := beam.AppendArraysHeader(nil, n)
+	h.each(func(field string, value []byte) bool {
+		if fields {
+			b = beam.AppendBulkStrings(b, field)
+		}
+		if values {
+			b = beam.AppendBulkStringsRaw(b, value)
+		}
+		return true
+	})
+	return b
+}
+
+func hlenCommand(c *call) beam.Reply {
+	h, reply := c.lookupHash(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	return integer(int64(h.len()))
+}
+
+func hexistsCommand(c *call) beam.Reply {
+	h, reply := c.lookupHash(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	if h.has(string(c.args[1])) {
+		return integer(1)
+	}
+	return integer(0)
+}
+
+func hstrlenCommand(c *call) beam.Reply {
+	h, reply := c.lookupHash(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	value, _ := h.get(string(c.args[1]))
+	return integer(int64(len(value)))
+}
+
+func hincrbyCommand(c *call) beam.Reply {
+	delta, ok := parseInt(c.args[2])
+	if !ok {
+		return notIntegerReply
+	}
+	h, reply := c.lookupOrCreateHash(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	var n int64
+	if v, exist := h.get(string(c.args[1])); exist {
+		if n, ok = parseInt(v); !ok {
+			return beam.NewErrorsReply("ERR hash value is not an integer")
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return overflowReply
+	}
+	n += delta
+	h.put(string(c.args[1]), strconv.AppendInt(nil, n, 10))
+	return integer(n)
+}
+
+func hincrbyfloatCommand(c *call) beam.Reply {
+	delta, ok := parseFloat(c.args[2])
+	if !ok {
+		return notFloatReply
+	}
+	h, reply := c.lookupOrCreateHash(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	var f float64
+	if v, exist := h.get(string(c.args[1])); exist {
+		if f, ok = parseFloat(v); !ok {
+			return beam.NewErrorsReply("ERR hash value is not a float")
+		}
+	}
+	f += delta
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return beam.NewErrorsReply("ERR increment would produce NaN or Infinity")
+	}
+	b := formatFloat(f)
+	h.put(string(c.args[1]), b)
+	return beam.NewBulkStringsReplyRaw(b)
+}
+
+// hrandfieldCommand implements HRANDFIELD key [count [WITHVALUES]], the fields are distinct with
+// the positive count, and may repeat with the negative one.
+func hrandfieldCommand(c *call) beam.Reply {
+	if len(c.args) > 3 {
+		return syntaxErrReply
+	}
+	withValues := false
+	if len(c.args) == 3 {
+		if !strings.EqualFold(string(c.args[2]), "WITHVALUES") {
+			return syntaxErrReply
+		}
+		withValues = true
+	}
+	var count int64
+	if len(c.args) > 1 {
+		var ok bool
+		if count, ok = parseInt(c.args[1]); !ok {
+			return notIntegerReply
+		}
+		if count < -math.MaxInt64/2 {
+			return beam.NewErrorsReply("ERR value is out of range")
+		}
+	}
+	h, reply := c.lookupHash(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	if len(c.args) == 1 {
+		field, _, ok := h.random()
+		if !ok {
+			return nilReply
+		}
+		return beam.NewBulkStringsReply(field)
+	}
+
+	fields := h.keys()
+	var picked []string
+	switch {
+	case len(fields) == 0:
+	case count >= 0:
+		rand.Shuffle(len(fields), func(i, j int) {
+			fields[i], fields[j] = fields[j], fields[i]
+		})
+		picked = fields[:min(int(count), len(fields))]
+	default:
+		picked = make([]string, -count)
+		for i := range picked {
+			picked[i] = fields[rand.IntN(len(fields))]
+		}
+	}
+
+	n := len(picked)
+	if withValues {
+		n *= 2
+	}
+	b := beam.AppendArraysHeader(nil, n)
+	for _, field := range picked {
+		b = beam.AppendBulkStrings(b, field)
+		if withValues {
+			value, _ := h.get(field)
+			b = beam.AppendBulkStringsRaw(b, value)
+		}
+	}
+	return b
+}
+
+// hscanCommand implements HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES].
+func hscanCommand(c *call) beam.Reply {
+	opts, reply := c.parseScanArgs(c.args[1:])
+	if reply != nil {
+		return reply
+	}
+	h, reply := c.lookupHash(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	var elems [][]byte
+	cursor := scanDict(h, opts, func(field string, value []byte) bool {
+		elems = append(elems, []byte(field))
+		if !opts.noValues {
+			elems = append(elems, value)
+		}
+		return true
+	})
+	return scanReply(cursor, elems)
+}
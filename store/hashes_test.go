@@ -0,0 +1,157 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/caeret/beam"
+	"github.com/caeret/beam/beamtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_Hash(t *testing.T) {
+	assert := assert.New(t)
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertInteger(t, 2, conn.Do("HSET", "h", "a", "1", "b", "2"))
+	beamtest.AssertInteger(t, 1, conn.Do("HSET", "h", "a", "10", "c", "3"))
+	beamtest.AssertOK(t, conn.Do("HMSET", "h", "d", ""))
+	beamtest.AssertError(t, "ERR wrong number of arguments for 'hset' command", conn.Do("HSET", "h", "a", "1", "b"))
+	beamtest.AssertSimpleString(t, "hash", conn.Do("TYPE", "h"))
+
+	beamtest.AssertBulkString(t, "10", conn.Do("HGET", "h", "a"))
+	beamtest.AssertBulkString(t, "", conn.Do("HGET", "h", "d"))
+	beamtest.AssertNil(t, conn.Do("HGET", "h", "x"))
+	beamtest.AssertNil(t, conn.Do("HGET", "missing", "x"))
+	beamtest.AssertReply(t, beam.Reply("*3\r\n$2\r\n10\r\n$-1\r\n$1\r\n3\r\n"), conn.Do("HMGET", "h", "a", "x", "c"))
+	beamtest.AssertInteger(t, 4, conn.Do("HLEN", "h"))
+	beamtest.AssertInteger(t, 1, conn.Do("HEXISTS", "h", "b"))
+	beamtest.AssertInteger(t, 0, conn.Do("HEXISTS", "h", "x"))
+	beamtest.AssertInteger(t, 2, conn.Do("HSTRLEN", "h", "a"))
+	beamtest.AssertInteger(t, 0, conn.Do("HSTRLEN", "h", "x"))
+
+	all, err := conn.Do("HGETALL", "h").Map()
+	assert.Nil(err)
+	assert.Len(all, 4)
+	v, _ := all["c"].Text()
+	assert.Equal("3", v)
+	keys, err := conn.Do("HKEYS", "h").Array()
+	assert.Nil(err)
+	assert.Len(keys, 4)
+	vals, err := conn.Do("HVALS", "h").Array()
+	assert.Nil(err)
+	assert.Len(vals, 4)
+	beamtest.AssertStrings(t, []string{}, conn.Do("HGETALL", "missing"))
+
+	beamtest.AssertInteger(t, 0, conn.Do("HSETNX", "h", "a", "x"))
+	beamtest.AssertInteger(t, 1, conn.Do("HSETNX", "h", "e", "5"))
+	beamtest.AssertInteger(t, 1, conn.Do("HSETNX", "h2", "a", "1"))
+
+	beamtest.AssertInteger(t, 2, conn.Do("HDEL", "h", "a", "b", "x"))
+	beamtest.AssertInteger(t, 3, conn.Do("HLEN", "h"))
+	beamtest.AssertInteger(t, 3, conn.Do("HDEL", "h", "c", "d", "e"))
+	// the key is removed with the last field.
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "h"))
+	beamtest.AssertInteger(t, 0, conn.Do("HDEL", "h", "a"))
+}
+
+func TestStore_HashIncr(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertInteger(t, 5, conn.Do("HINCRBY", "h", "n", "5"))
+	beamtest.AssertInteger(t, -5, conn.Do("HINCRBY", "h", "n", "-10"))
+	beamtest.AssertError(t, "ERR value is not an integer or out of range", conn.Do("HINCRBY", "h", "n", "x"))
+	beamtest.AssertInteger(t, 2, conn.Do("HSET", "h", "s", "abc", "max", "9223372036854775807"))
+	beamtest.AssertError(t, "ERR hash value is not an integer", conn.Do("HINCRBY", "h", "s", "1"))
+	beamtest.AssertError(t, "ERR increment or decrement would overflow", conn.Do("HINCRBY", "h", "max", "1"))
+
+	beamtest.AssertBulkString(t, "10.5", conn.Do("HINCRBYFLOAT", "h", "f", "10.5"))
+	beamtest.AssertBulkString(t, "5.5", conn.Do("HINCRBYFLOAT", "h", "n", "10.5"))
+	beamtest.AssertBulkString(t, "5.5", conn.Do("HGET", "h", "n"))
+	beamtest.AssertError(t, "ERR hash value is not a float", conn.Do("HINCRBYFLOAT", "h", "s", "1"))
+	beamtest.AssertError(t, "ERR value is not a valid float", conn.Do("HINCRBYFLOAT", "h", "f", "x"))
+}
+
+func TestStore_HRandField(t *testing.T) {
+	assert := assert.New(t)
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertNil(t, conn.Do("HRANDFIELD", "h"))
+	beamtest.AssertStrings(t, []string{}, conn.Do("HRANDFIELD", "h", "3"))
+	beamtest.AssertInteger(t, 3, conn.Do("HSET", "h", "a", "1", "b", "2", "c", "3"))
+
+	field, err := conn.Do("HRANDFIELD", "h").Text()
+	assert.Nil(err)
+	assert.Contains([]string{"a", "b", "c"}, field)
+
+	fields, err := conn.Do("HRANDFIELD", "h", "5").Array()
+	assert.Nil(err)
+	assert.Len(fields, 3)
+	distinct := make(map[string]bool)
+	for _, f := range fields {
+		s, _ := f.Text()
+		distinct[s] = true
+	}
+	assert.Len(distinct, 3)
+
+	fields, err = conn.Do("HRANDFIELD", "h", "-5").Array()
+	assert.Nil(err)
+	assert.Len(fields, 5)
+
+	pairs, err := conn.Do("HRANDFIELD", "h", "2", "WITHVALUES").Map()
+	assert.Nil(err)
+	assert.Len(pairs, 2)
+	for f, v := range pairs {
+		s, _ := v.Text()
+		assert.Equal(map[string]string{"a": "1", "b": "2", "c": "3"}[f], s)
+	}
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("HRANDFIELD", "h", "2", "foo"))
+	beamtest.AssertError(t, "ERR value is not an integer or out of range", conn.Do("HRANDFIELD", "h", "x"))
+}
+
+func TestStore_HScan(t *testing.T) {
+	assert := assert.New(t)
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertInteger(t, 3, conn.Do("HSET", "h", "name:1", "a", "name:2", "b", "age:1", "c"))
+	reply, err := conn.Do("HSCAN", "h", "0", "MATCH", "name:*", "COUNT", "100").Array()
+	if assert.Nil(err) && assert.Len(reply, 2) {
+		beamtest.AssertBulkString(t, "0", reply[0])
+		pairs, err := reply[1].Map()
+		assert.Nil(err)
+		assert.Len(pairs, 2)
+		assert.Contains(pairs, "name:1")
+	}
+	reply, err = conn.Do("HSCAN", "h", "0", "NOVALUES").Array()
+	if assert.Nil(err) && assert.Len(reply, 2) {
+		fields, _ := reply[1].Array()
+		assert.Len(fields, 3)
+	}
+	beamtest.AssertReply(t, beam.Reply("*2\r\n$1\r\n0\r\n*0\r\n"), conn.Do("HSCAN", "missing", "0"))
+	beamtest.AssertError(t, "ERR invalid cursor", conn.Do("HSCAN", "h", "x"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("HSCAN", "h", "0", "COUNT", "0"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("HSCAN", "h", "0", "MATCH"))
+}
+
+func TestStore_HashWrongType(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertOK(t, conn.Do("SET", "s", "v"))
+	for _, args := range [][]string{
+		{"HSET", "s", "f", "v"},
+		{"HSETNX", "s", "f", "v"},
+		{"HGET", "s", "f"},
+		{"HMGET", "s", "f"},
+		{"HDEL", "s", "f"},
+		{"HGETALL", "s"},
+		{"HLEN", "s"},
+		{"HINCRBY", "s", "f", "1"},
+		{"HINCRBYFLOAT", "s", "f", "1"},
+		{"HRANDFIELD", "s"},
+		{"HSCAN", "s", "0"},
+	} {
+		beamtest.AssertError(t, "WRONGTYPE", conn.Do(args[0], args[1:]...))
+	}
+	beamtest.AssertInteger(t, 1, conn.Do("HSET", "h", "f", "v"))
+	beamtest.AssertError(t, "WRONGTYPE", conn.Do("GET", "h"))
+	beamtest.AssertError(t, "WRONGTYPE", conn.Do("APPEND", "h", "v"))
+}
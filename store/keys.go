@@ -0,0 +1,237 @@
+package store
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/caeret/beam"
+)
+
+// typeName retrieves the name of the value replied by TYPE.
+func typeName(value any) string {
+	switch value.(type) {
+	case []byte:
+		return "string"
+	case *hash:
+		return "hash"
+	case *list:
+		return "list"
+	case *set:
+		return "set"
+	case *zset:
+		return "zset"
+	case *stream:
+		return "stream"
+	}
+	return "none"
+}
+
+func delCommand(c *call) beam.Reply {
+	n := 0
+	for _, key := range c.args {
+		if c.lookup(key) != nil && c.remove(key) {
+			n++
+		}
+	}
+	return integer(int64(n))
+}
+
+func existsCommand(c *call) beam.Reply {
+	// the duplicate keys are counted repeatedly.
+	n := 0
+	for _, key := range c.args {
+		if c.lookup(key) != nil {
+			n++
+		}
+	}
+	return integer(int64(n))
+}
+
+func typeCommand(c *call) beam.Reply {
+	e := c.lookup(c.args[0])
+	if e == nil {
+		return beam.NewSimpleStringsReply("none")
+	}
+	return beam.NewSimpleStringsReply(typeName(e.value))
+}
+
+func expireCommand(c *call) beam.Reply {
+	return c.expireGeneric(false, false)
+}
+
+func pexpireCommand(c *call) beam.Reply {
+	return c.expireGeneric(true, false)
+}
+
+func expireatCommand(c *call) beam.Reply {
+	return c.expireGeneric(false, true)
+}
+
+func pexpireatCommand(c *call) beam.Reply {
+	return c.expireGeneric(true, true)
+}
+
+// expireGeneric implements EXPIRE key time [NX | XX | GT | LT] and the variants in milliseconds or
+// the unix time.
+func (c *call) expireGeneric(ms, absolute bool) beam.Reply {
+	var nx, xx, gt, lt bool
+	for _, arg := range c.args[2:] {
+		switch opt := strings.ToUpper(string(arg)); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		default:
+			return beam.NewErrorsReply("ERR Unsupported option " + string(arg))
+		}
+	}
+	if nx && (xx || gt || lt) {
+		return beam.NewErrorsReply("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if gt && lt {
+		return beam.NewErrorsReply("ERR GT and LT options at the same time are not compatible")
+	}
+	n, ok := parseInt(c.args[1])
+	if !ok {
+		return notIntegerReply
+	}
+	at, ok := c.expireAt(n, ms, absolute)
+	if !ok {
+		return c.invalidExpire()
+	}
+
+	key := c.args[0]
+	e := c.lookup(key)
+	if e == nil {
+		c.propagate()
+		return integer(0)
+	}
+	// the key without expiry is regarded as expiring at infinity.
+	current := e.expireAt
+	if current == 0 {
+		current = math.MaxInt64
+	}
+	if (nx && e.expireAt != 0) || (xx && e.expireAt == 0) || (gt && at <= current) || (lt && at >= current) {
+		c.propagate()
+		return integer(0)
+	}
+	// the expiry is logged as the absolute one, and nothing is logged if it is not set.
+	if c.expire(key, e, at) {
+		c.propagate(query("PEXPIREAT", key, strconv.AppendInt(nil, at, 10)))
+	}
+	return integer(1)
+}
+
+// expireAt converts the expiry in seconds or milliseconds, relative to now or absolute, to the unix
+// time in milliseconds, false will be returned if it overflows.
+func (c *call) expireAt(n int64, ms, absolute bool) (int64, bool) {
+	if !ms {
+		if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+			return 0, false
+		}
+		n *= 1000
+	}
+	if !absolute {
+		if n > math.MaxInt64-c.now {
+			return 0, false
+		}
+		n += c.now
+	}
+	return n, true
+}
+
+func (c *call) invalidExpire() beam.Reply {
+	return beam.NewErrorsReply("ERR invalid expire time in '" + c.name + "' command")
+}
+
+func ttlCommand(c *call) beam.Reply {
+	return c.ttlGeneric(false, false)
+}
+
+func pttlCommand(c *call) beam.Reply {
+	return c.ttlGeneric(true, false)
+}
+
+func expiretimeCommand(c *call) beam.Reply {
+	return c.ttlGeneric(false, true)
+}
+
+func pexpiretimeCommand(c *call) beam.Reply {
+	return c.ttlGeneric(true, true)
+}
+
+// ttlGeneric replies the remaining time or the unix time of the expiry, -2 if the key does not exist
+// and -1 if it never expires.
+func (c *call) ttlGeneric(ms, absolute bool) beam.Reply {
+	e := c.lookup(c.args[0])
+	if e == nil {
+		return integer(-2)
+	}
+	if e.expireAt == 0 {
+		return integer(-1)
+	}
+	t := e.expireAt
+	if !absolute {
+		t -= c.now
+	}
+	if !ms {
+		t = (t + 500) / 1000
+	}
+	return integer(t)
+}
+
+func persistCommand(c *call) beam.Reply {
+	e := c.lookup(c.args[0])
+	if e == nil || !c.persist(c.args[0], e) {
+		return integer(0)
+	}
+	return integer(1)
+}
+
+func renameCommand(c *call) beam.Reply {
+	if reply := c.rename(false); reply != nil {
+		return reply
+	}
+	return okReply
+}
+
+func renamenxCommand(c *call) beam.Reply {
+	if reply := c.rename(true); reply != nil {
+		return reply
+	}
+	return integer(1)
+}
+
+// rename moves the value with its expiry to the new key, the error reply or 0 will be returned if
+// the key is not renamed.
+func (c *call) rename(nx bool) beam.Reply {
+	src, dst := c.args[0], c.args[1]
+	e := c.lookup(src)
+	if e == nil {
+		return beam.NewErrorsReply("ERR no such key")
+	}
+	if string(src) == string(dst) {
+		if nx {
+			return integer(0)
+		}
+		return nil
+	}
+	if c.lookup(dst) != nil {
+		if nx {
+			return integer(0)
+		}
+		c.remove(dst)
+	}
+	c.remove(src)
+	c.keys.put(string(dst), e)
+	if e.expireAt != 0 {
+		c.expires[string(dst)] = e
+	}
+	c.signal(dst)
+	return nil
+}
@@ -0,0 +1,140 @@
+package store
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/caeret/beam/beamtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_Keys(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertOK(t, conn.Do("MSET", "a", "1", "b", "2"))
+	beamtest.AssertInteger(t, 3, conn.Do("EXISTS", "a", "b", "a", "c"))
+	beamtest.AssertSimpleString(t, "string", conn.Do("TYPE", "a"))
+	beamtest.AssertSimpleString(t, "none", conn.Do("TYPE", "c"))
+	beamtest.AssertInteger(t, 2, conn.Do("DEL", "a", "b", "c", "a"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "a", "b"))
+}
+
+func TestStore_Expire(t *testing.T) {
+	_, clk, conn := newTestStore(t, Config{ActiveExpireInterval: time.Hour})
+
+	beamtest.AssertInteger(t, 0, conn.Do("EXPIRE", "foo", "10"))
+	beamtest.AssertInteger(t, -2, conn.Do("TTL", "foo"))
+	beamtest.AssertOK(t, conn.Do("SET", "foo", "bar"))
+	beamtest.AssertInteger(t, -1, conn.Do("TTL", "foo"))
+	beamtest.AssertInteger(t, -1, conn.Do("PEXPIRETIME", "foo"))
+
+	beamtest.AssertInteger(t, 1, conn.Do("EXPIRE", "foo", "10"))
+	beamtest.AssertInteger(t, 10, conn.Do("TTL", "foo"))
+	beamtest.AssertInteger(t, 1700000010, conn.Do("EXPIRETIME", "foo"))
+	beamtest.AssertInteger(t, 1, conn.Do("PEXPIRE", "foo", "1500"))
+	clk.advance(time.Millisecond * 100)
+	beamtest.AssertInteger(t, 1400, conn.Do("PTTL", "foo"))
+	// the remaining seconds are rounded.
+	beamtest.AssertInteger(t, 1, conn.Do("TTL", "foo"))
+	beamtest.AssertInteger(t, 1, conn.Do("PEXPIREAT", "foo", "1700000003000"))
+	beamtest.AssertInteger(t, 1700000003000, conn.Do("PEXPIRETIME", "foo"))
+	beamtest.AssertInteger(t, 1, conn.Do("EXPIREAT", "foo", "1700000005"))
+	beamtest.AssertInteger(t, 1700000005, conn.Do("EXPIRETIME", "foo"))
+
+	beamtest.AssertInteger(t, 1, conn.Do("PERSIST", "foo"))
+	beamtest.AssertInteger(t, 0, conn.Do("PERSIST", "foo"))
+	beamtest.AssertInteger(t, 0, conn.Do("PERSIST", "missing"))
+	beamtest.AssertInteger(t, -1, conn.Do("TTL", "foo"))
+
+	// the key is removed with the expiry in the past.
+	beamtest.AssertInteger(t, 1, conn.Do("EXPIRE", "foo", "-1"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "foo"))
+
+	beamtest.AssertError(t, "ERR value is not an integer or out of range", conn.Do("EXPIRE", "foo", "1.5"))
+	beamtest.AssertError(t, "ERR invalid expire time in 'expire' command", conn.Do("EXPIRE", "foo", "9223372036854775807"))
+	beamtest.AssertError(t, "ERR invalid expire time in 'pexpire' command", conn.Do("PEXPIRE", "foo", "9223372036854775807"))
+}
+
+func TestStore_ExpireOptions(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertOK(t, conn.Do("SET", "foo", "bar"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXPIRE", "foo", "100", "XX"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXPIRE", "foo", "100", "GT"))
+	beamtest.AssertInteger(t, 1, conn.Do("EXPIRE", "foo", "100", "NX"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXPIRE", "foo", "200", "NX"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXPIRE", "foo", "50", "gt"))
+	beamtest.AssertInteger(t, 1, conn.Do("EXPIRE", "foo", "200", "GT"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXPIRE", "foo", "300", "LT"))
+	beamtest.AssertInteger(t, 1, conn.Do("EXPIRE", "foo", "50", "XX", "LT"))
+	beamtest.AssertInteger(t, 50, conn.Do("TTL", "foo"))
+
+	beamtest.AssertError(t, "ERR NX and XX, GT or LT options at the same time are not compatible", conn.Do("EXPIRE", "foo", "1", "NX", "XX"))
+	beamtest.AssertError(t, "ERR GT and LT options at the same time are not compatible", conn.Do("EXPIRE", "foo", "1", "GT", "LT"))
+	beamtest.AssertError(t, "ERR Unsupported option foo", conn.Do("EXPIRE", "foo", "1", "foo"))
+}
+
+func TestStore_Rename(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertError(t, "ERR no such key", conn.Do("RENAME", "a", "b"))
+	beamtest.AssertOK(t, conn.Do("SET", "a", "1", "EX", "100"))
+	beamtest.AssertOK(t, conn.Do("SET", "b", "2"))
+	beamtest.AssertOK(t, conn.Do("RENAME", "a", "a"))
+	beamtest.AssertOK(t, conn.Do("RENAME", "a", "b"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "a"))
+	beamtest.AssertBulkString(t, "1", conn.Do("GET", "b"))
+	beamtest.AssertInteger(t, 100, conn.Do("TTL", "b"))
+
+	beamtest.AssertOK(t, conn.Do("SET", "c", "3"))
+	beamtest.AssertInteger(t, 0, conn.Do("RENAMENX", "b", "c"))
+	beamtest.AssertInteger(t, 1, conn.Do("RENAMENX", "b", "d"))
+	beamtest.AssertBulkString(t, "1", conn.Do("GET", "d"))
+	beamtest.AssertInteger(t, 100, conn.Do("TTL", "d"))
+}
+
+func TestStore_Scan(t *testing.T) {
+	assert := assert.New(t)
+	_, clk, conn := newTestStore(t, Config{})
+
+	for i := range 100 {
+		beamtest.AssertOK(t, conn.Do("SET", "key:"+strconv.Itoa(i), "v"))
+	}
+	beamtest.AssertInteger(t, 1, conn.Do("RPUSH", "list", "a"))
+	beamtest.AssertInteger(t, 1, conn.Do("PEXPIRE", "key:0", "10"))
+	clk.advance(time.Second)
+
+	scanAll := func(args ...string) []string {
+		var keys []string
+		cursor := "0"
+		for {
+			reply, err := conn.Do("SCAN", append([]string{cursor}, args...)...).Array()
+			if !assert.Nil(err) || !assert.Len(reply, 2) {
+				return nil
+			}
+			elems, err := reply[1].Array()
+			assert.Nil(err)
+			for _, elem := range elems {
+				key, _ := elem.Text()
+				keys = append(keys, key)
+			}
+			if cursor, _ = reply[0].Text(); cursor == "0" {
+				return keys
+			}
+		}
+	}
+	keys := scanAll("COUNT", "7")
+	// the expired key is not replied.
+	assert.Len(keys, 100)
+	assert.NotContains(keys, "key:0")
+	assert.ElementsMatch([]string{"key:1", "key:10", "key:11", "key:12", "key:13", "key:14", "key:15", "key:16", "key:17", "key:18", "key:19"},
+		scanAll("MATCH", "key:1*"))
+	assert.Equal([]string{"list"}, scanAll("TYPE", "LIST"))
+	assert.Empty(scanAll("TYPE", "unknown"))
+
+	beamtest.AssertError(t, "ERR invalid cursor", conn.Do("SCAN", "-1"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("SCAN", "0", "NOVALUES"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("SCAN", "0", "TYPE"))
+	beamtest.AssertError(t, "ERR value is not an integer or out of range", conn.Do("SCAN", "0", "COUNT", "x"))
+}
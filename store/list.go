@@ -0,0 +1,92 @@
+package store
+
+// list is the value of the list keys, it is a ring buffer so the elements are pushed and popped
+// at both ends in constant time.
+type list struct {
+	buf  [][]byte
+	head int
+	n    int
+}
+
+func (l *list) len() int {
+	return l.n
+}
+
+// at retrieves the element at the index from the head.
+func (l *list) at(i int) []byte {
+	return l.buf[(l.head+i)%len(l.buf)]
+}
+
+func (l *list) set(i int, v []byte) {
+	l.buf[(l.head+i)%len(l.buf)] = v
+}
+
+func (l *list) grow() {
+	if l.n < len(l.buf) {
+		return
+	}
+	buf := make([][]byte, max(len(l.buf)*2, 4))
+	for i := 0; i < l.n; i++ {
+		buf[i] = l.at(i)
+	}
+	l.buf, l.head = buf, 0
+}
+
+func (l *list) pushBack(v []byte) {
+	l.grow()
+	l.n++
+	l.set(l.n-1, v)
+}
+
+func (l *list) pushFront(v []byte) {
+	l.grow()
+	l.head = (l.head + len(l.buf) - 1) % len(l.buf)
+	l.n++
+	l.set(0, v)
+}
+
+func (l *list) popFront() []byte {
+	v := l.at(0)
+	l.set(0, nil)
+	l.head = (l.head + 1) % len(l.buf)
+	l.n--
+	return v
+}
+
+func (l *list) popBack() []byte {
+	v := l.at(l.n - 1)
+	l.set(l.n-1, nil)
+	l.n--
+	return v
+}
+
+// push pushes the element to the head if left is true, or to the tail.
+func (l *list) push(v []byte, left bool) {
+	if left {
+		l.pushFront(v)
+	} else {
+		l.pushBack(v)
+	}
+}
+
+// pop pops the element from the head if left is true, or from the tail.
+func (l *list) pop(left bool) []byte {
+	if left {
+		return l.popFront()
+	}
+	return l.popBack()
+}
+
+// elements copies the elements in order.
+func (l *list) elements() [][]byte {
+	elems := make([][]byte, l.n)
+	for i := range elems {
+		elems[i] = l.at(i)
+	}
+	return elems
+}
+
+// reset replaces the elements.
+func (l *list) reset(elems [][]byte) {
+	l.buf, l.head, l.n = elems, 0, len(elems)
+}
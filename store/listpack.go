@@ -0,0 +1,263 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+)
+
+// The compact encodings of redis are only used in the RDB files: the streams are always saved as the
+// listpacks, and the small collections saved by redis are loaded from the listpacks, ziplists and
+// intsets.
+
+var errCorruptEncoding = errors.New("corrupt compact encoding")
+
+// listpackWriter builds a listpack, the elements are encoded as the integers if they are given as
+// the integers.
+type listpackWriter struct {
+	b []byte
+	n int
+}
+
+func newListpackWriter() *listpackWriter {
+	// the header is the total bytes and the number of the elements.
+	return &listpackWriter{b: make([]byte, 6, 64)}
+}
+
+func (lp *listpackWriter) appendString(s []byte) {
+	start := len(lp.b)
+	switch n := len(s); {
+	case n < 64:
+		lp.b = append(lp.b, 0x80|byte(n))
+	case n < 4096:
+		lp.b = append(lp.b, 0xe0|byte(n>>8), byte(n))
+	default:
+		lp.b = append(lp.b, 0xf0)
+		lp.b = binary.LittleEndian.AppendUint32(lp.b, uint32(n))
+	}
+	lp.b = append(lp.b, s...)
+	lp.appendBacklen(len(lp.b) - start)
+}
+
+func (lp *listpackWriter) appendInt(v int64) {
+	start := len(lp.b)
+	switch {
+	case v >= 0 && v <= 127:
+		lp.b = append(lp.b, byte(v))
+	case v >= -4096 && v <= 4095:
+		u := uint64(v) & 0x1fff
+		lp.b = append(lp.b, 0xc0|byte(u>>8), byte(u))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		lp.b = append(lp.b, 0xf1)
+		lp.b = binary.LittleEndian.AppendUint16(lp.b, uint16(v))
+	case v >= -1<<23 && v < 1<<23:
+		lp.b = append(lp.b, 0xf2, byte(v), byte(v>>8), byte(v>>16))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		lp.b = append(lp.b, 0xf3)
+		lp.b = binary.LittleEndian.AppendUint32(lp.b, uint32(v))
+	default:
+		lp.b = append(lp.b, 0xf4)
+		lp.b = binary.LittleEndian.AppendUint64(lp.b, uint64(v))
+	}
+	lp.appendBacklen(len(lp.b) - start)
+}
+
+// appendBacklen appends the length of the element for the backward traversal, it is big endian
+// with 7 bits in every byte, and the bytes except the first one are flagged by the high bit.
+func (lp *listpackWriter) appendBacklen(l int) {
+	n := backlenSize(l)
+	for i := n - 1; i >= 0; i-- {
+		c := byte(l>>(7*i)) & 0x7f
+		if i < n-1 {
+			c |= 0x80
+		}
+		lp.b = append(lp.b, c)
+	}
+	lp.n++
+}
+
+func backlenSize(l int) int {
+	switch {
+	case l <= 127:
+		return 1
+	case l < 16383:
+		return 2
+	case l < 2097151:
+		return 3
+	case l < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// bytes terminates the listpack and fills the header.
+func (lp *listpackWriter) bytes() []byte {
+	lp.b = append(lp.b, 0xff)
+	binary.LittleEndian.PutUint32(lp.b, uint32(len(lp.b)))
+	binary.LittleEndian.PutUint16(lp.b[4:], uint16(min(lp.n, math.MaxUint16)))
+	return lp.b
+}
+
+// readListpack decodes the elements of the listpack, the integers are formatted as the strings.
+func readListpack(b []byte) ([][]byte, error) {
+	if len(b) < 7 || int(binary.LittleEndian.Uint32(b)) != len(b) || b[len(b)-1] != 0xff {
+		return nil, errCorruptEncoding
+	}
+	var elems [][]byte
+	p := b[6 : len(b)-1]
+	for len(p) > 0 {
+		c := p[0]
+		var (
+			elem []byte
+			size int
+		)
+		switch {
+		case c&0x80 == 0:
+			elem, size = strconv.AppendInt(nil, int64(c), 10), 1
+		case c&0xc0 == 0x80:
+			size = 1 + int(c&0x3f)
+			if len(p) < size {
+				return nil, errCorruptEncoding
+			}
+			elem = p[1:size]
+		case c&0xe0 == 0xc0:
+			if len(p) < 2 {
+				return nil, errCorruptEncoding
+			}
+			// the 13 bits integer is sign extended.
+			v := int64(uint16(c&0x1f)<<8|uint16(p[1])) << 51 >> 51
+			elem, size = strconv.AppendInt(nil, v, 10), 2
+		case c&0xf0 == 0xe0:
+			if len(p) < 2 {
+				return nil, errCorruptEncoding
+			}
+			size = 2 + (int(c&0x0f)<<8 | int(p[1]))
+			if len(p) < size {
+				return nil, errCorruptEncoding
+			}
+			elem = p[2:size]
+		case c == 0xf0:
+			if len(p) < 5 {
+				return nil, errCorruptEncoding
+			}
+			n := binary.LittleEndian.Uint32(p[1:])
+			if uint64(len(p)-5) < uint64(n) {
+				return nil, errCorruptEncoding
+			}
+			size = 5 + int(n)
+			elem = p[5:size]
+		case c >= 0xf1 && c <= 0xf4:
+			width := [...]int{2, 3, 4, 8}[c-0xf1]
+			if len(p) < 1+width {
+				return nil, errCorruptEncoding
+			}
+			elem, size = strconv.AppendInt(nil, littleEndianInt(p[1:1+width]), 10), 1+width
+		default:
+			return nil, errCorruptEncoding
+		}
+		size += backlenSize(size)
+		if len(p) < size {
+			return nil, errCorruptEncoding
+		}
+		elems = append(elems, elem)
+		p = p[size:]
+	}
+	return elems, nil
+}
+
+// littleEndianInt decodes the signed little endian integer of 1 to 8 bytes.
+func littleEndianInt(b []byte) int64 {
+	var u uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		u = u<<8 | uint64(b[i])
+	}
+	shift := 64 - 8*len(b)
+	return int64(u<<shift) >> shift
+}
+
+// readZiplist decodes the elements of the ziplist, which is replaced by the listpack since redis 7.
+func readZiplist(b []byte) ([][]byte, error) {
+	if len(b) < 11 || int(binary.LittleEndian.Uint32(b)) != len(b) || b[len(b)-1] != 0xff {
+		return nil, errCorruptEncoding
+	}
+	var elems [][]byte
+	p := b[10 : len(b)-1]
+	for len(p) > 0 {
+		// the length of the previous entry is skipped.
+		if p[0] < 254 {
+			p = p[1:]
+		} else if len(p) >= 5 {
+			p = p[5:]
+		} else {
+			return nil, errCorruptEncoding
+		}
+		if len(p) == 0 {
+			return nil, errCorruptEncoding
+		}
+		c := p[0]
+		var (
+			header, n int
+			integer   bool
+		)
+		switch {
+		case c>>6 == 0:
+			header, n = 1, int(c&0x3f)
+		case c>>6 == 1:
+			if len(p) < 2 {
+				return nil, errCorruptEncoding
+			}
+			header, n = 2, int(c&0x3f)<<8|int(p[1])
+		case c == 0x80:
+			if len(p) < 5 {
+				return nil, errCorruptEncoding
+			}
+			header, n = 5, int(binary.BigEndian.Uint32(p[1:]))
+		case c == 0xc0:
+			header, n, integer = 1, 2, true
+		case c == 0xd0:
+			header, n, integer = 1, 4, true
+		case c == 0xe0:
+			header, n, integer = 1, 8, true
+		case c == 0xf0:
+			header, n, integer = 1, 3, true
+		case c == 0xfe:
+			header, n, integer = 1, 1, true
+		case c >= 0xf1 && c <= 0xfd:
+			// the immediate integer from 0 to 12.
+			elems = append(elems, strconv.AppendInt(nil, int64(c&0x0f)-1, 10))
+			p = p[1:]
+			continue
+		default:
+			return nil, errCorruptEncoding
+		}
+		if n < 0 || len(p) < header+n {
+			return nil, errCorruptEncoding
+		}
+		data := p[header : header+n]
+		if integer {
+			elems = append(elems, strconv.AppendInt(nil, littleEndianInt(data), 10))
+		} else {
+			elems = append(elems, data)
+		}
+		p = p[header+n:]
+	}
+	return elems, nil
+}
+
+// readIntset decodes the members of the intset.
+func readIntset(b []byte) ([][]byte, error) {
+	if len(b) < 8 {
+		return nil, errCorruptEncoding
+	}
+	width := int(binary.LittleEndian.Uint32(b))
+	n := int(binary.LittleEndian.Uint32(b[4:]))
+	if (width != 2 && width != 4 && width != 8) || len(b)-8 != width*n {
+		return nil, errCorruptEncoding
+	}
+	members := make([][]byte, n)
+	for i := range members {
+		members[i] = strconv.AppendInt(nil, littleEndianInt(b[8+i*width:8+(i+1)*width]), 10)
+	}
+	return members, nil
+}
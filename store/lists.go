@@ -0,0 +1,550 @@
+package store
+
+import (
+	"math"
+	"strings"
+
+	"github.com/caeret/beam"
+)
+
+var nilArrayReply = beam.AppendArraysHeader(nil, -1)
+
+// lookupList retrieves the list of the key, the error reply will be returned if the key holds
+// another type.
+func (c *call) lookupList(key []byte) (*list, beam.Reply) {
+	e := c.lookup(key)
+	if e == nil {
+		return nil, nil
+	}
+	l, ok := e.value.(*list)
+	if !ok {
+		return nil, wrongTypeReply
+	}
+	return l, nil
+}
+
+// removeIfEmpty removes the key of the empty list, the empty lists never exist.
+func (c *call) removeIfEmpty(key []byte, l *list) {
+	if l.len() == 0 {
+		c.remove(key)
+	}
+}
+
+func lpushCommand(c *call) beam.Reply {
+	return c.pushGeneric(true, false)
+}
+
+func rpushCommand(c *call) beam.Reply {
+	return c.pushGeneric(false, false)
+}
+
+func lpushxCommand(c *call) beam.Reply {
+	return c.pushGeneric(true, true)
+}
+
+func rpushxCommand(c *call) beam.Reply {
+	return c.pushGeneric(false, true)
+}
+
+// pushGeneric pushes the elements to the head or the tail, the list is only pushed if it exists
+// with xx.
+func (c *call) pushGeneric(left, xx bool) beam.Reply {
+	key := c.args[0]
+	l, reply := c.lookupList(key)
+	if reply != nil {
+		return reply
+	}
+	if l == nil {
+		if xx {
+			return integer(0)
+		}
+		l = new(list)
+		c.set(key, l)
+	}
+	for _, elem := range c.args[1:] {
+		l.push(clone(elem), left)
+	}
+	return integer(int64(l.len()))
+}
+
+func lpopCommand(c *call) beam.Reply {
+	return c.popGeneric(true)
+}
+
+func rpopCommand(c *call) beam.Reply {
+	return c.popGeneric(false)
+}
+
+// popGeneric implements LPOP key [count] and RPOP key [count].
+func (c *call) popGeneric(left bool) beam.Reply {
+	if len(c.args) > 2 {
+		return syntaxErrReply
+	}
+	count := int64(-1)
+	if len(c.args) == 2 {
+		var ok bool
+		if count, ok = parseInt(c.args[1]); !ok || count < 0 {
+			return beam.NewErrorsReply("ERR value is out of range, must be positive")
+		}
+	}
+	key := c.args[0]
+	l, reply := c.lookupList(key)
+	if reply != nil {
+		return reply
+	}
+	if l == nil {
+		if count < 0 {
+			return nilReply
+		}
+		return nilArrayReply
+	}
+	if count < 0 {
+		v := l.pop(left)
+		c.removeIfEmpty(key, l)
+		return beam.NewBulkStringsReplyRaw(v)
+	}
+	elems := make([][]byte, min(count, int64(l.len())))
+	for i := range elems {
+		elems[i] = l.pop(left)
+	}
+	c.removeIfEmpty(key, l)
+	return beam.NewArraysReplyRaw(elems...)
+}
+
+// listRange converts the start and stop indexes which may be negative to the range [start, end)
+// of the list with n elements.
+func listRange(start, stop int64, n int) (int, int) {
+	if start < 0 {
+		start += int64(n)
+	}
+	if stop < 0 {
+		stop += int64(n)
+	}
+	start = max(start, 0)
+	stop = min(stop, int64(n)-1)
+	if start > stop {
+		return 0, 0
+	}
+	return int(start), int(stop) + 1
+}
+
+func lrangeCommand(c *call) beam.Reply {
+	start, ok1 := parseInt(c.args[1])
+	stop, ok2 := parseInt(c.args[2])
+	if !ok1 || !ok2 {
+		return notIntegerReply
+	}
+	l, reply := c.lookupList(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	if l == nil {
+		return beam.AppendArraysHeader(nil, 0)
+	}
+	from, to := listRange(start, stop, l.len())
+	b := beam.AppendArraysHeader(nil, to-from)
+	for i := from; i < to; i++ {
+		b = beam.AppendBulkStringsRaw(b, l.at(i))
+	}
+	return b
+}
+
+// listIndex converts the index which may be negative, false will be returned if it is out of range.
+func listIndex(index int64, n int) (int, bool) {
+	if index < 0 {
+		index += int64(n)
+	}
+	if index < 0 || index >= int64(n) {
+		return 0, false
+	}
+	return int(index), true
+}
+
+func lindexCommand(c *call) beam.Reply {
+	index, ok := parseInt(c.args[1])
+	if !ok {
+		return notIntegerReply
+	}
+	l, reply := c.lookupList(c.args[0])
+	if reply != nil || l == nil {
+		return orNil(reply)
+	}
+	i, ok := listIndex(index, l.len())
+	if !ok {
+		return nilReply
+	}
+	return beam.NewBulkStringsReplyRaw(l.at(i))
+}
+
+// orNil returns the reply, or the nil reply if it is nil.
+func orNil(reply beam.Reply) beam.Reply {
+	if reply == nil {
+		return nilReply
+	}
+	return reply
+}
+
+func lsetCommand(c *call) beam.Reply {
+	index, ok := parseInt(c.args[1])
+	if !ok {
+		return notIntegerReply
+	}
+	l, reply := c.lookupList(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	if l == nil {
+		return beam.NewErrorsReply("ERR no such key")
+	}
+	i, ok := listIndex(index, l.len())
+	if !ok {
+		return beam.NewErrorsReply("ERR index out of range")
+	}
+	l.set(i, clone(c.args[2]))
+	return okReply
+}
+
+// linsertCommand implements LINSERT key BEFORE | AFTER pivot element.
+func linsertCommand(c *call) beam.Reply {
+	var after bool
+	switch strings.ToUpper(string(c.args[1])) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		return syntaxErrReply
+	}
+	l, reply := c.lookupList(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	if l == nil {
+		return integer(0)
+	}
+	elems := l.elements()
+	for i, elem := range elems {
+		if string(elem) != string(c.args[2]) {
+			continue
+		}
+		if after {
+			i++
+		}
+		elems = append(elems[:i], append([][]byte{clone(c.args[3])}, elems[i:]...)...)
+		l.reset(elems)
+		return integer(int64(l.len()))
+	}
+	return integer(-1)
+}
+
+// lremCommand implements LREM key count element, the elements are removed from the head with the
+// positive count, from the tail with the negative one, and all of them with 0.
+func lremCommand(c *call) beam.Reply {
+	count, ok := parseInt(c.args[1])
+	if !ok {
+		return notIntegerReply
+	}
+	key := c.args[0]
+	l, reply := c.lookupList(key)
+	if reply != nil {
+		return reply
+	}
+	if l == nil {
+		return integer(0)
+	}
+	elems := l.elements()
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	removed := int64(0)
+	keep := make([]bool, len(elems))
+	for j := range elems {
+		i := j
+		if count < 0 {
+			i = len(elems) - 1 - j
+		}
+		if (limit == 0 || removed < limit) && string(elems[i]) == string(c.args[2]) {
+			removed++
+			continue
+		}
+		keep[i] = true
+	}
+	kept := elems[:0]
+	for i, elem := range elems {
+		if keep[i] {
+			kept = append(kept, elem)
+		}
+	}
+	l.reset(kept)
+	c.removeIfEmpty(key, l)
+	return integer(removed)
+}
+
+func ltrimCommand(c *call) beam.Reply {
+	start, ok1 := parseInt(c.args[1])
+	stop, ok2 := parseInt(c.args[2])
+	if !ok1 || !ok2 {
+		return notIntegerReply
+	}
+	key := c.args[0]
+	l, reply := c.lookupList(key)
+	if reply != nil {
+		return reply
+	}
+	if l == nil {
+		return okReply
+	}
+	from, to := listRange(start, stop, l.len())
+	l.reset(l.elements()[from:to])
+	c.removeIfEmpty(key, l)
+	return okReply
+}
+
+func llenCommand(c *call) beam.Reply {
+	l, reply := c.lookupList(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	if l == nil {
+		return integer(0)
+	}
+	return integer(int64(l.len()))
+}
+
+// lposCommand implements LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len].
+func lposCommand(c *call) beam.Reply {
+	rank, count, maxLen := int64(1), int64(-1), int64(0)
+	for i := 2; i < len(c.args); i++ {
+		opt := strings.ToUpper(string(c.args[i]))
+		if i+1 >= len(c.args) || (opt != "RANK" && opt != "COUNT" && opt != "MAXLEN") {
+			return syntaxErrReply
+		}
+		i++
+		n, ok := parseInt(c.args[i])
+		if !ok {
+			return notIntegerReply
+		}
+		switch opt {
+		case "RANK":
+			if n == math.MinInt64 {
+				return beam.NewErrorsReply("ERR value is out of range")
+			}
+			if n == 0 {
+				return beam.NewErrorsReply("ERR RANK can't be zero: use 1 to start from the first match, " +
+					"2 from the second ... or use negative to start from the end of the list")
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				return beam.NewErrorsReply("ERR COUNT can't be negative")
+			}
+			count = n
+		case "MAXLEN":
+			if n < 0 {
+				return beam.NewErrorsReply("ERR MAXLEN can't be negative")
+			}
+			maxLen = n
+		}
+	}
+	l, reply := c.lookupList(c.args[0])
+	if reply != nil {
+		return reply
+	}
+
+	var matches []int64
+	if l != nil {
+		n := l.len()
+		skip := rank - 1
+		if rank < 0 {
+			skip = -rank - 1
+		}
+		for j := 0; j < n && (maxLen == 0 || int64(j) < maxLen); j++ {
+			i := j
+			if rank < 0 {
+				i = n - 1 - j
+			}
+			if string(l.at(i)) != string(c.args[1]) {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			matches = append(matches, int64(i))
+			// COUNT 0 returns all the matches.
+			if count < 0 || int64(len(matches)) == count {
+				break
+			}
+		}
+	}
+	if count < 0 {
+		if len(matches) == 0 {
+			return nilReply
+		}
+		return integer(matches[0])
+	}
+	b := beam.AppendArraysHeader(nil, len(matches))
+	for _, i := range matches {
+		b = beam.AppendIntegers(b, i)
+	}
+	return b
+}
+
+// parseDirection parses LEFT or RIGHT, true will be returned for LEFT.
+func parseDirection(b []byte) (left bool, ok bool) {
+	switch strings.ToUpper(string(b)) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+// lmoveCommand implements LMOVE source destination LEFT | RIGHT LEFT | RIGHT.
+func lmoveCommand(c *call) beam.Reply {
+	from, ok1 := parseDirection(c.args[2])
+	to, ok2 := parseDirection(c.args[3])
+	if !ok1 || !ok2 {
+		return syntaxErrReply
+	}
+	return orNil(c.move(c.args[0], c.args[1], from, to))
+}
+
+func rpoplpushCommand(c *call) beam.Reply {
+	return orNil(c.move(c.args[0], c.args[1], false, true))
+}
+
+// move pops the element from the source and pushes it to the destination, nil will be returned
+// if the source does not exist.
+func (c *call) move(src, dst []byte, from, to bool) beam.Reply {
+	l, reply := c.lookupList(src)
+	if reply != nil || l == nil {
+		return reply
+	}
+	dl, reply := c.lookupList(dst)
+	if reply != nil {
+		return reply
+	}
+	v := l.pop(from)
+	if dl == nil {
+		dl = new(list)
+		c.set(dst, dl)
+	}
+	// the source may be the destination, so it is pushed before the empty source is removed.
+	dl.push(v, to)
+	c.removeIfEmpty(src, l)
+	return beam.NewBulkStringsReplyRaw(v)
+}
+
+func blpopCommand(c *call) beam.Reply {
+	return c.blockingPop(true)
+}
+
+func brpopCommand(c *call) beam.Reply {
+	return c.blockingPop(false)
+}
+
+// blockingPop implements BLPOP key [key ...] timeout and BRPOP, the first non-empty list is popped,
+// or the client is blocked until one of the keys is pushed.
+func (c *call) blockingPop(left bool) beam.Reply {
+	keys := c.args[:len(c.args)-1]
+	timeout, reply := parseTimeout(c.args[len(c.args)-1])
+	if reply != nil {
+		return reply
+	}
+	for _, key := range keys {
+		l, reply := c.lookupList(key)
+		if reply != nil {
+			return reply
+		}
+		if l != nil {
+			return c.popKey(key, left)
+		}
+	}
+	c.block(&waiter{
+		keys: keys,
+		serve: func(c *call, key []byte) beam.Reply {
+			return c.popKey(key, left)
+		},
+		timeout:      timeout,
+		timeoutReply: nilArrayReply,
+	})
+	return nil
+}
+
+// popKey pops the list of the key and replies the key with the element, nil will be returned if
+// the key is not a list.
+func (c *call) popKey(key []byte, left bool) beam.Reply {
+	l, _ := c.lookupList(key)
+	if l == nil {
+		return nil
+	}
+	v := l.pop(left)
+	c.removeIfEmpty(key, l)
+	// the blocking pop is logged as the pop of the served key.
+	if left {
+		c.propagate(query("LPOP", key))
+	} else {
+		c.propagate(query("RPOP", key))
+	}
+	return beam.NewArraysReplyRaw(key, v)
+}
+
+// blmoveCommand implements BLMOVE source destination LEFT | RIGHT LEFT | RIGHT timeout.
+func blmoveCommand(c *call) beam.Reply {
+	from, ok1 := parseDirection(c.args[2])
+	to, ok2 := parseDirection(c.args[3])
+	if !ok1 || !ok2 {
+		return syntaxErrReply
+	}
+	return c.blockingMove(from, to, c.args[4])
+}
+
+func brpoplpushCommand(c *call) beam.Reply {
+	return c.blockingMove(false, true, c.args[2])
+}
+
+// propagateMove logs the blocking move as LMOVE if the element is moved.
+func (c *call) propagateMove(reply beam.Reply, src, dst []byte, from, to bool) {
+	if reply == nil || reply.IsError() {
+		return
+	}
+	c.propagate(query("LMOVE", src, dst, directionArg(from), directionArg(to)))
+}
+
+func directionArg(left bool) []byte {
+	if left {
+		return []byte("LEFT")
+	}
+	return []byte("RIGHT")
+}
+
+func (c *call) blockingMove(from, to bool, timeoutArg []byte) beam.Reply {
+	timeout, reply := parseTimeout(timeoutArg)
+	if reply != nil {
+		return reply
+	}
+	src, dst := c.args[0], c.args[1]
+	if reply := c.move(src, dst, from, to); reply != nil {
+		c.propagateMove(reply, src, dst, from, to)
+		return reply
+	}
+	dst = clone(dst)
+	c.block(&waiter{
+		keys: [][]byte{src},
+		serve: func(c *call, key []byte) beam.Reply {
+			// the waiter is skipped if the destination holds another type, the element is left in
+			// the source as redis does.
+			if _, reply := c.lookupList(dst); reply != nil {
+				return nil
+			}
+			reply := c.move(key, dst, from, to)
+			c.propagateMove(reply, key, dst, from, to)
+			return reply
+		},
+		timeout:      timeout,
+		timeoutReply: nilReply,
+	})
+	return nil
+}
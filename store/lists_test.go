@@ -0,0 +1,242 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caeret/beam"
+	"github.com/caeret/beam/beamtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_List(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertInteger(t, 2, conn.Do("RPUSH", "l", "b", "c"))
+	beamtest.AssertInteger(t, 4, conn.Do("LPUSH", "l", "a", "z"))
+	beamtest.AssertSimpleString(t, "list", conn.Do("TYPE", "l"))
+	beamtest.AssertStrings(t, []string{"z", "a", "b", "c"}, conn.Do("LRANGE", "l", "0", "-1"))
+	beamtest.AssertStrings(t, []string{"a", "b"}, conn.Do("LRANGE", "l", "1", "-2"))
+	beamtest.AssertStrings(t, []string{}, conn.Do("LRANGE", "l", "5", "10"))
+	beamtest.AssertStrings(t, []string{}, conn.Do("LRANGE", "missing", "0", "-1"))
+	beamtest.AssertInteger(t, 0, conn.Do("LPUSHX", "missing", "a"))
+	beamtest.AssertInteger(t, 0, conn.Do("RPUSHX", "missing", "a"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "missing"))
+	beamtest.AssertInteger(t, 5, conn.Do("RPUSHX", "l", "d"))
+	beamtest.AssertInteger(t, 5, conn.Do("LLEN", "l"))
+	beamtest.AssertInteger(t, 0, conn.Do("LLEN", "missing"))
+
+	beamtest.AssertBulkString(t, "z", conn.Do("LINDEX", "l", "0"))
+	beamtest.AssertBulkString(t, "d", conn.Do("LINDEX", "l", "-1"))
+	beamtest.AssertNil(t, conn.Do("LINDEX", "l", "5"))
+	beamtest.AssertOK(t, conn.Do("LSET", "l", "-1", "x"))
+	beamtest.AssertError(t, "ERR index out of range", conn.Do("LSET", "l", "5", "x"))
+	beamtest.AssertError(t, "ERR no such key", conn.Do("LSET", "missing", "0", "x"))
+
+	beamtest.AssertBulkString(t, "z", conn.Do("LPOP", "l"))
+	beamtest.AssertBulkString(t, "x", conn.Do("RPOP", "l"))
+	beamtest.AssertStrings(t, []string{"a", "b"}, conn.Do("LPOP", "l", "2"))
+	beamtest.AssertStrings(t, []string{}, conn.Do("LPOP", "l", "0"))
+	beamtest.AssertStrings(t, []string{"c"}, conn.Do("RPOP", "l", "5"))
+	// the key is removed with the last element.
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "l"))
+	beamtest.AssertNil(t, conn.Do("LPOP", "l"))
+	beamtest.AssertReply(t, beam.Reply("*-1\r\n"), conn.Do("LPOP", "l", "1"))
+	beamtest.AssertError(t, "ERR value is out of range, must be positive", conn.Do("LPOP", "l", "-1"))
+}
+
+func TestStore_ListEdit(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertInteger(t, 3, conn.Do("RPUSH", "l", "a", "b", "c"))
+	beamtest.AssertInteger(t, 4, conn.Do("LINSERT", "l", "BEFORE", "b", "x"))
+	beamtest.AssertInteger(t, 5, conn.Do("LINSERT", "l", "after", "c", "y"))
+	beamtest.AssertInteger(t, -1, conn.Do("LINSERT", "l", "AFTER", "missing", "y"))
+	beamtest.AssertInteger(t, 0, conn.Do("LINSERT", "missing", "AFTER", "a", "y"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("LINSERT", "l", "MIDDLE", "a", "y"))
+	beamtest.AssertStrings(t, []string{"a", "x", "b", "c", "y"}, conn.Do("LRANGE", "l", "0", "-1"))
+
+	beamtest.AssertOK(t, conn.Do("LTRIM", "l", "1", "-2"))
+	beamtest.AssertStrings(t, []string{"x", "b", "c"}, conn.Do("LRANGE", "l", "0", "-1"))
+	beamtest.AssertOK(t, conn.Do("LTRIM", "l", "5", "10"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "l"))
+
+	beamtest.AssertInteger(t, 7, conn.Do("RPUSH", "l", "a", "b", "a", "c", "a", "b", "a"))
+	beamtest.AssertInteger(t, 2, conn.Do("LREM", "l", "2", "a"))
+	beamtest.AssertStrings(t, []string{"b", "c", "a", "b", "a"}, conn.Do("LRANGE", "l", "0", "-1"))
+	beamtest.AssertInteger(t, 1, conn.Do("LREM", "l", "-1", "b"))
+	beamtest.AssertStrings(t, []string{"b", "c", "a", "a"}, conn.Do("LRANGE", "l", "0", "-1"))
+	beamtest.AssertInteger(t, 2, conn.Do("LREM", "l", "0", "a"))
+	beamtest.AssertStrings(t, []string{"b", "c"}, conn.Do("LRANGE", "l", "0", "-1"))
+	beamtest.AssertInteger(t, 0, conn.Do("LREM", "missing", "0", "a"))
+}
+
+func TestStore_LPos(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertInteger(t, 8, conn.Do("RPUSH", "l", "a", "b", "c", "1", "2", "3", "c", "c"))
+	beamtest.AssertInteger(t, 2, conn.Do("LPOS", "l", "c"))
+	beamtest.AssertInteger(t, 6, conn.Do("LPOS", "l", "c", "RANK", "2"))
+	beamtest.AssertInteger(t, 7, conn.Do("LPOS", "l", "c", "RANK", "-1"))
+	beamtest.AssertNil(t, conn.Do("LPOS", "l", "x"))
+	beamtest.AssertReply(t, beam.Reply("*2\r\n:2\r\n:6\r\n"), conn.Do("LPOS", "l", "c", "COUNT", "2"))
+	beamtest.AssertReply(t, beam.Reply("*3\r\n:2\r\n:6\r\n:7\r\n"), conn.Do("LPOS", "l", "c", "COUNT", "0"))
+	beamtest.AssertReply(t, beam.Reply("*2\r\n:7\r\n:6\r\n"), conn.Do("LPOS", "l", "c", "RANK", "-1", "COUNT", "2"))
+	beamtest.AssertReply(t, beam.Reply("*1\r\n:2\r\n"), conn.Do("LPOS", "l", "c", "COUNT", "0", "MAXLEN", "4"))
+	beamtest.AssertReply(t, beam.Reply("*0\r\n"), conn.Do("LPOS", "missing", "c", "COUNT", "0"))
+	beamtest.AssertError(t, "ERR RANK can't be zero", conn.Do("LPOS", "l", "c", "RANK", "0"))
+	beamtest.AssertError(t, "ERR COUNT can't be negative", conn.Do("LPOS", "l", "c", "COUNT", "-1"))
+	beamtest.AssertError(t, "ERR MAXLEN can't be negative", conn.Do("LPOS", "l", "c", "MAXLEN", "-1"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("LPOS", "l", "c", "RANK"))
+}
+
+func TestStore_LMove(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertInteger(t, 3, conn.Do("RPUSH", "src", "a", "b", "c"))
+	beamtest.AssertBulkString(t, "a", conn.Do("LMOVE", "src", "dst", "LEFT", "RIGHT"))
+	beamtest.AssertBulkString(t, "c", conn.Do("LMOVE", "src", "dst", "right", "left"))
+	beamtest.AssertStrings(t, []string{"c", "a"}, conn.Do("LRANGE", "dst", "0", "-1"))
+	beamtest.AssertBulkString(t, "b", conn.Do("RPOPLPUSH", "src", "dst"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "src"))
+	beamtest.AssertNil(t, conn.Do("LMOVE", "src", "dst", "LEFT", "RIGHT"))
+
+	// the list is rotated if the source is the destination.
+	beamtest.AssertBulkString(t, "a", conn.Do("LMOVE", "dst", "dst", "RIGHT", "LEFT"))
+	beamtest.AssertStrings(t, []string{"a", "b", "c"}, conn.Do("LRANGE", "dst", "0", "-1"))
+	beamtest.AssertInteger(t, 1, conn.Do("RPUSH", "one", "x"))
+	beamtest.AssertBulkString(t, "x", conn.Do("LMOVE", "one", "one", "LEFT", "RIGHT"))
+	beamtest.AssertStrings(t, []string{"x"}, conn.Do("LRANGE", "one", "0", "-1"))
+
+	beamtest.AssertOK(t, conn.Do("SET", "s", "v"))
+	beamtest.AssertError(t, "WRONGTYPE", conn.Do("LMOVE", "dst", "s", "LEFT", "RIGHT"))
+	beamtest.AssertStrings(t, []string{"a", "b", "c"}, conn.Do("LRANGE", "dst", "0", "-1"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("LMOVE", "dst", "s", "UP", "RIGHT"))
+}
+
+// newBlockingStore starts a store server which can be dialed more than once.
+func newBlockingStore(t *testing.T) (*Store, *beamtest.Server) {
+	s := New(Config{})
+	t.Cleanup(func() { s.Close() })
+	return s, beamtest.NewServer(t, s, beam.Config{})
+}
+
+// waitBlocked waits until n clients are blocked on the key.
+func waitBlocked(t *testing.T, s *Store, key string, n int) {
+	assert.Eventually(t, func() bool {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return len(s.waiters[key]) == n
+	}, time.Second, time.Millisecond)
+}
+
+func TestStore_BlockingPop(t *testing.T) {
+	s, server := newBlockingStore(t)
+	conn := server.Dial()
+
+	// the non-empty list is popped without blocking.
+	beamtest.AssertInteger(t, 2, conn.Do("RPUSH", "b", "1", "2"))
+	beamtest.AssertStrings(t, []string{"b", "1"}, conn.Do("BLPOP", "a", "b", "0"))
+	beamtest.AssertStrings(t, []string{"b", "2"}, conn.Do("BRPOP", "a", "b", "0"))
+
+	// the clients are served in FIFO order.
+	c1, c2, c3 := server.Dial(), server.Dial(), server.Dial()
+	c1.Send(beam.NewQuery("BLPOP", "a", "q", "0"))
+	waitBlocked(t, s, "q", 1)
+	c2.Send(beam.NewQuery("BRPOP", "q", "0"))
+	waitBlocked(t, s, "q", 2)
+	c3.Send(beam.NewQuery("BLPOP", "q", "0"))
+	waitBlocked(t, s, "q", 3)
+
+	beamtest.AssertInteger(t, 2, conn.Do("RPUSH", "q", "x", "y"))
+	beamtest.AssertStrings(t, []string{"q", "x"}, c1.Read())
+	beamtest.AssertStrings(t, []string{"q", "y"}, c2.Read())
+	waitBlocked(t, s, "q", 1)
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "q"))
+	beamtest.AssertInteger(t, 1, conn.Do("LPUSH", "q", "z"))
+	beamtest.AssertStrings(t, []string{"q", "z"}, c3.Read())
+	waitBlocked(t, s, "a", 0)
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "q"))
+
+	// the other types do not serve the blocked clients.
+	c1.Send(beam.NewQuery("BLPOP", "q", "0"))
+	waitBlocked(t, s, "q", 1)
+	beamtest.AssertOK(t, conn.Do("SET", "q", "v"))
+	beamtest.AssertInteger(t, 1, conn.Do("DEL", "q"))
+	beamtest.AssertInteger(t, 1, conn.Do("RPUSH", "q", "w"))
+	beamtest.AssertStrings(t, []string{"q", "w"}, c1.Read())
+}
+
+func TestStore_BlockingDisconnect(t *testing.T) {
+	s, server := newBlockingStore(t)
+	conn := server.Dial()
+
+	// the client hanging up is no longer blocked, so the element is kept for the others.
+	c := server.Dial()
+	c.Send(beam.NewQuery("BLPOP", "q", "0"))
+	waitBlocked(t, s, "q", 1)
+	c.Close()
+	waitBlocked(t, s, "q", 0)
+	beamtest.AssertInteger(t, 1, conn.Do("RPUSH", "q", "job1"))
+	beamtest.AssertInteger(t, 1, conn.Do("LLEN", "q"))
+	beamtest.AssertStrings(t, []string{"q", "job1"}, conn.Do("BLPOP", "q", "0"))
+}
+
+func TestStore_BlockingTimeout(t *testing.T) {
+	assert := assert.New(t)
+	s, server := newBlockingStore(t)
+	conn := server.Dial()
+
+	start := time.Now()
+	beamtest.AssertReply(t, beam.Reply("*-1\r\n"), conn.Do("BLPOP", "q", "0.05"))
+	assert.GreaterOrEqual(time.Since(start), time.Millisecond*50)
+	beamtest.AssertNil(t, conn.Do("BLMOVE", "q", "d", "LEFT", "LEFT", "0.01"))
+	waitBlocked(t, s, "q", 0)
+
+	beamtest.AssertError(t, "ERR timeout is negative", conn.Do("BLPOP", "q", "-1"))
+	beamtest.AssertError(t, "ERR timeout is not a float or out of range", conn.Do("BLPOP", "q", "x"))
+	beamtest.AssertOK(t, conn.Do("SET", "s", "v"))
+	beamtest.AssertError(t, "WRONGTYPE", conn.Do("BLPOP", "q", "s", "0"))
+
+	// the blocked clients return after the store is closed.
+	c := server.Dial()
+	c.Send(beam.NewQuery("BLPOP", "q", "0"))
+	waitBlocked(t, s, "q", 1)
+	s.Close()
+	beamtest.AssertReply(t, beam.Reply("*-1\r\n"), c.Read())
+}
+
+func TestStore_BlockingMove(t *testing.T) {
+	s, server := newBlockingStore(t)
+	conn := server.Dial()
+
+	beamtest.AssertInteger(t, 1, conn.Do("RPUSH", "src", "a"))
+	beamtest.AssertBulkString(t, "a", conn.Do("BLMOVE", "src", "dst", "LEFT", "RIGHT", "0"))
+
+	// the element moved to the destination serves the clients blocked on it.
+	c1, c2 := server.Dial(), server.Dial()
+	c1.Send(beam.NewQuery("BRPOPLPUSH", "q", "next", "0"))
+	waitBlocked(t, s, "q", 1)
+	c2.Send(beam.NewQuery("BLPOP", "next", "0"))
+	waitBlocked(t, s, "next", 1)
+
+	beamtest.AssertInteger(t, 1, conn.Do("LPUSH", "q", "job"))
+	beamtest.AssertBulkString(t, "job", c1.Read())
+	beamtest.AssertStrings(t, []string{"next", "job"}, c2.Read())
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "q", "next"))
+
+	// the client is not served while the destination holds another type, the element is left in
+	// the source.
+	beamtest.AssertOK(t, conn.Do("SET", "str", "v"))
+	c1.Send(beam.NewQuery("BLMOVE", "q", "str", "LEFT", "RIGHT", "0"))
+	waitBlocked(t, s, "q", 1)
+	beamtest.AssertInteger(t, 1, conn.Do("RPUSH", "q", "job1"))
+	waitBlocked(t, s, "q", 1)
+	beamtest.AssertStrings(t, []string{"job1"}, conn.Do("LRANGE", "q", "0", "-1"))
+	beamtest.AssertInteger(t, 1, conn.Do("DEL", "str"))
+	beamtest.AssertBulkString(t, "job1", conn.Do("LPOP", "q"))
+	beamtest.AssertInteger(t, 1, conn.Do("RPUSH", "q", "job2"))
+	beamtest.AssertBulkString(t, "job2", c1.Read())
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "q"))
+	beamtest.AssertStrings(t, []string{"job2"}, conn.Do("LRANGE", "str", "0", "-1"))
+}
@@ -0,0 +1,420 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc64"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/caeret/beam"
+)
+
+// rdbVersion is the version of the RDB format of redis 7.2, which is the first version with the
+// consumer active time of the streams.
+const rdbVersion = 11
+
+// The value types, the opcodes and the encodings of the RDB format.
+const (
+	rdbTypeString           = 0
+	rdbTypeList             = 1
+	rdbTypeSet              = 2
+	rdbTypeZset             = 3
+	rdbTypeHash             = 4
+	rdbTypeZset2            = 5
+	rdbTypeListZiplist      = 10
+	rdbTypeSetIntset        = 11
+	rdbTypeZsetZiplist      = 12
+	rdbTypeHashZiplist      = 13
+	rdbTypeListQuicklist    = 14
+	rdbTypeStreamListpacks  = 15
+	rdbTypeHashListpack     = 16
+	rdbTypeZsetListpack     = 17
+	rdbTypeListQuicklist2   = 18
+	rdbTypeStreamListpacks2 = 19
+	rdbTypeSetListpack      = 20
+	rdbTypeStreamListpacks3 = 21
+
+	rdbOpcodeSlotInfo     = 0xf4
+	rdbOpcodeFunction2    = 0xf5
+	rdbOpcodeIdle         = 0xf8
+	rdbOpcodeFreq         = 0xf9
+	rdbOpcodeAux          = 0xfa
+	rdbOpcodeResizeDB     = 0xfb
+	rdbOpcodeExpireTimeMs = 0xfc
+	rdbOpcodeExpireTime   = 0xfd
+	rdbOpcodeSelectDB     = 0xfe
+	rdbOpcodeEOF          = 0xff
+
+	rdbLen6       = 0
+	rdbLen14      = 1
+	rdbLenEncoded = 3
+	rdbLen32      = 0x80
+	rdbLen64      = 0x81
+
+	rdbEncodingInt8  = 0
+	rdbEncodingInt16 = 1
+	rdbEncodingInt32 = 2
+	rdbEncodingLZF   = 3
+
+	// the special lengths of the scores before redis 5.
+	rdbDoubleNaN = 253
+	rdbPosInf    = 254
+	rdbNegInf    = 255
+
+	rdbStreamIDSize         = 16
+	rdbStreamNodeMaxEntries = 100
+	rdbStreamItemDeleted    = 1
+	rdbStreamItemSameFields = 2
+	rdbQuicklistNodePlain   = 1
+	rdbQuicklistNodePacked  = 2
+
+	// rdbMaxSupportedVersion is the version of redis 7.4, the types added in it such as the hashes
+	// with the field expiry are not supported.
+	rdbMaxSupportedVersion = 12
+	rdbMinChecksumVersion  = 5
+)
+
+// crcTable is the table of CRC-64-Jones used by the RDB files of redis.
+var crcTable = crc64.MakeTable(bits.Reverse64(0xad93d23594c935a9))
+
+// crc updates the checksum without the inversions of hash/crc64.
+func crc(sum uint64, p []byte) uint64 {
+	return ^crc64.Update(^sum, crcTable, p)
+}
+
+// rdbWriter writes the RDB file with the checksum.
+type rdbWriter struct {
+	w   *bufio.Writer
+	sum uint64
+	buf []byte
+	err error
+}
+
+func (w *rdbWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	w.sum = crc(w.sum, p)
+	_, w.err = w.w.Write(p)
+}
+
+func (w *rdbWriter) writeByte(c byte) {
+	w.write([]byte{c})
+}
+
+// writeLen writes the length in 1, 2, 5 or 9 bytes.
+func (w *rdbWriter) writeLen(n uint64) {
+	b := w.buf[:0]
+	switch {
+	case n < 1<<6:
+		b = append(b, byte(n))
+	case n < 1<<14:
+		b = append(b, rdbLen14<<6|byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		b = binary.BigEndian.AppendUint32(append(b, rdbLen32), uint32(n))
+	default:
+		b = binary.BigEndian.AppendUint64(append(b, rdbLen64), n)
+	}
+	w.buf = b
+	w.write(b)
+}
+
+func (w *rdbWriter) writeString(s []byte) {
+	w.writeLen(uint64(len(s)))
+	w.write(s)
+}
+
+// writeMillis writes the unix time in milliseconds as the little endian integer.
+func (w *rdbWriter) writeMillis(ms int64) {
+	w.buf = binary.LittleEndian.AppendUint64(w.buf[:0], uint64(ms))
+	w.write(w.buf)
+}
+
+// writeID writes the stream ID as the big endian integers.
+func (w *rdbWriter) writeID(id streamID) {
+	w.buf = binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(w.buf[:0], id.ms), id.seq)
+	w.write(w.buf)
+}
+
+// writeRDB writes the keys in the RDB format, the expired keys are skipped.
+func writeRDB(w io.Writer, keys *dict[*entry], now int64) error {
+	rw := &rdbWriter{w: bufio.NewWriterSize(w, 64*1024)}
+	rw.write([]byte("REDIS00" + strconv.Itoa(rdbVersion)))
+	for _, aux := range [][2]string{
+		{"redis-ver", "7.2.0"},
+		{"redis-bits", "64"},
+		{"ctime", strconv.FormatInt(now/1000, 10)},
+		{"aof-base", "0"},
+	} {
+		rw.writeByte(rdbOpcodeAux)
+		rw.writeString([]byte(aux[0]))
+		rw.writeString([]byte(aux[1]))
+	}
+	rw.writeByte(rdbOpcodeSelectDB)
+	rw.writeLen(0)
+	expires := 0
+	keys.each(func(_ string, e *entry) bool {
+		if e.expireAt > 0 {
+			expires++
+		}
+		return true
+	})
+	rw.writeByte(rdbOpcodeResizeDB)
+	rw.writeLen(uint64(keys.len()))
+	rw.writeLen(uint64(expires))
+	keys.each(func(key string, e *entry) bool {
+		if e.expireAt > 0 {
+			if e.expireAt <= now {
+				return true
+			}
+			rw.writeByte(rdbOpcodeExpireTimeMs)
+			rw.writeMillis(e.expireAt)
+		}
+		rw.writeValue([]byte(key), e.value)
+		return rw.err == nil
+	})
+	rw.writeByte(rdbOpcodeEOF)
+	// the checksum is not included in itself.
+	sum := binary.LittleEndian.AppendUint64(nil, rw.sum)
+	rw.write(sum)
+	if rw.err != nil {
+		return rw.err
+	}
+	return rw.w.Flush()
+}
+
+// writeValue writes the type, the key and the value, the collections are written in the plain types
+// except the streams, which are always encoded as the listpacks.
+func (w *rdbWriter) writeValue(key []byte, value any) {
+	switch v := value.(type) {
+	case []byte:
+		w.writeByte(rdbTypeString)
+		w.writeString(key)
+		w.writeString(v)
+	case *list:
+		w.writeByte(rdbTypeList)
+		w.writeString(key)
+		w.writeLen(uint64(v.len()))
+		for i := range v.len() {
+			w.writeString(v.at(i))
+		}
+	case *set:
+		w.writeByte(rdbTypeSet)
+		w.writeString(key)
+		w.writeLen(uint64(v.len()))
+		v.each(func(member string, _ struct{}) bool {
+			w.writeString([]byte(member))
+			return true
+		})
+	case *hash:
+		w.writeByte(rdbTypeHash)
+		w.writeString(key)
+		w.writeLen(uint64(v.len()))
+		v.each(func(field string, value []byte) bool {
+			w.writeString([]byte(field))
+			w.writeString(value)
+			return true
+		})
+	case *zset:
+		w.writeByte(rdbTypeZset2)
+		w.writeString(key)
+		w.writeLen(uint64(v.len()))
+		v.scores.each(func(member string, score float64) bool {
+			w.writeString([]byte(member))
+			w.buf = binary.LittleEndian.AppendUint64(w.buf[:0], math.Float64bits(score))
+			w.write(w.buf)
+			return true
+		})
+	case *stream:
+		w.writeByte(rdbTypeStreamListpacks3)
+		w.writeString(key)
+		w.writeStream(v)
+	}
+}
+
+// writeStream writes the entries in the listpacks of at most 100 entries as redis does, then the
+// metadata and the consumer groups.
+func (w *rdbWriter) writeStream(s *stream) {
+	nodes := (len(s.entries) + rdbStreamNodeMaxEntries - 1) / rdbStreamNodeMaxEntries
+	w.writeLen(uint64(nodes))
+	for i := 0; i < len(s.entries); i += rdbStreamNodeMaxEntries {
+		entries := s.entries[i:min(i+rdbStreamNodeMaxEntries, len(s.entries))]
+		master := entries[0]
+		// the key of the node is the master ID as the string.
+		w.writeLen(rdbStreamIDSize)
+		w.writeID(master.id)
+		w.writeString(streamListpack(master, entries))
+	}
+	w.writeLen(uint64(len(s.entries)))
+	w.writeLen(s.lastID.ms)
+	w.writeLen(s.lastID.seq)
+	first := s.firstID()
+	w.writeLen(first.ms)
+	w.writeLen(first.seq)
+	w.writeLen(s.maxDeletedID.ms)
+	w.writeLen(s.maxDeletedID.seq)
+	w.writeLen(uint64(s.entriesAdded))
+
+	groups := sortedGroups(s)
+	w.writeLen(uint64(len(groups)))
+	for _, g := range groups {
+		w.writeString([]byte(g.name))
+		w.writeLen(g.lastID.ms)
+		w.writeLen(g.lastID.seq)
+		// the unknown counter -1 is written as the max uint64 as redis does.
+		w.writeLen(uint64(g.entriesRead))
+		pending := sortedPending(g.pending)
+		w.writeLen(uint64(len(pending)))
+		for _, pe := range pending {
+			w.writeID(pe.id)
+			w.writeMillis(pe.deliveryTime)
+			w.writeLen(uint64(pe.deliveryCount))
+		}
+		consumers := sortedConsumers(g)
+		w.writeLen(uint64(len(consumers)))
+		for _, cons := range consumers {
+			w.writeString([]byte(cons.name))
+			w.writeMillis(cons.seenTime)
+			w.writeMillis(cons.activeTime)
+			pending := sortedPending(cons.pending)
+			w.writeLen(uint64(len(pending)))
+			for _, pe := range pending {
+				w.writeID(pe.id)
+			}
+		}
+	}
+}
+
+// streamListpack encodes the entries in the listpack of the stream node, the master entry holds the
+// fields of the first entry, and the entries with the same fields only hold the values.
+func streamListpack(master streamEntry, entries []streamEntry) []byte {
+	lp := newListpackWriter()
+	numFields := len(master.fields) / 2
+	lp.appendInt(int64(len(entries)))
+	lp.appendInt(0)
+	lp.appendInt(int64(numFields))
+	for i := 0; i < len(master.fields); i += 2 {
+		lp.appendString(master.fields[i])
+	}
+	lp.appendInt(0)
+	for _, e := range entries {
+		same := len(e.fields) == len(master.fields)
+		for i := 0; same && i < len(e.fields); i += 2 {
+			same = string(e.fields[i]) == string(master.fields[i])
+		}
+		flags := int64(0)
+		if same {
+			flags = rdbStreamItemSameFields
+		}
+		lp.appendInt(flags)
+		lp.appendInt(int64(e.id.ms - master.id.ms))
+		lp.appendInt(int64(e.id.seq - master.id.seq))
+		n := len(e.fields) / 2
+		if same {
+			for i := 1; i < len(e.fields); i += 2 {
+				lp.appendString(e.fields[i])
+			}
+			lp.appendInt(int64(n + 3))
+			continue
+		}
+		lp.appendInt(int64(n))
+		for _, field := range e.fields {
+			lp.appendString(field)
+		}
+		lp.appendInt(int64(n*2 + 4))
+	}
+	return lp.bytes()
+}
+
+// save writes the keys to the RDB file, it is written to a temporary file at first and renamed, so
+// the file is always complete.
+func (s *Store) save(keys *dict[*entry], now int64) error {
+	tmp := s.config.RDBFile + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = writeRDB(file, keys, now)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.config.RDBFile)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
+var rdbNotConfiguredReply = beam.NewErrorsReply("ERR The RDB file is not configured")
+
+// saveCommand implements SAVE, the keys are written with the lock held.
+func saveCommand(c *call) beam.Reply {
+	if c.config.RDBFile == "" {
+		return rdbNotConfiguredReply
+	}
+	if c.bgsaving {
+		return beam.NewErrorsReply("ERR Background save already in progress")
+	}
+	if err := c.save(&c.keys, c.now); err != nil {
+		c.config.Logger.Error("fail to save the RDB file", "path", c.config.RDBFile, beam.LogKeyError, err)
+		return beam.NewErrorsReply("ERR " + err.Error())
+	}
+	c.lastSave = c.now
+	return okReply
+}
+
+// bgsaveCommand implements BGSAVE [SCHEDULE], the keys are written from a snapshot in the
+// background. SCHEDULE is accepted for compatibility, since the saving never conflicts with the
+// rewrite of the append-only file.
+func bgsaveCommand(c *call) beam.Reply {
+	if len(c.args) > 1 || (len(c.args) == 1 && !strings.EqualFold(string(c.args[0]), "SCHEDULE")) {
+		return syntaxErrReply
+	}
+	if c.config.RDBFile == "" {
+		return rdbNotConfiguredReply
+	}
+	if c.bgsaving {
+		return beam.NewErrorsReply("ERR Background save already in progress")
+	}
+	c.bgsave()
+	return beam.NewSimpleStringsReply("Background saving started")
+}
+
+// bgsave saves the snapshot of the keys in the background.
+func (s *Store) bgsave() {
+	keys := s.snapshot()
+	now := s.now()
+	s.bgsaving = true
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		err := s.save(keys, now)
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.releaseSnapshot()
+		s.bgsaving = false
+		if err != nil {
+			s.config.Logger.Error("fail to save the RDB file", "path", s.config.RDBFile, beam.LogKeyError, err)
+			return
+		}
+		s.lastSave = now
+		s.config.Logger.Info("save the RDB file", "path", s.config.RDBFile)
+	}()
+}
+
+// lastsaveCommand implements LASTSAVE, the unix time of the last successful save is replied, or the
+// time when the store is opened.
+func lastsaveCommand(c *call) beam.Reply {
+	return integer(c.lastSave / 1000)
+}
@@ -0,0 +1,371 @@
+package store
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caeret/beam"
+	"github.com/caeret/beam/beamtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCRC(t *testing.T) {
+	assert.Equal(t, uint64(0xe9c6d914c4b8d9ca), crc(0, []byte("123456789")))
+	assert.Equal(t, uint64(0xe9c6d914c4b8d9ca), crc(crc(0, []byte("1234")), []byte("56789")))
+}
+
+func TestListpack(t *testing.T) {
+	assert := assert.New(t)
+
+	lp := newListpackWriter()
+	var expected [][]byte
+	for _, n := range []int{0, 63, 64, 4095, 4096} {
+		s := []byte(strings.Repeat("x", n))
+		lp.appendString(s)
+		expected = append(expected, s)
+	}
+	for _, v := range []int64{0, 127, 128, -1, -4096, 4095, 4096, math.MinInt16, math.MaxInt16 + 1, -1 << 23,
+		1<<23 - 1, 1 << 23, math.MinInt32, math.MaxInt32 + 1, math.MinInt64, math.MaxInt64} {
+		lp.appendInt(v)
+		expected = append(expected, []byte(strconv.FormatInt(v, 10)))
+	}
+	b := lp.bytes()
+	assert.Equal(len(expected), int(binary.LittleEndian.Uint16(b[4:])))
+	elems, err := readListpack(b)
+	assert.NoError(err)
+	assert.Equal(expected, elems)
+
+	_, err = readListpack(b[:len(b)-1])
+	assert.ErrorIs(err, errCorruptEncoding)
+}
+
+func TestStore_Save(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	s, clk, conn := newTestStore(t, Config{RDBFile: path, ActiveExpireInterval: time.Hour})
+
+	_, _, memory := newTestStore(t, Config{})
+	beamtest.AssertError(t, "ERR The RDB file is not configured", memory.Do("SAVE"))
+	beamtest.AssertError(t, "ERR The RDB file is not configured", memory.Do("BGSAVE"))
+
+	beamtest.AssertInteger(t, 1700000000, conn.Do("LASTSAVE"))
+	fillKeys(conn)
+	for i := range 250 {
+		conn.Do("XADD", "big", "*", "n", strconv.Itoa(i))
+		conn.Do("XADD", "big", "*", "n", strconv.Itoa(i), "other", "v")
+	}
+	conn.Do("XDEL", "big", "1700000000000-1")
+	conn.Do("XGROUP", "CREATE", "big", "g", "0")
+	conn.Do("XREADGROUP", "GROUP", "g", "bob", "COUNT", "10", "STREAMS", "big", ">")
+	clk.advance(time.Millisecond * 500)
+	before := append(readKeys(conn), beam.Reply(conn.Do("XINFO", "STREAM", "big")).String(),
+		beam.Reply(conn.Do("XPENDING", "big", "g", "-", "+", "20")).String())
+
+	beamtest.AssertOK(t, conn.Do("SAVE"))
+	s.mutex.Lock()
+	assert.Equal(clk.Load(), s.lastSave)
+	s.mutex.Unlock()
+
+	s, clk, conn = reopen(t, s, clk)
+	after := append(readKeys(conn), beam.Reply(conn.Do("XINFO", "STREAM", "big")).String(),
+		beam.Reply(conn.Do("XPENDING", "big", "g", "-", "+", "20")).String())
+	assert.Equal(before, after)
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "tmp"))
+
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("BGSAVE", "NOW"))
+	beamtest.AssertSimpleString(t, "Background saving started", conn.Do("BGSAVE", "SCHEDULE"))
+	// the keys written during the saving are not in the snapshot.
+	beamtest.AssertOK(t, conn.Do("SET", "new", "1"))
+	assert.Eventually(func() bool {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return !s.bgsaving
+	}, time.Second*5, time.Millisecond*10)
+	beamtest.AssertInteger(t, 1700000000, conn.Do("LASTSAVE"))
+
+	_, _, conn = reopen(t, s, clk)
+	beamtest.AssertNil(t, conn.Do("GET", "new"))
+	beamtest.AssertBulkString(t, "bar", conn.Do("GET", "foo"))
+}
+
+// rdbString encodes the string with the 6 or 14 bits length.
+func rdbString(s string) []byte {
+	if len(s) < 64 {
+		return append([]byte{byte(len(s))}, s...)
+	}
+	return append([]byte{0x40 | byte(len(s)>>8), byte(len(s))}, s...)
+}
+
+func listpack(elems ...any) string {
+	lp := newListpackWriter()
+	for _, elem := range elems {
+		switch v := elem.(type) {
+		case int:
+			lp.appendInt(int64(v))
+		case string:
+			lp.appendString([]byte(v))
+		}
+	}
+	return string(lp.bytes())
+}
+
+// writeRDBFile writes the body of the RDB file with the header, the EOF and the checksum.
+func writeRDBFile(t *testing.T, version string, body ...[]byte) string {
+	b := []byte("REDIS" + version)
+	for _, p := range body {
+		b = append(b, p...)
+	}
+	b = append(b, rdbOpcodeEOF)
+	if version >= "0005" {
+		b = binary.LittleEndian.AppendUint64(b, crc(0, b))
+	}
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func rdbKey(typ byte, key string, value ...[]byte) []byte {
+	b := append([]byte{typ}, rdbString(key)...)
+	for _, p := range value {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func TestStore_LoadRedisRDB(t *testing.T) {
+	assert := assert.New(t)
+
+	ziplist := "\x14\x00\x00\x00\x0f\x00\x00\x00\x03\x00" + "\x00\x01a" + "\x03\xf6" + "\x02\xc0\x2c\x01" + "\xff"
+	intset := "\x02\x00\x00\x00\x02\x00\x00\x00\x01\x00\xfe\xff"
+	path := writeRDBFile(t, "0011",
+		[]byte{rdbOpcodeAux}, rdbString("redis-ver"), rdbString("7.2.0"),
+		[]byte{rdbOpcodeSelectDB, 0, rdbOpcodeResizeDB, 10, 1},
+		rdbKey(rdbTypeString, "int8", []byte{0xc0, 0x7b}),
+		rdbKey(rdbTypeString, "int16", []byte{0xc1, 0x18, 0xfc}),
+		rdbKey(rdbTypeString, "int32", []byte{0xc2, 0x40, 0x42, 0x0f, 0x00}),
+		rdbKey(rdbTypeString, "lzf", []byte{0xc3, 5, 10, 0x00, 'a', 0xe0, 0x00, 0x00}),
+		[]byte{rdbOpcodeExpireTimeMs}, binary.LittleEndian.AppendUint64(nil, 1700000005000),
+		rdbKey(rdbTypeString, "ttl", rdbString("v")),
+		[]byte{rdbOpcodeExpireTimeMs}, binary.LittleEndian.AppendUint64(nil, 1600000000000),
+		rdbKey(rdbTypeString, "expired", rdbString("v")),
+		rdbKey(rdbTypeListZiplist, "ziplist", rdbString(ziplist)),
+		rdbKey(rdbTypeListQuicklist2, "quicklist", []byte{2, rdbQuicklistNodePacked},
+			rdbString(listpack("a", 1)), []byte{rdbQuicklistNodePlain}, rdbString(strings.Repeat("c", 100))),
+		rdbKey(rdbTypeSetIntset, "intset", rdbString(intset)),
+		rdbKey(rdbTypeSetListpack, "set", rdbString(listpack("a", "b"))),
+		rdbKey(rdbTypeHashListpack, "hash", rdbString(listpack("f", "v", "n", 2))),
+		rdbKey(rdbTypeZsetListpack, "zset", rdbString(listpack("a", "1.5", "b", 2))),
+		rdbKey(rdbTypeZset, "oldzset", []byte{2}, rdbString("a"), []byte{3}, []byte("2.5"), rdbString("b"), []byte{rdbNegInf}),
+		[]byte{rdbOpcodeSelectDB, 1},
+		rdbKey(rdbTypeString, "other", rdbString("v")),
+	)
+	s, _, conn := newTestStore(t, Config{RDBFile: path})
+
+	beamtest.AssertBulkString(t, "123", conn.Do("GET", "int8"))
+	beamtest.AssertBulkString(t, "-1000", conn.Do("GET", "int16"))
+	beamtest.AssertBulkString(t, "1000000", conn.Do("GET", "int32"))
+	beamtest.AssertBulkString(t, strings.Repeat("a", 10), conn.Do("GET", "lzf"))
+	beamtest.AssertInteger(t, 5000, conn.Do("PTTL", "ttl"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "expired", "other"))
+	beamtest.AssertStrings(t, []string{"a", "5", "300"}, conn.Do("LRANGE", "ziplist", "0", "-1"))
+	beamtest.AssertStrings(t, []string{"a", "1", strings.Repeat("c", 100)}, conn.Do("LRANGE", "quicklist", "0", "-1"))
+	beamtest.AssertInteger(t, 1, conn.Do("SISMEMBER", "intset", "-2"))
+	beamtest.AssertInteger(t, 2, conn.Do("SCARD", "set"))
+	beamtest.AssertBulkString(t, "2", conn.Do("HGET", "hash", "n"))
+	beamtest.AssertStrings(t, []string{"a", "1.5", "b", "2"}, conn.Do("ZRANGE", "zset", "0", "-1", "WITHSCORES"))
+	beamtest.AssertStrings(t, []string{"b", "-inf", "a", "2.5"}, conn.Do("ZRANGE", "oldzset", "0", "-1", "WITHSCORES"))
+	assert.Equal(12, s.keys.len())
+}
+
+// loadRedisDump opens the store with the RDB file saved by redis-server in testdata/rdb, the test
+// fails if the file is missing.
+func loadRedisDump(t *testing.T, name string) *beamtest.Conn {
+	path := filepath.Join("testdata", "rdb", name+".rdb")
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	clk := new(clock)
+	clk.Store(1700000000000)
+	s, err := newStore(Config{RDBFile: path}, clk.Load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	// the queries with the keys longer than 16KB fit in the buffer.
+	return beamtest.NewServer(t, s, beam.Config{BufferSize: 64 * 1024}).Dial()
+}
+
+func TestStore_LoadRedisDump(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := loadRedisDump(t, "rdb_version_5_with_checksum")
+	beamtest.AssertInteger(t, 6, conn.Do("EXISTS", "abcd", "foo", "bar", "abcdef", "longerstring", "abc"))
+	beamtest.AssertBulkString(t, "efgh", conn.Do("GET", "abcd"))
+	beamtest.AssertBulkString(t, "thisisalongerstring.idontknowwhatitmeans", conn.Do("GET", "longerstring"))
+
+	// the keys are encoded as the integers.
+	conn = loadRedisDump(t, "integer_keys")
+	beamtest.AssertBulkString(t, "Positive 8 bit integer", conn.Do("GET", "125"))
+	beamtest.AssertBulkString(t, "Positive 16 bit integer", conn.Do("GET", "43947"))
+	beamtest.AssertBulkString(t, "Positive 32 bit integer", conn.Do("GET", "183358245"))
+	beamtest.AssertBulkString(t, "Negative 8 bit integer", conn.Do("GET", "-123"))
+	beamtest.AssertBulkString(t, "Negative 16 bit integer", conn.Do("GET", "-29477"))
+	beamtest.AssertBulkString(t, "Negative 32 bit integer", conn.Do("GET", "-183358245"))
+
+	conn = loadRedisDump(t, "easily_compressible_string_key")
+	beamtest.AssertBulkString(t, "Key that redis should compress easily", conn.Do("GET", strings.Repeat("a", 200)))
+
+	// the lengths of the keys are encoded in 6, 14 and 32 bits.
+	conn = loadRedisDump(t, "uncompressible_string_keys")
+	elems, err := conn.Do("SCAN", "0", "COUNT", "10").Array()
+	assert.NoError(err)
+	keys, err := elems[1].Array()
+	assert.NoError(err)
+	values := map[int]string{}
+	for _, key := range keys {
+		k, err := key.Text()
+		assert.NoError(err)
+		v, err := conn.Do("GET", k).Text()
+		assert.NoError(err)
+		values[len(k)] = v
+	}
+	assert.Equal(map[int]string{
+		60:    "Key length within 6 bits",
+		16382: "Key length more than 6 bits but less than 14 bits",
+		16386: "Key length more than 14 bits but less than 32",
+	}, values)
+
+	// the key expired at 2022-12-25 is not loaded.
+	conn = loadRedisDump(t, "keys_with_expiry")
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "expires_ms_precision"))
+	conn = loadRedisDump(t, "keys_with_mixed_expiry")
+	beamtest.AssertInteger(t, 2080245030932, conn.Do("PEXPIRETIME", "key01"))
+	beamtest.AssertInteger(t, -1, conn.Do("PEXPIRETIME", "key02"))
+	beamtest.AssertInteger(t, -1, conn.Do("PEXPIRETIME", "key03"))
+	beamtest.AssertInteger(t, 2080245034115, conn.Do("PEXPIRETIME", "key04"))
+	beamtest.AssertBulkString(t, "this does expire", conn.Do("GET", "key04"))
+
+	// the keys of the other databases are skipped.
+	conn = loadRedisDump(t, "multiple_databases")
+	beamtest.AssertBulkString(t, "zero", conn.Do("GET", "key_in_zeroth_database"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "key_in_second_database"))
+	conn = loadRedisDump(t, "empty_database")
+	beamtest.AssertReply(t, beam.NewNestedArraysReply(beam.NewBulkStringsReply("0"), beam.NewArraysReply()), conn.Do("SCAN", "0"))
+
+	conn = loadRedisDump(t, "hash_as_ziplist")
+	beamtest.AssertInteger(t, 3, conn.Do("HLEN", "zipmap_compresses_easily"))
+	for field, value := range map[string]string{"a": "aa", "aa": "aaaa", "aaaaa": "aaaaaaaaaaaaaa"} {
+		beamtest.AssertBulkString(t, value, conn.Do("HGET", "zipmap_compresses_easily", field))
+	}
+	conn = loadRedisDump(t, "dictionary")
+	beamtest.AssertInteger(t, 1000, conn.Do("HLEN", "force_dictionary"))
+	beamtest.AssertBulkString(t, "T63SOS8DQJF0Q0VJEZ0D1IQFCYTIPSBOUIAI9SB0OV57MQR1FI",
+		conn.Do("HGET", "force_dictionary", "ZMU5WEJDG7KU89AOG5LJT6K7HMNB3DEI43M6EYTJ83VRJ6XNXQ"))
+	beamtest.AssertBulkString(t, "6VULTCV52FXJ8MGVSFTZVAGK2JXZMGQ5F8OVJI0X6GEDDR27RZ",
+		conn.Do("HGET", "force_dictionary", "UHS5ESW4HLK8XOGTM39IK1SJEUGVV9WOPK6JYA5QBZSJU84491"))
+
+	conn = loadRedisDump(t, "ziplist_that_compresses_easily")
+	var list []string
+	for _, n := range []int{6, 12, 18, 24, 30, 36} {
+		list = append(list, strings.Repeat("a", n))
+	}
+	beamtest.AssertStrings(t, list, conn.Do("LRANGE", "ziplist_compresses_easily", "0", "-1"))
+	conn = loadRedisDump(t, "ziplist_that_doesnt_compress")
+	beamtest.AssertStrings(t, []string{"aj2410", "cc953a17a8e096e76a44169ad3f9ac87c5f8248a403274416179aa9fbd852344"},
+		conn.Do("LRANGE", "ziplist_doesnt_compress", "0", "-1"))
+	conn = loadRedisDump(t, "ziplist_with_integers")
+	beamtest.AssertStrings(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12", "-2",
+		"13", "25", "-61", "63", "16380", "-16000", "65535", "-65523", "4194304", "9223372036854775807"},
+		conn.Do("LRANGE", "ziplist_with_integers", "0", "-1"))
+	conn = loadRedisDump(t, "linkedlist")
+	beamtest.AssertInteger(t, 1000, conn.Do("LLEN", "force_linkedlist"))
+	beamtest.AssertBulkString(t, "41PJSO2KRV6SK1WJ6936L06YQDPV68R5J2TAZO3YAR5IL5GUI8", conn.Do("LINDEX", "force_linkedlist", "0"))
+	beamtest.AssertBulkString(t, "2C5URE2L24D9GJUZJ59IWCAH8SGYF5T7QZ0EXQ0IE4I2JSB1QD", conn.Do("LINDEX", "force_linkedlist", "-1"))
+	conn = loadRedisDump(t, "rdb_v7_list_quicklist")
+	beamtest.AssertStrings(t, []string{"bar", "baz", "boo"}, conn.Do("LRANGE", "foo", "0", "-1"))
+
+	for key, members := range map[string][]string{
+		"intset_16":   {"32764", "32765", "32766"},
+		"intset_32":   {"2147418108", "2147418109", "2147418110"},
+		"intset_64":   {"9223090557583032316", "9223090557583032317", "9223090557583032318"},
+		"regular_set": {"alpha", "beta", "delta", "gamma", "kappa", "phi"},
+	} {
+		conn = loadRedisDump(t, key)
+		beamtest.AssertInteger(t, int64(len(members)), conn.Do("SCARD", key))
+		for _, member := range members {
+			beamtest.AssertInteger(t, 1, conn.Do("SISMEMBER", key, member))
+		}
+	}
+
+	conn = loadRedisDump(t, "sorted_set_as_ziplist")
+	beamtest.AssertStrings(t, []string{"8b6ba6718a786daefa69438148361901", "1", "cb7a24bb7528f934b841b34c3a73e0c7", "2.37",
+		"523af537946b79c4f8369ed39ba78605", "3.423"}, conn.Do("ZRANGE", "sorted_set_as_ziplist", "0", "-1", "WITHSCORES"))
+	conn = loadRedisDump(t, "regular_sorted_set")
+	beamtest.AssertInteger(t, 500, conn.Do("ZCARD", "force_sorted_set"))
+	beamtest.AssertBulkString(t, "3.19", conn.Do("ZSCORE", "force_sorted_set", "G72TWVWH0DY782VG0H8VVAR8RNO7BS9QGOHTZFJU67X7L0Z3PR"))
+	beamtest.AssertBulkString(t, "4.73", conn.Do("ZSCORE", "force_sorted_set", "MBNE4KFV66LQQUZNFC7Z5KS1Y5I1IIIOT37OBUSGNDQQ2ITGZ8"))
+
+	// the corrupted dump is rejected by the checksum.
+	b, err := os.ReadFile(filepath.Join("testdata", "rdb", "rdb_version_5_with_checksum.rdb"))
+	assert.NoError(err)
+	b[len(b)-1] ^= 0xff
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	assert.NoError(os.WriteFile(path, b, 0o644))
+	_, err = Open(Config{RDBFile: path})
+	assert.ErrorContains(err, "wrong checksum")
+}
+
+func TestStore_LoadRDBVersions(t *testing.T) {
+	// the checksum is not written before the version 5, and the expiry is in seconds.
+	path := writeRDBFile(t, "0003",
+		[]byte{rdbOpcodeSelectDB, 0},
+		[]byte{rdbOpcodeExpireTime}, binary.LittleEndian.AppendUint32(nil, 1700000010),
+		rdbKey(rdbTypeString, "foo", rdbString("bar")),
+	)
+	_, _, conn := newTestStore(t, Config{RDBFile: path})
+	beamtest.AssertBulkString(t, "bar", conn.Do("GET", "foo"))
+	beamtest.AssertInteger(t, 10, conn.Do("TTL", "foo"))
+
+	// the checksum is disabled.
+	path = filepath.Join(t.TempDir(), "dump.rdb")
+	b := append([]byte("REDIS0011"), rdbKey(rdbTypeString, "foo", rdbString("bar"))...)
+	b = append(b, rdbOpcodeEOF, 0, 0, 0, 0, 0, 0, 0, 0)
+	assert.NoError(t, os.WriteFile(path, b, 0o644))
+	_, _, conn = newTestStore(t, Config{RDBFile: path})
+	beamtest.AssertBulkString(t, "bar", conn.Do("GET", "foo"))
+}
+
+func TestStore_LoadRDBError(t *testing.T) {
+	assert := assert.New(t)
+
+	path := writeRDBFile(t, "0011", rdbKey(rdbTypeString, "foo", rdbString("bar")))
+	b, err := os.ReadFile(path)
+	assert.NoError(err)
+	b[len(b)-1] ^= 0xff
+	assert.NoError(os.WriteFile(path, b, 0o644))
+	_, err = Open(Config{RDBFile: path})
+	assert.ErrorContains(err, "wrong checksum")
+
+	for _, c := range []struct {
+		body []byte
+		err  string
+	}{
+		{rdbKey(6, "module", rdbString("v")), "unsupported type 6"},
+		{rdbKey(rdbTypeHashListpack, "hash", rdbString(listpack("f"))), "key \"hash\": corrupt compact encoding"},
+		{rdbKey(rdbTypeString, "lzf", []byte{0xc3, 3, 10, 0x00, 'a', 0xe0}), "corrupt compact encoding"},
+	} {
+		_, err = Open(Config{RDBFile: writeRDBFile(t, "0011", c.body)})
+		assert.ErrorContains(err, c.err)
+	}
+
+	_, err = Open(Config{RDBFile: writeRDBFile(t, "0013")})
+	assert.ErrorContains(err, "unsupported version \"0013\"")
+}
@@ -0,0 +1,718 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// rdbReader reads the RDB file and updates the checksum.
+type rdbReader struct {
+	r   *bufio.Reader
+	sum uint64
+	// offset is the number of the bytes read for the errors.
+	offset int64
+}
+
+func (r *rdbReader) read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	r.sum = crc(r.sum, b)
+	r.offset += int64(n)
+	return b, nil
+}
+
+func (r *rdbReader) readByte() (byte, error) {
+	b, err := r.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readLen reads the length, or the encoding of the special string if encoded is true.
+func (r *rdbReader) readLen() (n uint64, encoded bool, err error) {
+	c, err := r.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch c >> 6 {
+	case rdbLen6:
+		return uint64(c & 0x3f), false, nil
+	case rdbLen14:
+		c2, err := r.readByte()
+		return uint64(c&0x3f)<<8 | uint64(c2), false, err
+	case rdbLenEncoded:
+		return uint64(c & 0x3f), true, nil
+	}
+	switch c {
+	case rdbLen32:
+		b, err := r.read(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(b)), false, nil
+	case rdbLen64:
+		b, err := r.read(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(b), false, nil
+	}
+	return 0, false, fmt.Errorf("unknown length encoding 0x%02x", c)
+}
+
+// readCount reads the length of the collection, it is limited so the corrupt file does not allocate
+// too much memory.
+func (r *rdbReader) readCount() (int, error) {
+	n, encoded, err := r.readLen()
+	if err != nil {
+		return 0, err
+	}
+	if encoded || n > math.MaxInt32 {
+		return 0, errors.New("invalid length")
+	}
+	return int(n), nil
+}
+
+// readString reads the string, which may be encoded as the integer or compressed by LZF.
+func (r *rdbReader) readString() ([]byte, error) {
+	n, encoded, err := r.readLen()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		if n > math.MaxInt32 {
+			return nil, errors.New("invalid string length")
+		}
+		return r.read(int(n))
+	}
+	switch n {
+	case rdbEncodingInt8, rdbEncodingInt16, rdbEncodingInt32:
+		b, err := r.read(1 << n)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, littleEndianInt(b), 10), nil
+	case rdbEncodingLZF:
+		compressed, err := r.readCount()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.readCount()
+		if err != nil {
+			return nil, err
+		}
+		b, err := r.read(compressed)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(b, size)
+	}
+	return nil, fmt.Errorf("unknown string encoding %d", n)
+}
+
+// readDouble reads the score of the sorted sets before redis 5, which is a string of at most 255 bytes.
+func (r *rdbReader) readDouble() (float64, error) {
+	n, err := r.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case rdbDoubleNaN:
+		return math.NaN(), nil
+	case rdbPosInf:
+		return math.Inf(1), nil
+	case rdbNegInf:
+		return math.Inf(-1), nil
+	}
+	b, err := r.read(int(n))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(b), 64)
+}
+
+func (r *rdbReader) readUint64(order binary.ByteOrder) (uint64, error) {
+	b, err := r.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint64(b), nil
+}
+
+func (r *rdbReader) readID() (streamID, error) {
+	b, err := r.read(rdbStreamIDSize)
+	if err != nil {
+		return streamID{}, err
+	}
+	return streamID{binary.BigEndian.Uint64(b), binary.BigEndian.Uint64(b[8:])}, nil
+}
+
+// readLenID reads the stream ID written as two lengths.
+func (r *rdbReader) readLenID() (streamID, error) {
+	ms, _, err := r.readLen()
+	if err != nil {
+		return streamID{}, err
+	}
+	seq, _, err := r.readLen()
+	return streamID{ms, seq}, err
+}
+
+// lzfDecompress decompresses the data compressed by LZF to the size.
+func lzfDecompress(in []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			// the literal run of ctrl+1 bytes.
+			n := ctrl + 1
+			if i+n > len(in) || len(out)+n > size {
+				return nil, errCorruptEncoding
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+		// the back reference of n bytes.
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errCorruptEncoding
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errCorruptEncoding
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		n += 2
+		if ref < 0 || len(out)+n > size {
+			return nil, errCorruptEncoding
+		}
+		// the reference may overlap the output, so it is copied byte by byte.
+		for j := range n {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != size {
+		return nil, errCorruptEncoding
+	}
+	return out, nil
+}
+
+// loadRDB loads the keys of the first database from the RDB file saved by the store or redis, the
+// expired keys and the other databases are skipped.
+func (s *Store) loadRDB(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := &rdbReader{r: bufio.NewReaderSize(file, 64*1024)}
+	if err := s.readRDB(r); err != nil {
+		return fmt.Errorf("store: fail to load the RDB file %s at offset %d: %w", path, r.offset, err)
+	}
+	return nil
+}
+
+func (s *Store) readRDB(r *rdbReader) error {
+	header, err := r.read(9)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(header, []byte("REDIS")) {
+		return errors.New("wrong signature")
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 || version > rdbMaxSupportedVersion {
+		return fmt.Errorf("unsupported version %q", header[5:])
+	}
+
+	now := s.now()
+	db := uint64(0)
+	expireAt := int64(0)
+	skipped := 0
+	for {
+		c, err := r.readByte()
+		if err != nil {
+			return err
+		}
+		switch c {
+		case rdbOpcodeEOF:
+			if version >= rdbMinChecksumVersion {
+				expected := r.sum
+				sum, err := r.readUint64(binary.LittleEndian)
+				if err != nil {
+					return err
+				}
+				// the checksum is 0 if it is disabled.
+				if sum != 0 && sum != expected {
+					return errors.New("wrong checksum")
+				}
+			}
+			if skipped > 0 {
+				s.config.Logger.Warn("skip the keys of the other databases in the RDB file", "keys", skipped)
+			}
+			return nil
+		case rdbOpcodeSelectDB:
+			if db, _, err = r.readLen(); err != nil {
+				return err
+			}
+			continue
+		case rdbOpcodeResizeDB:
+			if _, _, err = r.readLen(); err == nil {
+				_, _, err = r.readLen()
+			}
+		case rdbOpcodeAux:
+			if _, err = r.readString(); err == nil {
+				_, err = r.readString()
+			}
+		case rdbOpcodeExpireTimeMs:
+			var ms uint64
+			ms, err = r.readUint64(binary.LittleEndian)
+			expireAt = int64(ms)
+		case rdbOpcodeExpireTime:
+			var b []byte
+			if b, err = r.read(4); err == nil {
+				expireAt = int64(binary.LittleEndian.Uint32(b)) * 1000
+			}
+		case rdbOpcodeIdle:
+			_, _, err = r.readLen()
+		case rdbOpcodeFreq:
+			_, err = r.readByte()
+		case rdbOpcodeSlotInfo:
+			for i := 0; i < 3 && err == nil; i++ {
+				_, _, err = r.readLen()
+			}
+		case rdbOpcodeFunction2:
+			// the functions are not supported, they are skipped.
+			_, err = r.readString()
+		default:
+			var key []byte
+			if key, err = r.readString(); err != nil {
+				return err
+			}
+			var value any
+			if value, err = readRDBValue(r, c); err != nil {
+				return fmt.Errorf("key %q: %w", key, err)
+			}
+			switch {
+			case db != 0:
+				skipped++
+			// the replica keeps the expired keys until the deletions are replicated.
+			case value != nil && (expireAt == 0 || expireAt > now || s.master != nil):
+				e := &entry{value: value, expireAt: expireAt, epoch: s.epoch}
+				s.keys.put(string(key), e)
+				if expireAt > 0 {
+					s.expires[string(key)] = e
+				}
+			}
+			expireAt = 0
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readRDBValue reads the value of the type, nil will be returned for the empty collections.
+func readRDBValue(r *rdbReader, typ byte) (any, error) {
+	switch typ {
+	case rdbTypeString:
+		return r.readString()
+	case rdbTypeList, rdbTypeSet:
+		n, err := r.readCount()
+		if err != nil {
+			return nil, err
+		}
+		elems := make([][]byte, 0, min(n, 1024))
+		for range n {
+			elem, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, elem)
+		}
+		if typ == rdbTypeList {
+			return newListValue(elems), nil
+		}
+		return newSetValue(elems), nil
+	case rdbTypeHash:
+		n, err := r.readCount()
+		if err != nil {
+			return nil, err
+		}
+		pairs := make([][]byte, 0, min(n*2, 1024))
+		for range n * 2 {
+			b, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, b)
+		}
+		return newHashValue(pairs)
+	case rdbTypeZset, rdbTypeZset2:
+		n, err := r.readCount()
+		if err != nil {
+			return nil, err
+		}
+		z := newZset()
+		for range n {
+			member, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			var score float64
+			if typ == rdbTypeZset2 {
+				bits, err := r.readUint64(binary.LittleEndian)
+				if err != nil {
+					return nil, err
+				}
+				score = math.Float64frombits(bits)
+			} else if score, err = r.readDouble(); err != nil {
+				return nil, err
+			}
+			if math.IsNaN(score) {
+				return nil, errors.New("NaN score")
+			}
+			z.add(string(member), score)
+		}
+		return orNilValue(z, z.len()), nil
+	case rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		n, err := r.readCount()
+		if err != nil {
+			return nil, err
+		}
+		var elems [][]byte
+		for range n {
+			container := uint64(rdbQuicklistNodePacked)
+			if typ == rdbTypeListQuicklist2 {
+				if container, _, err = r.readLen(); err != nil {
+					return nil, err
+				}
+			}
+			b, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			var node [][]byte
+			switch {
+			case container == rdbQuicklistNodePlain:
+				node = [][]byte{b}
+			case typ == rdbTypeListQuicklist:
+				node, err = readZiplist(b)
+			default:
+				node, err = readListpack(b)
+			}
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, node...)
+		}
+		return newListValue(elems), nil
+	case rdbTypeListZiplist, rdbTypeSetIntset, rdbTypeZsetZiplist, rdbTypeHashZiplist,
+		rdbTypeHashListpack, rdbTypeZsetListpack, rdbTypeSetListpack:
+		b, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		var elems [][]byte
+		switch typ {
+		case rdbTypeSetIntset:
+			elems, err = readIntset(b)
+		case rdbTypeListZiplist, rdbTypeZsetZiplist, rdbTypeHashZiplist:
+			elems, err = readZiplist(b)
+		default:
+			elems, err = readListpack(b)
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case rdbTypeListZiplist:
+			return newListValue(elems), nil
+		case rdbTypeSetIntset, rdbTypeSetListpack:
+			return newSetValue(elems), nil
+		case rdbTypeHashZiplist, rdbTypeHashListpack:
+			return newHashValue(elems)
+		}
+		if len(elems)%2 != 0 {
+			return nil, errCorruptEncoding
+		}
+		z := newZset()
+		for i := 0; i < len(elems); i += 2 {
+			score, ok := parseFloat(elems[i+1])
+			if !ok {
+				return nil, errCorruptEncoding
+			}
+			z.add(string(elems[i]), score)
+		}
+		return orNilValue(z, z.len()), nil
+	case rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3:
+		return readRDBStream(r, typ)
+	}
+	return nil, fmt.Errorf("unsupported type %d", typ)
+}
+
+func newListValue(elems [][]byte) any {
+	l := new(list)
+	for _, elem := range elems {
+		l.pushBack(elem)
+	}
+	return orNilValue(l, l.len())
+}
+
+func newSetValue(members [][]byte) any {
+	s := new(set)
+	for _, member := range members {
+		s.put(string(member), struct{}{})
+	}
+	return orNilValue(s, s.len())
+}
+
+func newHashValue(pairs [][]byte) (any, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errCorruptEncoding
+	}
+	h := new(hash)
+	for i := 0; i < len(pairs); i += 2 {
+		h.put(string(pairs[i]), pairs[i+1])
+	}
+	return orNilValue(h, h.len()), nil
+}
+
+// orNilValue returns nil for the empty collection, which is not loaded as redis does.
+func orNilValue(value any, n int) any {
+	if n == 0 {
+		return nil
+	}
+	return value
+}
+
+// readRDBStream reads the stream encoded as the listpacks, the metadata after the entries and the
+// consumer active time are added in the later versions.
+func readRDBStream(r *rdbReader, typ byte) (any, error) {
+	s := newStream()
+	nodes, err := r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	for range nodes {
+		key, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != rdbStreamIDSize {
+			return nil, errors.New("invalid stream node key")
+		}
+		master := streamID{binary.BigEndian.Uint64(key), binary.BigEndian.Uint64(key[8:])}
+		b, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		elems, err := readListpack(b)
+		if err != nil {
+			return nil, err
+		}
+		if err := readStreamNode(s, master, elems); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := r.readCount(); err != nil {
+		return nil, err
+	}
+	if s.lastID, err = r.readLenID(); err != nil {
+		return nil, err
+	}
+	if typ >= rdbTypeStreamListpacks2 {
+		// the first ID is computed from the entries.
+		if _, err := r.readLenID(); err != nil {
+			return nil, err
+		}
+		if s.maxDeletedID, err = r.readLenID(); err != nil {
+			return nil, err
+		}
+		added, _, err := r.readLen()
+		if err != nil {
+			return nil, err
+		}
+		s.entriesAdded = int64(added)
+	} else {
+		s.entriesAdded = int64(s.len())
+	}
+
+	groups, err := r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	for range groups {
+		name, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		lastID, err := r.readLenID()
+		if err != nil {
+			return nil, err
+		}
+		entriesRead := s.entriesReadAt(lastID)
+		if typ >= rdbTypeStreamListpacks2 {
+			n, _, err := r.readLen()
+			if err != nil {
+				return nil, err
+			}
+			entriesRead = int64(n)
+		}
+		g := newConsumerGroup(string(name), lastID, entriesRead)
+		s.groups[g.name] = g
+
+		n, err := r.readCount()
+		if err != nil {
+			return nil, err
+		}
+		for range n {
+			id, err := r.readID()
+			if err != nil {
+				return nil, err
+			}
+			deliveryTime, err := r.readUint64(binary.LittleEndian)
+			if err != nil {
+				return nil, err
+			}
+			deliveryCount, _, err := r.readLen()
+			if err != nil {
+				return nil, err
+			}
+			g.pending[id] = &pendingEntry{id: id, deliveryTime: int64(deliveryTime), deliveryCount: int64(deliveryCount)}
+		}
+
+		if n, err = r.readCount(); err != nil {
+			return nil, err
+		}
+		for range n {
+			name, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			seenTime, err := r.readUint64(binary.LittleEndian)
+			if err != nil {
+				return nil, err
+			}
+			activeTime := seenTime
+			if typ >= rdbTypeStreamListpacks3 {
+				if activeTime, err = r.readUint64(binary.LittleEndian); err != nil {
+					return nil, err
+				}
+			}
+			cons := g.consumer(string(name), int64(seenTime))
+			cons.activeTime = int64(activeTime)
+			pending, err := r.readCount()
+			if err != nil {
+				return nil, err
+			}
+			for range pending {
+				id, err := r.readID()
+				if err != nil {
+					return nil, err
+				}
+				pe := g.pending[id]
+				if pe == nil || pe.consumer != nil {
+					return nil, errors.New("invalid pending entry of the consumer")
+				}
+				pe.move(cons)
+			}
+		}
+		for _, pe := range g.pending {
+			if pe.consumer == nil {
+				return nil, errors.New("pending entry without the consumer")
+			}
+		}
+	}
+	return s, nil
+}
+
+// readStreamNode adds the entries of the listpack of the stream node, the deleted entries are skipped.
+func readStreamNode(s *stream, master streamID, elems [][]byte) error {
+	next := func() (int64, error) {
+		if len(elems) == 0 {
+			return 0, errCorruptEncoding
+		}
+		n, err := strconv.ParseInt(string(elems[0]), 10, 64)
+		elems = elems[1:]
+		return n, err
+	}
+	count, err := next()
+	if err != nil {
+		return err
+	}
+	deleted, err := next()
+	if err != nil {
+		return err
+	}
+	numFields, err := next()
+	if err != nil || numFields < 0 || int64(len(elems)) < numFields+1 {
+		return errCorruptEncoding
+	}
+	fields := elems[:numFields]
+	// the master entry is terminated by 0.
+	elems = elems[numFields+1:]
+	for range count + deleted {
+		flags, err := next()
+		if err != nil {
+			return err
+		}
+		msDiff, err := next()
+		if err != nil {
+			return err
+		}
+		seqDiff, err := next()
+		if err != nil {
+			return err
+		}
+		id := streamID{master.ms + uint64(msDiff), master.seq + uint64(seqDiff)}
+		var values [][]byte
+		if flags&rdbStreamItemSameFields != 0 {
+			if int64(len(elems)) < numFields {
+				return errCorruptEncoding
+			}
+			values = make([][]byte, 0, numFields*2)
+			for i, field := range fields {
+				values = append(values, field, elems[i])
+			}
+			elems = elems[numFields:]
+		} else {
+			n, err := next()
+			if err != nil || n < 0 || int64(len(elems)) < n*2 {
+				return errCorruptEncoding
+			}
+			values = elems[:n*2]
+			elems = elems[n*2:]
+		}
+		// the lp-count for the backward traversal.
+		if _, err := next(); err != nil {
+			return err
+		}
+		if flags&rdbStreamItemDeleted != 0 {
+			continue
+		}
+		if id.compare(s.lastID) <= 0 && s.len() > 0 {
+			return errors.New("stream IDs out of order")
+		}
+		s.add(id, values)
+	}
+	return nil
+}
@@ -0,0 +1,379 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caeret/beam"
+	"github.com/caeret/beam/client"
+)
+
+// replReconnectInterval is the interval of reconnecting to the primary after the link is broken.
+const replReconnectInterval = time.Second
+
+var errLinkCanceled = errors.New("the link to the primary is canceled")
+
+// linkState is the state of the link to the primary, shown by ROLE.
+type linkState int
+
+const (
+	linkConnect linkState = iota
+	linkConnecting
+	linkSync
+	linkConnected
+)
+
+var linkStateNames = [...]string{
+	linkConnect:    "connect",
+	linkConnecting: "connecting",
+	linkSync:       "sync",
+	linkConnected:  "connected",
+}
+
+// masterLink is the link of the replica to the primary, the fields are guarded by the lock of the
+// store.
+type masterLink struct {
+	addr   string
+	ctx    context.Context
+	cancel context.CancelFunc
+	state  linkState
+	// lastIO is the time when the stream is received from the primary.
+	lastIO time.Time
+}
+
+// replicaOf makes the store a replica of the primary at the address, the replicas of the store are
+// dropped so they resynchronize with the new primary through it.
+func (s *Store) replicaOf(addr string) {
+	if s.master != nil {
+		s.master.cancel()
+	}
+	s.dropReplicas()
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &masterLink{addr: addr, ctx: ctx, cancel: cancel}
+	s.master = l
+	s.wg.Add(1)
+	go s.runMasterLink(l)
+}
+
+// promote stops the replication and makes the store a primary, the replicas of the store can
+// continue with the previous replication ID after they reconnect and learn the new one.
+func (s *Store) promote() {
+	s.master.cancel()
+	s.master = nil
+	s.replID2, s.secondReplOffset = s.replID, s.replOffset+1
+	s.replID = newReplID()
+	s.dropReplicas()
+}
+
+// replicaofCommand implements REPLICAOF host port and REPLICAOF NO ONE.
+func replicaofCommand(c *call) beam.Reply {
+	host, port := string(c.args[0]), string(c.args[1])
+	if strings.EqualFold(host, "no") && strings.EqualFold(port, "one") {
+		if c.master != nil {
+			c.config.Logger.Info("stop the replication and become the primary", beam.LogKeyAddr, c.master.addr)
+			c.promote()
+		}
+		return okReply
+	}
+	if n, ok := parseInt(c.args[1]); !ok || n < 0 || n > 65535 {
+		return notIntegerReply
+	}
+	addr := net.JoinHostPort(host, port)
+	if c.master != nil && c.master.addr == addr {
+		return beam.NewSimpleStringsReply("OK Already connected to specified master")
+	}
+	c.config.Logger.Info("replicate the primary", beam.LogKeyAddr, addr)
+	c.replicaOf(addr)
+	return okReply
+}
+
+// runMasterLink synchronizes with the primary and applies the stream, it reconnects after the
+// connection is broken until the link is canceled.
+func (s *Store) runMasterLink(l *masterLink) {
+	defer s.wg.Done()
+	for {
+		err := s.syncWithMaster(l)
+		if l.ctx.Err() != nil {
+			return
+		}
+		s.config.Logger.Warn("lose the connection to the primary", beam.LogKeyAddr, l.addr, beam.LogKeyError, err)
+		s.setLinkState(l, linkConnect)
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-time.After(replReconnectInterval):
+		}
+	}
+}
+
+func (s *Store) setLinkState(l *masterLink, state linkState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	l.state = state
+}
+
+// masterConn is the connection to the primary.
+type masterConn struct {
+	conn    net.Conn
+	br      *bufio.Reader
+	timeout time.Duration
+}
+
+// do sends the command and reads the reply during the handshake.
+func (mc *masterConn) do(command string, args ...string) (beam.Reply, error) {
+	if err := mc.send(beam.NewQuery(command, args...)); err != nil {
+		return nil, err
+	}
+	_ = mc.conn.SetReadDeadline(time.Now().Add(mc.timeout))
+	return client.ReadReply(mc.br)
+}
+
+func (mc *masterConn) send(q beam.Query) error {
+	_ = mc.conn.SetWriteDeadline(time.Now().Add(mc.timeout))
+	_, err := mc.conn.Write(q.AppendRaw(nil))
+	return err
+}
+
+// readPayload reads the RDB sent as the bulk string without the trailing CRLF, the newlines sent by
+// redis to keep the connection alive before it are skipped.
+func (mc *masterConn) readPayload() ([]byte, error) {
+	var line string
+	for line == "" || line == "\n" {
+		_ = mc.conn.SetReadDeadline(time.Now().Add(mc.timeout))
+		var err error
+		if line, err = mc.br.ReadString('\n'); err != nil {
+			return nil, err
+		}
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, "$"), "\r\n"))
+	if !strings.HasPrefix(line, "$") || err != nil || n < 0 {
+		return nil, fmt.Errorf("unexpected payload %q", line)
+	}
+	payload := make([]byte, n)
+	for off := 0; off < n; {
+		_ = mc.conn.SetReadDeadline(time.Now().Add(mc.timeout))
+		m, err := mc.br.Read(payload[off:])
+		off += m
+		if err != nil && off < n {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+// syncWithMaster connects to the primary, synchronizes with it and applies the stream until the
+// connection is broken.
+func (s *Store) syncWithMaster(l *masterLink) error {
+	s.setLinkState(l, linkConnecting)
+	ctx, cancel := context.WithTimeout(l.ctx, s.config.ReplTimeout)
+	conn, err := s.config.Dialer(ctx, "tcp", l.addr)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(l.ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	mc := &masterConn{conn: conn, br: bufio.NewReaderSize(conn, 64*1024), timeout: s.config.ReplTimeout}
+	if err := s.handshake(l, mc); err != nil {
+		return err
+	}
+	return s.applyStream(l, mc)
+}
+
+// handshake sends PSYNC with the replication ID and the offset of the store, and synchronizes with
+// the snapshot of the primary if the stream can not be continued.
+func (s *Store) handshake(l *masterLink, mc *masterConn) error {
+	reply, err := mc.do("PING")
+	if err == nil {
+		err = reply.Err()
+	}
+	if err != nil {
+		return err
+	}
+	if port := s.config.ReplicaAnnouncePort; port > 0 {
+		if reply, err = mc.do("REPLCONF", "listening-port", strconv.Itoa(port)); err == nil {
+			err = reply.Err()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// the capabilities are optional, the error reply is ignored as redis does.
+	if _, err = mc.do("REPLCONF", "capa", "psync2"); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	replID, offset := s.replID, s.replOffset+1
+	s.mutex.Unlock()
+	if reply, err = mc.do("PSYNC", replID, strconv.FormatInt(offset, 10)); err != nil {
+		return err
+	}
+	text, err := reply.Text()
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(text)
+	switch {
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("unexpected reply of PSYNC %q", text)
+		}
+		return s.fullResync(l, mc, fields[1], offset)
+	case len(fields) >= 1 && len(fields) <= 2 && fields[0] == "CONTINUE":
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if s.master != l {
+			return errLinkCanceled
+		}
+		// the replicas of the store learn the new replication ID by the resynchronization.
+		if len(fields) == 2 && fields[1] != s.replID {
+			s.replID2, s.secondReplOffset = s.replID, s.replOffset+1
+			s.replID = fields[1]
+			s.dropReplicas()
+		}
+		if s.backlog == nil {
+			s.backlog = newBacklog(s.config.ReplBacklogSize, s.replOffset+1)
+		}
+		l.state, l.lastIO = linkConnected, time.Now()
+		s.config.Logger.Info("continue the replication", beam.LogKeyAddr, l.addr, beam.LogKeyOffset, s.replOffset)
+		return nil
+	}
+	return fmt.Errorf("unexpected reply of PSYNC %q", text)
+}
+
+// fullResync replaces the keys with the RDB sent by the primary.
+func (s *Store) fullResync(l *masterLink, mc *masterConn, replID string, offset int64) error {
+	s.setLinkState(l, linkSync)
+	payload, err := mc.readPayload()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.master != l {
+		return errLinkCanceled
+	}
+	s.keys = dict[*entry]{}
+	clear(s.expires)
+	if err := s.readRDB(&rdbReader{r: bufio.NewReader(bytes.NewReader(payload))}); err != nil {
+		// the partially loaded keys are never continued.
+		s.keys = dict[*entry]{}
+		clear(s.expires)
+		s.replID, s.replOffset = newReplID(), 0
+		return fmt.Errorf("fail to load the RDB of the primary: %w", err)
+	}
+	s.replID, s.replID2, s.replOffset, s.secondReplOffset = replID, noReplID, offset, -1
+	s.backlog = newBacklog(s.config.ReplBacklogSize, offset+1)
+	s.dropReplicas()
+	if s.aof != nil {
+		s.rewriteAfterSync()
+	}
+	l.state, l.lastIO = linkConnected, time.Now()
+	s.config.Logger.Info("synchronize with the primary", beam.LogKeyAddr, l.addr, beam.LogKeyBytes, len(payload))
+	return nil
+}
+
+// applyStream applies the commands from the primary and acknowledges the offset every second, the
+// stream is forwarded to the replicas of the store as it is, so the offsets are the same.
+func (s *Store) applyStream(l *masterLink, mc *masterConn) error {
+	buf := make([]byte, 0, 64*1024)
+	var lastAck time.Time
+	for {
+		if time.Since(lastAck) >= time.Second {
+			if err := s.sendAck(mc); err != nil {
+				return err
+			}
+			lastAck = time.Now()
+		}
+		if len(buf) == cap(buf) {
+			buf = slices.Grow(buf, cap(buf))
+		}
+		_ = mc.conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := mc.br.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err != nil {
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				return err
+			}
+			s.mutex.Lock()
+			idle := time.Since(l.lastIO)
+			s.mutex.Unlock()
+			if idle > s.config.ReplTimeout {
+				return errors.New("timeout")
+			}
+			continue
+		}
+
+		queries, rest, err := beam.ReadQuery(buf)
+		getack, aerr := s.applyReplicated(l, queries, buf[:len(buf)-len(rest)])
+		if aerr != nil {
+			return aerr
+		}
+		if err != nil {
+			return err
+		}
+		buf = buf[:copy(buf, rest)]
+		if getack {
+			if err := s.sendAck(mc); err != nil {
+				return err
+			}
+			lastAck = time.Now()
+		}
+	}
+}
+
+// sendAck reports the offset of the applied stream to the primary.
+func (s *Store) sendAck(mc *masterConn) error {
+	s.mutex.Lock()
+	offset := s.replOffset
+	s.mutex.Unlock()
+	return mc.send(beam.NewQuery("REPLCONF", "ACK", strconv.FormatInt(offset, 10)))
+}
+
+// applyReplicated executes the commands from the primary, true will be returned if the primary asks
+// for the offset with REPLCONF GETACK.
+func (s *Store) applyReplicated(l *masterLink, queries []beam.Query, raw []byte) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.master != l {
+		return false, errLinkCanceled
+	}
+	l.lastIO = time.Now()
+	getack := false
+	for _, q := range queries {
+		if strings.EqualFold(q.CommandStr(), "REPLCONF") && q.Len() > 0 && strings.EqualFold(string(q.Arguments[0]), "GETACK") {
+			getack = true
+			continue
+		}
+		cmd, reply := lookupCommand(q)
+		if reply == nil {
+			// the commands from the primary never block.
+			reply, _ = s.exec(cmd, beam.NewRequest(nil, q), true)
+		}
+		if reply.IsError() {
+			s.config.Logger.Warn("fail to apply the replicated command", beam.LogKeyCommand, q.CommandStr(), beam.LogKeyError, reply.Err())
+		}
+	}
+	if len(raw) > 0 {
+		s.feedReplicas(raw)
+	}
+	return getack, nil
+}
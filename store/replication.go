@@ -0,0 +1,423 @@
+package store
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caeret/beam"
+)
+
+// The replication works as redis does: the replica sends PSYNC with the replication ID and the offset
+// of the stream it has, the primary continues the stream from the backlog if it is held there, or
+// sends the RDB of a snapshot followed by the commands propagated after the snapshot.
+
+// replicaPortAttr is the attribute of the client keeping the port announced by REPLCONF.
+const replicaPortAttr = "store.replica-port"
+
+var (
+	readOnlyReply = beam.NewErrorsReply("READONLY You can't write against a read only replica.")
+	// noReply is returned by the commands replying with beam.Client.Send, or never replying such as
+	// REPLCONF ACK.
+	noReply beam.Reply
+	// noReplID is the replication ID 0000... shown when there is no previous ID.
+	noReplID = strings.Repeat("0", 40)
+)
+
+// newReplID generates the random replication ID of 40 hex characters.
+func newReplID() string {
+	var b [20]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// backlog is the ring buffer holding the latest replication stream.
+type backlog struct {
+	buf []byte
+	// idx is the position of the next byte written in buf.
+	idx int
+	// histlen is the number of the bytes held, offset is the replication offset of the first one.
+	histlen int
+	offset  int64
+}
+
+// newBacklog creates the empty backlog, offset is the offset of the next byte of the stream.
+func newBacklog(size int, offset int64) *backlog {
+	return &backlog{buf: make([]byte, size), offset: offset}
+}
+
+func (b *backlog) write(p []byte) {
+	b.histlen += len(p)
+	for len(p) > 0 {
+		n := copy(b.buf[b.idx:], p)
+		b.idx = (b.idx + n) % len(b.buf)
+		p = p[n:]
+	}
+	if b.histlen > len(b.buf) {
+		b.offset += int64(b.histlen - len(b.buf))
+		b.histlen = len(b.buf)
+	}
+}
+
+// holds checks if the stream from the offset is held.
+func (b *backlog) holds(offset int64) bool {
+	return offset >= b.offset && offset <= b.offset+int64(b.histlen)
+}
+
+// since copies the stream from the offset, which must be held.
+func (b *backlog) since(offset int64) []byte {
+	n := b.histlen - int(offset-b.offset)
+	start := (b.idx - n + len(b.buf)) % len(b.buf)
+	if start+n <= len(b.buf) {
+		return slices.Clone(b.buf[start : start+n])
+	}
+	return append(slices.Clone(b.buf[start:]), b.buf[:n-(len(b.buf)-start)]...)
+}
+
+// replica is a replica connected to the store.
+type replica struct {
+	client *beam.Client
+	// port is the listening port announced by the replica.
+	port int
+	// online is set after the snapshot is sent, the stream is kept in pending before it.
+	online  bool
+	pending []byte
+	// ackOffset is the offset reported by the last REPLCONF ACK at ackTime.
+	ackOffset int64
+	ackTime   time.Time
+}
+
+// addReplica starts sending the replication stream to the client, the replica is removed when the
+// client is disconnected.
+func (s *Store) addReplica(client *beam.Client) *replica {
+	r := &replica{client: client, ackTime: time.Now()}
+	r.port, _ = beam.GetAttrAs[int](client, replicaPortAttr)
+	client.SetClass(beam.ClientClassReplica)
+	s.replicas = append(s.replicas, r)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		select {
+		case <-client.Done():
+		case <-s.closeCh:
+		}
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.removeReplica(r)
+	}()
+	return r
+}
+
+func (s *Store) removeReplica(r *replica) {
+	if i := slices.Index(s.replicas, r); i >= 0 {
+		s.replicas = slices.Delete(s.replicas, i, i+1)
+	}
+}
+
+// findReplica finds the replica of the client, nil will be returned if it is not a replica.
+func (s *Store) findReplica(client *beam.Client) *replica {
+	for _, r := range s.replicas {
+		if r.client == client {
+			return r
+		}
+	}
+	return nil
+}
+
+// dropReplicas disconnects the replicas, so they resynchronize with the new history of the keys.
+func (s *Store) dropReplicas() {
+	for _, r := range s.replicas {
+		r.client.Close()
+	}
+	s.replicas = nil
+}
+
+// createBacklog creates the backlog for the first replica, the replication ID is changed since the
+// stream before it is not kept.
+func (s *Store) createBacklog() {
+	s.replID, s.replID2, s.secondReplOffset = newReplID(), noReplID, -1
+	s.backlog = newBacklog(s.config.ReplBacklogSize, s.replOffset+1)
+}
+
+// replicate sends the propagated commands to the replicas, the replica forwards the stream of its
+// primary as it is instead.
+func (s *Store) replicate(queries []beam.Query) {
+	if s.backlog == nil || s.master != nil {
+		return
+	}
+	var b []byte
+	for _, q := range queries {
+		b = q.AppendRaw(b)
+	}
+	s.feedReplicas(b)
+}
+
+// feedReplicas appends the stream to the backlog and sends it to the replicas.
+func (s *Store) feedReplicas(p []byte) {
+	s.backlog.write(p)
+	s.replOffset += int64(len(p))
+	for _, r := range s.replicas {
+		if !r.online {
+			r.pending = append(r.pending, p...)
+			continue
+		}
+		// the disconnected replica is removed by addReplica.
+		_ = r.client.Send(p)
+	}
+}
+
+// fullSync sends the RDB of the snapshot to the replica in the background, followed by the stream
+// propagated after the snapshot.
+func (s *Store) fullSync(r *replica) {
+	keys := s.snapshot()
+	now := s.now()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		var rdb bytes.Buffer
+		// writing to the memory never fails.
+		_ = writeRDB(&rdb, keys, now)
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.releaseSnapshot()
+		if !slices.Contains(s.replicas, r) {
+			return
+		}
+		// the RDB is sent as the bulk string without the trailing CRLF.
+		b := append([]byte("$"+strconv.Itoa(rdb.Len())+"\r\n"), rdb.Bytes()...)
+		_ = r.client.Send(b, r.pending)
+		r.pending = nil
+		r.online = true
+		s.config.Logger.Info("synchronize the replica", beam.LogKeyRemoteAddr, r.client.RemoteAddr().String(), beam.LogKeyBytes, rdb.Len())
+	}()
+}
+
+// syncAllowed checks if the store can be synchronized with, the replica can not before it is
+// synchronized with its primary.
+func (c *call) syncAllowed() beam.Reply {
+	if c.client == nil {
+		return beam.NewErrorsReply("ERR " + strings.ToUpper(c.name) + " requires the connection of the replica")
+	}
+	if c.master != nil && c.master.state != linkConnected {
+		return beam.NewErrorsReply("NOMASTERLINK Can't SYNC while not connected with my master")
+	}
+	return nil
+}
+
+// psyncCommand implements PSYNC replicationid offset, the stream continues from the offset if it is
+// held by the backlog, or the full resynchronization is started.
+func psyncCommand(c *call) beam.Reply {
+	if reply := c.syncAllowed(); reply != nil {
+		return reply
+	}
+	offset, ok := parseInt(c.args[1])
+	if !ok {
+		return notIntegerReply
+	}
+	if c.backlog == nil {
+		c.createBacklog()
+	}
+	replID := string(c.args[0])
+	r := c.addReplica(c.client)
+	// the replicas of the former primary continue with the stream before it is changed.
+	if (replID == c.replID || (replID == c.replID2 && offset <= c.secondReplOffset)) && c.backlog.holds(offset) {
+		b := append([]byte("+CONTINUE "+c.replID+"\r\n"), c.backlog.since(offset)...)
+		_ = c.client.Send(b)
+		r.online = true
+		return noReply
+	}
+	_ = c.client.Send(beam.NewSimpleStringsReply(fmt.Sprintf("FULLRESYNC %s %d", c.replID, c.replOffset)))
+	c.fullSync(r)
+	return noReply
+}
+
+// syncCommand implements SYNC, the full resynchronization without the replication ID.
+func syncCommand(c *call) beam.Reply {
+	if reply := c.syncAllowed(); reply != nil {
+		return reply
+	}
+	if c.backlog == nil {
+		c.createBacklog()
+	}
+	c.fullSync(c.addReplica(c.client))
+	return noReply
+}
+
+// replconfCommand implements REPLCONF option value [option value ...], the replica announces the
+// listening port and the capabilities before PSYNC, and reports the offset with ACK after it.
+func replconfCommand(c *call) beam.Reply {
+	if len(c.args)%2 != 0 {
+		return syntaxErrReply
+	}
+	for i := 0; i < len(c.args); i += 2 {
+		option, value := strings.ToLower(string(c.args[i])), c.args[i+1]
+		switch option {
+		case "listening-port":
+			port, ok := parseInt(value)
+			if !ok || port < 0 || port > 65535 {
+				return notIntegerReply
+			}
+			if c.client != nil {
+				c.client.SetAttr(replicaPortAttr, int(port))
+			}
+		case "capa", "ip-address":
+		case "ack":
+			// the FACK option of redis following it is ignored.
+			r := c.findReplica(c.client)
+			if offset, ok := parseInt(value); ok && r != nil {
+				r.ackOffset = max(r.ackOffset, offset)
+				r.ackTime = time.Now()
+			}
+			return noReply
+		case "getack":
+			// it is answered by the replica in the replication stream.
+			return noReply
+		default:
+			return beam.NewErrorsReply("ERR Unrecognized REPLCONF option: " + string(c.args[i]))
+		}
+	}
+	return okReply
+}
+
+// replicationCron pings the replicas so they can detect the broken primary, and disconnects the
+// replicas which have not acknowledged the stream within ReplTimeout.
+func (s *Store) replicationCron() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.config.ReplPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closeCh:
+			return
+		case <-ticker.C:
+		}
+		s.mutex.Lock()
+		if len(s.replicas) > 0 {
+			s.replicate([]beam.Query{beam.NewQuery("PING")})
+		}
+		for _, r := range s.replicas {
+			if r.online && time.Since(r.ackTime) > s.config.ReplTimeout {
+				s.config.Logger.Warn("disconnect the replica for timeout", beam.LogKeyRemoteAddr, r.client.RemoteAddr().String())
+				r.client.Close()
+			}
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// replicaIP retrieves the host of the remote address of the replica.
+func replicaIP(r *replica) string {
+	addr := r.client.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// roleCommand implements ROLE.
+func roleCommand(c *call) beam.Reply {
+	if l := c.master; l != nil {
+		host, port, _ := net.SplitHostPort(l.addr)
+		n, _ := strconv.Atoi(port)
+		offset := int64(-1)
+		if l.state == linkConnected {
+			offset = c.replOffset
+		}
+		b := beam.AppendArraysHeader(nil, 5)
+		b = beam.AppendBulkStrings(b, "slave")
+		b = beam.AppendBulkStrings(b, host)
+		b = beam.AppendIntegers(b, int64(n))
+		b = beam.AppendBulkStrings(b, linkStateNames[l.state])
+		return beam.AppendIntegers(b, offset)
+	}
+	var online []*replica
+	for _, r := range c.replicas {
+		if r.online {
+			online = append(online, r)
+		}
+	}
+	b := beam.AppendArraysHeader(nil, 3)
+	b = beam.AppendBulkStrings(b, "master")
+	b = beam.AppendIntegers(b, c.replOffset)
+	b = beam.AppendArraysHeader(b, len(online))
+	for _, r := range online {
+		b = beam.AppendArraysHeader(b, 3)
+		b = beam.AppendBulkStrings(b, replicaIP(r))
+		b = beam.AppendBulkStrings(b, strconv.Itoa(r.port))
+		b = beam.AppendBulkStrings(b, strconv.FormatInt(r.ackOffset, 10))
+	}
+	return b
+}
+
+// infoCommand implements INFO [section ...], only the replication section is provided.
+func infoCommand(c *call) beam.Reply {
+	all := len(c.args) == 0
+	for _, arg := range c.args {
+		switch strings.ToLower(string(arg)) {
+		case "replication", "all", "default", "everything":
+			all = true
+		}
+	}
+	if !all {
+		return beam.NewBulkStringsReply("")
+	}
+	return beam.NewBulkStringsReply(c.replicationInfo())
+}
+
+// replicationInfo formats the replication section of INFO with the same fields as redis.
+func (s *Store) replicationInfo() string {
+	var b strings.Builder
+	b.WriteString("# Replication\r\n")
+	if l := s.master; l != nil {
+		host, port, _ := net.SplitHostPort(l.addr)
+		status, lastIO, syncing := "down", int64(-1), 0
+		if l.state == linkConnected {
+			status, lastIO = "up", int64(time.Since(l.lastIO)/time.Second)
+		}
+		if l.state == linkSync {
+			syncing = 1
+		}
+		fmt.Fprintf(&b, "role:slave\r\nmaster_host:%s\r\nmaster_port:%s\r\nmaster_link_status:%s\r\n", host, port, status)
+		fmt.Fprintf(&b, "master_last_io_seconds_ago:%d\r\nmaster_sync_in_progress:%d\r\n", lastIO, syncing)
+		fmt.Fprintf(&b, "slave_repl_offset:%d\r\nslave_read_only:1\r\n", s.replOffset)
+	} else {
+		b.WriteString("role:master\r\n")
+	}
+	fmt.Fprintf(&b, "connected_slaves:%d\r\n", len(s.replicas))
+	for i, r := range s.replicas {
+		state := "wait_bgsave"
+		if r.online {
+			state = "online"
+		}
+		fmt.Fprintf(&b, "slave%d:ip=%s,port=%d,state=%s,offset=%d,lag=%d\r\n",
+			i, replicaIP(r), r.port, state, r.ackOffset, int64(time.Since(r.ackTime)/time.Second))
+	}
+	fmt.Fprintf(&b, "master_replid:%s\r\nmaster_replid2:%s\r\n", s.replID, s.replID2)
+	fmt.Fprintf(&b, "master_repl_offset:%d\r\nsecond_repl_offset:%d\r\n", s.replOffset, s.secondReplOffset)
+	if s.backlog == nil {
+		fmt.Fprintf(&b, "repl_backlog_active:0\r\nrepl_backlog_size:%d\r\n", s.config.ReplBacklogSize)
+		b.WriteString("repl_backlog_first_byte_offset:0\r\nrepl_backlog_histlen:0\r\n")
+	} else {
+		fmt.Fprintf(&b, "repl_backlog_active:1\r\nrepl_backlog_size:%d\r\n", len(s.backlog.buf))
+		fmt.Fprintf(&b, "repl_backlog_first_byte_offset:%d\r\nrepl_backlog_histlen:%d\r\n", s.backlog.offset, s.backlog.histlen)
+	}
+	return b.String()
+}
+
+// pingCommand implements PING [message], the primary also pings the replicas with it.
+func pingCommand(c *call) beam.Reply {
+	switch len(c.args) {
+	case 0:
+		return beam.NewSimpleStringsReply("PONG")
+	case 1:
+		return beam.NewBulkStringsReplyRaw(clone(c.args[0]))
+	}
+	return wrongArgs(c.name)
+}
@@ -0,0 +1,315 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caeret/beam"
+	"github.com/caeret/beam/beamtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// testNetwork connects the stores served on the in-memory listeners by the addresses.
+type testNetwork struct {
+	mutex     sync.Mutex
+	listeners map[string]*beamtest.PipeListener
+}
+
+func (n *testNetwork) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	n.mutex.Lock()
+	l := n.listeners[addr]
+	n.mutex.Unlock()
+	if l == nil {
+		return nil, errors.New("connection refused")
+	}
+	return l.Dial()
+}
+
+// testNode is a store served at the address of the testNetwork.
+type testNode struct {
+	*Store
+	clk  *clock
+	conn *beamtest.Conn
+	logs *logBuffer
+}
+
+func (n *testNetwork) serve(t *testing.T, addr string, config Config) *testNode {
+	clk := new(clock)
+	clk.Store(1700000000000)
+	logs := new(logBuffer)
+	config.Dialer = n.dial
+	config.Logger = slog.New(slog.NewTextHandler(logs, nil))
+	s, err := newStore(config, clk.Load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := beamtest.NewPipeListener()
+	server := beam.NewServer(s, beam.Config{RWTimeout: time.Millisecond * 100})
+	done := make(chan error, 1)
+	go func() {
+		done <- server.ServeListener(l)
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+		<-done
+		_ = s.Close()
+	})
+
+	n.mutex.Lock()
+	if n.listeners == nil {
+		n.listeners = make(map[string]*beamtest.PipeListener)
+	}
+	n.listeners[addr] = l
+	n.mutex.Unlock()
+
+	conn, err := l.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testNode{Store: s, clk: clk, conn: beamtest.NewConn(t, conn), logs: logs}
+}
+
+// logBuffer collects the logs of the store.
+type logBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) count(msg string) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return strings.Count(b.buf.String(), "msg=\""+msg+"\"")
+}
+
+// waitSynced waits until the replica is connected and has applied the stream of the primary.
+func waitSynced(t *testing.T, primary, replica *testNode) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		primary.mutex.Lock()
+		replID, offset := primary.replID, primary.replOffset
+		primary.mutex.Unlock()
+		replica.mutex.Lock()
+		defer replica.mutex.Unlock()
+		return replica.master != nil && replica.master.state == linkConnected &&
+			replica.replID == replID && replica.replOffset == offset
+	}, time.Second*5, time.Millisecond*10)
+}
+
+// disconnectReplicas breaks the links of the replicas, which reconnect in a second.
+func disconnectReplicas(primary *testNode) {
+	primary.mutex.Lock()
+	defer primary.mutex.Unlock()
+	primary.dropReplicas()
+}
+
+func TestBacklog(t *testing.T) {
+	assert := assert.New(t)
+
+	b := newBacklog(8, 1)
+	assert.True(b.holds(1))
+	assert.False(b.holds(2))
+	b.write([]byte("abcde"))
+	assert.Equal([]byte("abcde"), b.since(1))
+	assert.Equal([]byte("de"), b.since(4))
+	assert.Empty(b.since(6))
+
+	b.write([]byte("fghij"))
+	assert.Equal(int64(3), b.offset)
+	assert.False(b.holds(2))
+	assert.Equal([]byte("cdefghij"), b.since(3))
+	assert.Equal([]byte("ij"), b.since(9))
+
+	b.write([]byte("0123456789"))
+	assert.Equal(int64(13), b.offset)
+	assert.Equal([]byte("23456789"), b.since(13))
+}
+
+func TestStore_Replication(t *testing.T) {
+	assert := assert.New(t)
+	var network testNetwork
+	primary := network.serve(t, "primary:6379", Config{ActiveExpireInterval: time.Hour})
+	fillKeys(primary.conn)
+	primary.clk.advance(time.Millisecond)
+
+	replica := network.serve(t, "replica:6380", Config{ReplicaOf: "primary:6379", ReplicaAnnouncePort: 6380})
+	replica.clk.advance(time.Millisecond)
+	waitSynced(t, primary, replica)
+	assert.Equal(readKeys(primary.conn), readKeys(replica.conn))
+	beamtest.AssertInteger(t, 0, replica.conn.Do("EXISTS", "tmp"))
+
+	// the nondeterministic and the blocking commands are replicated by their effects.
+	beamtest.AssertOK(t, primary.conn.Do("SET", "new", "1", "EX", "100"))
+	beamtest.AssertInteger(t, 2, primary.conn.Do("RPUSH", "queue", "a", "b"))
+	beamtest.AssertStrings(t, []string{"queue", "a"}, primary.conn.Do("BLPOP", "queue", "0"))
+	beamtest.AssertInteger(t, 1, primary.conn.Do("SREM", "s", "a"))
+	primary.conn.Do("SPOP", "s")
+	primary.conn.Do("XADD", "x", "*", "f", "v")
+	waitSynced(t, primary, replica)
+	assert.Equal(readKeys(primary.conn), readKeys(replica.conn))
+	beamtest.AssertInteger(t, 100000, replica.conn.Do("PTTL", "new"))
+	beamtest.AssertStrings(t, []string{"b"}, replica.conn.Do("LRANGE", "queue", "0", "-1"))
+	beamtest.AssertInteger(t, 0, replica.conn.Do("SCARD", "s"))
+	assert.Equal(primary.conn.Do("XRANGE", "x", "-", "+"), replica.conn.Do("XRANGE", "x", "-", "+"))
+
+	beamtest.AssertError(t, "READONLY You can't write against a read only replica.", replica.conn.Do("SET", "foo", "bar"))
+	beamtest.AssertError(t, "READONLY", replica.conn.Do("BLPOP", "queue", "0"))
+	beamtest.AssertStrings(t, []string{"b"}, replica.conn.Do("LRANGE", "queue", "0", "-1"))
+
+	primary.mutex.Lock()
+	offset := primary.replOffset
+	replID := primary.replID
+	primary.mutex.Unlock()
+	assert.Eventually(func() bool {
+		primary.mutex.Lock()
+		defer primary.mutex.Unlock()
+		return len(primary.replicas) == 1 && primary.replicas[0].ackOffset == offset
+	}, time.Second*5, time.Millisecond*10)
+	beamtest.AssertReply(t, beam.NewNestedArraysReply(
+		beam.NewBulkStringsReply("master"),
+		integer(offset),
+		beam.NewNestedArraysReply(beam.NewArraysReply("pipe", "6380", strconv.FormatInt(offset, 10))),
+	), primary.conn.Do("ROLE"))
+	beamtest.AssertReply(t, beam.NewNestedArraysReply(
+		beam.NewBulkStringsReply("slave"),
+		beam.NewBulkStringsReply("primary"),
+		integer(6379),
+		beam.NewBulkStringsReply("connected"),
+		integer(offset),
+	), replica.conn.Do("ROLE"))
+
+	info, err := primary.conn.Do("INFO", "replication").Text()
+	assert.NoError(err)
+	assert.Contains(info, "# Replication\r\nrole:master\r\nconnected_slaves:1\r\n")
+	assert.Contains(info, "slave0:ip=pipe,port=6380,state=online,offset="+strconv.FormatInt(offset, 10)+",lag=0\r\n")
+	assert.Contains(info, "master_replid:"+replID+"\r\n")
+	assert.Contains(info, "master_repl_offset:"+strconv.FormatInt(offset, 10)+"\r\n")
+	assert.Contains(info, "repl_backlog_active:1\r\n")
+	info, err = replica.conn.Do("INFO").Text()
+	assert.NoError(err)
+	assert.Contains(info, "role:slave\r\nmaster_host:primary\r\nmaster_port:6379\r\nmaster_link_status:up\r\n")
+	assert.Contains(info, "slave_repl_offset:"+strconv.FormatInt(offset, 10)+"\r\nslave_read_only:1\r\n")
+	assert.Contains(info, "master_replid:"+replID+"\r\n")
+	beamtest.AssertBulkString(t, "", replica.conn.Do("INFO", "memory"))
+}
+
+func TestStore_ReplicationExpiry(t *testing.T) {
+	assert := assert.New(t)
+	var network testNetwork
+	primary := network.serve(t, "primary:6379", Config{ActiveExpireInterval: time.Hour})
+	replica := network.serve(t, "replica:6380", Config{ReplicaOf: "primary:6379", ActiveExpireInterval: time.Millisecond})
+
+	beamtest.AssertOK(t, primary.conn.Do("SET", "foo", "bar", "PX", "100"))
+	waitSynced(t, primary, replica)
+
+	// the replica hides the expired key, and waits for the deletion from the primary.
+	replica.clk.advance(time.Millisecond * 100)
+	beamtest.AssertNil(t, replica.conn.Do("GET", "foo"))
+	beamtest.AssertReply(t, beam.NewNestedArraysReply(beam.NewBulkStringsReply("0"), beam.NewArraysReply()), replica.conn.Do("SCAN", "0"))
+	time.Sleep(time.Millisecond * 10)
+	replica.mutex.Lock()
+	assert.Equal(1, replica.keys.len())
+	replica.mutex.Unlock()
+
+	primary.clk.advance(time.Millisecond * 100)
+	beamtest.AssertNil(t, primary.conn.Do("GET", "foo"))
+	waitSynced(t, primary, replica)
+	replica.mutex.Lock()
+	assert.Zero(replica.keys.len())
+	replica.mutex.Unlock()
+}
+
+func TestStore_PartialResync(t *testing.T) {
+	assert := assert.New(t)
+	var network testNetwork
+	primary := network.serve(t, "primary:6379", Config{ReplBacklogSize: 1024})
+	replica := network.serve(t, "replica:6380", Config{ReplicaOf: "primary:6379"})
+	beamtest.AssertOK(t, primary.conn.Do("SET", "foo", "1"))
+	waitSynced(t, primary, replica)
+	assert.Equal(1, replica.logs.count("synchronize with the primary"))
+
+	// the stream during the disconnection is continued from the backlog.
+	disconnectReplicas(primary)
+	beamtest.AssertOK(t, primary.conn.Do("SET", "foo", "2"))
+	waitSynced(t, primary, replica)
+	beamtest.AssertBulkString(t, "2", replica.conn.Do("GET", "foo"))
+	assert.Equal(1, replica.logs.count("synchronize with the primary"))
+	assert.Equal(1, replica.logs.count("continue the replication"))
+
+	// the full resynchronization is needed if the stream overflows the backlog.
+	disconnectReplicas(primary)
+	value := strings.Repeat("x", 1024)
+	beamtest.AssertOK(t, primary.conn.Do("SET", "foo", value))
+	waitSynced(t, primary, replica)
+	beamtest.AssertBulkString(t, value, replica.conn.Do("GET", "foo"))
+	assert.Equal(2, replica.logs.count("synchronize with the primary"))
+}
+
+func TestStore_Failover(t *testing.T) {
+	assert := assert.New(t)
+	var network testNetwork
+	primary := network.serve(t, "primary:6379", Config{})
+	replica := network.serve(t, "replica:6380", Config{ReplicaOf: "primary:6379"})
+	chained := network.serve(t, "chained:6381", Config{})
+	beamtest.AssertOK(t, primary.conn.Do("SET", "foo", "1"))
+	waitSynced(t, primary, replica)
+
+	// the replica of the replica receives the stream of the primary through it.
+	beamtest.AssertOK(t, chained.conn.Do("REPLICAOF", "replica", "6380"))
+	beamtest.AssertSimpleString(t, "OK Already connected to specified master", chained.conn.Do("REPLICAOF", "replica", "6380"))
+	waitSynced(t, replica, chained)
+	beamtest.AssertOK(t, primary.conn.Do("SET", "foo", "2"))
+	waitSynced(t, primary, chained)
+	beamtest.AssertBulkString(t, "2", chained.conn.Do("GET", "foo"))
+
+	// the former primary continues with the stream of the promoted replica by the previous ID.
+	replica.mutex.Lock()
+	replID := replica.replID
+	replica.mutex.Unlock()
+	beamtest.AssertOK(t, replica.conn.Do("REPLICAOF", "NO", "ONE"))
+	beamtest.AssertOK(t, replica.conn.Do("SET", "foo", "3"))
+	beamtest.AssertOK(t, primary.conn.Do("REPLICAOF", "replica", "6380"))
+	beamtest.AssertError(t, "READONLY", primary.conn.Do("SET", "foo", "4"))
+	waitSynced(t, replica, primary)
+	waitSynced(t, replica, chained)
+	beamtest.AssertBulkString(t, "3", primary.conn.Do("GET", "foo"))
+	beamtest.AssertBulkString(t, "3", chained.conn.Do("GET", "foo"))
+	assert.Equal(0, primary.logs.count("synchronize with the primary"))
+	assert.Equal(1, primary.logs.count("continue the replication"))
+	info, err := replica.conn.Do("INFO", "replication").Text()
+	assert.NoError(err)
+	assert.Contains(info, "master_replid2:"+replID+"\r\n")
+}
+
+func TestStore_ReplicaOfError(t *testing.T) {
+	var network testNetwork
+	replica := network.serve(t, "replica:6380", Config{ReplicaOf: "missing:6379"})
+
+	beamtest.AssertError(t, "NOMASTERLINK", replica.conn.Do("PSYNC", "?", "-1"))
+	beamtest.AssertError(t, "ERR value is not an integer or out of range", replica.conn.Do("REPLICAOF", "primary", "port"))
+	beamtest.AssertError(t, "ERR Unrecognized REPLCONF option: foo", replica.conn.Do("REPLCONF", "foo", "bar"))
+	beamtest.AssertOK(t, replica.conn.Do("REPLCONF", "listening-port", "6380", "capa", "psync2"))
+	beamtest.AssertError(t, "READONLY", replica.conn.Do("DEL", "foo"))
+	beamtest.AssertOK(t, replica.conn.Do("REPLICAOF", "NO", "ONE"))
+	beamtest.AssertReply(t, beam.NewNestedArraysReply(
+		beam.NewBulkStringsReply("master"),
+		integer(0),
+		beam.NewNestedArraysReply(),
+	), replica.conn.Do("ROLE"))
+	beamtest.AssertInteger(t, 0, replica.conn.Do("DEL", "foo"))
+	beamtest.AssertSimpleString(t, "PONG", replica.conn.Do("PING"))
+	beamtest.AssertBulkString(t, "hello", replica.conn.Do("PING", "hello"))
+}
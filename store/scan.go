@@ -0,0 +1,118 @@
+package store
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/caeret/beam"
+)
+
+// scanArgs is the options of the SCAN family.
+type scanArgs struct {
+	cursor  uint64
+	pattern []byte
+	count   int
+	// typ is the TYPE of SCAN, nil if the keys of all the types are replied.
+	typ      []byte
+	noValues bool
+}
+
+// parseScanArgs parses cursor [MATCH pattern] [COUNT count], TYPE is allowed by SCAN and NOVALUES
+// is allowed by HSCAN.
+func (c *call) parseScanArgs(args [][]byte) (scanArgs, beam.Reply) {
+	opts := scanArgs{count: 10}
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return opts, beam.NewErrorsReply("ERR invalid cursor")
+	}
+	opts.cursor = cursor
+	for i := 1; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		switch {
+		case opt == "MATCH" && i+1 < len(args):
+			i++
+			// "*" matches everything, so it is not checked.
+			if string(args[i]) != "*" {
+				opts.pattern = args[i]
+			}
+		case opt == "COUNT" && i+1 < len(args):
+			i++
+			count, ok := parseInt(args[i])
+			if !ok {
+				return opts, notIntegerReply
+			}
+			if count < 1 {
+				return opts, syntaxErrReply
+			}
+			opts.count = int(min(count, math.MaxInt32))
+		case opt == "TYPE" && i+1 < len(args) && c.name == "scan":
+			i++
+			opts.typ = args[i]
+		case opt == "NOVALUES" && c.name == "hscan":
+			opts.noValues = true
+		default:
+			return opts, syntaxErrReply
+		}
+	}
+	return opts, nil
+}
+
+// scanDict scans the dict from the cursor until count entries are matched, at most count*10
+// buckets are visited in a call as redis does, so the sparse matches do not block the store for
+// long. fn is called for the entries matching the pattern, and reports whether they are replied.
+func scanDict[V any](d *dict[V], opts scanArgs, fn func(key string, value V) bool) uint64 {
+	cursor := opts.cursor
+	matched := 0
+	for buckets := opts.count * 10; buckets > 0 && matched < opts.count; buckets-- {
+		cursor = d.scan(cursor, func(key string, value V) {
+			if opts.pattern != nil && !matchGlob(opts.pattern, []byte(key)) {
+				return
+			}
+			if fn(key, value) {
+				matched++
+			}
+		})
+		if cursor == 0 {
+			break
+		}
+	}
+	return cursor
+}
+
+// scanReply replies the next cursor and the elements.
+func scanReply(cursor uint64, elems [][]byte) beam.Reply {
+	b := beam.AppendArraysHeader(nil, 2)
+	b = beam.AppendBulkStrings(b, strconv.FormatUint(cursor, 10))
+	b = beam.AppendArraysHeader(b, len(elems))
+	for _, elem := range elems {
+		b = beam.AppendBulkStringsRaw(b, elem)
+	}
+	return b
+}
+
+// scanCommand implements SCAN cursor [MATCH pattern] [COUNT count] [TYPE type], the unknown type
+// matches no key as redis does.
+func scanCommand(c *call) beam.Reply {
+	opts, reply := c.parseScanArgs(c.args)
+	if reply != nil {
+		return reply
+	}
+	var keys, expired [][]byte
+	cursor := scanDict(&c.keys, opts, func(key string, e *entry) bool {
+		// the expired keys are removed after the scan, since the dict can not be modified during it.
+		if e.expireAt > 0 && e.expireAt <= c.now {
+			expired = append(expired, []byte(key))
+			return false
+		}
+		if opts.typ != nil && !strings.EqualFold(typeName(e.value), string(opts.typ)) {
+			return false
+		}
+		keys = append(keys, []byte(key))
+		return true
+	})
+	for _, key := range expired {
+		c.removeExpired(key)
+	}
+	return scanReply(cursor, keys)
+}
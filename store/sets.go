@@ -0,0 +1,318 @@
+package store
+
+import (
+	"math"
+	"math/rand/v2"
+
+	"github.com/caeret/beam"
+)
+
+// set is the value of the set keys.
+type set = dict[struct{}]
+
+// lookupSet retrieves the set of the key, the error reply will be returned if the key holds
+// another type.
+func (c *call) lookupSet(key []byte) (*set, beam.Reply) {
+	e := c.lookup(key)
+	if e == nil {
+		return nil, nil
+	}
+	s, ok := e.value.(*set)
+	if !ok {
+		return nil, wrongTypeReply
+	}
+	return s, nil
+}
+
+// membersReply replies the members in an array.
+func membersReply(members []string) beam.Reply {
+	b := beam.AppendArraysHeader(nil, len(members))
+	for _, member := range members {
+		b = beam.AppendBulkStrings(b, member)
+	}
+	return b
+}
+
+func saddCommand(c *call) beam.Reply {
+	s, reply := c.lookupSet(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	if s == nil {
+		s = new(set)
+		c.set(c.args[0], s)
+	}
+	n := 0
+	for _, member := range c.args[1:] {
+		if s.put(string(member), struct{}{}) {
+			n++
+		}
+	}
+	return integer(int64(n))
+}
+
+func sremCommand(c *call) beam.Reply {
+	s, reply := c.lookupSet(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	n := 0
+	for _, member := range c.args[1:] {
+		if s.delete(string(member)) {
+			n++
+		}
+	}
+	// the key is removed with the last member.
+	if s != nil && s.len() == 0 {
+		c.remove(c.args[0])
+	}
+	return integer(int64(n))
+}
+
+func smembersCommand(c *call) beam.Reply {
+	s, reply := c.lookupSet(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	return membersReply(s.keys())
+}
+
+func sismemberCommand(c *call) beam.Reply {
+	s, reply := c.lookupSet(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	if s.has(string(c.args[1])) {
+		return integer(1)
+	}
+	return integer(0)
+}
+
+func smismemberCommand(c *call) beam.Reply {
+	s, reply := c.lookupSet(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	b := beam.AppendArraysHeader(nil, len(c.args)-1)
+	for _, member := range c.args[1:] {
+		n := int64(0)
+		if s.has(string(member)) {
+			n = 1
+		}
+		b = beam.AppendIntegers(b, n)
+	}
+	return b
+}
+
+func scardCommand(c *call) beam.Reply {
+	s, reply := c.lookupSet(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	return integer(int64(s.len()))
+}
+
+// spopCommand implements SPOP key [count], the member is replied in a bulk string without the count.
+func spopCommand(c *call) beam.Reply {
+	if len(c.args) > 2 {
+		return syntaxErrReply
+	}
+	count := int64(-1)
+	if len(c.args) == 2 {
+		var ok bool
+		if count, ok = parseInt(c.args[1]); !ok || count < 0 {
+			return beam.NewErrorsReply("ERR value is out of range, must be positive")
+		}
+	}
+	s, reply := c.lookupSet(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	if s == nil {
+		if count < 0 {
+			return nilReply
+		}
+		return beam.AppendArraysHeader(nil, 0)
+	}
+	var popped []string
+	for int64(len(popped)) < max(count, 1) {
+		member, _, ok := s.random()
+		if !ok {
+			break
+		}
+		popped = append(popped, member)
+		s.delete(member)
+	}
+	if s.len() == 0 {
+		c.remove(c.args[0])
+	}
+	// the random members are logged as SREM.
+	members := make([][]byte, len(popped))
+	for i, member := range popped {
+		members[i] = []byte(member)
+	}
+	c.propagate()
+	if len(members) > 0 {
+		c.propagate(query("SREM", append([][]byte{c.args[0]}, members...)...))
+	}
+	if count < 0 {
+		return beam.NewBulkStringsReply(popped[0])
+	}
+	return membersReply(popped)
+}
+
+// srandmemberCommand implements SRANDMEMBER key [count], the members are distinct with the positive
+// count, and may repeat with the negative one.
+func srandmemberCommand(c *call) beam.Reply {
+	if len(c.args) > 2 {
+		return syntaxErrReply
+	}
+	var count int64
+	if len(c.args) == 2 {
+		var ok bool
+		if count, ok = parseInt(c.args[1]); !ok {
+			return notIntegerReply
+		}
+		if count == math.MinInt64 {
+			return beam.NewErrorsReply("ERR value is out of range")
+		}
+	}
+	s, reply := c.lookupSet(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	if len(c.args) == 1 {
+		member, _, ok := s.random()
+		if !ok {
+			return nilReply
+		}
+		return beam.NewBulkStringsReply(member)
+	}
+
+	members := s.keys()
+	var picked []string
+	switch {
+	case len(members) == 0:
+	case count >= 0:
+		rand.Shuffle(len(members), func(i, j int) {
+			members[i], members[j] = members[j], members[i]
+		})
+		picked = members[:min(int(count), len(members))]
+	default:
+		picked = make([]string, -count)
+		for i := range picked {
+			picked[i] = members[rand.IntN(len(members))]
+		}
+	}
+	return membersReply(picked)
+}
+
+// setOp is the operation of SINTER, SUNION and SDIFF.
+type setOp int
+
+const (
+	setInter setOp = iota
+	setUnion
+	setDiff
+)
+
+func sinterCommand(c *call) beam.Reply {
+	return c.setOpGeneric(setInter, nil)
+}
+
+func sunionCommand(c *call) beam.Reply {
+	return c.setOpGeneric(setUnion, nil)
+}
+
+func sdiffCommand(c *call) beam.Reply {
+	return c.setOpGeneric(setDiff, nil)
+}
+
+func sinterstoreCommand(c *call) beam.Reply {
+	return c.setOpGeneric(setInter, c.args[0])
+}
+
+func sunionstoreCommand(c *call) beam.Reply {
+	return c.setOpGeneric(setUnion, c.args[0])
+}
+
+func sdiffstoreCommand(c *call) beam.Reply {
+	return c.setOpGeneric(setDiff, c.args[0])
+}
+
+// setOpGeneric applies the operation to the sets, the result is stored in dst if it is not nil
+// and the cardinality is replied.
+func (c *call) setOpGeneric(op setOp, dst []byte) beam.Reply {
+	keys := c.args
+	if dst != nil {
+		keys = keys[1:]
+	}
+	// the missing keys are regarded as the empty sets.
+	sets := make([]*set, len(keys))
+	for i, key := range keys {
+		s, reply := c.lookupSet(key)
+		if reply != nil {
+			return reply
+		}
+		sets[i] = s
+	}
+
+	result := new(set)
+	switch op {
+	case setInter:
+		sets[0].each(func(member string, _ struct{}) bool {
+			for _, s := range sets[1:] {
+				if !s.has(member) {
+					return true
+				}
+			}
+			result.put(member, struct{}{})
+			return true
+		})
+	case setUnion:
+		for _, s := range sets {
+			s.each(func(member string, _ struct{}) bool {
+				result.put(member, struct{}{})
+				return true
+			})
+		}
+	case setDiff:
+		sets[0].each(func(member string, _ struct{}) bool {
+			for _, s := range sets[1:] {
+				if s.has(member) {
+					return true
+				}
+			}
+			result.put(member, struct{}{})
+			return true
+		})
+	}
+
+	if dst == nil {
+		return membersReply(result.keys())
+	}
+	if result.len() == 0 {
+		c.remove(dst)
+	} else {
+		c.set(dst, result)
+	}
+	return integer(int64(result.len()))
+}
+
+// sscanCommand implements SSCAN key cursor [MATCH pattern] [COUNT count].
+func sscanCommand(c *call) beam.Reply {
+	opts, reply := c.parseScanArgs(c.args[1:])
+	if reply != nil {
+		return reply
+	}
+	s, reply := c.lookupSet(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	var members [][]byte
+	cursor := scanDict(s, opts, func(member string, _ struct{}) bool {
+		members = append(members, []byte(member))
+		return true
+	})
+	return scanReply(cursor, members)
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/caeret/beam"
+	"github.com/caeret/beam/beamtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_SetMembers(t *testing.T) {
+	assert := assert.New(t)
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertInteger(t, 3, conn.Do("SADD", "s", "a", "b", "c"))
+	beamtest.AssertInteger(t, 1, conn.Do("SADD", "s", "a", "d"))
+	beamtest.AssertSimpleString(t, "set", conn.Do("TYPE", "s"))
+	beamtest.AssertInteger(t, 4, conn.Do("SCARD", "s"))
+	beamtest.AssertInteger(t, 0, conn.Do("SCARD", "missing"))
+	beamtest.AssertInteger(t, 1, conn.Do("SISMEMBER", "s", "a"))
+	beamtest.AssertInteger(t, 0, conn.Do("SISMEMBER", "s", "x"))
+	beamtest.AssertReply(t, beam.Reply("*2\r\n:1\r\n:0\r\n"), conn.Do("SMISMEMBER", "s", "b", "x"))
+	members, err := conn.Do("SMEMBERS", "s").Array()
+	assert.Nil(err)
+	assert.Len(members, 4)
+	beamtest.AssertStrings(t, []string{}, conn.Do("SMEMBERS", "missing"))
+
+	beamtest.AssertInteger(t, 2, conn.Do("SREM", "s", "a", "b", "x"))
+	beamtest.AssertInteger(t, 2, conn.Do("SREM", "s", "c", "d"))
+	// the key is removed with the last member.
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "s"))
+
+	beamtest.AssertOK(t, conn.Do("SET", "str", "v"))
+	beamtest.AssertError(t, "WRONGTYPE", conn.Do("SADD", "str", "a"))
+	beamtest.AssertError(t, "WRONGTYPE", conn.Do("SUNION", "s", "str"))
+}
+
+func TestStore_SetRandom(t *testing.T) {
+	assert := assert.New(t)
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertInteger(t, 3, conn.Do("SADD", "s", "a", "b", "c"))
+	member, err := conn.Do("SRANDMEMBER", "s").Text()
+	assert.Nil(err)
+	assert.Contains([]string{"a", "b", "c"}, member)
+	members, err := conn.Do("SRANDMEMBER", "s", "5").Array()
+	assert.Nil(err)
+	assert.Len(members, 3)
+	members, err = conn.Do("SRANDMEMBER", "s", "-5").Array()
+	assert.Nil(err)
+	assert.Len(members, 5)
+	beamtest.AssertNil(t, conn.Do("SRANDMEMBER", "missing"))
+	beamtest.AssertInteger(t, 3, conn.Do("SCARD", "s"))
+
+	member, err = conn.Do("SPOP", "s").Text()
+	assert.Nil(err)
+	beamtest.AssertInteger(t, 0, conn.Do("SISMEMBER", "s", member))
+	members, err = conn.Do("SPOP", "s", "5").Array()
+	assert.Nil(err)
+	assert.Len(members, 2)
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "s"))
+	beamtest.AssertNil(t, conn.Do("SPOP", "s"))
+	beamtest.AssertStrings(t, []string{}, conn.Do("SPOP", "s", "1"))
+	beamtest.AssertError(t, "ERR value is out of range, must be positive", conn.Do("SPOP", "s", "-1"))
+}
+
+func TestStore_SetOp(t *testing.T) {
+	assert := assert.New(t)
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertInteger(t, 4, conn.Do("SADD", "s1", "a", "b", "c", "d"))
+	beamtest.AssertInteger(t, 3, conn.Do("SADD", "s2", "c", "d", "e"))
+	beamtest.AssertInteger(t, 2, conn.Do("SADD", "s3", "a", "c"))
+
+	members, err := conn.Do("SINTER", "s1", "s2", "s3").Array()
+	assert.Nil(err)
+	assert.Len(members, 1)
+	beamtest.AssertStrings(t, []string{}, conn.Do("SINTER", "s1", "missing"))
+	beamtest.AssertInteger(t, 5, conn.Do("SUNIONSTORE", "u", "s1", "s2", "missing"))
+	beamtest.AssertInteger(t, 5, conn.Do("SCARD", "u"))
+	beamtest.AssertInteger(t, 1, conn.Do("SDIFFSTORE", "d", "s1", "s2", "s3"))
+	beamtest.AssertStrings(t, []string{"b"}, conn.Do("SDIFF", "s1", "s2", "s3"))
+	beamtest.AssertStrings(t, []string{"b"}, conn.Do("SMEMBERS", "d"))
+	beamtest.AssertInteger(t, 2, conn.Do("SINTERSTORE", "s1", "s1", "s2"))
+	beamtest.AssertInteger(t, 2, conn.Do("SCARD", "s1"))
+
+	// the destination is removed with the empty result.
+	beamtest.AssertInteger(t, 0, conn.Do("SINTERSTORE", "d", "s3", "missing"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "d"))
+}
+
+func TestStore_SScan(t *testing.T) {
+	assert := assert.New(t)
+	_, _, conn := newTestStore(t, Config{})
+
+	for i := range 50 {
+		conn.Do("SADD", "s", "m"+strconv.Itoa(i))
+	}
+	var members []string
+	for cursor := "0"; ; {
+		reply, err := conn.Do("SSCAN", "s", cursor, "COUNT", "5").Array()
+		if !assert.Nil(err) {
+			return
+		}
+		elems, _ := reply[1].Array()
+		for _, elem := range elems {
+			member, _ := elem.Text()
+			members = append(members, member)
+		}
+		// the set shrinks between the calls.
+		conn.Do("SREM", "s", "m49", "m48", "m47")
+		if cursor, _ = reply[0].Text(); cursor == "0" {
+			break
+		}
+	}
+	for i := range 47 {
+		assert.Contains(members, "m"+strconv.Itoa(i))
+	}
+	beamtest.AssertReply(t, beam.Reply("*2\r\n$1\r\n0\r\n*0\r\n"), conn.Do("SSCAN", "missing", "0"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("SSCAN", "s", "0", "TYPE", "set"))
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSkiplist(t *testing.T) {
+	assert := assert.New(t)
+	zsl := newSkiplist()
+	scores := make(map[string]float64)
+	for i := range 1000 {
+		member := strconv.Itoa(i)
+		score := float64(rand.IntN(100))
+		scores[member] = score
+		zsl.insert(score, member)
+	}
+	// update half of the members and remove a quarter of them.
+	for i := range 500 {
+		member := strconv.Itoa(i)
+		assert.True(zsl.delete(scores[member], member))
+		if i%2 == 0 {
+			delete(scores, member)
+			continue
+		}
+		scores[member] = float64(rand.IntN(100))
+		zsl.insert(scores[member], member)
+	}
+	assert.False(zsl.delete(0, "missing"))
+
+	type element struct {
+		member string
+		score  float64
+	}
+	var expected []element
+	for member, score := range scores {
+		expected = append(expected, element{member, score})
+	}
+	slices.SortFunc(expected, func(a, b element) int {
+		if a.score != b.score {
+			if a.score < b.score {
+				return -1
+			}
+			return 1
+		}
+		if a.member < b.member {
+			return -1
+		}
+		return 1
+	})
+
+	assert.Equal(len(expected), zsl.length)
+	x := zsl.header.next()
+	for i, e := range expected {
+		assert.Equal(e.member, x.member)
+		assert.Equal(i+1, zsl.rank(e.score, e.member))
+		assert.Same(x, zsl.byRank(i+1))
+		if i > 0 {
+			assert.Equal(expected[i-1].member, x.backward.member)
+		}
+		x = x.next()
+	}
+	assert.Nil(x)
+	assert.Equal(expected[len(expected)-1].member, zsl.tail.member)
+	assert.Nil(zsl.byRank(0))
+	assert.Nil(zsl.byRank(len(expected) + 1))
+	assert.Equal(0, zsl.rank(0, "missing"))
+
+	r := scoreRange{min: 10, max: 20, maxEx: true}
+	first, last := zsl.first(r), zsl.last(r)
+	i := slices.IndexFunc(expected, func(e element) bool { return e.score >= 10 })
+	j := slices.IndexFunc(expected, func(e element) bool { return e.score >= 20 }) - 1
+	assert.Equal(expected[i].member, first.member)
+	assert.Equal(expected[j].member, last.member)
+	assert.Nil(zsl.first(scoreRange{min: 100, max: 200}))
+	assert.Nil(zsl.last(scoreRange{min: -10, max: -1}))
+}
+
+func TestSkiplist_Lex(t *testing.T) {
+	assert := assert.New(t)
+	zsl := newSkiplist()
+	for _, member := range []string{"a", "b", "c", "d", "e"} {
+		zsl.insert(0, member)
+	}
+	r := lexRange{min: lexBound{value: "b", ex: true}, max: lexBound{value: "d"}}
+	assert.Equal("c", zsl.first(r).member)
+	assert.Equal("d", zsl.last(r).member)
+	r = lexRange{min: lexBound{inf: -1}, max: lexBound{inf: 1}}
+	assert.Equal("a", zsl.first(r).member)
+	assert.Equal("e", zsl.last(r).member)
+	assert.Nil(zsl.first(lexRange{min: lexBound{inf: 1}, max: lexBound{inf: 1}}))
+	assert.Nil(zsl.first(lexRange{min: lexBound{value: "x"}, max: lexBound{inf: 1}}))
+}
@@ -0,0 +1,94 @@
+package store
+
+import "slices"
+
+// snapshot retrieves the keys at the moment, it can be read without the lock until it is released.
+// The entries are shared with the store instead of copied, and the write commands copy the shared
+// entries before modifying them, so only the table of the keys is copied as the fork of redis
+// only copies the page tables.
+func (s *Store) snapshot() *dict[*entry] {
+	s.epoch++
+	s.snapshots++
+	return s.keys.clone()
+}
+
+// releaseSnapshot releases the snapshot, the entries are not copied if all the snapshots are released.
+func (s *Store) releaseSnapshot() {
+	s.snapshots--
+}
+
+// copyOnWrite replaces the entry shared with the snapshots with a copy.
+func (c *call) copyOnWrite(key []byte, e *entry) *entry {
+	cp := &entry{value: cloneValue(e.value), expireAt: e.expireAt, epoch: c.epoch}
+	c.keys.put(string(key), cp)
+	if cp.expireAt > 0 {
+		c.expires[string(key)] = cp
+	}
+	return cp
+}
+
+// clone copies the dict, the values are shared.
+func (d *dict[V]) clone() *dict[V] {
+	cp := &dict[V]{used: d.len()}
+	if d.len() == 0 {
+		return cp
+	}
+	cp.buckets = make([]*dictEntry[V], len(d.buckets))
+	for i, e := range d.buckets {
+		for p := &cp.buckets[i]; e != nil; e = e.next {
+			*p = &dictEntry[V]{key: e.key, value: e.value}
+			p = &(*p).next
+		}
+	}
+	return cp
+}
+
+// cloneValue copies the value deep enough that modifying the copy never changes the original, the
+// elements which are never modified in place such as the members are shared.
+func cloneValue(value any) any {
+	switch v := value.(type) {
+	case []byte:
+		return clone(v)
+	case *hash:
+		return v.clone()
+	case *set:
+		return v.clone()
+	case *list:
+		return &list{buf: slices.Clone(v.buf), head: v.head, n: v.n}
+	case *zset:
+		z := newZset()
+		v.scores.each(func(member string, score float64) bool {
+			z.add(member, score)
+			return true
+		})
+		return z
+	case *stream:
+		return v.clone()
+	}
+	panic("store: unknown value type")
+}
+
+// clone copies the stream with its consumer groups, the entries are shared.
+func (s *stream) clone() *stream {
+	cp := *s
+	cp.entries = slices.Clone(s.entries)
+	cp.groups = make(map[string]*consumerGroup, len(s.groups))
+	for name, g := range s.groups {
+		gp := *g
+		gp.pending = make(map[streamID]*pendingEntry, len(g.pending))
+		gp.consumers = make(map[string]*consumer, len(g.consumers))
+		for name, cons := range g.consumers {
+			consp := *cons
+			consp.pending = make(map[streamID]*pendingEntry, len(cons.pending))
+			gp.consumers[name] = &consp
+		}
+		for id, pe := range g.pending {
+			pep := *pe
+			pep.consumer = gp.consumers[pe.consumer.name]
+			pep.consumer.pending[id] = &pep
+			gp.pending[id] = &pep
+		}
+		cp.groups[name] = &gp
+	}
+	return &cp
+}
@@ -0,0 +1,481 @@
+// Package store is an in-memory keyspace implementing the redis commands, it can be served directly
+// or chained with the other handlers and middlewares:
+//
+//	s := store.New(store.Config{})
+//	defer s.Close()
+//	server := beam.NewServer(s, beam.Config{Addr: ":6379"})
+//
+// The keys are persisted with the append-only file if Config.AppendOnlyFile is set:
+//
+//	s, err := store.Open(store.Config{AppendOnlyFile: "appendonly.aof"})
+//
+// The store replicates the keys of another one over the RESP protocol if Config.ReplicaOf is set:
+//
+//	s := store.New(store.Config{ReplicaOf: "primary:6379"})
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caeret/beam"
+)
+
+// Config configures the Store.
+type Config struct {
+	// ActiveExpireInterval is the interval of removing the expired keys which are never accessed,
+	// the default is 100ms.
+	ActiveExpireInterval time.Duration
+	// ActiveExpireSamples is the number of the keys with expiry checked in a round, the rounds are
+	// repeated while more than a quarter of the samples are expired. The default is 20.
+	ActiveExpireSamples int
+	// AppendOnlyFile is the path of the append-only file, the write commands are logged to it and
+	// replayed when the store is opened. The keys are only kept in memory if it is empty.
+	AppendOnlyFile string
+	// AppendFsync is the policy of syncing the append-only file to the disk, the default is
+	// FsyncEverysec.
+	AppendFsync FsyncPolicy
+	// AutoRewritePercentage rewrites the append-only file in the background when it grows by the
+	// percentage since the last rewrite, the default is 100 and the negative one disables it.
+	AutoRewritePercentage int
+	// AutoRewriteMinSize is the min size of the append-only file rewritten automatically, the default
+	// is 64MB.
+	AutoRewriteMinSize int64
+	// RDBFile is the path of the RDB file written by SAVE and BGSAVE, the keys are loaded from it
+	// when the store is opened without the append-only file. SAVE and BGSAVE are rejected if it is
+	// empty.
+	RDBFile string
+	// ReplicaOf is the address of the primary, the store starts as a read-only replica of it if it
+	// is set, as REPLICAOF does.
+	ReplicaOf string
+	// ReplicaAnnouncePort is the port of the replica reported to the primary, which is shown by ROLE
+	// and INFO of the primary.
+	ReplicaAnnouncePort int
+	// ReplBacklogSize is the size of the backlog of the replication stream, the replicas reconnected
+	// within it continue with the partial resynchronization. The default is 1MB.
+	ReplBacklogSize int
+	// ReplTimeout disconnects the primary which sends nothing for longer, the default is 60s.
+	ReplTimeout time.Duration
+	// ReplPingPeriod is the interval of the PING sent to the replicas, the default is 10s.
+	ReplPingPeriod time.Duration
+	// Dialer connects the replica to the primary, the default is net.Dialer.
+	Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Logger logs the events of the persistence such as the truncated append-only file, the default
+	// is slog.Default().
+	Logger *slog.Logger
+}
+
+// Store is a beam.Handler keeping the keys in memory, the keys with expiry are removed lazily when
+// they are accessed and actively in the background.
+type Store struct {
+	config Config
+	// now retrieves the current unix time in milliseconds, it is replaced in the tests.
+	now func() int64
+
+	mutex   sync.Mutex
+	keys    dict[*entry]
+	expires map[string]*entry
+	// waiters are the clients blocked on the keys.
+	waiters map[string][]*waiter
+	// epoch is incremented by every snapshot, the entries of the earlier epochs may be shared with
+	// the snapshots, so they are copied before modified while any snapshot is in use.
+	epoch     uint64
+	snapshots int
+	// aof is the append-only file, nil if the keys are only kept in memory.
+	aof *appendOnlyFile
+	// loading is set while the append-only file is replayed, the keys are not expired then since
+	// the expired keys are deleted by the logged commands.
+	loading bool
+	// bgsaving is set while the RDB file is saved in the background.
+	bgsaving bool
+	// lastSave is the unix time in milliseconds of the last successful save.
+	lastSave int64
+
+	// replID identifies the history of the keys, replOffset is the number of the bytes of the
+	// replication stream. replID2 is the ID of the former primary, the replicas of it can continue
+	// with the stream until secondReplOffset.
+	replID           string
+	replID2          string
+	replOffset       int64
+	secondReplOffset int64
+	// backlog holds the latest replication stream, it is created with the first replica.
+	backlog  *backlog
+	replicas []*replica
+	// master is the link to the primary, nil if the store is not a replica.
+	master *masterLink
+
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// entry is the value of a key.
+type entry struct {
+	value any
+	// expireAt is the unix time in milliseconds, 0 if the key never expires.
+	expireAt int64
+	// epoch is the epoch of the store when the entry is created.
+	epoch uint64
+}
+
+// New creates a Store and starts the active expiry, it panics if the append-only file can not be
+// loaded, Open should be used with the persistence.
+func New(config Config) *Store {
+	s, err := Open(config)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Open creates a Store with the keys replayed from the append-only file or loaded from the RDB
+// file, and starts the active expiry and the persistence.
+func Open(config Config) (*Store, error) {
+	return newStore(config, func() int64 { return time.Now().UnixMilli() })
+}
+
+func newStore(config Config, now func() int64) (*Store, error) {
+	if config.ActiveExpireInterval <= 0 {
+		config.ActiveExpireInterval = time.Millisecond * 100
+	}
+	if config.ActiveExpireSamples <= 0 {
+		config.ActiveExpireSamples = 20
+	}
+	if config.AutoRewritePercentage == 0 {
+		config.AutoRewritePercentage = 100
+	}
+	if config.AutoRewriteMinSize <= 0 {
+		config.AutoRewriteMinSize = 64 << 20
+	}
+	if config.ReplBacklogSize <= 0 {
+		config.ReplBacklogSize = 1 << 20
+	}
+	if config.ReplTimeout <= 0 {
+		config.ReplTimeout = time.Minute
+	}
+	if config.ReplPingPeriod <= 0 {
+		config.ReplPingPeriod = time.Second * 10
+	}
+	if config.Dialer == nil {
+		var d net.Dialer
+		config.Dialer = d.DialContext
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+	s := &Store{
+		config:           config,
+		now:              now,
+		expires:          make(map[string]*entry),
+		waiters:          make(map[string][]*waiter),
+		replID:           newReplID(),
+		replID2:          noReplID,
+		secondReplOffset: -1,
+		closeCh:          make(chan struct{}),
+	}
+	s.lastSave = now()
+	// the append-only file is preferred since it is more up to date.
+	if config.AppendOnlyFile != "" {
+		aof, err := s.loadAppendOnlyFile(config.AppendOnlyFile)
+		if err != nil {
+			return nil, err
+		}
+		s.aof = aof
+		s.wg.Add(1)
+		go s.appendOnlyFileCron()
+	} else if config.RDBFile != "" {
+		if err := s.loadRDB(config.RDBFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	if config.ReplicaOf != "" {
+		s.mutex.Lock()
+		s.replicaOf(config.ReplicaOf)
+		s.mutex.Unlock()
+	}
+	s.wg.Add(2)
+	go s.activeExpire()
+	go s.replicationCron()
+	return s, nil
+}
+
+// Close stops the active expiry, the persistence and the replication, the append-only file is
+// synced and closed after the background rewrite and saving are finished.
+func (s *Store) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closeCh)
+		s.mutex.Lock()
+		if s.master != nil {
+			s.master.cancel()
+		}
+		s.mutex.Unlock()
+		s.wg.Wait()
+		if s.aof != nil {
+			s.mutex.Lock()
+			err = s.aof.close()
+			s.mutex.Unlock()
+		}
+	})
+	return err
+}
+
+// Handle implements beam.Handler.
+func (s *Store) Handle(request *beam.Request) (beam.Reply, error) {
+	cmd, reply := lookupCommand(request.Query)
+	if reply != nil {
+		return reply, nil
+	}
+	reply, w := s.handleLocked(cmd, request)
+	if w != nil {
+		return s.wait(request, w), nil
+	}
+	return reply, nil
+}
+
+// handleLocked executes the command with the lock held, the waiter is registered and returned if
+// the command is blocked. The lock is released even if the command panics, since the server
+// recovers the panic and keeps serving the other commands.
+func (s *Store) handleLocked(cmd *command, request *beam.Request) (beam.Reply, *waiter) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if cmd.flags&cmdWrite != 0 && s.aof != nil && s.aof.err != nil {
+		return beam.NewErrorsReply("MISCONF Errors writing to the AOF file: " + s.aof.err.Error()), nil
+	}
+	if cmd.flags&cmdWrite != 0 && s.master != nil {
+		return readOnlyReply, nil
+	}
+	reply, w := s.exec(cmd, request, false)
+	if w != nil {
+		// the logged commands never block, so the blocking ones are replied with the timeout.
+		if s.loading {
+			return w.timeoutReply, nil
+		}
+		s.register(w)
+	}
+	return reply, w
+}
+
+// lookupCommand finds the command of the query and checks the arity, the error reply is returned if
+// it can not be executed.
+func lookupCommand(q beam.Query) (*command, beam.Reply) {
+	// the upper case commands are looked up without allocation.
+	cmd, ok := commands[string(q.Command)]
+	if !ok {
+		name := strings.ToUpper(q.CommandStr())
+		if cmd, ok = commands[name]; !ok {
+			return nil, beam.NewErrorsReply(fmt.Sprintf("ERR unknown command '%s'", name))
+		}
+	}
+	if n := q.Len() + 1; (cmd.arity > 0 && n != cmd.arity) || n < -cmd.arity {
+		return nil, wrongArgs(cmd.name)
+	}
+	return cmd, nil
+}
+
+// exec executes the command with the lock held and propagates it, the waiter is returned if the
+// command is blocked.
+func (s *Store) exec(cmd *command, request *beam.Request, replicated bool) (beam.Reply, *waiter) {
+	c := &call{
+		Store:      s,
+		name:       cmd.name,
+		client:     request.Client,
+		write:      cmd.flags&cmdWrite != 0,
+		replicated: replicated,
+		args:       request.Arguments,
+		now:        s.now(),
+	}
+	reply := cmd.fn(c)
+	// the write command is propagated as it is unless it is replaced by the deterministic ones.
+	if c.write && c.blocked == nil && !reply.IsError() && !c.rewritten {
+		c.propagated = append(c.propagated, request.Query)
+	}
+	c.serveReady()
+	s.feed(c.propagated)
+	return reply, c.blocked
+}
+
+// call is a command being executed with the lock held.
+type call struct {
+	*Store
+	// name is the lower case command name for the error replies.
+	name string
+	// client is the client of the command, nil if the command is replayed or replicated.
+	client *beam.Client
+	// write is set for the write commands, the entries shared with the snapshots are copied when
+	// they are looked up.
+	write bool
+	// replicated is set for the commands from the primary, the keys are not expired by them since
+	// the deletions are replicated.
+	replicated bool
+	args       [][]byte
+	// now is the time of the command, the keys expired before it are not visible.
+	now int64
+	// blocked is set by the blocking commands which can not be served now.
+	blocked *waiter
+	// ready are the keys which the clients are blocked on and may be served now.
+	ready [][]byte
+	// propagated are the commands logged to the append-only file after the command, such as the
+	// deletions of the expired keys and the commands served the blocked clients.
+	propagated []beam.Query
+	// rewritten is set if the command is replaced by the propagated ones.
+	rewritten bool
+}
+
+// propagate replaces the command with the queries in the append-only file, so the nondeterministic
+// commands are replayed with the same effect, such as the relative expiry and the random members.
+// The command is not propagated if it is called without queries.
+func (c *call) propagate(queries ...beam.Query) {
+	c.propagated = append(c.propagated, queries...)
+	c.rewritten = true
+}
+
+// query creates the query with the binary arguments.
+func query(command string, args ...[]byte) beam.Query {
+	return beam.Query{Command: []byte(command), Arguments: args}
+}
+
+// lookup retrieves the entry of the key, the expired key is removed. The entry shared with the
+// snapshots is copied for the write commands.
+func (c *call) lookup(key []byte) *entry {
+	e, _ := c.keys.get(string(key))
+	if e == nil {
+		return nil
+	}
+	if e.expireAt > 0 && e.expireAt <= c.now && !c.loading && !c.replicated {
+		c.removeExpired(key)
+		return nil
+	}
+	if c.write && c.snapshots > 0 && e.epoch < c.epoch {
+		e = c.copyOnWrite(key, e)
+	}
+	return e
+}
+
+// removeExpired removes the expired key and propagates the deletion, the replica keeps the key
+// hidden from the clients until the deletion is replicated.
+func (c *call) removeExpired(key []byte) {
+	if c.master != nil {
+		return
+	}
+	c.remove(key)
+	c.propagated = append(c.propagated, query("DEL", key))
+}
+
+// set sets the value of the key and clears its expiry.
+func (c *call) set(key []byte, value any) *entry {
+	if e := c.lookup(key); e != nil {
+		e.value = value
+		c.persist(key, e)
+		return e
+	}
+	e := &entry{value: value, epoch: c.epoch}
+	c.keys.put(string(key), e)
+	c.signal(key)
+	return e
+}
+
+// remove removes the key, false will be returned if it does not exist.
+func (s *Store) remove(key []byte) bool {
+	if !s.keys.delete(string(key)) {
+		return false
+	}
+	delete(s.expires, string(key))
+	return true
+}
+
+// expire sets the expiry of the key, the key is removed and the deletion is propagated if the time
+// has passed, false will be returned then.
+func (c *call) expire(key []byte, e *entry, at int64) bool {
+	if at <= c.now && !c.loading && !c.replicated {
+		c.remove(key)
+		c.propagate(query("DEL", key))
+		return false
+	}
+	e.expireAt = at
+	c.expires[string(key)] = e
+	return true
+}
+
+// persist clears the expiry of the key.
+func (c *call) persist(key []byte, e *entry) bool {
+	if e.expireAt == 0 {
+		return false
+	}
+	e.expireAt = 0
+	delete(c.expires, string(key))
+	return true
+}
+
+// activeExpire removes the expired keys periodically.
+func (s *Store) activeExpire() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.config.ActiveExpireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closeCh:
+			return
+		case <-ticker.C:
+		}
+		// a quarter of the interval at most is spent in a round, so the commands are not starved.
+		deadline := time.Now().Add(s.config.ActiveExpireInterval / 4)
+		for s.expireCycle() {
+			if time.Now().After(deadline) {
+				break
+			}
+		}
+	}
+}
+
+// expireCycle removes the expired keys of the samples, true will be returned if more than
+// a quarter of them are expired.
+func (s *Store) expireCycle() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// the replica waits for the deletions from the primary.
+	if s.master != nil {
+		return false
+	}
+	now := s.now()
+	sampled, expired := 0, 0
+	// the iteration order of the map is random.
+	for key, e := range s.expires {
+		if sampled == s.config.ActiveExpireSamples {
+			break
+		}
+		sampled++
+		if e.expireAt <= now {
+			s.keys.delete(key)
+			delete(s.expires, key)
+			s.feed([]beam.Query{query("DEL", []byte(key))})
+			expired++
+		}
+	}
+	return expired*4 > sampled
+}
+
+func wrongArgs(command string) beam.Reply {
+	return beam.NewErrorsReply("ERR wrong number of arguments for '" + command + "' command")
+}
+
+var (
+	okReply         = beam.NewSimpleStringsReply("OK")
+	nilReply        = beam.NewBulkStringsReplyRaw(nil)
+	wrongTypeReply  = beam.NewErrorsReply("WRONGTYPE Operation against a key holding the wrong kind of value")
+	syntaxErrReply  = beam.NewErrorsReply("ERR syntax error")
+	notIntegerReply = beam.NewErrorsReply("ERR value is not an integer or out of range")
+	notFloatReply   = beam.NewErrorsReply("ERR value is not a valid float")
+)
+
+// clone copies the argument which is only valid during the command, the result is never nil.
+func clone(b []byte) []byte {
+	return append(make([]byte, 0, len(b)), b...)
+}
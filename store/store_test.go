@@ -0,0 +1,112 @@
+package store
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caeret/beam"
+	"github.com/caeret/beam/beamtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// clock is the time of the store in the tests, in milliseconds.
+type clock struct {
+	atomic.Int64
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.Add(d.Milliseconds())
+}
+
+func newTestStore(t *testing.T, config Config) (*Store, *clock, *beamtest.Conn) {
+	clk := new(clock)
+	clk.Store(1700000000000)
+	s, err := newStore(config, clk.Load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, clk, beamtest.NewServer(t, s, beam.Config{}).Dial()
+}
+
+func TestStore_Handle(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertError(t, "ERR unknown command 'FOO'", conn.Do("foo"))
+	beamtest.AssertError(t, "ERR wrong number of arguments for 'get' command", conn.Do("GET"))
+	beamtest.AssertError(t, "ERR wrong number of arguments for 'get' command", conn.Do("GET", "a", "b"))
+	beamtest.AssertError(t, "ERR wrong number of arguments for 'set' command", conn.Do("set", "a"))
+	beamtest.AssertOK(t, conn.Do("set", "a", "1"))
+	beamtest.AssertBulkString(t, "1", conn.Do("Get", "a"))
+}
+
+func TestStore_HandlePanic(t *testing.T) {
+	commands["PANIC"] = &command{name: "panic", arity: 1, fn: func(c *call) beam.Reply {
+		panic("boom")
+	}}
+	t.Cleanup(func() { delete(commands, "PANIC") })
+	_, _, conn := newTestStore(t, Config{})
+
+	// the lock is released, so the commands after the panic are still served.
+	beamtest.AssertError(t, "ERR internal error", conn.Do("PANIC"))
+	beamtest.AssertOK(t, conn.Do("SET", "a", "1"))
+	beamtest.AssertBulkString(t, "1", conn.Do("GET", "a"))
+}
+
+func TestStore_LazyExpire(t *testing.T) {
+	assert := assert.New(t)
+	// the active expiry never runs during the test.
+	s, clk, conn := newTestStore(t, Config{ActiveExpireInterval: time.Hour})
+
+	beamtest.AssertOK(t, conn.Do("SET", "foo", "bar", "PX", "100"))
+	clk.advance(time.Millisecond * 99)
+	beamtest.AssertBulkString(t, "bar", conn.Do("GET", "foo"))
+	clk.advance(time.Millisecond)
+	s.mutex.Lock()
+	assert.Equal(1, s.keys.len())
+	s.mutex.Unlock()
+
+	beamtest.AssertNil(t, conn.Do("GET", "foo"))
+	s.mutex.Lock()
+	assert.Zero(s.keys.len())
+	assert.Empty(s.expires)
+	s.mutex.Unlock()
+}
+
+func TestStore_ActiveExpire(t *testing.T) {
+	s, clk, conn := newTestStore(t, Config{ActiveExpireInterval: time.Millisecond * 10})
+
+	for i := 0; i < 100; i++ {
+		beamtest.AssertOK(t, conn.Do("SET", "key"+string(rune('a'+i%26))+string(rune('a'+i/26)), "v", "EX", "10"))
+	}
+	beamtest.AssertOK(t, conn.Do("SET", "persistent", "v"))
+	clk.advance(time.Second * 10)
+
+	// the expired keys are removed without being accessed.
+	assert.Eventually(t, func() bool {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return s.keys.len() == 1 && len(s.expires) == 0
+	}, time.Second, time.Millisecond*10)
+	beamtest.AssertBulkString(t, "v", conn.Do("GET", "persistent"))
+}
+
+func TestParseInt(t *testing.T) {
+	assert := assert.New(t)
+
+	for s, expected := range map[string]int64{
+		"0":                    0,
+		"-1":                   -1,
+		"9223372036854775807":  9223372036854775807,
+		"-9223372036854775808": -9223372036854775808,
+	} {
+		n, ok := parseInt([]byte(s))
+		assert.True(ok, s)
+		assert.Equal(expected, n, s)
+	}
+	for _, s := range []string{"", "+1", "01", "-0", " 1", "1 ", "1.0", "9223372036854775808", "-"} {
+		_, ok := parseInt([]byte(s))
+		assert.False(ok, s)
+	}
+}
@@ -0,0 +1,343 @@
+package store
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// streamID is the ID of the stream entries, which is the unix time in milliseconds and the sequence
+// number of the entries added in the same millisecond.
+type streamID struct {
+	ms, seq uint64
+}
+
+var maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+
+// parseStreamID parses the ID in the form of ms-seq, the seq is used if only ms is specified.
+func parseStreamID(b []byte, seq uint64) (streamID, bool) {
+	msPart, seqPart, found := strings.Cut(string(b), "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	if found {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return streamID{}, false
+		}
+	}
+	return streamID{ms, seq}, true
+}
+
+func (id streamID) compare(other streamID) int {
+	if c := cmp.Compare(id.ms, other.ms); c != 0 {
+		return c
+	}
+	return cmp.Compare(id.seq, other.seq)
+}
+
+func (id streamID) isZero() bool {
+	return id == streamID{}
+}
+
+// next retrieves the smallest ID greater than the ID, false will be returned if it is the max one.
+func (id streamID) next() (streamID, bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// prev retrieves the greatest ID smaller than the ID, false will be returned if it is 0-0.
+func (id streamID) prev() (streamID, bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{id.ms, id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+func (id streamID) append(b []byte) []byte {
+	b = strconv.AppendUint(b, id.ms, 10)
+	b = append(b, '-')
+	return strconv.AppendUint(b, id.seq, 10)
+}
+
+func (id streamID) String() string {
+	return string(id.append(nil))
+}
+
+// streamEntry is an entry of the stream, the fields are the field value pairs.
+type streamEntry struct {
+	id     streamID
+	fields [][]byte
+}
+
+// stream is the value of the stream keys, the entries are ordered by the IDs in a slice since they
+// are appended at the tail and trimmed from the head mostly.
+type stream struct {
+	entries []streamEntry
+	// lastID is the ID of the last added entry, which may have been deleted.
+	lastID streamID
+	// maxDeletedID is the max ID of the entries deleted by XDEL.
+	maxDeletedID streamID
+	// entriesAdded is the number of the entries ever added.
+	entriesAdded int64
+	groups       map[string]*consumerGroup
+}
+
+// consumerGroup tracks the entries delivered to the consumers of the group.
+type consumerGroup struct {
+	name string
+	// lastID is the ID of the last delivered entry.
+	lastID streamID
+	// entriesRead is the logical counter of the last delivered entry, -1 if it is unknown.
+	entriesRead int64
+	// pending is the pending entries list, which are delivered but not acknowledged.
+	pending   map[streamID]*pendingEntry
+	consumers map[string]*consumer
+}
+
+type consumer struct {
+	name string
+	// seenTime is the time of the last attempted interaction, such as XREADGROUP and XCLAIM.
+	seenTime int64
+	// activeTime is the time of the last successful interaction, -1 if there is none.
+	activeTime int64
+	pending    map[streamID]*pendingEntry
+}
+
+// pendingEntry is an entry delivered to the consumer but not acknowledged.
+type pendingEntry struct {
+	id            streamID
+	consumer      *consumer
+	deliveryTime  int64
+	deliveryCount int64
+}
+
+func newStream() *stream {
+	return &stream{groups: make(map[string]*consumerGroup)}
+}
+
+func (s *stream) len() int {
+	return len(s.entries)
+}
+
+// search retrieves the index of the first entry whose ID is not less than the ID.
+func (s *stream) search(id streamID) int {
+	i, _ := slices.BinarySearchFunc(s.entries, id, func(e streamEntry, id streamID) int {
+		return e.id.compare(id)
+	})
+	return i
+}
+
+// get retrieves the entry of the ID, nil will be returned if it does not exist.
+func (s *stream) get(id streamID) *streamEntry {
+	i := s.search(id)
+	if i < len(s.entries) && s.entries[i].id == id {
+		return &s.entries[i]
+	}
+	return nil
+}
+
+// rangeEntries retrieves at most count entries between start and end inclusively, in reverse order
+// if rev is true, all of them are retrieved if count is not positive.
+func (s *stream) rangeEntries(start, end streamID, count int, rev bool) []streamEntry {
+	if start.compare(end) > 0 {
+		return nil
+	}
+	from, to := s.search(start), len(s.entries)
+	if next, ok := end.next(); ok {
+		to = s.search(next)
+	}
+	entries := s.entries[from:to]
+	if count > 0 && len(entries) > count {
+		if rev {
+			entries = entries[len(entries)-count:]
+		} else {
+			entries = entries[:count]
+		}
+	}
+	if rev {
+		entries = slices.Clone(entries)
+		slices.Reverse(entries)
+	}
+	return entries
+}
+
+// add appends the entry whose ID must be greater than the last ID.
+func (s *stream) add(id streamID, fields [][]byte) {
+	s.entries = append(s.entries, streamEntry{id: id, fields: fields})
+	s.lastID = id
+	s.entriesAdded++
+}
+
+// delete deletes the entry of the ID, false will be returned if it does not exist.
+func (s *stream) delete(id streamID) bool {
+	i := s.search(id)
+	if i == len(s.entries) || s.entries[i].id != id {
+		return false
+	}
+	s.entries = slices.Delete(s.entries, i, i+1)
+	if id.compare(s.maxDeletedID) > 0 {
+		s.maxDeletedID = id
+	}
+	return true
+}
+
+// trim deletes the entries from the head while evict reports true, at most limit entries are deleted
+// if it is positive.
+func (s *stream) trim(evict func(i int, e streamEntry) bool, limit int) int {
+	n := 0
+	for n < len(s.entries) && (limit <= 0 || n < limit) && evict(n, s.entries[n]) {
+		n++
+	}
+	s.entries = slices.Delete(s.entries, 0, n)
+	return n
+}
+
+// firstID retrieves the ID of the first entry, 0-0 will be returned if the stream is empty.
+func (s *stream) firstID() streamID {
+	if len(s.entries) == 0 {
+		return streamID{}
+	}
+	return s.entries[0].id
+}
+
+// hasTombstones reports whether there are the entries deleted by XDEL after the ID inclusively.
+func (s *stream) hasTombstones(id streamID) bool {
+	if len(s.entries) == 0 || s.maxDeletedID.isZero() {
+		return false
+	}
+	return s.maxDeletedID.compare(id) >= 0
+}
+
+// entriesReadAt estimates the logical counter of the entry of the ID as redis does, -1 will be
+// returned if it can not be determined because of the deleted entries.
+func (s *stream) entriesReadAt(id streamID) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+	if len(s.entries) == 0 && id.compare(s.lastID) < 1 {
+		return s.entriesAdded
+	}
+	switch c := id.compare(s.lastID); {
+	case c == 0:
+		return s.entriesAdded
+	case c > 0:
+		return -1
+	}
+	// the counter is known if no entry in the stream is deleted.
+	first := s.firstID()
+	if s.maxDeletedID.isZero() || s.maxDeletedID.compare(first) < 0 {
+		switch c := id.compare(first); {
+		case c < 0:
+			return s.entriesAdded - int64(len(s.entries))
+		case c == 0:
+			return s.entriesAdded - int64(len(s.entries)) + 1
+		}
+	}
+	return -1
+}
+
+// lag retrieves the number of the entries not delivered to the group, false will be returned if it
+// can not be determined.
+func (s *stream) lag(g *consumerGroup) (int64, bool) {
+	if s.entriesAdded == 0 {
+		return 0, true
+	}
+	if g.entriesRead >= 0 && !s.hasTombstones(g.lastID) {
+		return s.entriesAdded - g.entriesRead, true
+	}
+	if read := s.entriesReadAt(g.lastID); read >= 0 {
+		return s.entriesAdded - read, true
+	}
+	return 0, false
+}
+
+func newConsumerGroup(name string, lastID streamID, entriesRead int64) *consumerGroup {
+	return &consumerGroup{
+		name:        name,
+		lastID:      lastID,
+		entriesRead: entriesRead,
+		pending:     make(map[streamID]*pendingEntry),
+		consumers:   make(map[string]*consumer),
+	}
+}
+
+// consumer retrieves the consumer of the name, it is created if it does not exist.
+func (g *consumerGroup) consumer(name string, now int64) *consumer {
+	cons := g.consumers[name]
+	if cons == nil {
+		cons = &consumer{name: name, seenTime: now, activeTime: -1, pending: make(map[streamID]*pendingEntry)}
+		g.consumers[name] = cons
+	}
+	return cons
+}
+
+// deliver marks the entry delivered to the group after the last delivered one, the counter is
+// incremented if it is still valid.
+func (g *consumerGroup) deliver(s *stream, id streamID) {
+	if id.compare(g.lastID) <= 0 {
+		return
+	}
+	if g.entriesRead >= 0 && !s.hasTombstones(id) {
+		g.entriesRead++
+	} else if s.entriesAdded > 0 {
+		g.entriesRead = s.entriesReadAt(id)
+	}
+	g.lastID = id
+}
+
+// assign adds the entry to the pending entries list of the consumer as a new delivery, the entry
+// pending for another consumer is moved.
+func (g *consumerGroup) assign(id streamID, cons *consumer, now int64) *pendingEntry {
+	pe := g.pending[id]
+	if pe == nil {
+		pe = &pendingEntry{id: id}
+		g.pending[id] = pe
+	}
+	pe.move(cons)
+	pe.deliveryTime = now
+	pe.deliveryCount = 1
+	return pe
+}
+
+// move moves the pending entry to the pending entries list of the consumer.
+func (pe *pendingEntry) move(cons *consumer) {
+	if pe.consumer != nil {
+		delete(pe.consumer.pending, pe.id)
+	}
+	pe.consumer = cons
+	cons.pending[pe.id] = pe
+}
+
+// ack removes the entry from the pending entries lists, false will be returned if it is not pending.
+func (g *consumerGroup) ack(id streamID) bool {
+	pe := g.pending[id]
+	if pe == nil {
+		return false
+	}
+	delete(g.pending, id)
+	delete(pe.consumer.pending, id)
+	return true
+}
+
+// sortedPending retrieves the pending entries ordered by the IDs.
+func sortedPending(pending map[streamID]*pendingEntry) []*pendingEntry {
+	entries := make([]*pendingEntry, 0, len(pending))
+	for _, pe := range pending {
+		entries = append(entries, pe)
+	}
+	slices.SortFunc(entries, func(a, b *pendingEntry) int {
+		return a.id.compare(b.id)
+	})
+	return entries
+}
@@ -0,0 +1,795 @@
+package store
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/caeret/beam"
+)
+
+func noGroupReply(key []byte, group string) beam.Reply {
+	return beam.NewErrorsReply("NOGROUP No such key '" + string(key) + "' or consumer group '" + group + "'")
+}
+
+// lookupGroup retrieves the stream of the key and its consumer group, the NOGROUP error will be
+// replied if either of them does not exist.
+func (c *call) lookupGroup(key []byte, group string) (*stream, *consumerGroup, beam.Reply) {
+	s, reply := c.lookupStream(key)
+	if reply != nil {
+		return nil, nil, reply
+	}
+	if s == nil || s.groups[group] == nil {
+		return nil, nil, noGroupReply(key, group)
+	}
+	return s, s.groups[group], nil
+}
+
+// xgroupArity is the min and max numbers of the arguments of the XGROUP subcommands.
+var xgroupArity = map[string][2]int{
+	"create":         {4, 7},
+	"setid":          {4, 6},
+	"destroy":        {3, 3},
+	"createconsumer": {4, 4},
+	"delconsumer":    {4, 4},
+}
+
+// xgroupCommand implements the subcommands CREATE, SETID, DESTROY, CREATECONSUMER and DELCONSUMER.
+func xgroupCommand(c *call) beam.Reply {
+	sub := strings.ToLower(string(c.args[0]))
+	r, ok := xgroupArity[sub]
+	if !ok {
+		return beam.NewErrorsReply("ERR unknown subcommand '" + string(c.args[0]) + "'. Try XGROUP HELP.")
+	}
+	if len(c.args) < r[0] || len(c.args) > r[1] {
+		return wrongArgs("xgroup|" + sub)
+	}
+	key, name := c.args[1], string(c.args[2])
+
+	// the options of CREATE and SETID.
+	mkStream := false
+	entriesRead := int64(-1)
+	if sub == "create" || sub == "setid" {
+		opts := c.args[4:]
+		for i := 0; i < len(opts); i++ {
+			opt := strings.ToUpper(string(opts[i]))
+			switch {
+			case opt == "MKSTREAM" && sub == "create":
+				mkStream = true
+			case opt == "ENTRIESREAD" && i+1 < len(opts):
+				i++
+				if entriesRead, ok = parseInt(opts[i]); !ok {
+					return notIntegerReply
+				}
+				if entriesRead < -1 {
+					return beam.NewErrorsReply("ERR value for ENTRIESREAD must be positive or -1")
+				}
+			default:
+				return syntaxErrReply
+			}
+		}
+	}
+
+	s, reply := c.lookupStream(key)
+	if reply != nil {
+		return reply
+	}
+	if s == nil && !mkStream {
+		return beam.NewErrorsReply("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	}
+	var g *consumerGroup
+	if s != nil {
+		g = s.groups[name]
+	}
+	if g == nil && sub != "create" {
+		return beam.NewErrorsReply("NOGROUP No such consumer group '" + name + "' for key name '" + string(key) + "'")
+	}
+
+	// the ID of CREATE and SETID, "$" is the last ID of the stream.
+	var id streamID
+	if sub == "create" || sub == "setid" {
+		if string(c.args[3]) == "$" {
+			if s != nil {
+				id = s.lastID
+			}
+		} else if id, ok = parseStreamID(c.args[3], 0); !ok {
+			return invalidStreamIDReply
+		}
+	}
+
+	switch sub {
+	case "create":
+		if g != nil {
+			return beam.NewErrorsReply("BUSYGROUP Consumer Group name already exists")
+		}
+		if s == nil {
+			s = newStream()
+			c.set(key, s)
+		}
+		s.groups[name] = newConsumerGroup(name, id, entriesRead)
+		return okReply
+	case "setid":
+		g.lastID, g.entriesRead = id, entriesRead
+		return okReply
+	case "destroy":
+		delete(s.groups, name)
+		return integer(1)
+	case "createconsumer":
+		if g.consumers[string(c.args[3])] != nil {
+			return integer(0)
+		}
+		g.consumer(string(c.args[3]), c.now)
+		return integer(1)
+	}
+	// DELCONSUMER replies the number of the pending entries of the consumer.
+	cons := g.consumers[string(c.args[3])]
+	if cons == nil {
+		return integer(0)
+	}
+	n := len(cons.pending)
+	for id := range cons.pending {
+		delete(g.pending, id)
+	}
+	delete(g.consumers, cons.name)
+	return integer(int64(n))
+}
+
+// xreadgroupCommand implements XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds]
+// [NOACK] STREAMS key [key ...] id [id ...]. The ID ">" reads the entries never delivered to the
+// group, or the pending entries of the consumer after the ID are read again.
+func xreadgroupCommand(c *call) beam.Reply {
+	args, reply := c.parseXreadArgs(true)
+	if reply != nil {
+		return reply
+	}
+	// the groups and the IDs are checked before any entry is delivered.
+	ids := make([]streamID, len(args.keys))
+	for i, key := range args.keys {
+		s, reply := c.lookupStream(key)
+		if reply != nil {
+			return reply
+		}
+		if s == nil || s.groups[args.group] == nil {
+			return beam.NewErrorsReply("NOGROUP No such key '" + string(key) + "' or consumer group '" + args.group + "' in XREADGROUP with GROUP option")
+		}
+		switch string(args.ids[i]) {
+		case ">":
+			ids[i] = maxStreamID
+		case "$":
+			return beam.NewErrorsReply("ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+		default:
+			var ok bool
+			if ids[i], ok = parseStreamID(args.ids[i], 0); !ok {
+				return invalidStreamIDReply
+			}
+		}
+	}
+
+	// the deliveries are logged instead, so the history reading is not logged.
+	c.propagate()
+	var results []beam.Reply
+	for i, key := range args.keys {
+		s, _ := c.lookupStream(key)
+		g := s.groups[args.group]
+		cons := c.consumer(key, g, args.consumer)
+		cons.seenTime = c.now
+		if string(args.ids[i]) != ">" {
+			results = append(results, streamReply(key, c.history(s, cons, ids[i], args.count)))
+			continue
+		}
+		if entries := c.deliver(key, s, g, cons, args); len(entries) > 0 {
+			results = append(results, streamReply(key, entriesReply(entries)))
+		}
+	}
+	if len(results) > 0 {
+		return beam.NewNestedArraysReply(results...)
+	}
+	if args.block < 0 {
+		return nilArrayReply
+	}
+	c.block(&waiter{
+		keys: args.keys,
+		serve: func(c *call, key []byte) beam.Reply {
+			s, _ := c.lookupStream(key)
+			if s == nil {
+				return nil
+			}
+			g := s.groups[args.group]
+			if g == nil {
+				return beam.NewErrorsReply("NOGROUP the consumer group this client was blocked on no longer exists")
+			}
+			cons := c.consumer(key, g, args.consumer)
+			cons.seenTime = c.now
+			entries := c.deliver(key, s, g, cons, args)
+			if len(entries) == 0 {
+				return nil
+			}
+			return beam.NewNestedArraysReply(streamReply(key, entriesReply(entries)))
+		},
+		timeout:      args.block,
+		timeoutReply: nilArrayReply,
+	})
+	return nil
+}
+
+// deliver delivers the entries never delivered to the group to the consumer, the entries are added
+// to the pending entries list unless NOACK is specified. The deliveries are logged as XCLAIM and
+// XGROUP SETID.
+func (c *call) deliver(key []byte, s *stream, g *consumerGroup, cons *consumer, args xreadArgs) []streamEntry {
+	entries := s.entriesAfter(g.lastID, args.count)
+	for _, e := range entries {
+		g.deliver(s, e.id)
+		if !args.noAck {
+			c.propagateClaim(key, g, g.assign(e.id, cons, c.now))
+		}
+	}
+	if len(entries) > 0 {
+		cons.activeTime = c.now
+		c.propagateLastID(key, g)
+	}
+	return entries
+}
+
+// consumer retrieves the consumer of the group, the created one is logged as XGROUP CREATECONSUMER.
+func (c *call) consumer(key []byte, g *consumerGroup, name string) *consumer {
+	if cons := g.consumers[name]; cons != nil {
+		return cons
+	}
+	c.propagated = append(c.propagated, query("XGROUP", []byte("CREATECONSUMER"), key, []byte(g.name), []byte(name)))
+	return g.consumer(name, c.now)
+}
+
+// propagateClaim logs the pending entry as XCLAIM with its delivery time and count.
+func (c *call) propagateClaim(key []byte, g *consumerGroup, pe *pendingEntry) {
+	c.propagated = append(c.propagated, query("XCLAIM", key, []byte(g.name), []byte(pe.consumer.name),
+		[]byte("0"), pe.id.append(nil), []byte("TIME"), strconv.AppendInt(nil, pe.deliveryTime, 10),
+		[]byte("RETRYCOUNT"), strconv.AppendInt(nil, pe.deliveryCount, 10), []byte("FORCE"), []byte("JUSTID")))
+}
+
+// propagateLastID logs the last delivered ID of the group as XGROUP SETID.
+func (c *call) propagateLastID(key []byte, g *consumerGroup) {
+	c.propagated = append(c.propagated, query("XGROUP", []byte("SETID"), key, []byte(g.name),
+		g.lastID.append(nil), []byte("ENTRIESREAD"), strconv.AppendInt(nil, g.entriesRead, 10)))
+}
+
+// history replies the pending entries of the consumer after the ID, the deleted entries are replied
+// with the nil fields.
+func (c *call) history(s *stream, cons *consumer, id streamID, count int) beam.Reply {
+	var pending []*pendingEntry
+	for _, pe := range sortedPending(cons.pending) {
+		if count > 0 && len(pending) == count {
+			break
+		}
+		if pe.id.compare(id) > 0 {
+			pending = append(pending, pe)
+		}
+	}
+	b := beam.AppendArraysHeader(nil, len(pending))
+	for _, pe := range pending {
+		if e := s.get(pe.id); e != nil {
+			b = appendEntry(b, *e)
+			continue
+		}
+		b = beam.AppendArraysHeader(b, 2)
+		b = beam.AppendBulkStringsRaw(b, pe.id.append(nil))
+		b = beam.AppendArraysHeader(b, -1)
+	}
+	return b
+}
+
+func xackCommand(c *call) beam.Reply {
+	ids := make([]streamID, len(c.args)-2)
+	for i, arg := range c.args[2:] {
+		var ok bool
+		if ids[i], ok = parseStreamID(arg, 0); !ok {
+			return invalidStreamIDReply
+		}
+	}
+	s, reply := c.lookupStream(c.args[0])
+	if reply != nil || s == nil || s.groups[string(c.args[1])] == nil {
+		return orZero(reply)
+	}
+	g := s.groups[string(c.args[1])]
+	n := 0
+	for _, id := range ids {
+		if g.ack(id) {
+			n++
+		}
+	}
+	return integer(int64(n))
+}
+
+// xpendingCommand implements XPENDING key group [[IDLE min-idle-time] start end count [consumer]],
+// the summary of the pending entries is replied without the range.
+func xpendingCommand(c *call) beam.Reply {
+	key, name := c.args[0], string(c.args[1])
+	opts := c.args[2:]
+	extended := len(opts) > 0
+	var (
+		minIdle    int64
+		start, end streamID
+		count      int64
+		consumer   []byte
+	)
+	if extended {
+		if strings.EqualFold(string(opts[0]), "IDLE") && len(opts) > 1 {
+			var ok bool
+			if minIdle, ok = parseInt(opts[1]); !ok {
+				return notIntegerReply
+			}
+			opts = opts[2:]
+		}
+		if len(opts) < 3 || len(opts) > 4 {
+			return syntaxErrReply
+		}
+		var reply beam.Reply
+		if start, reply = parseRangeID(opts[0], false); reply != nil {
+			return reply
+		}
+		if end, reply = parseRangeID(opts[1], true); reply != nil {
+			return reply
+		}
+		var ok bool
+		if count, ok = parseInt(opts[2]); !ok {
+			return notIntegerReply
+		}
+		if len(opts) == 4 {
+			consumer = opts[3]
+		}
+	}
+	_, g, reply := c.lookupGroup(key, name)
+	if reply != nil {
+		return reply
+	}
+
+	if !extended {
+		if len(g.pending) == 0 {
+			b := beam.AppendArraysHeader(nil, 4)
+			b = beam.AppendIntegers(b, 0)
+			b = beam.AppendBulkStringsRaw(b, nil)
+			b = beam.AppendBulkStringsRaw(b, nil)
+			return beam.AppendArraysHeader(b, -1)
+		}
+		pending := sortedPending(g.pending)
+		var consumers []beam.Reply
+		for _, cons := range sortedConsumers(g) {
+			if len(cons.pending) > 0 {
+				consumers = append(consumers, beam.NewArraysReply(cons.name, strconv.Itoa(len(cons.pending))))
+			}
+		}
+		return beam.NewNestedArraysReply(
+			integer(int64(len(pending))),
+			idReply(pending[0].id),
+			idReply(pending[len(pending)-1].id),
+			beam.NewNestedArraysReply(consumers...),
+		)
+	}
+
+	source := g.pending
+	if consumer != nil {
+		cons := g.consumers[string(consumer)]
+		if cons == nil {
+			return emptyArrayReply
+		}
+		source = cons.pending
+	}
+	var entries []beam.Reply
+	for _, pe := range sortedPending(source) {
+		if int64(len(entries)) >= count {
+			break
+		}
+		idle := max(c.now-pe.deliveryTime, 0)
+		if pe.id.compare(start) < 0 || pe.id.compare(end) > 0 || idle < minIdle {
+			continue
+		}
+		b := beam.AppendArraysHeader(nil, 4)
+		b = beam.AppendBulkStringsRaw(b, pe.id.append(nil))
+		b = beam.AppendBulkStrings(b, pe.consumer.name)
+		b = beam.AppendIntegers(b, idle)
+		b = beam.AppendIntegers(b, pe.deliveryCount)
+		entries = append(entries, b)
+	}
+	return beam.NewNestedArraysReply(entries...)
+}
+
+// sortedConsumers retrieves the consumers of the group ordered by the names.
+func sortedConsumers(g *consumerGroup) []*consumer {
+	consumers := make([]*consumer, 0, len(g.consumers))
+	for _, cons := range g.consumers {
+		consumers = append(consumers, cons)
+	}
+	slices.SortFunc(consumers, func(a, b *consumer) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return consumers
+}
+
+// claimArgs is the options of XCLAIM and XAUTOCLAIM.
+type claimArgs struct {
+	minIdle int64
+	// deliveryTime is the delivery time of the claimed entries.
+	deliveryTime int64
+	// retryCount is the delivery count of the claimed entries if it is not negative, or the count
+	// is incremented unless justID.
+	retryCount int64
+	force      bool
+	justID     bool
+}
+
+// claim moves the pending entry to the consumer if it has been idle long enough, the entry deleted
+// from the stream is acknowledged, false will be returned if it is not claimed. The idle time of the
+// entry created by FORCE without the consumer is not checked. The claimed and acknowledged entries
+// are logged as XCLAIM and XACK.
+func (c *call) claim(key []byte, s *stream, g *consumerGroup, cons *consumer, pe *pendingEntry, args claimArgs) bool {
+	if s.get(pe.id) == nil {
+		if g.ack(pe.id) {
+			c.propagated = append(c.propagated, query("XACK", key, []byte(g.name), pe.id.append(nil)))
+		}
+		return false
+	}
+	if pe.consumer != nil && args.minIdle > 0 && c.now-pe.deliveryTime < args.minIdle {
+		return false
+	}
+	pe.move(cons)
+	pe.deliveryTime = args.deliveryTime
+	if args.retryCount >= 0 {
+		pe.deliveryCount = args.retryCount
+	} else if !args.justID {
+		pe.deliveryCount++
+	}
+	cons.activeTime = c.now
+	c.propagateClaim(key, g, pe)
+	return true
+}
+
+// xclaimCommand implements XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms]
+// [TIME unix-time-milliseconds] [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid].
+func xclaimCommand(c *call) beam.Reply {
+	key, name := c.args[0], string(c.args[1])
+	minIdle, ok := parseInt(c.args[3])
+	if !ok {
+		return beam.NewErrorsReply("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+	args := claimArgs{minIdle: max(minIdle, 0), deliveryTime: c.now, retryCount: -1}
+	// the IDs are followed by the options.
+	var ids []streamID
+	i := 4
+	for ; i < len(c.args); i++ {
+		id, ok := parseStreamID(c.args[i], 0)
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return invalidStreamIDReply
+	}
+	var lastID *streamID
+	for ; i < len(c.args); i++ {
+		opt := strings.ToUpper(string(c.args[i]))
+		hasValue := i+1 < len(c.args)
+		switch {
+		case opt == "FORCE":
+			args.force = true
+		case opt == "JUSTID":
+			args.justID = true
+		case (opt == "IDLE" || opt == "TIME" || opt == "RETRYCOUNT") && hasValue:
+			i++
+			n, ok := parseInt(c.args[i])
+			if !ok {
+				return notIntegerReply
+			}
+			switch opt {
+			case "IDLE":
+				args.deliveryTime = c.now - max(n, 0)
+			case "TIME":
+				args.deliveryTime = n
+			default:
+				args.retryCount = max(n, 0)
+			}
+		case opt == "LASTID" && hasValue:
+			i++
+			id, ok := parseStreamID(c.args[i], 0)
+			if !ok {
+				return invalidStreamIDReply
+			}
+			lastID = &id
+		default:
+			return beam.NewErrorsReply("ERR Unrecognized XCLAIM option '" + string(c.args[i]) + "'")
+		}
+	}
+
+	s, g, reply := c.lookupGroup(key, name)
+	if reply != nil {
+		return reply
+	}
+	c.propagate()
+	if lastID != nil && lastID.compare(g.lastID) > 0 {
+		g.lastID = *lastID
+		c.propagateLastID(key, g)
+	}
+	cons := c.consumer(key, g, string(c.args[2]))
+	cons.seenTime = c.now
+	var claimed []streamID
+	for _, id := range ids {
+		pe := g.pending[id]
+		if pe == nil {
+			// FORCE creates the pending entry of the existing entry.
+			if !args.force || s.get(id) == nil {
+				continue
+			}
+			pe = &pendingEntry{id: id, deliveryTime: c.now, deliveryCount: 1}
+			g.pending[id] = pe
+		}
+		if c.claim(key, s, g, cons, pe, args) {
+			claimed = append(claimed, id)
+		}
+	}
+	return claimedReply(s, claimed, args.justID)
+}
+
+// claimedReply replies the claimed entries, or their IDs with justID.
+func claimedReply(s *stream, ids []streamID, justID bool) beam.Reply {
+	b := beam.AppendArraysHeader(nil, len(ids))
+	for _, id := range ids {
+		if justID {
+			b = beam.AppendBulkStringsRaw(b, id.append(nil))
+		} else {
+			b = appendEntry(b, *s.get(id))
+		}
+	}
+	return b
+}
+
+// xautoclaimCommand implements XAUTOCLAIM key group consumer min-idle-time start [COUNT count]
+// [JUSTID], the cursor to continue, the claimed entries and the IDs of the deleted entries are replied.
+func xautoclaimCommand(c *call) beam.Reply {
+	key, name := c.args[0], string(c.args[1])
+	minIdle, ok := parseInt(c.args[3])
+	if !ok {
+		return beam.NewErrorsReply("ERR Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	start, reply := parseRangeID(c.args[4], false)
+	if reply != nil {
+		return reply
+	}
+	args := claimArgs{minIdle: max(minIdle, 0), deliveryTime: c.now, retryCount: -1}
+	count := int64(100)
+	opts := c.args[5:]
+	for i := 0; i < len(opts); i++ {
+		opt := strings.ToUpper(string(opts[i]))
+		switch {
+		case opt == "JUSTID":
+			args.justID = true
+		case opt == "COUNT" && i+1 < len(opts):
+			i++
+			if count, ok = parseInt(opts[i]); !ok {
+				return notIntegerReply
+			}
+			// the attempts are limited to 10 times of the count.
+			if count < 1 || count > math.MaxInt64/10 {
+				return beam.NewErrorsReply("ERR COUNT must be > 0")
+			}
+		default:
+			return syntaxErrReply
+		}
+	}
+
+	s, g, reply := c.lookupGroup(key, name)
+	if reply != nil {
+		return reply
+	}
+	c.propagate()
+	cons := c.consumer(key, g, string(c.args[2]))
+	cons.seenTime = c.now
+	var claimed, deleted []streamID
+	next := streamID{}
+	attempts := count * 10
+	for _, pe := range sortedPending(g.pending) {
+		if pe.id.compare(start) < 0 {
+			continue
+		}
+		if attempts == 0 || int64(len(claimed)) == count {
+			next = pe.id
+			break
+		}
+		attempts--
+		if s.get(pe.id) == nil {
+			deleted = append(deleted, pe.id)
+		}
+		if c.claim(key, s, g, cons, pe, args) {
+			claimed = append(claimed, pe.id)
+		}
+	}
+	deletedReply := beam.AppendArraysHeader(nil, len(deleted))
+	for _, id := range deleted {
+		deletedReply = beam.AppendBulkStringsRaw(deletedReply, id.append(nil))
+	}
+	return beam.NewNestedArraysReply(idReply(next), claimedReply(s, claimed, args.justID), deletedReply)
+}
+
+// xinfoCommand implements XINFO STREAM key [FULL [COUNT count]], XINFO GROUPS key and
+// XINFO CONSUMERS key group.
+func xinfoCommand(c *call) beam.Reply {
+	sub := strings.ToLower(string(c.args[0]))
+	switch {
+	case sub == "stream" && len(c.args) >= 2:
+	case sub == "groups" && len(c.args) == 2:
+	case sub == "consumers" && len(c.args) == 3:
+	case sub == "stream" || sub == "groups" || sub == "consumers":
+		return wrongArgs("xinfo|" + sub)
+	default:
+		return beam.NewErrorsReply("ERR unknown subcommand '" + string(c.args[0]) + "'. Try XINFO HELP.")
+	}
+	full, count := false, int64(10)
+	if sub == "stream" {
+		opts := c.args[2:]
+		if len(opts) > 0 {
+			if !strings.EqualFold(string(opts[0]), "FULL") {
+				return syntaxErrReply
+			}
+			full = true
+			opts = opts[1:]
+		}
+		if len(opts) > 0 {
+			if len(opts) != 2 || !strings.EqualFold(string(opts[0]), "COUNT") {
+				return syntaxErrReply
+			}
+			var ok bool
+			if count, ok = parseInt(opts[1]); !ok {
+				return notIntegerReply
+			}
+		}
+	}
+
+	s, reply := c.lookupStream(c.args[1])
+	if reply != nil {
+		return reply
+	}
+	if s == nil {
+		return beam.NewErrorsReply("ERR no such key")
+	}
+	switch sub {
+	case "groups":
+		groups := make([]beam.Reply, 0, len(s.groups))
+		for _, g := range sortedGroups(s) {
+			groups = append(groups, beam.NewNestedArraysReply(c.groupInfo(s, g, false)...))
+		}
+		return beam.NewNestedArraysReply(groups...)
+	case "consumers":
+		g := s.groups[string(c.args[2])]
+		if g == nil {
+			return beam.NewErrorsReply("NOGROUP No such consumer group '" + string(c.args[2]) + "' for key name '" + string(c.args[1]) + "'")
+		}
+		consumers := make([]beam.Reply, 0, len(g.consumers))
+		for _, cons := range sortedConsumers(g) {
+			inactive := int64(-1)
+			if cons.activeTime >= 0 {
+				inactive = max(c.now-cons.activeTime, 0)
+			}
+			consumers = append(consumers, beam.NewNestedArraysReply(
+				beam.NewBulkStringsReply("name"), beam.NewBulkStringsReply(cons.name),
+				beam.NewBulkStringsReply("pending"), integer(int64(len(cons.pending))),
+				beam.NewBulkStringsReply("idle"), integer(max(c.now-cons.seenTime, 0)),
+				beam.NewBulkStringsReply("inactive"), integer(inactive),
+			))
+		}
+		return beam.NewNestedArraysReply(consumers...)
+	}
+
+	info := []beam.Reply{
+		beam.NewBulkStringsReply("length"), integer(int64(s.len())),
+		beam.NewBulkStringsReply("last-generated-id"), idReply(s.lastID),
+		beam.NewBulkStringsReply("max-deleted-entry-id"), idReply(s.maxDeletedID),
+		beam.NewBulkStringsReply("entries-added"), integer(s.entriesAdded),
+		beam.NewBulkStringsReply("recorded-first-entry-id"), idReply(s.firstID()),
+	}
+	if !full {
+		first, last := nilReply, nilReply
+		if s.len() > 0 {
+			first = appendEntry(nil, s.entries[0])
+			last = appendEntry(nil, s.entries[s.len()-1])
+		}
+		info = append(info,
+			beam.NewBulkStringsReply("groups"), integer(int64(len(s.groups))),
+			beam.NewBulkStringsReply("first-entry"), first,
+			beam.NewBulkStringsReply("last-entry"), last,
+		)
+		return beam.NewNestedArraysReply(info...)
+	}
+	// the entries and the pending entries are limited by COUNT with FULL, 0 is unlimited.
+	limit := int(min(max(count, 0), math.MaxInt32))
+	groups := make([]beam.Reply, 0, len(s.groups))
+	for _, g := range sortedGroups(s) {
+		groups = append(groups, c.groupFullInfo(s, g, limit))
+	}
+	info = append(info,
+		beam.NewBulkStringsReply("entries"), entriesReply(s.rangeEntries(streamID{}, maxStreamID, limit, false)),
+		beam.NewBulkStringsReply("groups"), beam.NewNestedArraysReply(groups...),
+	)
+	return beam.NewNestedArraysReply(info...)
+}
+
+// sortedGroups retrieves the consumer groups of the stream ordered by the names.
+func sortedGroups(s *stream) []*consumerGroup {
+	groups := make([]*consumerGroup, 0, len(s.groups))
+	for _, g := range s.groups {
+		groups = append(groups, g)
+	}
+	slices.SortFunc(groups, func(a, b *consumerGroup) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return groups
+}
+
+// groupInfo retrieves the fields of the group replied by XINFO GROUPS, the numbers of the consumers
+// and the pending entries are omitted with full.
+func (c *call) groupInfo(s *stream, g *consumerGroup, full bool) []beam.Reply {
+	entriesRead, lag := nilReply, nilReply
+	if g.entriesRead >= 0 {
+		entriesRead = integer(g.entriesRead)
+	}
+	if n, ok := s.lag(g); ok {
+		lag = integer(n)
+	}
+	info := []beam.Reply{beam.NewBulkStringsReply("name"), beam.NewBulkStringsReply(g.name)}
+	if !full {
+		info = append(info,
+			beam.NewBulkStringsReply("consumers"), integer(int64(len(g.consumers))),
+			beam.NewBulkStringsReply("pending"), integer(int64(len(g.pending))),
+		)
+	}
+	info = append(info,
+		beam.NewBulkStringsReply("last-delivered-id"), idReply(g.lastID),
+		beam.NewBulkStringsReply("entries-read"), entriesRead,
+		beam.NewBulkStringsReply("lag"), lag,
+	)
+	return info
+}
+
+// groupFullInfo replies the group with its pending entries and consumers for XINFO STREAM FULL.
+func (c *call) groupFullInfo(s *stream, g *consumerGroup, limit int) beam.Reply {
+	info := c.groupInfo(s, g, true)
+	pending := sortedPending(g.pending)
+	if limit > 0 {
+		pending = pending[:min(limit, len(pending))]
+	}
+	groupPending := make([]beam.Reply, len(pending))
+	for i, pe := range pending {
+		b := beam.AppendArraysHeader(nil, 4)
+		b = beam.AppendBulkStringsRaw(b, pe.id.append(nil))
+		b = beam.AppendBulkStrings(b, pe.consumer.name)
+		b = beam.AppendIntegers(b, pe.deliveryTime)
+		b = beam.AppendIntegers(b, pe.deliveryCount)
+		groupPending[i] = b
+	}
+	var consumers []beam.Reply
+	for _, cons := range sortedConsumers(g) {
+		pending := sortedPending(cons.pending)
+		if limit > 0 {
+			pending = pending[:min(limit, len(pending))]
+		}
+		consPending := make([]beam.Reply, len(pending))
+		for i, pe := range pending {
+			b := beam.AppendArraysHeader(nil, 3)
+			b = beam.AppendBulkStringsRaw(b, pe.id.append(nil))
+			b = beam.AppendIntegers(b, pe.deliveryTime)
+			b = beam.AppendIntegers(b, pe.deliveryCount)
+			consPending[i] = b
+		}
+		consumers = append(consumers, beam.NewNestedArraysReply(
+			beam.NewBulkStringsReply("name"), beam.NewBulkStringsReply(cons.name),
+			beam.NewBulkStringsReply("seen-time"), integer(cons.seenTime),
+			beam.NewBulkStringsReply("active-time"), integer(cons.activeTime),
+			beam.NewBulkStringsReply("pel-count"), integer(int64(len(cons.pending))),
+			beam.NewBulkStringsReply("pending"), beam.NewNestedArraysReply(consPending...),
+		))
+	}
+	info = append(info,
+		beam.NewBulkStringsReply("pel-count"), integer(int64(len(g.pending))),
+		beam.NewBulkStringsReply("pending"), beam.NewNestedArraysReply(groupPending...),
+		beam.NewBulkStringsReply("consumers"), beam.NewNestedArraysReply(consumers...),
+	)
+	return beam.NewNestedArraysReply(info...)
+}
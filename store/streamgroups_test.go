@@ -0,0 +1,251 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caeret/beam"
+	"github.com/caeret/beam/beamtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_XGroup(t *testing.T) {
+	assert := assert.New(t)
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertError(t, "ERR The XGROUP subcommand requires the key to exist", conn.Do("XGROUP", "CREATE", "s", "g", "$"))
+	beamtest.AssertOK(t, conn.Do("XGROUP", "CREATE", "s", "g", "$", "MKSTREAM"))
+	beamtest.AssertInteger(t, 0, conn.Do("XLEN", "s"))
+	beamtest.AssertError(t, "BUSYGROUP Consumer Group name already exists", conn.Do("XGROUP", "CREATE", "s", "g", "$"))
+	beamtest.AssertOK(t, conn.Do("XGROUP", "CREATE", "s", "g2", "0", "ENTRIESREAD", "0"))
+	beamtest.AssertInteger(t, 1, conn.Do("XGROUP", "CREATECONSUMER", "s", "g", "alice"))
+	beamtest.AssertInteger(t, 0, conn.Do("XGROUP", "CREATECONSUMER", "s", "g", "alice"))
+
+	groups, err := conn.Do("XINFO", "GROUPS", "s").Array()
+	assert.Nil(err)
+	assert.Len(groups, 2)
+	g, err := groups[0].Map()
+	assert.Nil(err)
+	beamtest.AssertBulkString(t, "g", g["name"])
+	beamtest.AssertInteger(t, 1, g["consumers"])
+	beamtest.AssertBulkString(t, "0-0", g["last-delivered-id"])
+	beamtest.AssertNil(t, g["entries-read"])
+	beamtest.AssertInteger(t, 0, g["lag"])
+
+	beamtest.AssertOK(t, conn.Do("XGROUP", "SETID", "s", "g", "5-0", "ENTRIESREAD", "3"))
+	groups, _ = conn.Do("XINFO", "GROUPS", "s").Array()
+	g, _ = groups[0].Map()
+	beamtest.AssertBulkString(t, "5-0", g["last-delivered-id"])
+	beamtest.AssertInteger(t, 3, g["entries-read"])
+
+	beamtest.AssertInteger(t, 0, conn.Do("XGROUP", "DELCONSUMER", "s", "g", "bob"))
+	beamtest.AssertInteger(t, 0, conn.Do("XGROUP", "DELCONSUMER", "s", "g", "alice"))
+	beamtest.AssertInteger(t, 1, conn.Do("XGROUP", "DESTROY", "s", "g"))
+	beamtest.AssertError(t, "NOGROUP No such consumer group 'g' for key name 's'", conn.Do("XGROUP", "DESTROY", "s", "g"))
+	beamtest.AssertError(t, "ERR unknown subcommand 'FOO'", conn.Do("XGROUP", "FOO"))
+	beamtest.AssertError(t, "ERR wrong number of arguments for 'xgroup|create' command", conn.Do("XGROUP", "CREATE", "s"))
+	beamtest.AssertError(t, "ERR Invalid stream ID", conn.Do("XGROUP", "CREATE", "s", "g", "x"))
+}
+
+func TestStore_XReadGroup(t *testing.T) {
+	assert := assert.New(t)
+	_, clk, conn := newTestStore(t, Config{})
+
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		conn.Do("XADD", "s", id, "k", id)
+	}
+	beamtest.AssertOK(t, conn.Do("XGROUP", "CREATE", "s", "g", "0"))
+	entries := func(reply beam.Reply) []string {
+		streams, err := reply.Array()
+		assert.Nil(err)
+		assert.Len(streams, 1)
+		elems, err := streams[0].Array()
+		assert.Nil(err)
+		return entryIDs(t, elems[1])
+	}
+	assert.Equal([]string{"1-0", "2-0"}, entries(conn.Do("XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "s", ">")))
+	assert.Equal([]string{"3-0"}, entries(conn.Do("XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">")))
+	beamtest.AssertReply(t, beam.Reply("*-1\r\n"), conn.Do("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">"))
+	assert.Equal([]string{"1-0", "2-0"}, entries(conn.Do("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "0")))
+	assert.Equal([]string{"2-0"}, entries(conn.Do("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "1-0")))
+
+	clk.advance(time.Second)
+	beamtest.AssertReply(t, beam.NewNestedArraysReply(
+		beam.NewIntegersReply(3),
+		beam.NewBulkStringsReply("1-0"),
+		beam.NewBulkStringsReply("3-0"),
+		beam.NewNestedArraysReply(beam.NewArraysReply("alice", "2"), beam.NewArraysReply("bob", "1")),
+	), conn.Do("XPENDING", "s", "g"))
+	beamtest.AssertReply(t, beam.NewNestedArraysReply(
+		beam.NewNestedArraysReply(beam.NewBulkStringsReply("3-0"), beam.NewBulkStringsReply("bob"), beam.NewIntegersReply(1000), beam.NewIntegersReply(1)),
+	), conn.Do("XPENDING", "s", "g", "IDLE", "500", "(1-0", "+", "10", "bob"))
+	pending, err := conn.Do("XPENDING", "s", "g", "-", "+", "2").Array()
+	assert.Nil(err)
+	assert.Len(pending, 2)
+
+	beamtest.AssertInteger(t, 1, conn.Do("XACK", "s", "g", "1-0", "9-0"))
+	beamtest.AssertInteger(t, 0, conn.Do("XACK", "s", "missing", "1-0"))
+	// the deleted entries are replied with the nil fields.
+	beamtest.AssertInteger(t, 1, conn.Do("XDEL", "s", "2-0"))
+	beamtest.AssertReply(t, beam.NewNestedArraysReply(
+		beam.NewNestedArraysReply(beam.NewBulkStringsReply("s"), beam.NewNestedArraysReply(
+			beam.NewNestedArraysReply(beam.NewBulkStringsReply("2-0"), beam.Reply("*-1\r\n")),
+		)),
+	), conn.Do("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "0"))
+
+	// the entries are not pending with NOACK.
+	conn.Do("XADD", "s", "4-0", "k", "4")
+	assert.Equal([]string{"4-0"}, entries(conn.Do("XREADGROUP", "GROUP", "g", "carol", "NOACK", "STREAMS", "s", ">")))
+	beamtest.AssertStrings(t, []string{}, conn.Do("XPENDING", "s", "g", "-", "+", "10", "carol"))
+
+	beamtest.AssertError(t, "NOGROUP No such key 's' or consumer group 'x' in XREADGROUP with GROUP option", conn.Do("XREADGROUP", "GROUP", "x", "alice", "STREAMS", "s", ">"))
+	beamtest.AssertError(t, "ERR The $ ID is meaningless", conn.Do("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "$"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("XREADGROUP", "COUNT", "1", "NOACK", "STREAMS", "s", ">"))
+	beamtest.AssertError(t, "NOGROUP No such key 's' or consumer group 'x'", conn.Do("XPENDING", "s", "x"))
+}
+
+func TestStore_XReadGroupBlocking(t *testing.T) {
+	assert := assert.New(t)
+	s, server := newBlockingStore(t)
+	conn := server.Dial()
+
+	beamtest.AssertOK(t, conn.Do("XGROUP", "CREATE", "s", "g", "$", "MKSTREAM"))
+	c1, c2 := server.Dial(), server.Dial()
+	c1.Send(beam.NewQuery("XREADGROUP", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", "s", ">"))
+	waitBlocked(t, s, "s", 1)
+	c2.Send(beam.NewQuery("XREADGROUP", "GROUP", "g", "bob", "BLOCK", "0", "STREAMS", "s", ">"))
+	waitBlocked(t, s, "s", 2)
+
+	// each entry is delivered to one consumer of the group.
+	conn.Do("XADD", "s", "1-0", "k", "1")
+	reply, err := c1.Read().Array()
+	assert.Nil(err)
+	assert.Len(reply, 1)
+	waitBlocked(t, s, "s", 1)
+	conn.Do("XADD", "s", "2-0", "k", "2")
+	reply, err = c2.Read().Array()
+	assert.Nil(err)
+	assert.Len(reply, 1)
+	beamtest.AssertReply(t, beam.NewNestedArraysReply(
+		beam.NewIntegersReply(2),
+		beam.NewBulkStringsReply("1-0"),
+		beam.NewBulkStringsReply("2-0"),
+		beam.NewNestedArraysReply(beam.NewArraysReply("alice", "1"), beam.NewArraysReply("bob", "1")),
+	), conn.Do("XPENDING", "s", "g"))
+
+	// the destroyed group fails the blocked clients.
+	c1.Send(beam.NewQuery("XREADGROUP", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", "s", ">"))
+	waitBlocked(t, s, "s", 1)
+	beamtest.AssertInteger(t, 1, conn.Do("XGROUP", "DESTROY", "s", "g"))
+	conn.Do("XADD", "s", "3-0", "k", "3")
+	beamtest.AssertError(t, "NOGROUP the consumer group this client was blocked on no longer exists", c1.Read())
+}
+
+func TestStore_XClaim(t *testing.T) {
+	assert := assert.New(t)
+	_, clk, conn := newTestStore(t, Config{})
+
+	for _, id := range []string{"1-0", "2-0", "3-0", "4-0"} {
+		conn.Do("XADD", "s", id, "k", id)
+	}
+	beamtest.AssertOK(t, conn.Do("XGROUP", "CREATE", "s", "g", "0"))
+	conn.Do("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">")
+	clk.advance(time.Second)
+
+	// the entries idle less than min-idle-time are not claimed.
+	beamtest.AssertStrings(t, []string{}, conn.Do("XCLAIM", "s", "g", "bob", "2000", "1-0"))
+	beamtest.AssertReply(t, beam.NewNestedArraysReply(
+		beam.NewNestedArraysReply(beam.NewBulkStringsReply("1-0"), beam.NewArraysReply("k", "1-0")),
+	), conn.Do("XCLAIM", "s", "g", "bob", "1000", "1-0", "9-0"))
+	beamtest.AssertStrings(t, []string{"2-0"}, conn.Do("XCLAIM", "s", "g", "bob", "0", "2-0", "JUSTID", "RETRYCOUNT", "5"))
+	beamtest.AssertReply(t, beam.NewNestedArraysReply(
+		beam.NewNestedArraysReply(beam.NewBulkStringsReply("1-0"), beam.NewBulkStringsReply("bob"), beam.NewIntegersReply(0), beam.NewIntegersReply(2)),
+		beam.NewNestedArraysReply(beam.NewBulkStringsReply("2-0"), beam.NewBulkStringsReply("bob"), beam.NewIntegersReply(0), beam.NewIntegersReply(5)),
+	), conn.Do("XPENDING", "s", "g", "-", "+", "10", "bob"))
+
+	// FORCE creates the pending entries of the existing entries.
+	beamtest.AssertInteger(t, 4, conn.Do("XACK", "s", "g", "1-0", "2-0", "3-0", "4-0"))
+	beamtest.AssertStrings(t, []string{"3-0"}, conn.Do("XCLAIM", "s", "g", "bob", "1000", "3-0", "9-0", "FORCE", "JUSTID"))
+	beamtest.AssertInteger(t, 1, conn.Do("XACK", "s", "g", "3-0"))
+	beamtest.AssertError(t, "ERR Unrecognized XCLAIM option 'FOO'", conn.Do("XCLAIM", "s", "g", "bob", "0", "1-0", "FOO"))
+	beamtest.AssertError(t, "ERR Invalid min-idle-time argument for XCLAIM", conn.Do("XCLAIM", "s", "g", "bob", "x", "1-0"))
+	beamtest.AssertError(t, "NOGROUP", conn.Do("XCLAIM", "s", "x", "bob", "0", "1-0"))
+
+	// XAUTOCLAIM scans the pending entries from the start.
+	for _, id := range []string{"5-0", "6-0", "7-0"} {
+		conn.Do("XADD", "s", id, "k", id)
+	}
+	conn.Do("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">")
+	beamtest.AssertInteger(t, 1, conn.Do("XDEL", "s", "6-0"))
+	clk.advance(time.Second)
+	beamtest.AssertReply(t, beam.NewNestedArraysReply(
+		beam.NewBulkStringsReply("6-0"),
+		beam.NewArraysReply("5-0"),
+		beam.NewArraysReply(),
+	), conn.Do("XAUTOCLAIM", "s", "g", "bob", "1000", "-", "COUNT", "1", "JUSTID"))
+	// the deleted entries are removed from the pending entries list.
+	reply, err := conn.Do("XAUTOCLAIM", "s", "g", "bob", "1000", "6-0").Array()
+	assert.Nil(err)
+	beamtest.AssertBulkString(t, "0-0", reply[0])
+	assert.Equal([]string{"7-0"}, entryIDs(t, reply[1]))
+	beamtest.AssertStrings(t, []string{"6-0"}, reply[2])
+	beamtest.AssertInteger(t, 0, conn.Do("XACK", "s", "g", "6-0"))
+	beamtest.AssertError(t, "ERR COUNT must be > 0", conn.Do("XAUTOCLAIM", "s", "g", "bob", "0", "0", "COUNT", "0"))
+}
+
+func TestStore_XInfo(t *testing.T) {
+	assert := assert.New(t)
+	_, clk, conn := newTestStore(t, Config{})
+
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		conn.Do("XADD", "s", id, "k", id)
+	}
+	beamtest.AssertOK(t, conn.Do("XGROUP", "CREATE", "s", "g", "0"))
+	conn.Do("XREADGROUP", "GROUP", "g", "alice", "COUNT", "1", "STREAMS", "s", ">")
+	clk.advance(time.Second)
+
+	info, err := conn.Do("XINFO", "STREAM", "s").Map()
+	assert.Nil(err)
+	beamtest.AssertInteger(t, 3, info["length"])
+	beamtest.AssertBulkString(t, "3-0", info["last-generated-id"])
+	beamtest.AssertInteger(t, 3, info["entries-added"])
+	beamtest.AssertInteger(t, 1, info["groups"])
+	beamtest.AssertReply(t, beam.NewNestedArraysReply(beam.NewBulkStringsReply("1-0"), beam.NewArraysReply("k", "1-0")), info["first-entry"])
+
+	groups, err := conn.Do("XINFO", "GROUPS", "s").Array()
+	assert.Nil(err)
+	g, err := groups[0].Map()
+	assert.Nil(err)
+	beamtest.AssertInteger(t, 1, g["pending"])
+	beamtest.AssertInteger(t, 1, g["entries-read"])
+	beamtest.AssertInteger(t, 2, g["lag"])
+	// the lag is unknown with the deleted entries not delivered.
+	beamtest.AssertInteger(t, 1, conn.Do("XDEL", "s", "2-0"))
+	groups, _ = conn.Do("XINFO", "GROUPS", "s").Array()
+	g, _ = groups[0].Map()
+	beamtest.AssertNil(t, g["lag"])
+
+	consumers, err := conn.Do("XINFO", "CONSUMERS", "s", "g").Array()
+	assert.Nil(err)
+	cons, err := consumers[0].Map()
+	assert.Nil(err)
+	beamtest.AssertBulkString(t, "alice", cons["name"])
+	beamtest.AssertInteger(t, 1, cons["pending"])
+	beamtest.AssertInteger(t, 1000, cons["idle"])
+	beamtest.AssertInteger(t, 1000, cons["inactive"])
+
+	full, err := conn.Do("XINFO", "STREAM", "s", "FULL", "COUNT", "1").Map()
+	assert.Nil(err)
+	assert.Equal([]string{"1-0"}, entryIDs(t, full["entries"]))
+	groups, err = full["groups"].Array()
+	assert.Nil(err)
+	g, err = groups[0].Map()
+	assert.Nil(err)
+	beamtest.AssertInteger(t, 1, g["pel-count"])
+	assert.Nil(full["groups"].Validate())
+
+	beamtest.AssertError(t, "ERR no such key", conn.Do("XINFO", "STREAM", "missing"))
+	beamtest.AssertError(t, "NOGROUP", conn.Do("XINFO", "CONSUMERS", "s", "x"))
+	beamtest.AssertError(t, "ERR unknown subcommand 'FOO'", conn.Do("XINFO", "FOO", "s"))
+	beamtest.AssertError(t, "ERR wrong number of arguments for 'xinfo|groups' command", conn.Do("XINFO", "GROUPS"))
+}
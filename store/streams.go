@@ -0,0 +1,569 @@
+package store
+
+import (
+	"bytes"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/caeret/beam"
+)
+
+var (
+	invalidStreamIDReply = beam.NewErrorsReply("ERR Invalid stream ID specified as stream command argument")
+	emptyArrayReply      = beam.AppendArraysHeader(nil, 0)
+)
+
+// lookupStream retrieves the stream of the key, the error reply will be returned if the key holds
+// another type.
+func (c *call) lookupStream(key []byte) (*stream, beam.Reply) {
+	e := c.lookup(key)
+	if e == nil {
+		return nil, nil
+	}
+	s, ok := e.value.(*stream)
+	if !ok {
+		return nil, wrongTypeReply
+	}
+	return s, nil
+}
+
+// appendEntry appends the entry as the array of the ID and the field value pairs.
+func appendEntry(b []byte, e streamEntry) []byte {
+	b = beam.AppendArraysHeader(b, 2)
+	b = beam.AppendBulkStringsRaw(b, e.id.append(nil))
+	b = beam.AppendArraysHeader(b, len(e.fields))
+	for _, field := range e.fields {
+		b = beam.AppendBulkStringsRaw(b, field)
+	}
+	return b
+}
+
+func entriesReply(entries []streamEntry) beam.Reply {
+	b := beam.AppendArraysHeader(nil, len(entries))
+	for _, e := range entries {
+		b = appendEntry(b, e)
+	}
+	return b
+}
+
+// streamReply replies the key with the entries of the stream as XREAD does.
+func streamReply(key []byte, entries beam.Reply) beam.Reply {
+	return beam.NewNestedArraysReply(beam.NewBulkStringsReplyRaw(key), entries)
+}
+
+func idReply(id streamID) beam.Reply {
+	return beam.NewBulkStringsReplyRaw(id.append(nil))
+}
+
+// trimArgs is the trimming strategy of XADD and XTRIM.
+type trimArgs struct {
+	// maxLen trims the stream to maxLen entries if it is not negative, or the entries less than
+	// minID are trimmed.
+	maxLen int64
+	minID  streamID
+	// limit is the max number of the trimmed entries with "~", 0 is unlimited. The stream is always
+	// trimmed exactly since the entries are not kept in nodes.
+	limit int
+}
+
+// parseTrimArgs parses MAXLEN | MINID [= | ~] threshold [LIMIT count], the number of the parsed
+// arguments will be returned.
+func parseTrimArgs(args [][]byte) (trimArgs, int, beam.Reply) {
+	opts := trimArgs{maxLen: -1}
+	strategy := strings.ToUpper(string(args[0]))
+	i := 1
+	approx := false
+	if i < len(args) && (string(args[i]) == "=" || string(args[i]) == "~") {
+		approx = string(args[i]) == "~"
+		i++
+	}
+	if i == len(args) {
+		return opts, 0, syntaxErrReply
+	}
+	if strategy == "MAXLEN" {
+		n, ok := parseInt(args[i])
+		if !ok {
+			return opts, 0, notIntegerReply
+		}
+		if n < 0 {
+			return opts, 0, beam.NewErrorsReply("ERR The MAXLEN argument must be >= 0.")
+		}
+		opts.maxLen = n
+	} else {
+		id, ok := parseStreamID(args[i], 0)
+		if !ok {
+			return opts, 0, invalidStreamIDReply
+		}
+		opts.minID = id
+	}
+	i++
+	if i+1 < len(args) && strings.EqualFold(string(args[i]), "LIMIT") {
+		n, ok := parseInt(args[i+1])
+		if !ok {
+			return opts, 0, notIntegerReply
+		}
+		if n < 0 {
+			return opts, 0, beam.NewErrorsReply("ERR The LIMIT argument must be >= 0.")
+		}
+		if !approx {
+			return opts, 0, beam.NewErrorsReply("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		opts.limit = int(min(n, math.MaxInt32))
+		i += 2
+	}
+	return opts, i, nil
+}
+
+// trimBy trims the stream by the strategy, the number of the trimmed entries will be returned.
+func (s *stream) trimBy(opts trimArgs) int {
+	if opts.maxLen >= 0 {
+		return s.trim(func(i int, _ streamEntry) bool {
+			return int64(s.len()-i) > opts.maxLen
+		}, opts.limit)
+	}
+	return s.trim(func(_ int, e streamEntry) bool {
+		return e.id.compare(opts.minID) < 0
+	}, opts.limit)
+}
+
+// xaddCommand implements XADD key [NOMKSTREAM] [MAXLEN | MINID [= | ~] threshold [LIMIT count]]
+// * | id field value [field value ...].
+func xaddCommand(c *call) beam.Reply {
+	key := c.args[0]
+	args := c.args[1:]
+	noMkStream := false
+	var trim *trimArgs
+options:
+	for len(args) > 0 {
+		switch strings.ToUpper(string(args[0])) {
+		case "NOMKSTREAM":
+			noMkStream = true
+			args = args[1:]
+		case "MAXLEN", "MINID":
+			opts, n, reply := parseTrimArgs(args)
+			if reply != nil {
+				return reply
+			}
+			trim = &opts
+			args = args[n:]
+		default:
+			break options
+		}
+	}
+	if len(args) < 3 || len(args)%2 != 1 {
+		return wrongArgs(c.name)
+	}
+
+	// the ID is "*" or "ms-*" to be generated, or the explicit one.
+	idArg := args[0]
+	var (
+		id              streamID
+		autoMs, autoSeq bool
+	)
+	switch {
+	case string(idArg) == "*":
+		autoMs, autoSeq = true, true
+	case bytes.HasSuffix(idArg, []byte("-*")):
+		var ok bool
+		if id, ok = parseStreamID(idArg[:len(idArg)-2], 0); !ok {
+			return invalidStreamIDReply
+		}
+		autoSeq = true
+	default:
+		var ok bool
+		if id, ok = parseStreamID(idArg, 0); !ok {
+			return invalidStreamIDReply
+		}
+		if id.isZero() {
+			return beam.NewErrorsReply("ERR The ID specified in XADD must be greater than 0-0")
+		}
+	}
+
+	s, reply := c.lookupStream(key)
+	if reply != nil {
+		return reply
+	}
+	if s == nil && noMkStream {
+		return nilReply
+	}
+	var last streamID
+	if s != nil {
+		last = s.lastID
+	}
+	tooSmall := beam.NewErrorsReply("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	switch {
+	case autoMs:
+		id = streamID{max(uint64(c.now), last.ms), 0}
+		if id.ms == last.ms {
+			var ok bool
+			if id, ok = last.next(); !ok {
+				return beam.NewErrorsReply("ERR The stream has exhausted the last possible ID, unable to add more items")
+			}
+		}
+	case autoSeq:
+		if id.ms < last.ms || (id.ms == last.ms && last.seq == math.MaxUint64) {
+			return tooSmall
+		}
+		if id.ms == last.ms {
+			id.seq = last.seq + 1
+		}
+	default:
+		if id.compare(last) <= 0 {
+			return tooSmall
+		}
+	}
+
+	fields := make([][]byte, len(args)-1)
+	for i, field := range args[1:] {
+		fields[i] = clone(field)
+	}
+	if s == nil {
+		s = newStream()
+		c.set(key, s)
+	} else {
+		c.signal(key)
+	}
+	s.add(id, fields)
+	if trim != nil {
+		s.trimBy(*trim)
+	}
+	if autoMs || autoSeq {
+		// the generated ID is logged, so the entry is replayed with the same ID.
+		logged := slices.Clone(c.args)
+		logged[len(c.args)-len(args)] = id.append(nil)
+		c.propagate(query("XADD", logged...))
+	}
+	return idReply(id)
+}
+
+func xlenCommand(c *call) beam.Reply {
+	s, reply := c.lookupStream(c.args[0])
+	if reply != nil || s == nil {
+		return orZero(reply)
+	}
+	return integer(int64(s.len()))
+}
+
+// parseRangeID parses the bound of the range, "-" and "+" are the min and max IDs, the seq of the end
+// is the max one if only ms is specified, and the ID prefixed with "(" is excluded.
+func parseRangeID(b []byte, end bool) (streamID, beam.Reply) {
+	switch string(b) {
+	case "-":
+		return streamID{}, nil
+	case "+":
+		return maxStreamID, nil
+	}
+	ex := len(b) > 0 && b[0] == '('
+	if ex {
+		b = b[1:]
+	}
+	seq := uint64(0)
+	if end {
+		seq = math.MaxUint64
+	}
+	id, ok := parseStreamID(b, seq)
+	if !ok {
+		return id, invalidStreamIDReply
+	}
+	if ex {
+		if end {
+			id, ok = id.prev()
+		} else {
+			id, ok = id.next()
+		}
+		if !ok {
+			if end {
+				return id, beam.NewErrorsReply("ERR invalid end ID for the interval")
+			}
+			return id, beam.NewErrorsReply("ERR invalid start ID for the interval")
+		}
+	}
+	return id, nil
+}
+
+func xrangeCommand(c *call) beam.Reply {
+	return c.xrangeGeneric(false)
+}
+
+func xrevrangeCommand(c *call) beam.Reply {
+	return c.xrangeGeneric(true)
+}
+
+// xrangeGeneric implements XRANGE key start end [COUNT count] and XREVRANGE key end start [COUNT count].
+func (c *call) xrangeGeneric(rev bool) beam.Reply {
+	startArg, endArg := c.args[1], c.args[2]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, reply := parseRangeID(startArg, false)
+	if reply != nil {
+		return reply
+	}
+	end, reply := parseRangeID(endArg, true)
+	if reply != nil {
+		return reply
+	}
+	count := int64(-1)
+	opts := c.args[3:]
+	for i := 0; i < len(opts); i++ {
+		if !strings.EqualFold(string(opts[i]), "COUNT") || i+1 == len(opts) {
+			return syntaxErrReply
+		}
+		i++
+		var ok bool
+		if count, ok = parseInt(opts[i]); !ok {
+			return notIntegerReply
+		}
+		count = max(count, 0)
+	}
+	s, reply := c.lookupStream(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	if s == nil || count == 0 {
+		return emptyArrayReply
+	}
+	return entriesReply(s.rangeEntries(start, end, int(min(count, math.MaxInt32)), rev))
+}
+
+func xdelCommand(c *call) beam.Reply {
+	// the IDs are parsed before any entry is deleted.
+	ids := make([]streamID, len(c.args)-1)
+	for i, arg := range c.args[1:] {
+		var ok bool
+		if ids[i], ok = parseStreamID(arg, 0); !ok {
+			return invalidStreamIDReply
+		}
+	}
+	s, reply := c.lookupStream(c.args[0])
+	if reply != nil || s == nil {
+		return orZero(reply)
+	}
+	n := 0
+	for _, id := range ids {
+		if s.delete(id) {
+			n++
+		}
+	}
+	return integer(int64(n))
+}
+
+// xtrimCommand implements XTRIM key MAXLEN | MINID [= | ~] threshold [LIMIT count].
+func xtrimCommand(c *call) beam.Reply {
+	strategy := strings.ToUpper(string(c.args[1]))
+	if strategy != "MAXLEN" && strategy != "MINID" {
+		return syntaxErrReply
+	}
+	opts, n, reply := parseTrimArgs(c.args[1:])
+	if reply != nil {
+		return reply
+	}
+	if n != len(c.args)-1 {
+		return syntaxErrReply
+	}
+	s, reply := c.lookupStream(c.args[0])
+	if reply != nil || s == nil {
+		return orZero(reply)
+	}
+	return integer(int64(s.trimBy(opts)))
+}
+
+// xsetidCommand implements XSETID key last-id [ENTRIESADDED entries-added] [MAXDELETEDID
+// max-deleted-id], it restores the metadata of the stream in the rewritten append-only file.
+func xsetidCommand(c *call) beam.Reply {
+	id, ok := parseStreamID(c.args[1], 0)
+	if !ok {
+		return invalidStreamIDReply
+	}
+	entriesAdded := int64(-1)
+	var maxDeletedID *streamID
+	opts := c.args[2:]
+	for i := 0; i < len(opts); i++ {
+		opt := strings.ToUpper(string(opts[i]))
+		switch {
+		case opt == "ENTRIESADDED" && i+1 < len(opts):
+			i++
+			if entriesAdded, ok = parseInt(opts[i]); !ok {
+				return notIntegerReply
+			}
+			if entriesAdded < 0 {
+				return beam.NewErrorsReply("ERR entries_added must be positive")
+			}
+		case opt == "MAXDELETEDID" && i+1 < len(opts):
+			i++
+			deleted, ok := parseStreamID(opts[i], 0)
+			if !ok {
+				return invalidStreamIDReply
+			}
+			if id.compare(deleted) < 0 {
+				return beam.NewErrorsReply("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+			}
+			maxDeletedID = &deleted
+		default:
+			return syntaxErrReply
+		}
+	}
+
+	s, reply := c.lookupStream(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	if s == nil {
+		return beam.NewErrorsReply("ERR no such key")
+	}
+	// the last ID can not be less than the existing entries.
+	if s.len() > 0 {
+		if id.compare(s.entries[s.len()-1].id) < 0 {
+			return beam.NewErrorsReply("ERR The ID specified in XSETID is smaller than the target stream top item")
+		}
+		if entriesAdded >= 0 && int64(s.len()) > entriesAdded {
+			return beam.NewErrorsReply("ERR The entries_added specified in XSETID is smaller than the target stream length")
+		}
+	}
+	s.lastID = id
+	if entriesAdded >= 0 {
+		s.entriesAdded = entriesAdded
+	}
+	if maxDeletedID != nil {
+		s.maxDeletedID = *maxDeletedID
+	}
+	return okReply
+}
+
+// xreadArgs is the arguments of XREAD and XREADGROUP.
+type xreadArgs struct {
+	group, consumer string
+	// count is the max number of the entries of each stream, 0 is unlimited.
+	count int
+	// block is the max time of blocking if it is not negative, 0 blocks forever.
+	block time.Duration
+	noAck bool
+	keys  [][]byte
+	ids   [][]byte
+}
+
+// parseXreadArgs parses [GROUP group consumer] [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS
+// key [key ...] id [id ...], GROUP and NOACK are only allowed for XREADGROUP.
+func (c *call) parseXreadArgs(group bool) (xreadArgs, beam.Reply) {
+	args := xreadArgs{block: -1}
+	var streams [][]byte
+	for i := 0; i < len(c.args) && streams == nil; i++ {
+		opt := strings.ToUpper(string(c.args[i]))
+		switch {
+		case opt == "STREAMS":
+			streams = c.args[i+1:]
+		case opt == "COUNT" && i+1 < len(c.args):
+			i++
+			n, ok := parseInt(c.args[i])
+			if !ok {
+				return args, notIntegerReply
+			}
+			args.count = int(min(max(n, 0), math.MaxInt32))
+		case opt == "BLOCK" && i+1 < len(c.args):
+			i++
+			n, ok := parseInt(c.args[i])
+			if !ok {
+				return args, beam.NewErrorsReply("ERR timeout is not an integer or out of range")
+			}
+			if n < 0 {
+				return args, beam.NewErrorsReply("ERR timeout is negative")
+			}
+			args.block = time.Duration(min(n, math.MaxInt64/int64(time.Millisecond))) * time.Millisecond
+		case opt == "GROUP" && group && i+2 < len(c.args):
+			args.group, args.consumer = string(c.args[i+1]), string(c.args[i+2])
+			i += 2
+		case opt == "NOACK" && group:
+			args.noAck = true
+		default:
+			return args, syntaxErrReply
+		}
+	}
+	if streams == nil || (group && args.group == "") {
+		return args, syntaxErrReply
+	}
+	if len(streams) == 0 || len(streams)%2 != 0 {
+		token := "$"
+		if group {
+			token = ">"
+		}
+		return args, beam.NewErrorsReply("ERR Unbalanced '" + c.name + "' list of streams: for each stream key an ID or '" + token + "' must be specified.")
+	}
+	args.keys, args.ids = streams[:len(streams)/2], streams[len(streams)/2:]
+	return args, nil
+}
+
+// entriesAfter retrieves the entries after the ID.
+func (s *stream) entriesAfter(id streamID, count int) []streamEntry {
+	start, ok := id.next()
+	if !ok {
+		return nil
+	}
+	return s.rangeEntries(start, maxStreamID, count, false)
+}
+
+// xreadCommand implements XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...],
+// the client is blocked until one of the streams has the entries after the ID with BLOCK.
+func xreadCommand(c *call) beam.Reply {
+	args, reply := c.parseXreadArgs(false)
+	if reply != nil {
+		return reply
+	}
+	ids := make(map[string]streamID, len(args.keys))
+	var results []beam.Reply
+	for i, key := range args.keys {
+		s, reply := c.lookupStream(key)
+		if reply != nil {
+			return reply
+		}
+		var id streamID
+		switch string(args.ids[i]) {
+		case "$":
+			if s != nil {
+				id = s.lastID
+			}
+		case ">":
+			return beam.NewErrorsReply("ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.")
+		default:
+			var ok bool
+			if id, ok = parseStreamID(args.ids[i], 0); !ok {
+				return invalidStreamIDReply
+			}
+		}
+		// the first ID is used for the duplicate keys.
+		if _, exist := ids[string(key)]; exist {
+			continue
+		}
+		ids[string(key)] = id
+		if s == nil {
+			continue
+		}
+		if entries := s.entriesAfter(id, args.count); len(entries) > 0 {
+			results = append(results, streamReply(key, entriesReply(entries)))
+		}
+	}
+	if len(results) > 0 {
+		return beam.NewNestedArraysReply(results...)
+	}
+	if args.block < 0 {
+		return nilArrayReply
+	}
+	c.block(&waiter{
+		keys: args.keys,
+		serve: func(c *call, key []byte) beam.Reply {
+			s, _ := c.lookupStream(key)
+			if s == nil {
+				return nil
+			}
+			entries := s.entriesAfter(ids[string(key)], args.count)
+			if len(entries) == 0 {
+				return nil
+			}
+			return beam.NewNestedArraysReply(streamReply(key, entriesReply(entries)))
+		},
+		timeout:      args.block,
+		timeoutReply: nilArrayReply,
+	})
+	return nil
+}
@@ -0,0 +1,207 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caeret/beam"
+	"github.com/caeret/beam/beamtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// entryIDs retrieves the IDs of the entries replied by XRANGE.
+func entryIDs(t *testing.T, reply beam.Reply) []string {
+	entries, err := reply.Array()
+	assert.Nil(t, err)
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		elems, err := entry.Array()
+		assert.Nil(t, err)
+		ids[i], err = elems[0].Text()
+		assert.Nil(t, err)
+	}
+	return ids
+}
+
+func TestStreamID(t *testing.T) {
+	assert := assert.New(t)
+
+	id, ok := parseStreamID([]byte("1-2"), 0)
+	assert.True(ok)
+	assert.Equal(streamID{1, 2}, id)
+	id, ok = parseStreamID([]byte("5"), 7)
+	assert.True(ok)
+	assert.Equal("5-7", id.String())
+	for _, s := range []string{"", "-", "1-", "a-1", "1-2-3", "-1"} {
+		_, ok = parseStreamID([]byte(s), 0)
+		assert.False(ok, s)
+	}
+
+	next, ok := streamID{1, 2}.next()
+	assert.True(ok)
+	assert.Equal(streamID{1, 3}, next)
+	next, _ = streamID{1, maxStreamID.seq}.next()
+	assert.Equal(streamID{2, 0}, next)
+	_, ok = maxStreamID.next()
+	assert.False(ok)
+	prev, _ := streamID{2, 0}.prev()
+	assert.Equal(streamID{1, maxStreamID.seq}, prev)
+	_, ok = streamID{}.prev()
+	assert.False(ok)
+	assert.Equal(-1, streamID{1, 5}.compare(streamID{2, 0}))
+}
+
+func TestStore_XAdd(t *testing.T) {
+	_, clk, conn := newTestStore(t, Config{})
+
+	beamtest.AssertBulkString(t, "1-1", conn.Do("XADD", "s", "1-1", "a", "1"))
+	beamtest.AssertSimpleString(t, "stream", conn.Do("TYPE", "s"))
+	beamtest.AssertError(t, "ERR The ID specified in XADD is equal or smaller than the target stream top item", conn.Do("XADD", "s", "1-1", "a", "1"))
+	beamtest.AssertError(t, "ERR The ID specified in XADD is equal or smaller than the target stream top item", conn.Do("XADD", "s", "0-*", "a", "1"))
+	beamtest.AssertBulkString(t, "1-2", conn.Do("XADD", "s", "1-*", "b", "2"))
+	beamtest.AssertBulkString(t, "2-0", conn.Do("XADD", "s", "2-*", "b", "2"))
+	beamtest.AssertBulkString(t, "1700000000000-0", conn.Do("XADD", "s", "*", "c", "3"))
+	beamtest.AssertBulkString(t, "1700000000000-1", conn.Do("XADD", "s", "*", "c", "3"))
+	clk.advance(time.Millisecond)
+	beamtest.AssertBulkString(t, "1700000000001-0", conn.Do("XADD", "s", "*", "c", "3"))
+	// the time going backwards does not generate the smaller IDs.
+	clk.advance(-time.Second)
+	beamtest.AssertBulkString(t, "1700000000001-1", conn.Do("XADD", "s", "*", "c", "3"))
+	beamtest.AssertInteger(t, 7, conn.Do("XLEN", "s"))
+	beamtest.AssertInteger(t, 0, conn.Do("XLEN", "missing"))
+
+	beamtest.AssertBulkString(t, "0-1", conn.Do("XADD", "s2", "0-*", "a", "1"))
+	beamtest.AssertError(t, "ERR The ID specified in XADD must be greater than 0-0", conn.Do("XADD", "s3", "0-0", "a", "1"))
+	beamtest.AssertError(t, "ERR Invalid stream ID specified as stream command argument", conn.Do("XADD", "s3", "abc", "a", "1"))
+	beamtest.AssertError(t, "ERR wrong number of arguments for 'xadd' command", conn.Do("XADD", "s3", "*", "a"))
+	beamtest.AssertNil(t, conn.Do("XADD", "s3", "NOMKSTREAM", "*", "a", "1"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "s3"))
+	beamtest.AssertOK(t, conn.Do("SET", "str", "v"))
+	beamtest.AssertError(t, "WRONGTYPE", conn.Do("XADD", "str", "*", "a", "1"))
+
+	beamtest.AssertBulkString(t, "3-0", conn.Do("XADD", "t", "MAXLEN", "2", "3-0", "a", "1"))
+	beamtest.AssertBulkString(t, "4-0", conn.Do("XADD", "t", "MAXLEN", "=", "2", "4-0", "a", "1"))
+	beamtest.AssertBulkString(t, "5-0", conn.Do("XADD", "t", "MAXLEN", "~", "2", "LIMIT", "10", "5-0", "a", "1"))
+	beamtest.AssertStrings(t, []string{"4-0", "5-0"}, beam.NewArraysReply(entryIDs(t, conn.Do("XRANGE", "t", "-", "+"))...))
+	beamtest.AssertBulkString(t, "6-0", conn.Do("XADD", "t", "MINID", "5", "6-0", "a", "1"))
+	beamtest.AssertInteger(t, 2, conn.Do("XLEN", "t"))
+	beamtest.AssertError(t, "ERR The MAXLEN argument must be >= 0.", conn.Do("XADD", "t", "MAXLEN", "-1", "*", "a", "1"))
+}
+
+func TestStore_XTrim(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	for _, id := range []string{"1-0", "2-0", "3-0", "4-0", "5-0"} {
+		conn.Do("XADD", "s", id, "a", "1")
+	}
+	beamtest.AssertInteger(t, 1, conn.Do("XTRIM", "s", "MAXLEN", "~", "2", "LIMIT", "1"))
+	beamtest.AssertInteger(t, 2, conn.Do("XTRIM", "s", "MAXLEN", "2"))
+	beamtest.AssertInteger(t, 0, conn.Do("XTRIM", "s", "MAXLEN", "2"))
+	beamtest.AssertInteger(t, 1, conn.Do("XTRIM", "s", "MINID", "5"))
+	beamtest.AssertInteger(t, 1, conn.Do("XLEN", "s"))
+	beamtest.AssertInteger(t, 1, conn.Do("XTRIM", "s", "MAXLEN", "0"))
+	// the empty stream is kept.
+	beamtest.AssertInteger(t, 1, conn.Do("EXISTS", "s"))
+	beamtest.AssertInteger(t, 0, conn.Do("XTRIM", "missing", "MAXLEN", "0"))
+
+	beamtest.AssertError(t, "ERR syntax error, LIMIT cannot be used without the special ~ option", conn.Do("XTRIM", "s", "MAXLEN", "2", "LIMIT", "1"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("XTRIM", "s", "SIZE", "2"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("XTRIM", "s", "MAXLEN", "2", "FOO"))
+}
+
+func TestStore_XRange(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	for _, id := range []string{"1-0", "1-1", "2-0", "3-0", "3-1"} {
+		conn.Do("XADD", "s", id, "id", id)
+	}
+	ids := func(reply beam.Reply) beam.Reply {
+		return beam.NewArraysReply(entryIDs(t, reply)...)
+	}
+	beamtest.AssertStrings(t, []string{"1-0", "1-1", "2-0", "3-0", "3-1"}, ids(conn.Do("XRANGE", "s", "-", "+")))
+	beamtest.AssertStrings(t, []string{"1-0", "1-1"}, ids(conn.Do("XRANGE", "s", "1", "1")))
+	beamtest.AssertStrings(t, []string{"1-1", "2-0"}, ids(conn.Do("XRANGE", "s", "(1-0", "(3-0")))
+	beamtest.AssertStrings(t, []string{"1-0", "1-1"}, ids(conn.Do("XRANGE", "s", "-", "+", "COUNT", "2")))
+	beamtest.AssertStrings(t, []string{"3-1", "3-0"}, ids(conn.Do("XREVRANGE", "s", "+", "-", "COUNT", "2")))
+	beamtest.AssertStrings(t, []string{"2-0", "1-1"}, ids(conn.Do("XREVRANGE", "s", "2", "(1-0")))
+	beamtest.AssertStrings(t, []string{}, conn.Do("XRANGE", "s", "3", "2"))
+	beamtest.AssertStrings(t, []string{}, conn.Do("XRANGE", "s", "-", "+", "COUNT", "0"))
+	beamtest.AssertStrings(t, []string{}, conn.Do("XRANGE", "missing", "-", "+"))
+	beamtest.AssertReply(t, beam.NewNestedArraysReply(
+		beam.NewNestedArraysReply(beam.NewBulkStringsReply("2-0"), beam.NewArraysReply("id", "2-0")),
+	), conn.Do("XRANGE", "s", "2-0", "2-0"))
+
+	beamtest.AssertError(t, "ERR Invalid stream ID specified as stream command argument", conn.Do("XRANGE", "s", "x", "+"))
+	beamtest.AssertError(t, "ERR invalid start ID for the interval", conn.Do("XRANGE", "s", "(18446744073709551615-18446744073709551615", "+"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("XRANGE", "s", "-", "+", "LIMIT", "1"))
+
+	beamtest.AssertInteger(t, 2, conn.Do("XDEL", "s", "1-1", "3-0", "9-0"))
+	beamtest.AssertStrings(t, []string{"1-0", "2-0", "3-1"}, ids(conn.Do("XRANGE", "s", "-", "+")))
+	beamtest.AssertInteger(t, 0, conn.Do("XDEL", "missing", "1-0"))
+	beamtest.AssertError(t, "ERR Invalid stream ID specified as stream command argument", conn.Do("XDEL", "s", "1-0", "x"))
+}
+
+func TestStore_XRead(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	conn.Do("XADD", "a", "1-0", "k", "1")
+	conn.Do("XADD", "a", "2-0", "k", "2")
+	conn.Do("XADD", "b", "1-0", "k", "3")
+	beamtest.AssertReply(t, beam.NewNestedArraysReply(
+		beam.NewNestedArraysReply(beam.NewBulkStringsReply("a"), beam.NewNestedArraysReply(
+			beam.NewNestedArraysReply(beam.NewBulkStringsReply("2-0"), beam.NewArraysReply("k", "2")),
+		)),
+		beam.NewNestedArraysReply(beam.NewBulkStringsReply("b"), beam.NewNestedArraysReply(
+			beam.NewNestedArraysReply(beam.NewBulkStringsReply("1-0"), beam.NewArraysReply("k", "3")),
+		)),
+	), conn.Do("XREAD", "COUNT", "1", "STREAMS", "a", "b", "1-0", "0"))
+	beamtest.AssertReply(t, beam.Reply("*-1\r\n"), conn.Do("XREAD", "STREAMS", "a", "b", "missing", "$", "$", "$"))
+
+	beamtest.AssertError(t, "ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.", conn.Do("XREAD", "STREAMS", "a", "b", "0"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("XREAD", "COUNT", "1", "a", "0"))
+	beamtest.AssertError(t, "ERR The > ID can be specified only when calling XREADGROUP", conn.Do("XREAD", "STREAMS", "a", ">"))
+	beamtest.AssertError(t, "ERR timeout is negative", conn.Do("XREAD", "BLOCK", "-1", "STREAMS", "a", "0"))
+}
+
+func TestStore_XReadBlocking(t *testing.T) {
+	assert := assert.New(t)
+	s, server := newBlockingStore(t)
+	conn := server.Dial()
+
+	conn.Do("XADD", "s", "1-0", "k", "1")
+	// the entries are read without blocking.
+	ids, err := conn.Do("XREAD", "BLOCK", "0", "STREAMS", "s", "0").Array()
+	assert.Nil(err)
+	assert.Len(ids, 1)
+
+	c1, c2 := server.Dial(), server.Dial()
+	// the waiter of the future ID does not stop serving the others.
+	c1.Send(beam.NewQuery("XREAD", "BLOCK", "0", "STREAMS", "s", "100-0"))
+	waitBlocked(t, s, "s", 1)
+	c2.Send(beam.NewQuery("XREAD", "BLOCK", "0", "STREAMS", "missing", "s", "$", "$"))
+	waitBlocked(t, s, "s", 2)
+	beamtest.AssertBulkString(t, "2-0", conn.Do("XADD", "s", "2-0", "k", "2"))
+	beamtest.AssertReply(t, beam.NewNestedArraysReply(
+		beam.NewNestedArraysReply(beam.NewBulkStringsReply("s"), beam.NewNestedArraysReply(
+			beam.NewNestedArraysReply(beam.NewBulkStringsReply("2-0"), beam.NewArraysReply("k", "2")),
+		)),
+	), c2.Read())
+	waitBlocked(t, s, "missing", 0)
+	waitBlocked(t, s, "s", 1)
+	beamtest.AssertBulkString(t, "101-0", conn.Do("XADD", "s", "101-0", "k", "3"))
+	reply, err := c1.Read().Array()
+	assert.Nil(err)
+	assert.Len(reply, 1)
+	waitBlocked(t, s, "s", 0)
+
+	// the new stream serves the waiters.
+	c1.Send(beam.NewQuery("XREAD", "BLOCK", "0", "STREAMS", "new", "$"))
+	waitBlocked(t, s, "new", 1)
+	conn.Do("XADD", "new", "1-0", "k", "1")
+	reply, err = c1.Read().Array()
+	assert.Nil(err)
+	assert.Len(reply, 1)
+
+	beamtest.AssertReply(t, beam.Reply("*-1\r\n"), conn.Do("XREAD", "BLOCK", "10", "STREAMS", "s", "$"))
+	waitBlocked(t, s, "s", 0)
+}
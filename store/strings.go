@@ -0,0 +1,290 @@
+package store
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/caeret/beam"
+)
+
+// maxStringLength is the max length of the strings built by SETRANGE and APPEND, 512MB as redis.
+const maxStringLength = 512 << 20
+
+var (
+	overflowReply       = beam.NewErrorsReply("ERR increment or decrement would overflow")
+	stringTooLargeReply = beam.NewErrorsReply("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+)
+
+// lookupString retrieves the string of the key, the error reply will be returned if the key holds
+// another type.
+func (c *call) lookupString(key []byte) (*entry, []byte, beam.Reply) {
+	e := c.lookup(key)
+	if e == nil {
+		return nil, nil, nil
+	}
+	v, ok := e.value.([]byte)
+	if !ok {
+		return nil, nil, wrongTypeReply
+	}
+	return e, v, nil
+}
+
+func getCommand(c *call) beam.Reply {
+	_, v, reply := c.lookupString(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	return beam.NewBulkStringsReplyRaw(v)
+}
+
+// setCommand implements SET key value [NX | XX] [GET] [EX seconds | PX milliseconds |
+// EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL].
+func setCommand(c *call) beam.Reply {
+	key := c.args[0]
+	var (
+		nx, xx, get, keepTTL bool
+		expiry               string
+		at                   int64
+	)
+	for i := 2; i < len(c.args); i++ {
+		switch opt := strings.ToUpper(string(c.args[i])); {
+		case opt == "NX" && !xx:
+			nx = true
+		case opt == "XX" && !nx:
+			xx = true
+		case opt == "GET":
+			get = true
+		case opt == "KEEPTTL" && expiry == "":
+			keepTTL = true
+		case (opt == "EX" || opt == "PX" || opt == "EXAT" || opt == "PXAT") && !keepTTL && expiry == "" && i+1 < len(c.args):
+			expiry = opt
+			i++
+			n, ok := parseInt(c.args[i])
+			if !ok {
+				return notIntegerReply
+			}
+			if n <= 0 {
+				return c.invalidExpire()
+			}
+			if at, ok = c.expireAt(n, opt[0] == 'P', strings.HasSuffix(opt, "AT")); !ok {
+				return c.invalidExpire()
+			}
+		default:
+			return syntaxErrReply
+		}
+	}
+
+	e := c.lookup(key)
+	var old []byte
+	if get && e != nil {
+		var ok bool
+		if old, ok = e.value.([]byte); !ok {
+			return wrongTypeReply
+		}
+	}
+	if (nx && e != nil) || (xx && e == nil) {
+		if get {
+			return beam.NewBulkStringsReplyRaw(old)
+		}
+		return nilReply
+	}
+	if keepTTL && e != nil {
+		e.value = clone(c.args[1])
+	} else {
+		e = c.set(key, clone(c.args[1]))
+		// the relative expiry is logged as the absolute one.
+		if expiry != "" && c.expire(key, e, at) {
+			c.propagate(query("SET", key, c.args[1], []byte("PXAT"), strconv.AppendInt(nil, at, 10)))
+		}
+	}
+	if get {
+		return beam.NewBulkStringsReplyRaw(old)
+	}
+	return okReply
+}
+
+func mgetCommand(c *call) beam.Reply {
+	values := make([][]byte, len(c.args))
+	for i, key := range c.args {
+		// the keys holding the other types are replied as nil.
+		if e := c.lookup(key); e != nil {
+			values[i], _ = e.value.([]byte)
+		}
+	}
+	return beam.NewArraysReplyRaw(values...)
+}
+
+func msetCommand(c *call) beam.Reply {
+	if len(c.args)%2 != 0 {
+		return wrongArgs(c.name)
+	}
+	for i := 0; i < len(c.args); i += 2 {
+		c.set(c.args[i], clone(c.args[i+1]))
+	}
+	return okReply
+}
+
+func incrCommand(c *call) beam.Reply {
+	return c.incrBy(c.args[0], 1)
+}
+
+func decrCommand(c *call) beam.Reply {
+	return c.incrBy(c.args[0], -1)
+}
+
+func incrbyCommand(c *call) beam.Reply {
+	delta, ok := parseInt(c.args[1])
+	if !ok {
+		return notIntegerReply
+	}
+	return c.incrBy(c.args[0], delta)
+}
+
+func decrbyCommand(c *call) beam.Reply {
+	delta, ok := parseInt(c.args[1])
+	if !ok {
+		return notIntegerReply
+	}
+	if delta == math.MinInt64 {
+		return beam.NewErrorsReply("ERR decrement would overflow")
+	}
+	return c.incrBy(c.args[0], -delta)
+}
+
+// incrBy adds delta to the integer of the key, the expiry of the key is kept.
+func (c *call) incrBy(key []byte, delta int64) beam.Reply {
+	e, v, reply := c.lookupString(key)
+	if reply != nil {
+		return reply
+	}
+	var n int64
+	if e != nil {
+		var ok bool
+		if n, ok = parseInt(v); !ok {
+			return notIntegerReply
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return overflowReply
+	}
+	n += delta
+	c.setString(key, e, strconv.AppendInt(nil, n, 10))
+	return integer(n)
+}
+
+func incrbyfloatCommand(c *call) beam.Reply {
+	e, v, reply := c.lookupString(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	delta, ok := parseFloat(c.args[1])
+	if !ok {
+		return notFloatReply
+	}
+	var f float64
+	if e != nil {
+		if f, ok = parseFloat(v); !ok {
+			return notFloatReply
+		}
+	}
+	f += delta
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return beam.NewErrorsReply("ERR increment would produce NaN or Infinity")
+	}
+	b := formatFloat(f)
+	c.setString(c.args[0], e, b)
+	return beam.NewBulkStringsReplyRaw(b)
+}
+
+// setString sets the string of the key, the expiry is kept if the entry exists.
+func (c *call) setString(key []byte, e *entry, v []byte) {
+	if e != nil {
+		e.value = v
+		return
+	}
+	c.set(key, v)
+}
+
+func appendCommand(c *call) beam.Reply {
+	e, v, reply := c.lookupString(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	if len(v)+len(c.args[1]) > maxStringLength {
+		return stringTooLargeReply
+	}
+	if e == nil {
+		v = clone(c.args[1])
+	} else {
+		v = append(v, c.args[1]...)
+	}
+	c.setString(c.args[0], e, v)
+	return integer(int64(len(v)))
+}
+
+func getrangeCommand(c *call) beam.Reply {
+	start, ok1 := parseInt(c.args[1])
+	end, ok2 := parseInt(c.args[2])
+	if !ok1 || !ok2 {
+		return notIntegerReply
+	}
+	_, v, reply := c.lookupString(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	n := int64(len(v))
+	if start < 0 && end < 0 && start > end {
+		return beam.NewBulkStringsReply("")
+	}
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	start = max(start, 0)
+	end = min(max(end, 0), n-1)
+	if start > end || n == 0 {
+		return beam.NewBulkStringsReply("")
+	}
+	return beam.NewBulkStringsReplyRaw(v[start : end+1])
+}
+
+func setrangeCommand(c *call) beam.Reply {
+	offset, ok := parseInt(c.args[1])
+	if !ok {
+		return notIntegerReply
+	}
+	if offset < 0 {
+		return beam.NewErrorsReply("ERR offset is out of range")
+	}
+	value := c.args[2]
+	e, v, reply := c.lookupString(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	// the empty value neither creates nor changes the key.
+	if len(value) == 0 {
+		return integer(int64(len(v)))
+	}
+	if offset > maxStringLength-int64(len(value)) {
+		return stringTooLargeReply
+	}
+	if end := int(offset) + len(value); end > len(v) {
+		grown := make([]byte, end)
+		copy(grown, v)
+		v = grown
+	}
+	copy(v[offset:], value)
+	c.setString(c.args[0], e, v)
+	return integer(int64(len(v)))
+}
+
+func strlenCommand(c *call) beam.Reply {
+	_, v, reply := c.lookupString(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	return integer(int64(len(v)))
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caeret/beam"
+	"github.com/caeret/beam/beamtest"
+)
+
+func TestStore_Set(t *testing.T) {
+	_, clk, conn := newTestStore(t, Config{ActiveExpireInterval: time.Hour})
+
+	beamtest.AssertOK(t, conn.Do("SET", "foo", "bar"))
+	beamtest.AssertBulkString(t, "bar", conn.Do("GET", "foo"))
+	beamtest.AssertNil(t, conn.Do("GET", "missing"))
+	beamtest.AssertOK(t, conn.Do("SET", "empty", ""))
+	beamtest.AssertBulkString(t, "", conn.Do("GET", "empty"))
+
+	beamtest.AssertNil(t, conn.Do("SET", "foo", "baz", "NX"))
+	beamtest.AssertNil(t, conn.Do("SET", "new", "v", "XX"))
+	beamtest.AssertNil(t, conn.Do("GET", "new"))
+	beamtest.AssertOK(t, conn.Do("SET", "foo", "baz", "xx"))
+	beamtest.AssertBulkString(t, "baz", conn.Do("SET", "foo", "qux", "GET"))
+	beamtest.AssertNil(t, conn.Do("SET", "new", "v", "GET"))
+	beamtest.AssertBulkString(t, "qux", conn.Do("SET", "foo", "quux", "NX", "GET"))
+	beamtest.AssertBulkString(t, "qux", conn.Do("GET", "foo"))
+
+	beamtest.AssertOK(t, conn.Do("SET", "foo", "bar", "EX", "10"))
+	beamtest.AssertInteger(t, 10, conn.Do("TTL", "foo"))
+	beamtest.AssertOK(t, conn.Do("SET", "foo", "baz", "KEEPTTL"))
+	beamtest.AssertInteger(t, 10000, conn.Do("PTTL", "foo"))
+	beamtest.AssertOK(t, conn.Do("SET", "foo", "baz"))
+	beamtest.AssertInteger(t, -1, conn.Do("TTL", "foo"))
+	beamtest.AssertOK(t, conn.Do("SET", "foo", "bar", "PXAT", "1700000000500"))
+	beamtest.AssertInteger(t, 500, conn.Do("PTTL", "foo"))
+	beamtest.AssertOK(t, conn.Do("SET", "foo", "bar", "EXAT", "1700000002"))
+	beamtest.AssertInteger(t, 2000, conn.Do("PTTL", "foo"))
+	clk.advance(time.Second * 2)
+	beamtest.AssertNil(t, conn.Do("GET", "foo"))
+
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("SET", "foo", "bar", "NX", "XX"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("SET", "foo", "bar", "EX", "10", "KEEPTTL"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("SET", "foo", "bar", "EX", "10", "PX", "10"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("SET", "foo", "bar", "EX"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("SET", "foo", "bar", "foo"))
+	beamtest.AssertError(t, "ERR value is not an integer or out of range", conn.Do("SET", "foo", "bar", "EX", "1.5"))
+	beamtest.AssertError(t, "ERR invalid expire time in 'set' command", conn.Do("SET", "foo", "bar", "EX", "0"))
+	beamtest.AssertError(t, "ERR invalid expire time in 'set' command", conn.Do("SET", "foo", "bar", "EX", "9223372036854775807"))
+}
+
+func TestStore_MGetMSet(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertOK(t, conn.Do("MSET", "a", "1", "b", "2"))
+	beamtest.AssertError(t, "ERR wrong number of arguments for 'mset' command", conn.Do("MSET", "a", "1", "b"))
+	beamtest.AssertReply(t, beam.Reply("*3\r\n$1\r\n1\r\n$-1\r\n$1\r\n2\r\n"), conn.Do("MGET", "a", "c", "b"))
+}
+
+func TestStore_Incr(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertInteger(t, 1, conn.Do("INCR", "n"))
+	beamtest.AssertInteger(t, 11, conn.Do("INCRBY", "n", "10"))
+	beamtest.AssertInteger(t, 10, conn.Do("DECR", "n"))
+	beamtest.AssertInteger(t, -5, conn.Do("DECRBY", "n", "15"))
+	beamtest.AssertBulkString(t, "-5", conn.Do("GET", "n"))
+
+	beamtest.AssertOK(t, conn.Do("SET", "n", "9223372036854775807", "EX", "100"))
+	beamtest.AssertError(t, "ERR increment or decrement would overflow", conn.Do("INCR", "n"))
+	beamtest.AssertInteger(t, 9223372036854775806, conn.Do("DECR", "n"))
+	beamtest.AssertInteger(t, 100, conn.Do("TTL", "n"))
+	beamtest.AssertError(t, "ERR decrement would overflow", conn.Do("DECRBY", "n", "-9223372036854775808"))
+
+	beamtest.AssertOK(t, conn.Do("SET", "s", "01"))
+	beamtest.AssertError(t, "ERR value is not an integer or out of range", conn.Do("INCR", "s"))
+	beamtest.AssertError(t, "ERR value is not an integer or out of range", conn.Do("INCRBY", "n", "x"))
+
+	beamtest.AssertBulkString(t, "10.5", conn.Do("INCRBYFLOAT", "f", "10.5"))
+	beamtest.AssertBulkString(t, "10.6", conn.Do("INCRBYFLOAT", "f", "0.1"))
+	beamtest.AssertBulkString(t, "5010.6", conn.Do("INCRBYFLOAT", "f", "5.0e3"))
+	beamtest.AssertBulkString(t, "4810", conn.Do("INCRBYFLOAT", "f", "-200.6"))
+	beamtest.AssertError(t, "ERR value is not a valid float", conn.Do("INCRBYFLOAT", "f", "abc"))
+	beamtest.AssertError(t, "ERR increment would produce NaN or Infinity", conn.Do("INCRBYFLOAT", "f", "inf"))
+	beamtest.AssertError(t, "ERR value is not a valid float", conn.Do("INCRBYFLOAT", "s", " 1"))
+}
+
+func TestStore_WrongType(t *testing.T) {
+	s, _, conn := newTestStore(t, Config{})
+	s.mutex.Lock()
+	s.keys.put("other", &entry{value: struct{}{}})
+	s.mutex.Unlock()
+
+	for _, args := range [][]string{
+		{"GET", "other"},
+		{"SET", "other", "v", "GET"},
+		{"INCR", "other"},
+		{"INCRBYFLOAT", "other", "1"},
+		{"APPEND", "other", "v"},
+		{"GETRANGE", "other", "0", "1"},
+		{"SETRANGE", "other", "0", "v"},
+		{"STRLEN", "other"},
+	} {
+		beamtest.AssertError(t, "WRONGTYPE Operation against a key holding the wrong kind of value", conn.Do(args[0], args[1:]...))
+	}
+	beamtest.AssertReply(t, beam.Reply("*1\r\n$-1\r\n"), conn.Do("MGET", "other"))
+	beamtest.AssertOK(t, conn.Do("SET", "other", "v"))
+	beamtest.AssertBulkString(t, "v", conn.Do("GET", "other"))
+}
+
+func TestStore_StringRanges(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertInteger(t, 5, conn.Do("APPEND", "s", "Hello"))
+	beamtest.AssertInteger(t, 11, conn.Do("APPEND", "s", " World"))
+	beamtest.AssertInteger(t, 11, conn.Do("STRLEN", "s"))
+	beamtest.AssertInteger(t, 0, conn.Do("STRLEN", "missing"))
+	beamtest.AssertInteger(t, 0, conn.Do("APPEND", "empty", ""))
+	beamtest.AssertBulkString(t, "", conn.Do("GET", "empty"))
+
+	beamtest.AssertBulkString(t, "Hello", conn.Do("GETRANGE", "s", "0", "4"))
+	beamtest.AssertBulkString(t, "World", conn.Do("GETRANGE", "s", "-5", "-1"))
+	beamtest.AssertBulkString(t, "Hello World", conn.Do("GETRANGE", "s", "-100", "100"))
+	beamtest.AssertBulkString(t, "", conn.Do("GETRANGE", "s", "5", "3"))
+	beamtest.AssertBulkString(t, "", conn.Do("GETRANGE", "s", "-1", "-5"))
+	beamtest.AssertBulkString(t, "", conn.Do("GETRANGE", "missing", "0", "-1"))
+
+	beamtest.AssertInteger(t, 11, conn.Do("SETRANGE", "s", "6", "Redis"))
+	beamtest.AssertBulkString(t, "Hello Redis", conn.Do("GET", "s"))
+	beamtest.AssertInteger(t, 13, conn.Do("SETRANGE", "s", "11", "!!"))
+	beamtest.AssertInteger(t, 3, conn.Do("SETRANGE", "z", "2", "x"))
+	beamtest.AssertBulkString(t, "\x00\x00x", conn.Do("GET", "z"))
+	beamtest.AssertInteger(t, 0, conn.Do("SETRANGE", "none", "2", ""))
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "none"))
+	beamtest.AssertError(t, "ERR offset is out of range", conn.Do("SETRANGE", "s", "-1", "x"))
+	beamtest.AssertError(t, "ERR string exceeds maximum allowed size", conn.Do("SETRANGE", "s", "536870912", "x"))
+	beamtest.AssertError(t, "ERR string exceeds maximum allowed size", conn.Do("SETRANGE", "s", "9223372036854775807", "x"))
+}
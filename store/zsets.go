@@ -0,0 +1,788 @@
+package store
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/caeret/beam"
+)
+
+// zset is the value of the sorted set keys, the scores are looked up by the members in the dict and
+// the members are ordered by the scores in the skiplist.
+type zset struct {
+	scores dict[float64]
+	zsl    *skiplist
+}
+
+func newZset() *zset {
+	return &zset{zsl: newSkiplist()}
+}
+
+func (z *zset) len() int {
+	return z.scores.len()
+}
+
+// add adds the member or updates its score.
+func (z *zset) add(member string, score float64) {
+	if current, exist := z.scores.get(member); exist {
+		if current == score {
+			return
+		}
+		z.zsl.delete(current, member)
+	}
+	z.scores.put(member, score)
+	z.zsl.insert(score, member)
+}
+
+// remove removes the member, false will be returned if it does not exist.
+func (z *zset) remove(member string) bool {
+	score, exist := z.scores.get(member)
+	if !exist {
+		return false
+	}
+	z.scores.delete(member)
+	z.zsl.delete(score, member)
+	return true
+}
+
+// lookupZset retrieves the sorted set of the key, the error reply will be returned if the key holds
+// another type.
+func (c *call) lookupZset(key []byte) (*zset, beam.Reply) {
+	e := c.lookup(key)
+	if e == nil {
+		return nil, nil
+	}
+	z, ok := e.value.(*zset)
+	if !ok {
+		return nil, wrongTypeReply
+	}
+	return z, nil
+}
+
+// lookupOrCreateZset retrieves the sorted set of the key, the empty one is created if the key does
+// not exist.
+func (c *call) lookupOrCreateZset(key []byte) (*zset, beam.Reply) {
+	z, reply := c.lookupZset(key)
+	if reply != nil || z != nil {
+		return z, reply
+	}
+	z = newZset()
+	c.set(key, z)
+	return z, nil
+}
+
+// removeIfEmptyZset removes the key of the empty sorted set.
+func (c *call) removeIfEmptyZset(key []byte, z *zset) {
+	if z.len() == 0 {
+		c.remove(key)
+	}
+}
+
+// formatScore formats the score as redis does, the exponent is used for the very large or small
+// scores only.
+func formatScore(f float64) []byte {
+	switch {
+	case math.IsInf(f, 1):
+		return []byte("inf")
+	case math.IsInf(f, -1):
+		return []byte("-inf")
+	}
+	b := strconv.AppendFloat(nil, f, 'e', -1, 64)
+	exp, _ := strconv.Atoi(string(b[bytes.IndexByte(b, 'e')+1:]))
+	if exp < -4 || exp >= 17 {
+		return b
+	}
+	return strconv.AppendFloat(b[:0], f, 'f', -1, 64)
+}
+
+var nanScoreReply = beam.NewErrorsReply("ERR resulting score is not a number (NaN)")
+
+// zaddCommand implements ZADD key [NX | XX] [GT | LT] [CH] [INCR] score member [score member ...].
+func zaddCommand(c *call) beam.Reply {
+	var nx, xx, gt, lt, ch, incr bool
+	i := 1
+options:
+	for ; i < len(c.args); i++ {
+		switch strings.ToUpper(string(c.args[i])) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		case "CH":
+			ch = true
+		case "INCR":
+			incr = true
+		default:
+			break options
+		}
+	}
+	pairs := c.args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return syntaxErrReply
+	}
+	if nx && xx {
+		return beam.NewErrorsReply("ERR XX and NX options at the same time are not compatible")
+	}
+	if (gt && lt) || (nx && (gt || lt)) {
+		return beam.NewErrorsReply("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if incr && len(pairs) > 2 {
+		return beam.NewErrorsReply("ERR INCR option supports a single increment-element pair")
+	}
+	// the scores are parsed before any member is added.
+	scores := make([]float64, len(pairs)/2)
+	for j := range scores {
+		var ok bool
+		if scores[j], ok = parseFloat(pairs[j*2]); !ok {
+			return notFloatReply
+		}
+	}
+
+	key := c.args[0]
+	z, reply := c.lookupZset(key)
+	if reply != nil {
+		return reply
+	}
+	if z == nil {
+		if xx {
+			if incr {
+				return nilReply
+			}
+			return integer(0)
+		}
+		z = newZset()
+		c.set(key, z)
+	}
+	added, changed := 0, 0
+	for j, score := range scores {
+		member := string(pairs[j*2+1])
+		current, exist := z.scores.get(member)
+		if (nx && exist) || (xx && !exist) {
+			if incr {
+				return nilReply
+			}
+			continue
+		}
+		if exist {
+			if incr {
+				score += current
+				if math.IsNaN(score) {
+					return nanScoreReply
+				}
+			}
+			if (gt && score <= current) || (lt && score >= current) {
+				if incr {
+					return nilReply
+				}
+				continue
+			}
+			if score != current {
+				changed++
+			}
+		} else {
+			added++
+		}
+		z.add(member, score)
+		if incr {
+			return beam.NewBulkStringsReplyRaw(formatScore(score))
+		}
+	}
+	if ch {
+		return integer(int64(added + changed))
+	}
+	return integer(int64(added))
+}
+
+func zincrbyCommand(c *call) beam.Reply {
+	delta, ok := parseFloat(c.args[1])
+	if !ok {
+		return notFloatReply
+	}
+	z, reply := c.lookupOrCreateZset(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	member := string(c.args[2])
+	current, _ := z.scores.get(member)
+	score := current + delta
+	if math.IsNaN(score) {
+		c.removeIfEmptyZset(c.args[0], z)
+		return nanScoreReply
+	}
+	z.add(member, score)
+	return beam.NewBulkStringsReplyRaw(formatScore(score))
+}
+
+func zremCommand(c *call) beam.Reply {
+	z, reply := c.lookupZset(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	if z == nil {
+		return integer(0)
+	}
+	n := 0
+	for _, member := range c.args[1:] {
+		if z.remove(string(member)) {
+			n++
+		}
+	}
+	c.removeIfEmptyZset(c.args[0], z)
+	return integer(int64(n))
+}
+
+func zcardCommand(c *call) beam.Reply {
+	z, reply := c.lookupZset(c.args[0])
+	if reply != nil || z == nil {
+		return orZero(reply)
+	}
+	return integer(int64(z.len()))
+}
+
+// orZero replies 0 if there is no error reply.
+func orZero(reply beam.Reply) beam.Reply {
+	if reply == nil {
+		return integer(0)
+	}
+	return reply
+}
+
+func zscoreCommand(c *call) beam.Reply {
+	z, reply := c.lookupZset(c.args[0])
+	if reply != nil || z == nil {
+		return orNil(reply)
+	}
+	score, exist := z.scores.get(string(c.args[1]))
+	if !exist {
+		return nilReply
+	}
+	return beam.NewBulkStringsReplyRaw(formatScore(score))
+}
+
+func zmscoreCommand(c *call) beam.Reply {
+	z, reply := c.lookupZset(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	b := beam.AppendArraysHeader(nil, len(c.args)-1)
+	for _, member := range c.args[1:] {
+		var score []byte
+		if z != nil {
+			if f, exist := z.scores.get(string(member)); exist {
+				score = formatScore(f)
+			}
+		}
+		b = beam.AppendBulkStringsRaw(b, score)
+	}
+	return b
+}
+
+func zrankCommand(c *call) beam.Reply {
+	return c.zrankGeneric(false)
+}
+
+func zrevrankCommand(c *call) beam.Reply {
+	return c.zrankGeneric(true)
+}
+
+// zrankGeneric implements ZRANK key member [WITHSCORE] and ZREVRANK.
+func (c *call) zrankGeneric(rev bool) beam.Reply {
+	withScore := false
+	if len(c.args) > 2 {
+		if len(c.args) > 3 || !strings.EqualFold(string(c.args[2]), "WITHSCORE") {
+			return syntaxErrReply
+		}
+		withScore = true
+	}
+	notFound := nilReply
+	if withScore {
+		notFound = nilArrayReply
+	}
+	z, reply := c.lookupZset(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	if z == nil {
+		return notFound
+	}
+	member := string(c.args[1])
+	score, exist := z.scores.get(member)
+	if !exist {
+		return notFound
+	}
+	rank := z.zsl.rank(score, member) - 1
+	if rev {
+		rank = z.len() - 1 - rank
+	}
+	if !withScore {
+		return integer(int64(rank))
+	}
+	b := beam.AppendArraysHeader(nil, 2)
+	b = beam.AppendIntegers(b, int64(rank))
+	return beam.AppendBulkStringsRaw(b, formatScore(score))
+}
+
+// parseScoreRange parses the min and max scores, which are excluded with the prefix "(".
+func parseScoreRange(minArg, maxArg []byte) (scoreRange, beam.Reply) {
+	var r scoreRange
+	var ok1, ok2 bool
+	r.min, r.minEx, ok1 = parseScoreBound(minArg)
+	r.max, r.maxEx, ok2 = parseScoreBound(maxArg)
+	if !ok1 || !ok2 {
+		return r, beam.NewErrorsReply("ERR min or max is not a float")
+	}
+	return r, nil
+}
+
+func parseScoreBound(b []byte) (float64, bool, bool) {
+	ex := len(b) > 0 && b[0] == '('
+	if ex {
+		b = b[1:]
+	}
+	f, ok := parseFloat(b)
+	return f, ex, ok
+}
+
+// parseLexRange parses the min and max members, which are "-", "+", or prefixed with "[" or "(".
+func parseLexRange(minArg, maxArg []byte) (lexRange, beam.Reply) {
+	var r lexRange
+	var ok1, ok2 bool
+	r.min, ok1 = parseLexBound(minArg)
+	r.max, ok2 = parseLexBound(maxArg)
+	if !ok1 || !ok2 {
+		return r, beam.NewErrorsReply("ERR min or max not valid string range item")
+	}
+	return r, nil
+}
+
+func parseLexBound(b []byte) (lexBound, bool) {
+	switch {
+	case string(b) == "-":
+		return lexBound{inf: -1}, true
+	case string(b) == "+":
+		return lexBound{inf: 1}, true
+	case len(b) > 0 && (b[0] == '[' || b[0] == '('):
+		return lexBound{value: string(b[1:]), ex: b[0] == '('}, true
+	}
+	return lexBound{}, false
+}
+
+func zcountCommand(c *call) beam.Reply {
+	r, reply := parseScoreRange(c.args[1], c.args[2])
+	if reply != nil {
+		return reply
+	}
+	return c.zcountGeneric(r)
+}
+
+func zlexcountCommand(c *call) beam.Reply {
+	r, reply := parseLexRange(c.args[1], c.args[2])
+	if reply != nil {
+		return reply
+	}
+	return c.zcountGeneric(r)
+}
+
+// zcountGeneric counts the members in the range by the ranks of the first and the last ones.
+func (c *call) zcountGeneric(r zrange) beam.Reply {
+	z, reply := c.lookupZset(c.args[0])
+	if reply != nil || z == nil {
+		return orZero(reply)
+	}
+	first := z.zsl.first(r)
+	if first == nil {
+		return integer(0)
+	}
+	last := z.zsl.last(r)
+	n := z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+	return integer(int64(max(n, 0)))
+}
+
+// rangeBy is how the members are selected by ZRANGE.
+type rangeBy int
+
+const (
+	byRank rangeBy = iota
+	byScore
+	byLex
+)
+
+// zrangeArgs is the arguments of ZRANGE and its variants, start and stop are the min and the max
+// with BYSCORE or BYLEX.
+type zrangeArgs struct {
+	start, stop []byte
+	by          rangeBy
+	rev         bool
+	withScores  bool
+	limit       bool
+	offset      int64
+	// count is negative for all the members.
+	count int64
+}
+
+func zrangeCommand(c *call) beam.Reply {
+	return c.zrangeGeneric(zrangeArgs{}, true)
+}
+
+func zrevrangeCommand(c *call) beam.Reply {
+	return c.zrangeGeneric(zrangeArgs{rev: true}, false)
+}
+
+func zrangebyscoreCommand(c *call) beam.Reply {
+	return c.zrangeGeneric(zrangeArgs{by: byScore}, false)
+}
+
+func zrevrangebyscoreCommand(c *call) beam.Reply {
+	return c.zrangeGeneric(zrangeArgs{by: byScore, rev: true}, false)
+}
+
+func zrangebylexCommand(c *call) beam.Reply {
+	return c.zrangeGeneric(zrangeArgs{by: byLex}, false)
+}
+
+func zrevrangebylexCommand(c *call) beam.Reply {
+	return c.zrangeGeneric(zrangeArgs{by: byLex, rev: true}, false)
+}
+
+// zrangeGeneric implements ZRANGE key start stop [BYSCORE | BYLEX] [REV] [LIMIT offset count]
+// [WITHSCORES], BYSCORE, BYLEX and REV are only parsed for ZRANGE as the variants imply them.
+func (c *call) zrangeGeneric(args zrangeArgs, zrange bool) beam.Reply {
+	args.count = -1
+	opts := c.args[3:]
+	for i := 0; i < len(opts); i++ {
+		opt := strings.ToUpper(string(opts[i]))
+		switch {
+		case opt == "WITHSCORES":
+			args.withScores = true
+		case opt == "LIMIT" && i+2 < len(opts):
+			offset, ok1 := parseInt(opts[i+1])
+			count, ok2 := parseInt(opts[i+2])
+			if !ok1 || !ok2 {
+				return notIntegerReply
+			}
+			args.limit, args.offset, args.count = true, offset, count
+			i += 2
+		case opt == "BYSCORE" && zrange:
+			args.by = byScore
+		case opt == "BYLEX" && zrange:
+			args.by = byLex
+		case opt == "REV" && zrange:
+			args.rev = true
+		default:
+			return syntaxErrReply
+		}
+	}
+	if args.limit && args.by == byRank {
+		return beam.NewErrorsReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if args.withScores && args.by == byLex {
+		return beam.NewErrorsReply("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	args.start, args.stop = c.args[1], c.args[2]
+	// the max is ahead of the min in the reversed range.
+	if args.rev && args.by != byRank {
+		args.start, args.stop = args.stop, args.start
+	}
+
+	var (
+		nodes []*skiplistNode
+		reply beam.Reply
+	)
+	switch args.by {
+	case byRank:
+		nodes, reply = c.zrangeByRank(args)
+	case byScore:
+		var r scoreRange
+		if r, reply = parseScoreRange(args.start, args.stop); reply == nil {
+			nodes, reply = c.zrangeByRange(args, r)
+		}
+	case byLex:
+		var r lexRange
+		if r, reply = parseLexRange(args.start, args.stop); reply == nil {
+			nodes, reply = c.zrangeByRange(args, r)
+		}
+	}
+	if reply != nil {
+		return reply
+	}
+	return nodesReply(nodes, args.withScores)
+}
+
+// zrangeByRank selects the members by the indexes which may be negative.
+func (c *call) zrangeByRank(args zrangeArgs) ([]*skiplistNode, beam.Reply) {
+	start, ok1 := parseInt(args.start)
+	stop, ok2 := parseInt(args.stop)
+	if !ok1 || !ok2 {
+		return nil, notIntegerReply
+	}
+	z, reply := c.lookupZset(c.args[0])
+	if reply != nil || z == nil {
+		return nil, reply
+	}
+	from, to := listRange(start, stop, z.len())
+	if from == to {
+		return nil, nil
+	}
+	nodes := make([]*skiplistNode, 0, to-from)
+	if args.rev {
+		for x := z.zsl.byRank(z.len() - from); len(nodes) < to-from; x = x.backward {
+			nodes = append(nodes, x)
+		}
+	} else {
+		for x := z.zsl.byRank(from + 1); len(nodes) < to-from; x = x.next() {
+			nodes = append(nodes, x)
+		}
+	}
+	return nodes, nil
+}
+
+// zrangeByRange selects the members in the range of the scores or the members.
+func (c *call) zrangeByRange(args zrangeArgs, r zrange) ([]*skiplistNode, beam.Reply) {
+	z, reply := c.lookupZset(c.args[0])
+	if reply != nil || z == nil || args.offset < 0 {
+		return nil, reply
+	}
+	var x *skiplistNode
+	if args.rev {
+		x = z.zsl.last(r)
+	} else {
+		x = z.zsl.first(r)
+	}
+	var nodes []*skiplistNode
+	for offset := args.offset; x != nil && int64(len(nodes)) != args.count; {
+		if args.rev {
+			if !r.gteMin(x) {
+				break
+			}
+		} else if !r.lteMax(x) {
+			break
+		}
+		if offset > 0 {
+			offset--
+		} else {
+			nodes = append(nodes, x)
+		}
+		if args.rev {
+			x = x.backward
+		} else {
+			x = x.next()
+		}
+	}
+	return nodes, nil
+}
+
+// nodesReply replies the members, which are followed by their scores with withScores.
+func nodesReply(nodes []*skiplistNode, withScores bool) beam.Reply {
+	n := len(nodes)
+	if withScores {
+		n *= 2
+	}
+	b := beam.AppendArraysHeader(nil, n)
+	for _, x := range nodes {
+		b = beam.AppendBulkStrings(b, x.member)
+		if withScores {
+			b = beam.AppendBulkStringsRaw(b, formatScore(x.score))
+		}
+	}
+	return b
+}
+
+func zpopminCommand(c *call) beam.Reply {
+	return c.zpopGeneric(false)
+}
+
+func zpopmaxCommand(c *call) beam.Reply {
+	return c.zpopGeneric(true)
+}
+
+// zpopGeneric implements ZPOPMIN key [count] and ZPOPMAX key [count], the members are replied with
+// their scores.
+func (c *call) zpopGeneric(highest bool) beam.Reply {
+	if len(c.args) > 2 {
+		return syntaxErrReply
+	}
+	count := int64(1)
+	if len(c.args) == 2 {
+		var ok bool
+		if count, ok = parseInt(c.args[1]); !ok || count < 0 {
+			return beam.NewErrorsReply("ERR value is out of range, must be positive")
+		}
+	}
+	key := c.args[0]
+	z, reply := c.lookupZset(key)
+	if reply != nil || z == nil {
+		if reply == nil {
+			reply = beam.AppendArraysHeader(nil, 0)
+		}
+		return reply
+	}
+	var nodes []*skiplistNode
+	for int64(len(nodes)) < count && z.len() > 0 {
+		x := z.zsl.header.next()
+		if highest {
+			x = z.zsl.tail
+		}
+		z.remove(x.member)
+		nodes = append(nodes, x)
+	}
+	c.removeIfEmptyZset(key, z)
+	return nodesReply(nodes, true)
+}
+
+func zunionstoreCommand(c *call) beam.Reply {
+	return c.zstoreGeneric(false)
+}
+
+func zinterstoreCommand(c *call) beam.Reply {
+	return c.zstoreGeneric(true)
+}
+
+// zstoreGeneric implements ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight ...]
+// [AGGREGATE SUM | MIN | MAX] and ZINTERSTORE, the sets are regarded as the sorted sets with the
+// score 1.
+func (c *call) zstoreGeneric(inter bool) beam.Reply {
+	dst := c.args[0]
+	numKeys, ok := parseInt(c.args[1])
+	if !ok {
+		return notIntegerReply
+	}
+	if numKeys < 1 {
+		return beam.NewErrorsReply("ERR at least 1 input key is needed for '" + c.name + "' command")
+	}
+	if numKeys > int64(len(c.args)-2) {
+		return syntaxErrReply
+	}
+	keys := c.args[2 : 2+numKeys]
+	weights := make([]float64, len(keys))
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggregate := "SUM"
+	opts := c.args[2+numKeys:]
+	for i := 0; i < len(opts); i++ {
+		opt := strings.ToUpper(string(opts[i]))
+		switch {
+		case opt == "WEIGHTS" && i+len(keys) < len(opts):
+			for j := range weights {
+				if weights[j], ok = parseFloat(opts[i+1+j]); !ok {
+					return beam.NewErrorsReply("ERR weight value is not a float")
+				}
+			}
+			i += len(keys)
+		case opt == "AGGREGATE" && i+1 < len(opts):
+			i++
+			aggregate = strings.ToUpper(string(opts[i]))
+			if aggregate != "SUM" && aggregate != "MIN" && aggregate != "MAX" {
+				return syntaxErrReply
+			}
+		default:
+			return syntaxErrReply
+		}
+	}
+
+	// the scores of the members of each key, the missing keys are regarded as empty.
+	sources := make([]map[string]float64, len(keys))
+	for i, key := range keys {
+		e := c.lookup(key)
+		if e == nil {
+			continue
+		}
+		switch v := e.value.(type) {
+		case *zset:
+			scores := make(map[string]float64, v.len())
+			v.scores.each(func(member string, score float64) bool {
+				scores[member] = score
+				return true
+			})
+			sources[i] = scores
+		case *set:
+			scores := make(map[string]float64, v.len())
+			v.each(func(member string, _ struct{}) bool {
+				scores[member] = 1
+				return true
+			})
+			sources[i] = scores
+		default:
+			return wrongTypeReply
+		}
+	}
+
+	result := make(map[string]float64)
+	for i, source := range sources {
+		for member, score := range source {
+			// inf * 0 is regarded as 0.
+			score *= weights[i]
+			if math.IsNaN(score) {
+				score = 0
+			}
+			current, exist := result[member]
+			if !exist {
+				if inter && i > 0 {
+					continue
+				}
+				result[member] = score
+				continue
+			}
+			switch aggregate {
+			case "SUM":
+				// inf + -inf is regarded as 0.
+				if current += score; math.IsNaN(current) {
+					current = 0
+				}
+			case "MIN":
+				current = min(current, score)
+			case "MAX":
+				current = max(current, score)
+			}
+			result[member] = current
+		}
+		if inter {
+			for member := range result {
+				if _, exist := source[member]; !exist {
+					delete(result, member)
+				}
+			}
+		}
+	}
+
+	if len(result) == 0 {
+		c.remove(dst)
+		return integer(0)
+	}
+	z := newZset()
+	for member, score := range result {
+		z.add(member, score)
+	}
+	c.set(dst, z)
+	return integer(int64(z.len()))
+}
+
+// zscanCommand implements ZSCAN key cursor [MATCH pattern] [COUNT count], the members are followed
+// by their scores.
+func zscanCommand(c *call) beam.Reply {
+	opts, reply := c.parseScanArgs(c.args[1:])
+	if reply != nil {
+		return reply
+	}
+	z, reply := c.lookupZset(c.args[0])
+	if reply != nil {
+		return reply
+	}
+	// the missing key is scanned as the empty dict.
+	var scores *dict[float64]
+	if z != nil {
+		scores = &z.scores
+	}
+	var elems [][]byte
+	cursor := scanDict(scores, opts, func(member string, score float64) bool {
+		elems = append(elems, []byte(member), formatScore(score))
+		return true
+	})
+	return scanReply(cursor, elems)
+}
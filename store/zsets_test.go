@@ -0,0 +1,179 @@
+package store
+
+import (
+	"math"
+	"testing"
+
+	"github.com/caeret/beam"
+	"github.com/caeret/beam/beamtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_ZAdd(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertInteger(t, 3, conn.Do("ZADD", "z", "1", "a", "2", "b", "3", "c"))
+	beamtest.AssertSimpleString(t, "zset", conn.Do("TYPE", "z"))
+	beamtest.AssertInteger(t, 1, conn.Do("ZADD", "z", "10", "a", "4", "d"))
+	beamtest.AssertInteger(t, 2, conn.Do("ZADD", "z", "CH", "1", "a", "2", "b", "5", "e"))
+	beamtest.AssertInteger(t, 0, conn.Do("ZADD", "z", "NX", "100", "a"))
+	beamtest.AssertInteger(t, 0, conn.Do("ZADD", "z", "XX", "100", "x"))
+	beamtest.AssertInteger(t, 1, conn.Do("ZADD", "z", "XX", "CH", "0.5", "a"))
+	beamtest.AssertInteger(t, 0, conn.Do("ZADD", "z", "GT", "CH", "0", "a"))
+	beamtest.AssertInteger(t, 1, conn.Do("ZADD", "z", "LT", "CH", "0", "a"))
+	beamtest.AssertInteger(t, 0, conn.Do("ZADD", "missing", "XX", "1", "a"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "missing"))
+	beamtest.AssertInteger(t, 5, conn.Do("ZCARD", "z"))
+	beamtest.AssertInteger(t, 0, conn.Do("ZCARD", "missing"))
+
+	beamtest.AssertBulkString(t, "2.5", conn.Do("ZADD", "z", "INCR", "2.5", "a"))
+	beamtest.AssertNil(t, conn.Do("ZADD", "z", "INCR", "NX", "1", "a"))
+	beamtest.AssertNil(t, conn.Do("ZADD", "z", "INCR", "GT", "-1", "a"))
+	beamtest.AssertBulkString(t, "1.5", conn.Do("ZINCRBY", "z", "-1", "a"))
+	beamtest.AssertBulkString(t, "1.5", conn.Do("ZSCORE", "z", "a"))
+	beamtest.AssertNil(t, conn.Do("ZSCORE", "z", "x"))
+	beamtest.AssertReply(t, beam.Reply("*2\r\n$1\r\n2\r\n$-1\r\n"), conn.Do("ZMSCORE", "z", "b", "x"))
+
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("ZADD", "z", "1", "a", "2"))
+	beamtest.AssertError(t, "ERR value is not a valid float", conn.Do("ZADD", "z", "1", "a", "x", "b"))
+	beamtest.AssertError(t, "ERR value is not a valid float", conn.Do("ZADD", "z", "nan", "a"))
+	beamtest.AssertError(t, "ERR XX and NX options at the same time are not compatible", conn.Do("ZADD", "z", "NX", "XX", "1", "a"))
+	beamtest.AssertError(t, "ERR GT, LT, and/or NX options at the same time are not compatible", conn.Do("ZADD", "z", "GT", "LT", "1", "a"))
+	beamtest.AssertError(t, "ERR INCR option supports a single increment-element pair", conn.Do("ZADD", "z", "INCR", "1", "a", "2", "b"))
+	beamtest.AssertOK(t, conn.Do("SET", "str", "v"))
+	beamtest.AssertError(t, "WRONGTYPE", conn.Do("ZADD", "str", "1", "a"))
+
+	beamtest.AssertInteger(t, 1, conn.Do("ZADD", "inf", "inf", "a"))
+	beamtest.AssertError(t, "ERR resulting score is not a number (NaN)", conn.Do("ZINCRBY", "inf", "-inf", "a"))
+	beamtest.AssertBulkString(t, "inf", conn.Do("ZSCORE", "inf", "a"))
+
+	beamtest.AssertInteger(t, 2, conn.Do("ZREM", "z", "a", "b", "x"))
+	beamtest.AssertInteger(t, 3, conn.Do("ZREM", "z", "c", "d", "e"))
+	// the key is removed with the last member.
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "z"))
+}
+
+func TestStore_ZRange(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertInteger(t, 5, conn.Do("ZADD", "z", "1", "a", "2", "b", "3", "c", "3", "d", "5", "e"))
+	beamtest.AssertStrings(t, []string{"a", "b", "c", "d", "e"}, conn.Do("ZRANGE", "z", "0", "-1"))
+	beamtest.AssertStrings(t, []string{"b", "2", "c", "3"}, conn.Do("ZRANGE", "z", "1", "2", "WITHSCORES"))
+	beamtest.AssertStrings(t, []string{"e", "d"}, conn.Do("ZRANGE", "z", "0", "1", "REV"))
+	beamtest.AssertStrings(t, []string{"d", "c"}, conn.Do("ZREVRANGE", "z", "1", "2"))
+	beamtest.AssertStrings(t, []string{}, conn.Do("ZRANGE", "z", "10", "20"))
+	beamtest.AssertStrings(t, []string{}, conn.Do("ZRANGE", "missing", "0", "-1"))
+
+	beamtest.AssertStrings(t, []string{"b", "c", "d"}, conn.Do("ZRANGE", "z", "(1", "3", "BYSCORE"))
+	beamtest.AssertStrings(t, []string{"c", "d", "e"}, conn.Do("ZRANGEBYSCORE", "z", "3", "+inf"))
+	beamtest.AssertStrings(t, []string{"e", "d"}, conn.Do("ZRANGE", "z", "+inf", "3", "BYSCORE", "REV", "LIMIT", "0", "2"))
+	beamtest.AssertStrings(t, []string{"c", "3"}, conn.Do("ZREVRANGEBYSCORE", "z", "(5", "-inf", "WITHSCORES", "LIMIT", "1", "1"))
+	beamtest.AssertStrings(t, []string{"b", "c"}, conn.Do("ZRANGE", "z", "-inf", "inf", "BYSCORE", "LIMIT", "1", "2"))
+	beamtest.AssertStrings(t, []string{}, conn.Do("ZRANGE", "z", "4", "3", "BYSCORE"))
+	beamtest.AssertStrings(t, []string{}, conn.Do("ZRANGE", "z", "(3", "3", "BYSCORE"))
+
+	beamtest.AssertInteger(t, 3, conn.Do("ZCOUNT", "z", "2", "3"))
+	beamtest.AssertInteger(t, 2, conn.Do("ZCOUNT", "z", "(2", "(5"))
+	beamtest.AssertInteger(t, 0, conn.Do("ZCOUNT", "z", "6", "+inf"))
+	beamtest.AssertInteger(t, 0, conn.Do("ZCOUNT", "missing", "-inf", "+inf"))
+
+	beamtest.AssertInteger(t, 2, conn.Do("ZRANK", "z", "c"))
+	beamtest.AssertInteger(t, 1, conn.Do("ZREVRANK", "z", "d"))
+	beamtest.AssertReply(t, beam.Reply("*2\r\n:4\r\n$1\r\n5\r\n"), conn.Do("ZRANK", "z", "e", "WITHSCORE"))
+	beamtest.AssertNil(t, conn.Do("ZRANK", "z", "x"))
+	beamtest.AssertReply(t, beam.Reply("*-1\r\n"), conn.Do("ZRANK", "z", "x", "WITHSCORE"))
+
+	beamtest.AssertError(t, "ERR min or max is not a float", conn.Do("ZRANGE", "z", "a", "3", "BYSCORE"))
+	beamtest.AssertError(t, "ERR value is not an integer or out of range", conn.Do("ZRANGE", "z", "a", "3"))
+	beamtest.AssertError(t, "ERR syntax error, LIMIT is only supported", conn.Do("ZRANGE", "z", "0", "1", "LIMIT", "0", "1"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("ZREVRANGE", "z", "0", "1", "BYSCORE"))
+}
+
+func TestStore_ZRangeByLex(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertInteger(t, 5, conn.Do("ZADD", "z", "0", "a", "0", "b", "0", "c", "0", "d", "0", "e"))
+	beamtest.AssertStrings(t, []string{"a", "b", "c", "d", "e"}, conn.Do("ZRANGE", "z", "-", "+", "BYLEX"))
+	beamtest.AssertStrings(t, []string{"b", "c"}, conn.Do("ZRANGEBYLEX", "z", "[b", "(d"))
+	beamtest.AssertStrings(t, []string{"d", "c"}, conn.Do("ZRANGE", "z", "[d", "(b", "BYLEX", "REV"))
+	beamtest.AssertStrings(t, []string{"e"}, conn.Do("ZREVRANGEBYLEX", "z", "+", "-", "LIMIT", "0", "1"))
+	beamtest.AssertInteger(t, 3, conn.Do("ZLEXCOUNT", "z", "(a", "[d"))
+	beamtest.AssertError(t, "ERR min or max not valid string range item", conn.Do("ZRANGEBYLEX", "z", "a", "+"))
+	beamtest.AssertError(t, "ERR syntax error, WITHSCORES not supported", conn.Do("ZRANGE", "z", "-", "+", "BYLEX", "WITHSCORES"))
+}
+
+func TestStore_ZPop(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertInteger(t, 4, conn.Do("ZADD", "z", "1", "a", "2", "b", "3", "c", "4", "d"))
+	beamtest.AssertStrings(t, []string{"a", "1"}, conn.Do("ZPOPMIN", "z"))
+	beamtest.AssertStrings(t, []string{"d", "4", "c", "3"}, conn.Do("ZPOPMAX", "z", "2"))
+	beamtest.AssertStrings(t, []string{"b", "2"}, conn.Do("ZPOPMIN", "z", "5"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "z"))
+	beamtest.AssertStrings(t, []string{}, conn.Do("ZPOPMIN", "z"))
+	beamtest.AssertError(t, "ERR value is out of range, must be positive", conn.Do("ZPOPMAX", "z", "-1"))
+}
+
+func TestStore_ZStore(t *testing.T) {
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertInteger(t, 3, conn.Do("ZADD", "z1", "1", "a", "2", "b", "3", "c"))
+	beamtest.AssertInteger(t, 2, conn.Do("ZADD", "z2", "10", "b", "20", "d"))
+	beamtest.AssertInteger(t, 2, conn.Do("SADD", "s", "a", "b"))
+
+	beamtest.AssertInteger(t, 4, conn.Do("ZUNIONSTORE", "u", "2", "z1", "z2"))
+	beamtest.AssertStrings(t, []string{"a", "1", "c", "3", "b", "12", "d", "20"}, conn.Do("ZRANGE", "u", "0", "-1", "WITHSCORES"))
+	beamtest.AssertInteger(t, 4, conn.Do("ZUNIONSTORE", "u", "3", "z1", "z2", "s", "WEIGHTS", "2", "1", "5", "AGGREGATE", "MAX"))
+	beamtest.AssertStrings(t, []string{"a", "5", "c", "6", "b", "10", "d", "20"}, conn.Do("ZRANGE", "u", "0", "-1", "WITHSCORES"))
+
+	beamtest.AssertInteger(t, 1, conn.Do("ZINTERSTORE", "i", "3", "z1", "z2", "s", "AGGREGATE", "min"))
+	beamtest.AssertStrings(t, []string{"b", "1"}, conn.Do("ZRANGE", "i", "0", "-1", "WITHSCORES"))
+	// the destination may be one of the sources.
+	beamtest.AssertInteger(t, 2, conn.Do("ZINTERSTORE", "z1", "2", "z1", "s"))
+	beamtest.AssertStrings(t, []string{"a", "2", "b", "3"}, conn.Do("ZRANGE", "z1", "0", "-1", "WITHSCORES"))
+	beamtest.AssertInteger(t, 0, conn.Do("ZINTERSTORE", "i", "2", "z1", "missing"))
+	beamtest.AssertInteger(t, 0, conn.Do("EXISTS", "i"))
+
+	beamtest.AssertError(t, "ERR at least 1 input key is needed for 'zunionstore' command", conn.Do("ZUNIONSTORE", "u", "0", "z1"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("ZUNIONSTORE", "u", "3", "z1", "z2"))
+	beamtest.AssertError(t, "ERR weight value is not a float", conn.Do("ZUNIONSTORE", "u", "1", "z1", "WEIGHTS", "x"))
+	beamtest.AssertError(t, "ERR syntax error", conn.Do("ZUNIONSTORE", "u", "1", "z1", "AGGREGATE", "AVG"))
+	beamtest.AssertOK(t, conn.Do("SET", "str", "v"))
+	beamtest.AssertError(t, "WRONGTYPE", conn.Do("ZUNIONSTORE", "u", "2", "z1", "str"))
+}
+
+func TestFormatScore(t *testing.T) {
+	assert := assert.New(t)
+	for f, expected := range map[float64]string{
+		0:            "0",
+		1.5:          "1.5",
+		-2:           "-2",
+		1234567:      "1234567",
+		0.0001:       "0.0001",
+		0.00001:      "1e-05",
+		1e17:         "1e+17",
+		1e16:         "10000000000000000",
+		math.Inf(1):  "inf",
+		math.Inf(-1): "-inf",
+	} {
+		assert.Equal(expected, string(formatScore(f)), f)
+	}
+}
+
+func TestStore_ZScan(t *testing.T) {
+	assert := assert.New(t)
+	_, _, conn := newTestStore(t, Config{})
+
+	beamtest.AssertInteger(t, 3, conn.Do("ZADD", "z", "1", "a", "2.5", "b", "-inf", "c"))
+	reply, err := conn.Do("ZSCAN", "z", "0", "MATCH", "[ab]").Array()
+	if assert.Nil(err) && assert.Len(reply, 2) {
+		beamtest.AssertBulkString(t, "0", reply[0])
+		scores, err := reply[1].Map()
+		assert.Nil(err)
+		assert.Len(scores, 2)
+		beamtest.AssertBulkString(t, "2.5", scores["b"])
+	}
+	beamtest.AssertReply(t, beam.Reply("*2\r\n$1\r\n0\r\n*0\r\n"), conn.Do("ZSCAN", "missing", "0"))
+	beamtest.AssertOK(t, conn.Do("SET", "str", "v"))
+	beamtest.AssertError(t, "WRONGTYPE", conn.Do("ZSCAN", "str", "0"))
+}
@@ -1,9 +1,9 @@
 package beam
 
 import (
+	"log/slog"
 	"strings"
-
-	"github.com/gaemma/logging"
+	"unsafe"
 )
 
 var (
@@ -14,11 +14,40 @@ func escapeCrlf(data string) string {
 	return strings.NewReplacer("\r", "\\r", "\n", "\\n").Replace(data)
 }
 
-func protectCall(call func(), logger logging.Logger) {
+func protectCall(call func(), logger *slog.Logger) {
 	defer func() {
 		if err := recover(); err != nil {
-			logger.Error("recover from panic: %v.", err)
+			logger.Error("recover from panic", LogKeyPanic, err)
 		}
 	}()
 	call()
 }
+
+// unsafeString converts b to string without copying, b must not be modified after that.
+func unsafeString(b []byte) string {
+	return unsafe.String(unsafe.SliceData(b), len(b))
+}
+
+// parseInt parses the decimal integer in b, ErrFormat will be returned if b is not a valid integer.
+func parseInt(b []byte) (int, error) {
+	var neg bool
+	if len(b) > 0 && b[0] == '-' {
+		neg = true
+		b = b[1:]
+	}
+	// 18 digits never overflow.
+	if len(b) == 0 || len(b) > 18 {
+		return 0, ErrFormat
+	}
+	var n int
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, ErrFormat
+		}
+		n = n*10 + int(c-'0')
+	}
+	if neg {
+		n = -n
+	}
+	return n, nil
+}